@@ -0,0 +1,160 @@
+// Register level access to the GPIO block of the Broadcom SoCs used on Raspberry Pi (BCM2835, BCM2836, BCM2837 and
+// BCM2711). The registers are reached through /dev/gpiomem, which maps just the GPIO block and doesn't need root.
+
+package hwio
+
+// References:
+// - BCM2835 ARM Peripherals, chapter 6
+// - BCM2711 ARM Peripherals, chapter 5
+// - http://abyz.me.uk/rpi/pigpio/ for BCM2711 detection
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Byte offsets of the GPIO registers
+const (
+	bcmGPFSEL0    = 0x00
+	bcmGPSET0     = 0x1c
+	bcmGPCLR0     = 0x28
+	bcmGPLEV0     = 0x34
+	bcmGPPUD      = 0x94 // BCM2835-7 only
+	bcmGPPUDCLK0  = 0x98 // BCM2835-7 only
+	bcmGPPUPPDN0  = 0xe4 // BCM2711 only
+	bcmGPPUPPDN3  = 0xf0 // BCM2711 only
+	bcmGPIOMapLen = 4096
+
+	// Value read from GPPUPPDN3 on SoCs before BCM2711, where it is not a register
+	bcmNoPUPPDN = 0x6770696f
+)
+
+// Values of GPPUD on BCM2835-7
+const (
+	bcmPUDOff  = 0
+	bcmPUDDown = 1
+	bcmPUDUp   = 2
+)
+
+// Values of the GPPUPPDN fields on BCM2711
+const (
+	bcm2711PullNone = 0
+	bcm2711PullUp   = 1
+	bcm2711PullDown = 2
+)
+
+// bcmGPIO reads and writes the GPIO registers, which are held in a byte slice. On hardware the slice is the memory map
+// of /dev/gpiomem; in unit tests it is ordinary memory.
+type bcmGPIO struct {
+	mem []byte
+
+	// true if the memory was mapped by openBCMGPIO, and needs to be unmapped on close
+	mapped bool
+}
+
+// Map the GPIO registers from /dev/gpiomem.
+func openBCMGPIO() (*bcmGPIO, error) {
+	f, e := os.OpenFile("/dev/gpiomem", os.O_RDWR|os.O_SYNC, 0)
+	if e != nil {
+		return nil, e
+	}
+	// the mapping remains valid after the file is closed
+	defer f.Close()
+
+	mem, e := syscall.Mmap(int(f.Fd()), 0, bcmGPIOMapLen, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if e != nil {
+		return nil, e
+	}
+
+	return &bcmGPIO{mem: mem, mapped: true}, nil
+}
+
+// Create register access over an existing slice.
+func newBCMGPIO(mem []byte) *bcmGPIO {
+	return &bcmGPIO{mem: mem}
+}
+
+func (g *bcmGPIO) close() error {
+	if !g.mapped {
+		return nil
+	}
+	g.mapped = false
+	return syscall.Munmap(g.mem)
+}
+
+// Registers are accessed atomically so each access is a single 32-bit load or store that the compiler won't elide.
+func (g *bcmGPIO) read(offset int) uint32 {
+	return atomic.LoadUint32((*uint32)(unsafe.Pointer(&g.mem[offset])))
+}
+
+func (g *bcmGPIO) write(offset int, value uint32) {
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&g.mem[offset])), value)
+}
+
+// Determine if the SoC is a BCM2711, which has a different pull up/down mechanism to earlier SoCs.
+func (g *bcmGPIO) is2711() bool {
+	return g.read(bcmGPPUPPDN3) != bcmNoPUPPDN
+}
+
+// Set the pull resistor of a GPIO. mode is INPUT for no pull, INPUT_PULLUP or INPUT_PULLDOWN.
+func (g *bcmGPIO) setPull(gpio int, mode PinIOMode) error {
+	if gpio < 0 || gpio > 53 {
+		return fmt.Errorf("GPIO %d is out of range", gpio)
+	}
+
+	if g.is2711() {
+		pull := uint32(bcm2711PullNone)
+		switch mode {
+		case INPUT_PULLUP:
+			pull = bcm2711PullUp
+		case INPUT_PULLDOWN:
+			pull = bcm2711PullDown
+		}
+
+		// 16 GPIOs per register, 2 bits each
+		offset := bcmGPPUPPDN0 + (gpio/16)*4
+		shift := uint(gpio%16) * 2
+		g.write(offset, g.read(offset)&^(3<<shift)|pull<<shift)
+		return nil
+	}
+
+	pud := uint32(bcmPUDOff)
+	switch mode {
+	case INPUT_PULLUP:
+		pud = bcmPUDUp
+	case INPUT_PULLDOWN:
+		pud = bcmPUDDown
+	}
+
+	// The sequence from the BCM2835 datasheet: set the control signal, wait 150 cycles, clock it into the GPIO,
+	// wait 150 cycles, then remove the control signal and the clock.
+	offset := bcmGPPUDCLK0 + (gpio/32)*4
+	g.write(bcmGPPUD, pud)
+	time.Sleep(time.Microsecond)
+	g.write(offset, 1<<uint(gpio%32))
+	time.Sleep(time.Microsecond)
+	g.write(bcmGPPUD, 0)
+	g.write(offset, 0)
+	return nil
+}
+
+// A DTGPIOPullSetter for Raspberry Pi, which sets pull resistors through /dev/gpiomem. The registers are mapped the
+// first time a pull is set.
+type bcmGPIOPullSetter struct {
+	gpio *bcmGPIO
+}
+
+func (s *bcmGPIOPullSetter) SetPull(gpioLogical int, mode PinIOMode) error {
+	if s.gpio == nil {
+		g, e := openBCMGPIO()
+		if e != nil {
+			return e
+		}
+		s.gpio = g
+	}
+	return s.gpio.setPull(gpioLogical, mode)
+}
@@ -0,0 +1,55 @@
+package hwio
+
+// Unit tests for BCM283x register access, against registers held in memory.
+
+import (
+	"testing"
+)
+
+func TestBCM2711Pull(t *testing.T) {
+	// GPPUPPDN3 reads as a register rather than the "gpio" filler, so this is a BCM2711.
+	g := newBCMGPIO(make([]byte, bcmGPIOMapLen))
+	if !g.is2711() {
+		t.Fatal("Expected registers to be detected as BCM2711")
+	}
+
+	g.setPull(17, INPUT_PULLUP)
+	g.setPull(18, INPUT_PULLDOWN)
+
+	// GPIO 16-31 are in the second register, two bits per GPIO.
+	v := g.read(bcmGPPUPPDN0 + 4)
+	if (v>>2)&3 != bcm2711PullUp {
+		t.Errorf("Expected GPIO 17 to be pulled up, register is %08x", v)
+	}
+	if (v>>4)&3 != bcm2711PullDown {
+		t.Errorf("Expected GPIO 18 to be pulled down, register is %08x", v)
+	}
+
+	g.setPull(17, INPUT)
+	v = g.read(bcmGPPUPPDN0 + 4)
+	if (v>>2)&3 != bcm2711PullNone || (v>>4)&3 != bcm2711PullDown {
+		t.Errorf("Expected only GPIO 17 pull to be removed, register is %08x", v)
+	}
+}
+
+func TestBCM2835Pull(t *testing.T) {
+	g := newBCMGPIO(make([]byte, bcmGPIOMapLen))
+	g.write(bcmGPPUPPDN3, bcmNoPUPPDN)
+	if g.is2711() {
+		t.Fatal("Expected registers to be detected as BCM2835")
+	}
+
+	e := g.setPull(40, INPUT_PULLUP)
+	if e != nil {
+		t.Errorf("setPull returned an unexpected error: %s", e)
+	}
+
+	// the control and clock registers are cleared at the end of the sequence
+	if g.read(bcmGPPUD) != 0 || g.read(bcmGPPUDCLK0+4) != 0 {
+		t.Error("Expected GPPUD and GPPUDCLK1 to be cleared after setting pull")
+	}
+
+	if e = g.setPull(54, INPUT_PULLUP); e == nil {
+		t.Error("Setting pull on GPIO 54 should return an error")
+	}
+}
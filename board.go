@@ -0,0 +1,575 @@
+// A Board is a hardware driver together with its pin map and the assignment of pins to modules. The package level
+// functions such as PinMode and DigitalWrite act on a default board, which is created the first time it is needed
+// with the driver that matches the hardware. Programs that need more than one driver at a time, such as a real board
+// and a simulated one, can create further boards with NewBoard.
+
+package hwio
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Board is safe for concurrent use. The driver and pin map are fixed when the board is created; the lock protects
+// pin assignments and settings.
+type Board struct {
+	sync.Mutex
+
+	driver HardwareDriver
+
+	// Retrieved from the driver, this is the map of the hardware pins supported by
+	// the driver and their capabilities
+	definedPins HardwarePinMap
+
+	// A map of pin numbers to the assigned dynamic properties of the pin. This is
+	// set by PinMode when errorChecking is on, and can be used by other functions
+	// to determine if the request is valid given the assigned properties of the pin.
+	assignedPins map[Pin]*assignedPin
+
+	// If set to true, functions should test that their constraints are met.
+	// e.g. test that the pin is capable of doing what is asked. This can be set
+	// with SetErrorChecking(). Setting to false bypasses checks for performance.
+	// By default turned on, which is a better default for beginners.
+	errorChecking bool
+
+	// The number of bits of AnalogWrite values, and the frequency of the PWM output in Hz.
+	analogWriteResolution int
+	analogWriteFrequency  int
+
+	// The PWM modules that AnalogWrite has enabled, so each is only enabled once. This has its own lock, as modules
+	// can assign pins while they are enabled.
+	analogWriteModules map[Module]bool
+	analogWriteLock    sync.Mutex
+}
+
+// A private type for associating a pin's definition with the current IO mode
+// and any other dynamic properties of the pin.
+type assignedPin struct {
+	pin    Pin    // pin being assigned
+	module Module // module that has assigned this pin
+	// pinIOMode PinIOMode // mode that was assigned to this pin
+}
+
+// Protects defaultBoard, moduleBoards and initialisingBoard.
+var boardsLock sync.Mutex
+
+// Held while the default board is being created or replaced, so only one goroutine detects the driver.
+var defaultBoardLock sync.Mutex
+
+// Held while a driver is being initialised, so initialisingBoard refers to one board at a time.
+var initLock sync.Mutex
+
+// The board used by the package level functions. Created by DefaultBoard or SetDriver.
+var defaultBoard *Board
+
+// The board that owns each module, so that pins assigned by a module are recorded on the right board. Modules are
+// registered once their driver has been initialised.
+var moduleBoards = make(map[Module]*Board)
+
+// The board whose driver is being initialised. Drivers create and enable modules in Init, before the modules can be
+// registered in moduleBoards, so pins they assign go to this board.
+var initialisingBoard *Board
+
+// Create a board for a driver. Init is called on the driver, and its pin map and modules are loaded.
+func NewBoard(d HardwareDriver) (*Board, error) {
+	b := newBoard()
+	e := b.setDriver(d)
+	if e != nil {
+		return nil, e
+	}
+	return b, nil
+}
+
+func newBoard() *Board {
+	return &Board{
+		assignedPins:          make(map[Pin]*assignedPin),
+		errorChecking:         true,
+		analogWriteResolution: DEFAULT_ANALOG_WRITE_RESOLUTION,
+		analogWriteFrequency:  DEFAULT_ANALOG_WRITE_FREQUENCY,
+		analogWriteModules:    make(map[Module]bool),
+	}
+}
+
+// Initialise the driver and take ownership of its modules.
+func (b *Board) setDriver(d HardwareDriver) error {
+	b.driver = d
+
+	initLock.Lock()
+	defer initLock.Unlock()
+
+	setInitialisingBoard(b)
+	e := d.Init()
+	setInitialisingBoard(nil)
+
+	b.definedPins = d.PinMap()
+
+	boardsLock.Lock()
+	defer boardsLock.Unlock()
+	for _, module := range d.GetModules() {
+		if module != nil {
+			moduleBoards[module] = b
+		}
+	}
+	return e
+}
+
+func setInitialisingBoard(b *Board) {
+	boardsLock.Lock()
+	defer boardsLock.Unlock()
+	initialisingBoard = b
+}
+
+// Return the default board, creating it if necessary. If no driver matches the hardware, the board has no driver and
+// its functions return errors.
+func DefaultBoard() *Board {
+	if b := getDefaultBoard(); b != nil {
+		return b
+	}
+
+	defaultBoardLock.Lock()
+	defer defaultBoardLock.Unlock()
+
+	// another goroutine may have created the board while this one was waiting
+	if b := getDefaultBoard(); b != nil {
+		return b
+	}
+
+	b := newBoard()
+	d := determineDriver()
+	if d == nil {
+		fmt.Printf("Unable to select a suitable driver for this board.\n")
+	} else {
+		e := b.setDriver(d)
+		if e != nil {
+			fmt.Printf("Could not initialise driver: %s", e)
+		}
+	}
+
+	boardsLock.Lock()
+	defaultBoard = b
+	boardsLock.Unlock()
+	return b
+}
+
+// Return the default board, or nil if it hasn't been created.
+func getDefaultBoard() *Board {
+	boardsLock.Lock()
+	defer boardsLock.Unlock()
+	return defaultBoard
+}
+
+// Replace the default board, forgetting the modules of the previous one.
+func replaceDefaultBoard(b *Board) {
+	boardsLock.Lock()
+	defer boardsLock.Unlock()
+
+	if defaultBoard != nil {
+		defaultBoard.forgetModules()
+	}
+	defaultBoard = b
+}
+
+// Return the board that a module belongs to. Modules that aren't from a board's driver use the default board.
+func boardForModule(module Module) *Board {
+	boardsLock.Lock()
+	b := moduleBoards[module]
+	if b == nil {
+		b = initialisingBoard
+	}
+	boardsLock.Unlock()
+
+	if b != nil {
+		return b
+	}
+	return DefaultBoard()
+}
+
+// Check if the driver is assigned. If not, return an error to indicate that,
+// otherwise return no error.
+func (b *Board) assertDriver() error {
+	if b.driver == nil {
+		return ErrNoDriver
+	}
+	return nil
+}
+
+// Retrieve the board's hardware driver.
+func (b *Board) GetDriver() HardwareDriver {
+	return b.driver
+}
+
+// Returns a map of the hardware pins.
+func (b *Board) GetDefinedPins() HardwarePinMap {
+	return b.definedPins
+}
+
+// Set error checking. This should be called before pin assignments.
+func (b *Board) SetErrorChecking(check bool) {
+	b.Lock()
+	defer b.Unlock()
+	b.errorChecking = check
+}
+
+// Close the board's driver, releasing any resources external to the program. The board's modules are forgotten,
+// so the board should not be used afterwards.
+func (b *Board) Close() {
+	if b.driver == nil {
+		return
+	}
+	b.driver.Close()
+
+	boardsLock.Lock()
+	defer boardsLock.Unlock()
+	b.forgetModules()
+}
+
+// Remove the board's modules from moduleBoards. boardsLock must be held.
+func (b *Board) forgetModules() {
+	for module, owner := range moduleBoards {
+		if owner == b {
+			delete(moduleBoards, module)
+		}
+	}
+}
+
+// Returns a Pin given a canonical name for the pin. See GetPin.
+func (b *Board) GetPin(pinName string) (Pin, error) {
+	pl := strings.ToLower(pinName)
+	for pin, pinDef := range b.definedPins {
+		for _, name := range pinDef.names {
+			if strings.ToLower(name) == pl {
+				return pin, nil
+			}
+		}
+	}
+
+	return Pin(0), fmt.Errorf("Could not find a pin called %s: %w", pinName, ErrUnknownPin)
+}
+
+// Shortcut for calling GetPin and then PinMode.
+func (b *Board) GetPinWithMode(cname string, mode PinIOMode) (pin Pin, e error) {
+	p, e := b.GetPin(cname)
+	if e != nil {
+		return
+	}
+
+	e = b.PinMode(p, mode)
+	return p, e
+}
+
+// Given an internal pin number, return the canonical name for the pin, as defined by the driver. If the pin
+// is not to the driver, return "".
+func (b *Board) PinName(pin Pin) string {
+	p := b.definedPins[pin]
+	if p == nil {
+		return ""
+	}
+	return p.names[0]
+}
+
+// Get a module by name. If driver is not set, it will return an error. If the driver does not support that module,
+// nil is returned.
+func (b *Board) GetModule(name string) (Module, error) {
+	if b.driver == nil {
+		return nil, fmt.Errorf("GetModule: %w", ErrNoDriver)
+	}
+
+	modules := b.driver.GetModules()
+	return modules[name], nil
+}
+
+// Helper function to get GPIO module
+func (b *Board) GetGPIOModule() (GPIOModule, error) {
+	m, e := b.GetModule("gpio")
+	if e != nil {
+		return nil, e
+	}
+
+	if m == nil {
+		return nil, fmt.Errorf("GPIO is %w by the driver", ErrUnsupported)
+	}
+
+	return m.(GPIOModule), nil
+}
+
+// Helper function to get the GPIO module, if it supports watching pins for edges.
+func (b *Board) GetGPIOWatchModule() (GPIOWatchModule, error) {
+	gpio, e := b.GetGPIOModule()
+	if e != nil {
+		return nil, e
+	}
+
+	watcher, ok := gpio.(GPIOWatchModule)
+	if !ok {
+		return nil, fmt.Errorf("Watching pins is %w by GPIO module '%s'", ErrUnsupported, gpio.GetName())
+	}
+	return watcher, nil
+}
+
+// Helper function to get analog module
+func (b *Board) GetAnalogModule() (AnalogModule, error) {
+	m, e := b.GetModule("analog")
+	if e != nil {
+		return nil, e
+	}
+
+	if m == nil {
+		return nil, fmt.Errorf("Analog is %w by the driver", ErrUnsupported)
+	}
+
+	return m.(AnalogModule), nil
+}
+
+// Set the mode of a pin. Analogous to Arduino pin mode.
+func (b *Board) PinMode(pin Pin, mode PinIOMode) error {
+	gpio, e := b.GetGPIOModule()
+	if e != nil {
+		return e
+	}
+
+	return gpio.PinMode(pin, mode)
+}
+
+// Close a specific pin that has been assigned as GPIO by PinMode
+func (b *Board) ClosePin(pin Pin) error {
+	gpio, e := b.GetGPIOModule()
+	if e != nil {
+		return e
+	}
+
+	return gpio.ClosePin(pin)
+}
+
+// Write a value to a digital pin
+func (b *Board) DigitalWrite(pin Pin, value int) error {
+	gpio, e := b.GetGPIOModule()
+	if e != nil {
+		return e
+	}
+
+	return gpio.DigitalWrite(pin, value)
+}
+
+// Read a value from a digital pin
+func (b *Board) DigitalRead(pin Pin) (int, error) {
+	gpio, e := b.GetGPIOModule()
+	if e != nil {
+		return 0, e
+	}
+
+	return gpio.DigitalRead(pin)
+}
+
+// Read an analog value from a pin. The range of values is hardware driver dependent.
+func (b *Board) AnalogRead(pin Pin) (int, error) {
+	analog, e := b.GetAnalogModule()
+	if e != nil {
+		return 0, e
+	}
+
+	return analog.AnalogRead(pin)
+}
+
+// Write an analog value to a pin as PWM, with a duty cycle of value out of the largest value for the resolution. See
+// AnalogWrite.
+func (b *Board) AnalogWrite(pin Pin, value int) error {
+	e := b.assertDriver()
+	if e != nil {
+		return e
+	}
+
+	b.Lock()
+	resolution := b.analogWriteResolution
+	period := int64(time.Second) / int64(b.analogWriteFrequency)
+	b.Unlock()
+
+	max := 1<<uint(resolution) - 1
+	if value < 0 || value > max {
+		return &PinError{Op: "AnalogWrite", Pin: pin, Err: fmt.Errorf("value %d is out of the range 0 to %d", value, max)}
+	}
+
+	pwm, e := b.getPWMModuleForPin(pin)
+	if e != nil {
+		return e
+	}
+
+	e = b.enableAnalogWriteModule(pwm)
+	if e != nil {
+		return e
+	}
+
+	enabled, e := pwm.IsPinEnabled(pin)
+	if e == nil && !enabled {
+		e = pwm.EnablePin(pin, true)
+	}
+	if e != nil {
+		return e
+	}
+
+	// changing the period keeps the duty cycle, so the duty time is never longer than the period
+	current, e := pwm.GetPeriod(pin)
+	if e == nil && current != period {
+		e = pwm.SetPeriodKeepDutyCycle(pin, period)
+	}
+	if e != nil {
+		return e
+	}
+	return pwm.SetDutyCycle(pin, float64(value)/float64(max))
+}
+
+// Enable a PWM module the first time AnalogWrite uses it. Some modules do more than check their state when enabled,
+// so Enable isn't called again.
+func (b *Board) enableAnalogWriteModule(pwm PWMModule) error {
+	b.analogWriteLock.Lock()
+	defer b.analogWriteLock.Unlock()
+
+	if b.analogWriteModules[pwm] {
+		return nil
+	}
+	e := pwm.Enable()
+	if e != nil {
+		return e
+	}
+	b.analogWriteModules[pwm] = true
+	return nil
+}
+
+// Get the first PWM module in the list of modules of a pin.
+func (b *Board) getPWMModuleForPin(pin Pin) (PWMModule, error) {
+	def := b.definedPins.GetPin(pin)
+	if def == nil {
+		return nil, &PinError{Op: "AnalogWrite", Pin: pin, Err: ErrUnknownPin}
+	}
+
+	modules := b.driver.GetModules()
+	for _, name := range def.modules {
+		if pwm, ok := modules[name].(PWMModule); ok {
+			return pwm, nil
+		}
+	}
+	return nil, &PinError{Op: "AnalogWrite", Pin: pin, Err: fmt.Errorf("PWM is %w on this pin", ErrUnsupported)}
+}
+
+// Set the number of bits of the values for AnalogWrite, from 1 to 16. See AnalogWriteResolution.
+func (b *Board) AnalogWriteResolution(bits int) error {
+	if bits < 1 || bits > 16 {
+		return fmt.Errorf("AnalogWriteResolution: %d bits is %w, it must be from 1 to 16", bits, ErrUnsupported)
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	b.analogWriteResolution = bits
+	return nil
+}
+
+// Set the frequency of the PWM output of AnalogWrite, in Hz. See AnalogWriteFrequency.
+func (b *Board) AnalogWriteFrequency(hz int) error {
+	if hz < 1 || hz > 1000000000 {
+		return fmt.Errorf("AnalogWriteFrequency: %d Hz is %w", hz, ErrUnsupported)
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	b.analogWriteFrequency = hz
+	return nil
+}
+
+// Watch a GPIO pin for edges. See WatchPin.
+func (b *Board) WatchPin(pin Pin, edge Edge) (<-chan PinEvent, error) {
+	watcher, e := b.GetGPIOWatchModule()
+	if e != nil {
+		return nil, e
+	}
+
+	return watcher.WatchPin(pin, edge)
+}
+
+// Stop watching a GPIO pin for edges.
+func (b *Board) UnwatchPin(pin Pin) error {
+	watcher, e := b.GetGPIOWatchModule()
+	if e != nil {
+		return e
+	}
+
+	return watcher.UnwatchPin(pin)
+}
+
+// Open a bank over a set of GPIO pins. See OpenBank.
+func (b *Board) OpenBank(pins PinList) (GPIOBank, error) {
+	gpio, e := b.GetGPIOModule()
+	if e != nil {
+		return nil, e
+	}
+
+	if banks, ok := gpio.(GPIOBankModule); ok {
+		return banks.OpenBank(pins)
+	}
+	return newSequentialGPIOBank(gpio, pins)
+}
+
+// Determine if a bank over the pins would be written atomically by the GPIO module.
+func (b *Board) PinsAtomic(pins PinList) bool {
+	gpio, e := b.GetGPIOModule()
+	if e != nil {
+		return false
+	}
+
+	if banks, ok := gpio.(GPIOBankModule); ok {
+		return banks.AtomicPins(pins)
+	}
+	return false
+}
+
+// Assign a pin to a module. If the pin is already assigned, an error is generated.
+func (b *Board) AssignPin(pin Pin, module Module) error {
+	b.Lock()
+	defer b.Unlock()
+
+	if a := b.assignedPins[pin]; a != nil {
+		return pinError("AssignPin", pin, module, fmt.Errorf("%w by module %s", ErrPinInUse, a.module.GetName()))
+	}
+	b.assignedPins[pin] = &assignedPin{pin, module}
+	return nil
+}
+
+// Unassign a pin.
+func (b *Board) UnassignPin(pin Pin) error {
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.assignedPins, pin)
+	return nil
+}
+
+// Helper to turn an on-board LED on or off. Uses LED module
+func (b *Board) Led(name string, on bool) error {
+	m, e := b.GetModule("leds")
+	if e != nil {
+		return e
+	}
+
+	leds := m.(LEDModule)
+	led, e := leds.GetLED(name)
+	if e != nil {
+		return e
+	}
+
+	e = led.SetTrigger("none")
+	if e != nil {
+		return e
+	}
+
+	return led.SetOn(on)
+}
+
+// Print the board's pin map.
+// @todo DebugPinMap: sort
+func (b *Board) DebugPinMap() {
+	fmt.Println("HardwarePinMap:")
+	for key, val := range b.definedPins {
+		fmt.Printf("Pin %d: %s\n", key, val.String())
+	}
+	fmt.Printf("\n")
+}
@@ -0,0 +1,159 @@
+package hwio
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestBoardsAreIndependent(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	b1, e := NewBoard(new(TestDriver))
+	if e != nil {
+		t.Fatalf("NewBoard returned an unexpected error: %s", e)
+	}
+	defer b1.Close()
+	b2, _ := NewBoard(new(TestDriver))
+	defer b2.Close()
+
+	pin, e := b1.GetPin("p2")
+	if e != nil {
+		t.Fatalf("GetPin returned an unexpected error: %s", e)
+	}
+
+	b1.PinMode(pin, OUTPUT)
+	b2.PinMode(pin, OUTPUT)
+	b1.DigitalWrite(pin, HIGH)
+	b2.DigitalWrite(pin, LOW)
+
+	if v, _ := b1.DigitalRead(pin); v != HIGH {
+		t.Error("Expected pin on first board to be HIGH")
+	}
+	if v, _ := b2.DigitalRead(pin); v != LOW {
+		t.Error("Expected pin on second board to be LOW")
+	}
+	if b1.GetDriver() == GetDriver() || b2.GetDriver() == GetDriver() {
+		t.Error("Expected boards not to share the default board's driver")
+	}
+}
+
+func TestBoardPinAssignment(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	b1, _ := NewBoard(new(TestDriver))
+	defer b1.Close()
+	b2, _ := NewBoard(new(TestDriver))
+	defer b2.Close()
+
+	m1, _ := b1.GetGPIOModule()
+	m2, _ := b2.GetGPIOModule()
+
+	// assignments made through modules are recorded on the board that owns the module
+	if e := AssignPin(3, m1); e != nil {
+		t.Errorf("AssignPin returned an unexpected error: %s", e)
+	}
+	if e := AssignPin(3, m2); e != nil {
+		t.Errorf("The same pin on a different board should be assignable, got %s", e)
+	}
+	if e := AssignPin(3, m1); e == nil {
+		t.Error("Assigning a pin twice on a board should return an error")
+	}
+
+	gpio, _ := GetGPIOModule()
+	if e := AssignPin(3, gpio); e != nil {
+		t.Errorf("Pin should be free on the default board, got %s", e)
+	}
+
+	unassignPin(3, m1)
+	if e := b1.AssignPin(3, m1); e != nil {
+		t.Errorf("Pin should be free after it is unassigned, got %s", e)
+	}
+}
+
+func TestSetDriverResetsAssignments(t *testing.T) {
+	SetDriver(new(TestDriver))
+	gpio, _ := GetGPIOModule()
+	AssignPin(4, gpio)
+
+	SetDriver(new(TestDriver))
+	gpio, _ = GetGPIOModule()
+	if e := AssignPin(4, gpio); e != nil {
+		t.Errorf("Assignments should be forgotten when the driver is set, got %s", e)
+	}
+}
+
+// Use the registry and the mock modules from several goroutines at once. This is mostly useful under the race
+// detector: go test -race
+func TestConcurrentAccess(t *testing.T) {
+	SetDriver(new(TestDriver))
+	gpio := getMockGPIO(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			pin, e := GetPin(fmt.Sprintf("p%d", i+1))
+			if e != nil {
+				t.Error(e)
+				return
+			}
+			for j := 0; j < 100; j++ {
+				PinMode(pin, OUTPUT)
+				DigitalWrite(pin, j%2)
+				DigitalRead(pin)
+				AnalogRead(10)
+
+				AssignPin(pin, gpio)
+				unassignPin(pin, gpio)
+			}
+		}(i)
+	}
+
+	// boards can be created and closed while the default board is in use
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 20; j++ {
+			b, e := NewBoard(new(TestDriver))
+			if e != nil {
+				t.Error(e)
+				return
+			}
+			b.PinMode(0, OUTPUT)
+			b.DigitalWrite(0, HIGH)
+			b.Close()
+		}
+	}()
+
+	wg.Wait()
+}
+
+func TestConcurrentWatch(t *testing.T) {
+	SetDriver(new(TestDriver))
+	gpio := getMockGPIO(t)
+
+	pin, _ := GetPin("p1")
+	PinMode(pin, INPUT)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for j := 0; j < 1000; j++ {
+			gpio.MockSetPinValue(pin, j%2)
+		}
+	}()
+
+	for j := 0; j < 50; j++ {
+		events, e := WatchPin(pin, EDGE_BOTH)
+		if e != nil {
+			t.Fatal(e)
+		}
+		UnwatchPin(pin)
+		for range events {
+		}
+	}
+	<-done
+}
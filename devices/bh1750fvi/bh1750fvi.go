@@ -65,17 +65,28 @@ func (t *BH1750FVI) ReadLightLevel(mode ReadMode) (float32, error) {
 	// Get the settings
 	m := modes[mode]
 
-	// send a command to initiate low resolution read. The empty slice indicates there are no additional bytes,
-	// just the command
-	t.device.Write(m.deviceMode, []byte{})
+	// send the mode as a single byte, which initiates the measurement. If the bus can't send a byte on its own, a
+	// write with an empty slice does the same thing, as there are no additional bytes.
+	var e error
+	if smbus, ok := t.device.(hwio.I2CSMBusDevice); ok {
+		e = smbus.SendByte(m.deviceMode)
+	} else {
+		e = t.device.Write(m.deviceMode, []byte{})
+	}
+	if e != nil {
+		return 0, e
+	}
 
 	// wait for the sampling to be complete, max of 24ms
 	hwio.Delay(m.sampleTimeMs)
 
-	// read two bytes
-	// @todo verify if this is correct. From Arduino examples I've seen, they use beginTransmission with the address,
-	// @todo then requestFrom with the address. However the address 0x23 is also a device register. Need to check this.
-	buffer, e := t.device.Read(m.deviceMode, 2)
+	// read two bytes. The device has no registers, so this is a plain read where the bus supports it.
+	buffer := make([]byte, 2)
+	if tx, ok := t.device.(hwio.I2CTxDevice); ok {
+		e = tx.Tx([]hwio.I2CMessage{{Flags: hwio.I2C_M_RD, Data: buffer}})
+	} else {
+		buffer, e = t.device.Read(m.deviceMode, 2)
+	}
 	if e != nil {
 		return 0, e
 	}
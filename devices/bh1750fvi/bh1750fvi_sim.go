@@ -0,0 +1,51 @@
+package bh1750fvi
+
+import (
+	"github.com/mrmorphic/hwio"
+)
+
+// A simulated BH1750FVI for tests, to attach to a simulated I2C bus at DEVICE_ADDRESS_ADDR_LOW or
+// DEVICE_ADDRESS_ADDR_HIGH. The device has no registers; each byte written is an instruction, and a measurement
+// instruction makes the level set with SetLevel available to read.
+type SimBH1750FVI struct {
+	*hwio.SimI2CRegisters
+
+	level uint16
+	mode  byte
+}
+
+func NewSimBH1750FVI() *SimBH1750FVI {
+	s := &SimBH1750FVI{SimI2CRegisters: hwio.NewSimI2CRegisters()}
+
+	// The result of a measurement is kept in the first two registers, and reads of more than two bytes go back to the
+	// start.
+	s.OnPointer = func(regs []byte, register byte) byte {
+		if register >= 0x10 {
+			s.mode = register
+			regs[0] = byte(s.level >> 8)
+			regs[1] = byte(s.level)
+		}
+		return 0
+	}
+	s.Next = func(regs []byte, register byte) byte {
+		return register ^ 1
+	}
+
+	return s
+}
+
+// Set the level that the next measurement gives, in the units the device reports.
+func (s *SimBH1750FVI) SetLevel(level uint16) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.level = level
+}
+
+// Get the last measurement instruction received, which is the mode of the measurement.
+func (s *SimBH1750FVI) GetMode() byte {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.mode
+}
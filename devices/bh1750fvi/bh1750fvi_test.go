@@ -0,0 +1,29 @@
+package bh1750fvi
+
+import (
+	"github.com/mrmorphic/hwio"
+	"testing"
+)
+
+func TestReadLightLevel(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	m, _ := hwio.GetModule("i2c")
+	i2c := m.(*hwio.SimI2CModule)
+	i2c.Enable()
+
+	sim := NewSimBH1750FVI()
+	i2c.MockAttachDevice(DEVICE_ADDRESS_ADDR_HIGH, sim)
+	sensor := NewBH1750FVIAddr(i2c, DEVICE_ADDRESS_ADDR_HIGH)
+
+	sim.SetLevel(0x1234)
+	level, e := sensor.ReadLightLevel(CONTINUOUS_LOW_RES)
+	if e != nil {
+		t.Fatalf("ReadLightLevel returned an unexpected error: %s", e)
+	}
+	if level != 0x1234 {
+		t.Errorf("Expected a level of %d, got %f", 0x1234, level)
+	}
+	if sim.GetMode() != 0x13 {
+		t.Errorf("Expected the low resolution mode instruction, got 0x%02x", sim.GetMode())
+	}
+}
@@ -0,0 +1,164 @@
+// Support for the DS18B20 1-Wire temperature sensor, through the kernel's w1_therm driver, which starts a conversion
+// and reads the sensor's scratchpad each time its w1_slave file is read.
+
+package ds18b20
+
+// references:
+// - DS18B20 datasheet
+// - https://www.kernel.org/doc/html/latest/w1/slaves/w1_therm.html
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mrmorphic/hwio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// The family code of the DS18B20, the first byte of its ROM code.
+	FAMILY = 0x28
+)
+
+type DS18B20 struct {
+	device hwio.OneWireDevice
+}
+
+func NewDS18B20(device hwio.OneWireDevice) *DS18B20 {
+	return &DS18B20{device: device}
+}
+
+// Get all the DS18B20s on a bus.
+func Find(module hwio.OneWireModule) ([]*DS18B20, error) {
+	devices, e := module.Devices()
+	if e != nil {
+		return nil, e
+	}
+
+	result := make([]*DS18B20, 0)
+	for _, device := range devices {
+		if device.Family() == FAMILY {
+			result = append(result, NewDS18B20(device))
+		}
+	}
+	return result, nil
+}
+
+// Get the ID of the sensor on the bus, e.g. "28-0000055f1b2c".
+func (t *DS18B20) ID() string {
+	return t.device.ID()
+}
+
+// Measure the temperature, in degrees C. This takes up to 750ms at 12 bits of resolution, and half as long for each bit
+// less.
+func (t *DS18B20) GetTemp() (float32, error) {
+	scratchpad, e := t.ReadScratchpad()
+	if e != nil {
+		return 0, e
+	}
+
+	// 12 bit two's complement, in 1/16ths of a degree. At lower resolutions, the lowest bits are undefined.
+	temp := int16(uint16(scratchpad[1])<<8 | uint16(scratchpad[0]))
+	temp &^= 1<<uint(12-resolution(scratchpad)) - 1
+
+	return float32(temp) / 16, nil
+}
+
+// Get the number of bits the temperature is measured to, from 9 (0.5 degrees) to 12 (0.0625 degrees).
+func (t *DS18B20) GetResolution() (int, error) {
+	scratchpad, e := t.ReadScratchpad()
+	if e != nil {
+		return 0, e
+	}
+	return resolution(scratchpad), nil
+}
+
+// Set the number of bits the temperature is measured to, from 9 to 12. Fewer bits make measurements quicker. The
+// setting is lost when the sensor loses power.
+func (t *DS18B20) SetResolution(bits int) error {
+	if bits < 9 || bits > 12 {
+		return fmt.Errorf("a resolution of %d bits is %w", bits, hwio.ErrUnsupported)
+	}
+	device, e := t.attributes()
+	if e != nil {
+		return e
+	}
+
+	value := []byte(strconv.Itoa(bits))
+	e = device.WriteAttribute("resolution", value)
+	if errors.Is(e, os.ErrNotExist) {
+		// kernels before 5.10 take the resolution through w1_slave
+		e = device.WriteAttribute("w1_slave", value)
+	}
+	return e
+}
+
+// Measure the temperature, and read the 9 bytes of the sensor's scratchpad, which has the temperature, the alarm
+// thresholds and the configuration, followed by their CRC. The CRC is checked.
+func (t *DS18B20) ReadScratchpad() ([]byte, error) {
+	device, e := t.attributes()
+	if e != nil {
+		return nil, e
+	}
+
+	b, e := device.ReadAttribute("w1_slave")
+	if e != nil {
+		return nil, e
+	}
+
+	scratchpad, e := parseW1Slave(string(b))
+	if e != nil {
+		return nil, fmt.Errorf("DS18B20 %s: %w", t.device.ID(), e)
+	}
+	return scratchpad, nil
+}
+
+// The sensor is read through the files of its kernel driver.
+func (t *DS18B20) attributes() (hwio.OneWireAttributeDevice, error) {
+	device, ok := t.device.(hwio.OneWireAttributeDevice)
+	if !ok {
+		return nil, fmt.Errorf("DS18B20 %s: a device without a kernel driver is %w", t.device.ID(), hwio.ErrUnsupported)
+	}
+	return device, nil
+}
+
+// Parse the scratchpad from the output of w1_slave, which looks like this:
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+//
+// The first line has the result of the kernel's CRC check, and the second the temperature in thousandths of a degree.
+func parseW1Slave(s string) ([]byte, error) {
+	line := strings.SplitN(s, "\n", 2)[0]
+	fields := strings.Fields(line)
+	if len(fields) != 12 || fields[9] != ":" {
+		return nil, fmt.Errorf("unexpected w1_slave output %q", line)
+	}
+
+	scratchpad := make([]byte, 9)
+	zeros := true
+	for i := range scratchpad {
+		v, e := strconv.ParseUint(fields[i], 16, 8)
+		if e != nil {
+			return nil, fmt.Errorf("unexpected w1_slave output %q", line)
+		}
+		scratchpad[i] = byte(v)
+		zeros = zeros && v == 0
+	}
+
+	if fields[11] != "YES" || hwio.OneWireCRC8(scratchpad) != 0 {
+		return nil, fmt.Errorf("scratchpad %x: %w", scratchpad, hwio.ErrCRC)
+	}
+	// zeros pass the CRC check, but are what is read when nothing answers
+	if zeros {
+		return nil, fmt.Errorf("scratchpad is all zeros: %w", syscall.EIO)
+	}
+	return scratchpad, nil
+}
+
+// Get the resolution from the configuration register, which has it in bits 5 and 6.
+func resolution(scratchpad []byte) int {
+	return 9 + int(scratchpad[4]>>5&3)
+}
@@ -0,0 +1,127 @@
+package ds18b20
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mrmorphic/hwio"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// Make a directory like /sys/bus/w1/devices with a bus master, a DS18B20 and another device, and get the enabled
+// 1-Wire module for it.
+func newTestBus(t *testing.T) (hwio.OneWireModule, string) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "w1_bus_master1"), 0755)
+	os.Mkdir(filepath.Join(dir, "28-0000055f1b2c"), 0755)
+	os.Mkdir(filepath.Join(dir, "3a-00000012ab34"), 0755)
+
+	w1 := hwio.NewDTOneWireModule("onewire")
+	w1.SetOptions(map[string]interface{}{"pins": hwio.DTOneWireModulePins{}, "path": dir})
+	if e := w1.Enable(); e != nil {
+		t.Fatalf("Enable returned an unexpected error: %s", e)
+	}
+	t.Cleanup(func() { w1.Disable() })
+	return w1, filepath.Join(dir, "28-0000055f1b2c")
+}
+
+// Write the w1_slave file of a device the way the kernel shows a scratchpad, with its CRC.
+func writeScratchpad(dir string, scratchpad []byte, crc string) {
+	if len(scratchpad) == 8 {
+		scratchpad = append(scratchpad, hwio.OneWireCRC8(scratchpad))
+	}
+	hex := fmt.Sprintf("% x", scratchpad)
+	s := fmt.Sprintf("%s : crc=%02x %s\n%s t=0\n", hex, scratchpad[8], crc, hex)
+	os.WriteFile(filepath.Join(dir, "w1_slave"), []byte(s), 0644)
+}
+
+func TestFind(t *testing.T) {
+	w1, _ := newTestBus(t)
+
+	sensors, e := Find(w1)
+	if e != nil || len(sensors) != 1 || sensors[0].ID() != "28-0000055f1b2c" {
+		t.Errorf("Expected to find the DS18B20 and not the other device, got %d sensors, %v", len(sensors), e)
+	}
+}
+
+func TestGetTemp(t *testing.T) {
+	w1, dir := newTestBus(t)
+	sensor := NewDS18B20(w1.GetDevice("28-0000055f1b2c"))
+
+	// the output of a real sensor, at 23.125 degrees
+	os.WriteFile(filepath.Join(dir, "w1_slave"), []byte("72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n"), 0644)
+	if temp, e := sensor.GetTemp(); e != nil || temp != 23.125 {
+		t.Errorf("Expected 23.125 degrees, got %f, %v", temp, e)
+	}
+	if bits, e := sensor.GetResolution(); e != nil || bits != 12 {
+		t.Errorf("Expected 12 bits of resolution, got %d, %v", bits, e)
+	}
+
+	writeScratchpad(dir, []byte{0x5e, 0xff, 0x4b, 0x46, 0x7f, 0xff, 0x02, 0x10}, "YES")
+	if temp, e := sensor.GetTemp(); e != nil || temp != -10.125 {
+		t.Errorf("Expected -10.125 degrees, got %f, %v", temp, e)
+	}
+
+	// at 9 bits, the lowest 3 bits of the temperature are ignored
+	writeScratchpad(dir, []byte{0x72, 0x01, 0x4b, 0x46, 0x1f, 0xff, 0x0e, 0x10}, "YES")
+	if temp, e := sensor.GetTemp(); e != nil || temp != 23 {
+		t.Errorf("Expected 23 degrees at 9 bits of resolution, got %f, %v", temp, e)
+	}
+	if bits, e := sensor.GetResolution(); e != nil || bits != 9 {
+		t.Errorf("Expected 9 bits of resolution, got %d, %v", bits, e)
+	}
+}
+
+func TestScratchpadErrors(t *testing.T) {
+	w1, dir := newTestBus(t)
+	sensor := NewDS18B20(w1.GetDevice("28-0000055f1b2c"))
+
+	writeScratchpad(dir, []byte{0x72, 0x01, 0x4b, 0x46, 0x7f, 0xff, 0x0e, 0x10, 0x58}, "YES")
+	if _, e := sensor.GetTemp(); !errors.Is(e, hwio.ErrCRC) {
+		t.Errorf("Expected ErrCRC for a scratchpad with the wrong CRC, got %v", e)
+	}
+	writeScratchpad(dir, []byte{0x72, 0x01, 0x4b, 0x46, 0x7f, 0xff, 0x0e, 0x10}, "NO")
+	if _, e := sensor.GetTemp(); !errors.Is(e, hwio.ErrCRC) {
+		t.Errorf("Expected ErrCRC when the kernel's check failed, got %v", e)
+	}
+	writeScratchpad(dir, make([]byte, 9), "YES")
+	if _, e := sensor.GetTemp(); !errors.Is(e, syscall.EIO) {
+		t.Errorf("Expected EIO for a scratchpad of zeros, got %v", e)
+	}
+	os.WriteFile(filepath.Join(dir, "w1_slave"), []byte("garbage\n"), 0644)
+	if _, e := sensor.GetTemp(); e == nil {
+		t.Errorf("Expected an error for output that isn't a scratchpad")
+	}
+	if _, e := NewDS18B20(w1.GetDevice("28-000000000001")).GetTemp(); !errors.Is(e, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist for a sensor that isn't on the bus, got %v", e)
+	}
+}
+
+func TestSetResolution(t *testing.T) {
+	w1, dir := newTestBus(t)
+	sensor := NewDS18B20(w1.GetDevice("28-0000055f1b2c"))
+
+	// kernels before 5.10 only have w1_slave
+	writeScratchpad(dir, []byte{0x72, 0x01, 0x4b, 0x46, 0x7f, 0xff, 0x0e, 0x10}, "YES")
+	if e := sensor.SetResolution(10); e != nil {
+		t.Errorf("SetResolution returned an unexpected error: %s", e)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "w1_slave")); string(b) != "10" {
+		t.Errorf("Expected the resolution to be written to w1_slave, got %q", b)
+	}
+
+	os.WriteFile(filepath.Join(dir, "resolution"), []byte("12\n"), 0644)
+	if e := sensor.SetResolution(11); e != nil {
+		t.Errorf("SetResolution returned an unexpected error: %s", e)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "resolution")); string(b) != "11" {
+		t.Errorf("Expected the resolution to be written to the resolution file, got %q", b)
+	}
+
+	if e := sensor.SetResolution(8); !errors.Is(e, hwio.ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for 8 bits of resolution, got %v", e)
+	}
+}
@@ -20,6 +20,9 @@ const (
 	REG_GYRO_CONFIG  = 0x1b
 	REG_ACCEL_CONFIG = 0x1c
 
+	// interrupt status, cleared when read
+	REG_INT_STATUS = 0x3a
+
 	// accelerometer sensor registers, read-only
 	REG_ACCEL_XOUT_H = 0x3b
 	REG_ACCEL_XOUT_L = 0x3c
@@ -41,8 +44,10 @@ const (
 	REG_GYRO_ZOUT_L = 0x48
 
 	REG_PWR_MGMT_1 = 0x6b
+	REG_WHO_AM_I   = 0x75
 
-	PARAM_SLEEP = 0x40
+	PARAM_SLEEP        = 0x40
+	PARAM_DATA_RDY_INT = 0x01 // data ready bit of INT_STATUS
 )
 
 type GY520 struct {
@@ -80,7 +85,7 @@ func (g *GY520) Sleep() error {
 		return e
 	}
 
-	v |= ^byte(PARAM_SLEEP)
+	v |= PARAM_SLEEP
 
 	e = g.device.WriteByte(REG_PWR_MGMT_1, v)
 	if e != nil {
@@ -0,0 +1,67 @@
+package gy520
+
+import (
+	"github.com/mrmorphic/hwio"
+)
+
+// A simulated GY-520 (MPU-6050) for tests, to attach to a simulated I2C bus at DEVICE_ADDRESS. It starts asleep, as
+// the device does at power on. The sensor registers follow the values set with SetAccel, SetGyro and SetTemp while
+// the device is awake, and keep their last values while it sleeps. Setting values also sets the data ready bit of
+// INT_STATUS, which is cleared when it is read.
+type SimGY520 struct {
+	*hwio.SimI2CRegisters
+
+	// values of the sensor registers, from REG_ACCEL_XOUT_H to REG_GYRO_ZOUT_L
+	sensors [REG_GYRO_ZOUT_L - REG_ACCEL_XOUT_H + 1]byte
+}
+
+func NewSimGY520() *SimGY520 {
+	s := &SimGY520{SimI2CRegisters: hwio.NewSimI2CRegisters()}
+
+	s.OnRead = func(regs []byte, register byte) byte {
+		if register >= REG_ACCEL_XOUT_H && register <= REG_GYRO_ZOUT_L && regs[REG_PWR_MGMT_1]&PARAM_SLEEP == 0 {
+			regs[register] = s.sensors[register-REG_ACCEL_XOUT_H]
+		}
+		return regs[register]
+	}
+
+	s.Set(REG_PWR_MGMT_1, PARAM_SLEEP)
+	s.Set(REG_WHO_AM_I, DEVICE_ADDRESS)
+	s.SetReadClears(REG_INT_STATUS, PARAM_DATA_RDY_INT)
+
+	return s
+}
+
+// Set sensor registers from values, with the high byte first.
+func (s *SimGY520) setSensors(register byte, values ...int16) {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, v := range values {
+		s.sensors[int(register-REG_ACCEL_XOUT_H)+i*2] = byte(v >> 8)
+		s.sensors[int(register-REG_ACCEL_XOUT_H)+i*2+1] = byte(v)
+	}
+}
+
+// Set the values the accelerometer measures.
+func (s *SimGY520) SetAccel(x int16, y int16, z int16) {
+	s.setSensors(REG_ACCEL_XOUT_H, x, y, z)
+	s.setDataReady()
+}
+
+// Set the values the gyroscope measures.
+func (s *SimGY520) SetGyro(x int16, y int16, z int16) {
+	s.setSensors(REG_GYRO_XOUT_H, x, y, z)
+	s.setDataReady()
+}
+
+// Set the value the temperature sensor measures.
+func (s *SimGY520) SetTemp(temp int16) {
+	s.setSensors(REG_TEMP_OUT_H, temp)
+	s.setDataReady()
+}
+
+func (s *SimGY520) setDataReady() {
+	status := s.Get(REG_INT_STATUS, 1)[0]
+	s.Set(REG_INT_STATUS, status|PARAM_DATA_RDY_INT)
+}
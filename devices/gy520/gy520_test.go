@@ -0,0 +1,50 @@
+package gy520
+
+import (
+	"github.com/mrmorphic/hwio"
+	"testing"
+)
+
+func TestReadSensors(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	m, _ := hwio.GetModule("i2c")
+	i2c := m.(*hwio.SimI2CModule)
+	i2c.Enable()
+
+	sim := NewSimGY520()
+	i2c.MockAttachDevice(DEVICE_ADDRESS, sim)
+	gyro := NewGY520(i2c)
+
+	sim.SetAccel(100, -200, 16384)
+	sim.SetGyro(-1, 2, -3)
+	sim.SetTemp(-521)
+
+	// nothing is measured until the device is woken
+	if x, y, z, _ := gyro.GetAccel(); x != 0 || y != 0 || z != 0 {
+		t.Errorf("Expected no acceleration while asleep, got %d, %d, %d", x, y, z)
+	}
+
+	if e := gyro.Wake(); e != nil {
+		t.Fatalf("Wake returned an unexpected error: %s", e)
+	}
+	if x, y, z, _ := gyro.GetAccel(); x != 100 || y != -200 || z != 16384 {
+		t.Errorf("Expected acceleration of 100, -200, 16384, got %d, %d, %d", x, y, z)
+	}
+	if x, y, z, _ := gyro.GetGyro(); x != -1 || y != 2 || z != -3 {
+		t.Errorf("Expected rotation of -1, 2, -3, got %d, %d, %d", x, y, z)
+	}
+	if temp, _ := gyro.GetTemp(); temp != -521 {
+		t.Errorf("Expected a temperature of -521, got %d", temp)
+	}
+
+	if e := gyro.Sleep(); e != nil {
+		t.Fatalf("Sleep returned an unexpected error: %s", e)
+	}
+	if v := sim.Get(REG_PWR_MGMT_1, 1)[0]; v != PARAM_SLEEP {
+		t.Errorf("Expected only the sleep bit of PWR_MGMT_1 to be set, got 0x%02x", v)
+	}
+	sim.SetAccel(1, 1, 1)
+	if x, _, _, _ := gyro.GetAccel(); x != 100 {
+		t.Errorf("Expected the last acceleration while asleep, got %d", x)
+	}
+}
@@ -102,15 +102,24 @@ const (
 	PROFILE_PCF8574
 )
 
+// The bits of the port expander that the LCD pins are connected to for each profile, and the backlight polarity.
+type expanderPins struct {
+	en, rw, rs, d4, d5, d6, d7, bl int
+	polarity                       int
+}
+
+var profiles = map[I2CExpanderProfile]expanderPins{
+	PROFILE_MJKDZ:   {4, 5, 6, 0, 1, 2, 3, 7, NEGATIVE},
+	PROFILE_PCF8574: {2, 1, 0, 4, 5, 6, 7, 3, POSITIVE},
+}
+
 func NewHD44780(module hwio.I2CModule, address int, profile I2CExpanderProfile) *HD44780 {
-	switch profile {
-	case PROFILE_MJKDZ:
-		return NewHD44780Extended(module, address, 4, 5, 6, 0, 1, 2, 3, 7, NEGATIVE)
-	case PROFILE_PCF8574:
-		return NewHD44780Extended(module, address, 2, 1, 0, 4, 5, 6, 7, 3, POSITIVE)
+	p, ok := profiles[profile]
+	if !ok {
+		return nil
 	}
 
-	return nil
+	return NewHD44780Extended(module, address, p.en, p.rw, p.rs, p.d4, p.d5, p.d6, p.d7, p.bl, p.polarity)
 }
 
 func NewHD44780Extended(module hwio.I2CModule, address int, en int, rw int, rs int, d4 int, d5 int, d6 int, d7 int, bl int, polarity int) *HD44780 {
@@ -0,0 +1,154 @@
+package hd44780
+
+import (
+	"github.com/mrmorphic/hwio"
+)
+
+// A simulated HD44780 display behind an I2C port expander, for tests. Each byte written to the expander sets its
+// port, and the display reads its pins from the port, wired as in one of the profiles. The display latches its
+// data lines when EN falls, starting in 8-bit mode until a function set switches it to 4 bits. Text written to the
+// display RAM can be read back with GetLine. Custom characters and shifting the display are not modelled.
+type SimHD44780 struct {
+	*hwio.SimI2CRegisters
+
+	// masks of the LCD pins on the port
+	en, rs, d4, d5, d6, d7 byte
+
+	port byte
+
+	fourBit   bool
+	pending   bool
+	high      byte
+	cgram     bool
+	ddram     [128]byte
+	address   byte
+	increment bool
+	displayOn bool
+}
+
+func NewSimHD44780(profile I2CExpanderProfile) *SimHD44780 {
+	p := profiles[profile]
+	s := &SimHD44780{
+		SimI2CRegisters: hwio.NewSimI2CRegisters(),
+		en:              1 << uint(p.en),
+		rs:              1 << uint(p.rs),
+		d4:              1 << uint(p.d4),
+		d5:              1 << uint(p.d5),
+		d6:              1 << uint(p.d6),
+		d7:              1 << uint(p.d7),
+		increment:       true,
+	}
+	for i := range s.ddram {
+		s.ddram[i] = ' '
+	}
+
+	// The expander has no registers, so every byte written is the new value of the port.
+	s.OnPointer = func(regs []byte, register byte) byte {
+		s.setPort(register)
+		return 0
+	}
+	s.OnWrite = func(regs []byte, register byte, value byte) {
+		s.setPort(value)
+	}
+
+	return s
+}
+
+// Set the port of the expander, latching the data lines if EN falls.
+func (s *SimHD44780) setPort(value byte) {
+	if s.port&s.en != 0 && value&s.en == 0 {
+		s.latch(s.port)
+	}
+	s.port = value
+}
+
+// Latch the data lines. In 8-bit mode, only the high 4 data lines are connected, so the low 4 bits are 0.
+func (s *SimHD44780) latch(port byte) {
+	nibble := byte(0)
+	for i, mask := range []byte{s.d4, s.d5, s.d6, s.d7} {
+		if port&mask != 0 {
+			nibble |= 1 << uint(i)
+		}
+	}
+	rs := port&s.rs != 0
+
+	if !s.fourBit {
+		s.execute(nibble<<4, rs)
+		return
+	}
+	if !s.pending {
+		s.high = nibble
+		s.pending = true
+		return
+	}
+	s.pending = false
+	s.execute(s.high<<4|nibble, rs)
+}
+
+// Execute an instruction, or write data if rs is set.
+func (s *SimHD44780) execute(value byte, rs bool) {
+	if rs {
+		if !s.cgram {
+			s.ddram[s.address] = value
+		}
+		s.move(s.increment)
+		return
+	}
+
+	switch {
+	case value&LCD_SETDDRAMADDR != 0:
+		s.address = value & 0x7f
+		s.cgram = false
+	case value&LCD_SETCGRAMADDR != 0:
+		s.cgram = true
+	case value&LCD_FUNCTIONSET != 0:
+		s.fourBit = value&LCD_8BITMODE == 0
+		s.pending = false
+	case value&LCD_CURSORSHIFT != 0:
+		if value&LCD_DISPLAYMOVE == 0 {
+			s.move(value&LCD_MOVERIGHT != 0)
+		}
+	case value&LCD_DISPLAYCONTROL != 0:
+		s.displayOn = value&LCD_DISPLAYON != 0
+	case value&LCD_ENTRYMODESET != 0:
+		s.increment = value&LCD_ENTRYLEFT != 0
+	case value&LCD_RETURNHOME != 0:
+		s.address = 0
+		s.cgram = false
+	case value&LCD_CLEARDISPLAY != 0:
+		for i := range s.ddram {
+			s.ddram[i] = ' '
+		}
+		s.address = 0
+		s.cgram = false
+		s.increment = true
+	}
+}
+
+// Move the cursor one place.
+func (s *SimHD44780) move(forward bool) {
+	if forward {
+		s.address = (s.address + 1) & 0x7f
+	} else {
+		s.address = (s.address - 1) & 0x7f
+	}
+}
+
+// Get the text on a row of the display, for a display that is cols wide. Rows start at the same display RAM addresses
+// as SetCursor uses.
+func (s *SimHD44780) GetLine(row int, cols int) string {
+	s.Lock()
+	defer s.Unlock()
+
+	rowOffsets := []int{0x00, 0x40, 0x14, 0x54}
+	start := rowOffsets[row]
+	return string(s.ddram[start : start+cols])
+}
+
+// Determine if the display has been turned on.
+func (s *SimHD44780) IsDisplayOn() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.displayOn
+}
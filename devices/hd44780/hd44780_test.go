@@ -0,0 +1,40 @@
+package hd44780
+
+import (
+	"github.com/mrmorphic/hwio"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	m, _ := hwio.GetModule("i2c")
+	i2c := m.(*hwio.SimI2CModule)
+	i2c.Enable()
+
+	for _, profile := range []I2CExpanderProfile{PROFILE_MJKDZ, PROFILE_PCF8574} {
+		sim := NewSimHD44780(profile)
+		i2c.MockAttachDevice(0x27, sim)
+
+		display := NewHD44780(i2c, 0x27, profile)
+		display.Init(20, 4)
+		if !sim.IsDisplayOn() {
+			t.Errorf("Expected Init to turn the display on for profile %d", profile)
+		}
+
+		display.Write([]byte("hello"))
+		display.SetCursor(3, 1)
+		display.Write([]byte("world"))
+
+		if line := sim.GetLine(0, 20); line != "hello               " {
+			t.Errorf("Expected hello on the first line for profile %d, got '%s'", profile, line)
+		}
+		if line := sim.GetLine(1, 20); line != "   world            " {
+			t.Errorf("Expected world on the second line for profile %d, got '%s'", profile, line)
+		}
+
+		display.Clear()
+		if line := sim.GetLine(0, 20); line != "                    " {
+			t.Errorf("Expected Clear to blank the display for profile %d, got '%s'", profile, line)
+		}
+	}
+}
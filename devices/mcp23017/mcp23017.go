@@ -34,6 +34,10 @@ const (
 	REG_GPIOB   = 0x13
 	REG_OLATA   = 0x14
 	REG_OLATB   = 0x15
+
+	// bits of IOCON
+	IOCON_SEQOP = 0x20 // sequential addressing disabled
+	IOCON_BANK  = 0x80 // registers of each port in separate banks
 )
 
 type MCP23017 struct {
@@ -0,0 +1,89 @@
+package mcp23017
+
+import (
+	"github.com/mrmorphic/hwio"
+)
+
+// A simulated MCP23017 for tests, to attach to a simulated I2C bus at one of 0x20-0x27. It has the registers of
+// BANK=0, and models the GPIO ports: reading GPIOA or GPIOB gives the levels set with SetInputA and SetInputB for
+// input pins, inverted by IPOL, and the output latches for output pins. Writing GPIOA or GPIOB writes the output
+// latches. Sequential addressing is turned off by the SEQOP bit of IOCON. Interrupts are not modelled.
+type SimMCP23017 struct {
+	*hwio.SimI2CRegisters
+
+	inputA byte
+	inputB byte
+}
+
+func NewSimMCP23017() *SimMCP23017 {
+	s := &SimMCP23017{SimI2CRegisters: hwio.NewSimI2CRegisters()}
+
+	s.OnRead = func(regs []byte, register byte) byte {
+		switch register {
+		case REG_GPIOA:
+			return s.port(regs, s.inputA, REG_IODIRA, REG_IPOLA, REG_OLATA)
+		case REG_GPIOB:
+			return s.port(regs, s.inputB, REG_IODIRB, REG_IPOLB, REG_OLATB)
+		}
+		return regs[register]
+	}
+	s.OnWrite = func(regs []byte, register byte, value byte) {
+		switch register {
+		case REG_GPIOA:
+			register = REG_OLATA
+		case REG_GPIOB:
+			register = REG_OLATB
+		case REG_IOCON, REG_IOCON + 1:
+			// IOCON appears at both addresses
+			regs[REG_IOCON] = value
+			register = REG_IOCON + 1
+		}
+		regs[register] = value
+	}
+	s.Next = func(regs []byte, register byte) byte {
+		if regs[REG_IOCON]&IOCON_SEQOP != 0 {
+			return register
+		}
+		return register + 1
+	}
+
+	// all the pins are inputs at power on
+	s.Set(REG_IODIRA, 0xff, 0xff)
+
+	return s
+}
+
+// Work out the value read from a port.
+func (s *SimMCP23017) port(regs []byte, input byte, iodir byte, ipol byte, olat byte) byte {
+	inputs := (input ^ regs[ipol]) & regs[iodir]
+	outputs := regs[olat] &^ regs[iodir]
+	return inputs | outputs
+}
+
+// Set the levels of the pins of port A. Only the pins that are inputs are read.
+func (s *SimMCP23017) SetInputA(value byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.inputA = value
+}
+
+// Set the levels of the pins of port B. Only the pins that are inputs are read.
+func (s *SimMCP23017) SetInputB(value byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.inputB = value
+}
+
+// Get the levels of the output pins of port A. The bits of input pins are 0.
+func (s *SimMCP23017) GetOutputA() byte {
+	b := s.Get(REG_IODIRA, REG_OLATA+1)
+	return b[REG_OLATA] &^ b[REG_IODIRA]
+}
+
+// Get the levels of the output pins of port B. The bits of input pins are 0.
+func (s *SimMCP23017) GetOutputB() byte {
+	b := s.Get(REG_IODIRA, REG_OLATB+1)
+	return b[REG_OLATB] &^ b[REG_IODIRB]
+}
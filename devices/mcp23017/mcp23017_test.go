@@ -0,0 +1,56 @@
+package mcp23017
+
+import (
+	"github.com/mrmorphic/hwio"
+	"testing"
+)
+
+func newTestExpander(t *testing.T) (*MCP23017, *SimMCP23017) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	m, _ := hwio.GetModule("i2c")
+	i2c := m.(*hwio.SimI2CModule)
+	i2c.Enable()
+
+	sim := NewSimMCP23017()
+	i2c.MockAttachDevice(0x21, sim)
+	expander, e := NewMCP23017(i2c, 1)
+	if e != nil {
+		t.Fatalf("NewMCP23017 returned an unexpected error: %s", e)
+	}
+	return expander, sim
+}
+
+func TestPorts(t *testing.T) {
+	expander, sim := newTestExpander(t)
+
+	// low nibble of A is inputs, high nibble is outputs
+	expander.SetDirA(0x0f)
+	expander.SetPortA(0xa5)
+	if v := sim.GetOutputA(); v != 0xa0 {
+		t.Errorf("Expected the outputs of port A to be 0xa0, got 0x%02x", v)
+	}
+
+	sim.SetInputA(0x03)
+	if v, _ := expander.GetPortA(); v != 0xa3 {
+		t.Errorf("Expected port A to read 0xa3, got 0x%02x", v)
+	}
+
+	// port B is all inputs from power on
+	sim.SetInputB(0x81)
+	if v, _ := expander.GetPortB(); v != 0x81 {
+		t.Errorf("Expected port B to read 0x81, got 0x%02x", v)
+	}
+	expander.SetPullupB(0xff)
+	if v := sim.Get(REG_GPPUB, 1)[0]; v != 0xff {
+		t.Errorf("Expected the pull-ups of port B to be set, got 0x%02x", v)
+	}
+}
+
+func TestInvalidAddress(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	m, _ := hwio.GetModule("i2c")
+
+	if _, e := NewMCP23017(m.(hwio.I2CModule), 0x30); e == nil {
+		t.Error("Expected an error for an address outside 0x20-0x27")
+	}
+}
@@ -0,0 +1,83 @@
+package nunchuck
+
+import (
+	"github.com/mrmorphic/hwio"
+)
+
+// A simulated nunchuck for tests, to attach to a simulated I2C bus at DEVICE_ADDRESS. The sensor values are read from
+// registers 0x00-0x05, packed as a real nunchuck packs them. Until it has been initialised without encryption, by
+// writing 0x55 to 0xf0 and then 0x00 to 0xfb, the data reads as 0xff.
+type SimNunchuck struct {
+	*hwio.SimI2CRegisters
+
+	joyX      byte
+	joyY      byte
+	accel     [3]int
+	zPressed  bool
+	cPressed  bool
+	unlocked  bool
+	initStage int
+}
+
+func NewSimNunchuck() *SimNunchuck {
+	s := &SimNunchuck{SimI2CRegisters: hwio.NewSimI2CRegisters()}
+
+	s.OnWrite = func(regs []byte, register byte, value byte) {
+		regs[register] = value
+		switch {
+		case register == 0xf0 && value == 0x55:
+			s.initStage = 1
+		case register == 0xfb && value == 0x00 && s.initStage == 1:
+			s.unlocked = true
+		}
+	}
+	s.OnRead = func(regs []byte, register byte) byte {
+		if register > 5 {
+			return regs[register]
+		}
+		if !s.unlocked {
+			return 0xff
+		}
+		return s.packet()[register]
+	}
+
+	return s
+}
+
+// Pack the sensor values into the 6 bytes the nunchuck sends. The accelerometer values are 10 bits, with the high 8
+// bits in bytes 2-4 and the low 2 bits in byte 5, which also has the buttons, 0 when pressed.
+func (s *SimNunchuck) packet() []byte {
+	b := []byte{s.joyX, s.joyY, byte(s.accel[0] >> 2), byte(s.accel[1] >> 2), byte(s.accel[2] >> 2), 0}
+	b[5] = byte(s.accel[0]&3)<<2 | byte(s.accel[1]&3)<<4 | byte(s.accel[2]&3)<<6
+	if !s.zPressed {
+		b[5] |= 1
+	}
+	if !s.cPressed {
+		b[5] |= 2
+	}
+	return b
+}
+
+// Set the position of the joystick.
+func (s *SimNunchuck) SetJoystick(x byte, y byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.joyX, s.joyY = x, y
+}
+
+// Set the accelerometer values, which are 10 bits.
+func (s *SimNunchuck) SetAccel(x int, y int, z int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.accel = [3]int{x, y, z}
+}
+
+// Set whether the buttons are pressed.
+func (s *SimNunchuck) SetButtons(zPressed bool, cPressed bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.zPressed, s.cPressed = zPressed, cPressed
+}
@@ -0,0 +1,48 @@
+package nunchuck
+
+import (
+	"github.com/mrmorphic/hwio"
+	"testing"
+)
+
+func TestReadSensors(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	m, _ := hwio.GetModule("i2c")
+	i2c := m.(*hwio.SimI2CModule)
+	i2c.Enable()
+
+	sim := NewSimNunchuck()
+	i2c.MockAttachDevice(DEVICE_ADDRESS, sim)
+	n, e := NewNunchuck(i2c)
+	if e != nil {
+		t.Fatalf("NewNunchuck returned an unexpected error: %s", e)
+	}
+
+	sim.SetJoystick(10, 250)
+	sim.SetAccel(0x41, 0x22, 0x13)
+	sim.SetButtons(true, false)
+	if e = n.ReadSensors(); e != nil {
+		t.Fatalf("ReadSensors returned an unexpected error: %s", e)
+	}
+
+	if x, y := n.GetJoystick(); x != 10 || y != 250 {
+		t.Errorf("Expected the joystick at 10, 250, got %d, %d", x, y)
+	}
+	if x, y, z := n.GetAccel(); x != 0x41 || y != 0x22 || z != 0x13 {
+		t.Errorf("Expected acceleration of 0x41, 0x22, 0x13, got %f, %f, %f", x, y, z)
+	}
+	if !n.GetZPressed() || n.GetCPressed() {
+		t.Error("Expected Z to be pressed and C not to be")
+	}
+}
+
+func TestNotResponding(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	m, _ := hwio.GetModule("i2c")
+	i2c := m.(*hwio.SimI2CModule)
+	i2c.Enable()
+
+	if _, e := NewNunchuck(i2c); e == nil {
+		t.Error("Expected an error when there is no nunchuck")
+	}
+}
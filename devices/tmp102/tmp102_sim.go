@@ -0,0 +1,34 @@
+package tmp102
+
+import (
+	"github.com/mrmorphic/hwio"
+	"math"
+)
+
+// A simulated TMP102 for tests, to attach to a simulated I2C bus at DEVICE_ADDRESS. Its registers are 16 bits, and
+// the pointer selects one of the 4: temperature, configuration, T(low) and T(high). They start with their power on
+// values, and the temperature is 0 until it is set.
+type SimTMP102 struct {
+	*hwio.SimI2CRegisters
+}
+
+func NewSimTMP102() *SimTMP102 {
+	regs := hwio.NewSimI2CRegisters()
+
+	// Each register is kept in two bytes. Reading or writing more than two bytes goes back to the start of the register.
+	regs.OnPointer = func(regs []byte, register byte) byte {
+		return (register & 3) * 2
+	}
+	regs.Next = func(regs []byte, register byte) byte {
+		return register ^ 1
+	}
+	regs.Set(2, 0x60, 0xa0, 0x4b, 0x00, 0x50, 0x00)
+
+	return &SimTMP102{regs}
+}
+
+// Set the temperature in degrees C, which the device measures to 0.0625 degrees.
+func (s *SimTMP102) SetTemp(celsius float32) {
+	v := int16(math.Round(float64(celsius)/0.0625)) << 4
+	s.Set(0, byte(v>>8), byte(v))
+}
@@ -0,0 +1,31 @@
+package tmp102
+
+import (
+	"github.com/mrmorphic/hwio"
+	"testing"
+)
+
+func TestGetTemp(t *testing.T) {
+	hwio.SetDriver(new(hwio.TestDriver))
+	m, _ := hwio.GetModule("i2c")
+	i2c := m.(*hwio.SimI2CModule)
+	i2c.Enable()
+
+	sim := NewSimTMP102()
+	i2c.MockAttachDevice(DEVICE_ADDRESS, sim)
+	sensor := NewTMP102(i2c)
+
+	sim.SetTemp(23.5)
+	temp, e := sensor.GetTemp()
+	if e != nil {
+		t.Fatalf("GetTemp returned an unexpected error: %s", e)
+	}
+	if temp != 23.5 {
+		t.Errorf("Expected 23.5 degrees, got %f", temp)
+	}
+
+	i2c.MockDetachDevice(DEVICE_ADDRESS)
+	if _, e = sensor.GetTemp(); e == nil {
+		t.Error("Expected an error when the sensor doesn't respond")
+	}
+}
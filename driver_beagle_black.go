@@ -1,6 +1,11 @@
 package hwio
 
-import "strings"
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
 
 // A driver for BeagleBone's running Linux kernel 3.8 or higher, which use device trees instead
 // of the old driver.
@@ -133,16 +138,16 @@ func (d *BeagleBoneBlackDriver) createPinData() {
 		d.makePin([]string{"P9.14", "gpmc_a2", "gpio1_18"}, []string{"gpio"}, 50, 0),
 		d.makePin([]string{"P9.15", "gpmc_a0", "gpio1_16"}, []string{"gpio"}, 48, 0),
 		d.makePin([]string{"P9.16", "gpmc_a3", "gpio1_19"}, []string{"gpio"}, 51, 0),
-		d.makePin([]string{"P9.17", "spi0_cs0", "gpio0_5"}, []string{"gpio"}, 5, 0),
-		d.makePin([]string{"P9.18", "spi0_d1", "gpio0_4"}, []string{"gpio"}, 4, 0),
+		d.makePin([]string{"P9.17", "spi0_cs0", "gpio0_5"}, []string{"gpio", "spi0"}, 5, 0),
+		d.makePin([]string{"P9.18", "spi0_d1", "gpio0_4"}, []string{"gpio", "spi0"}, 4, 0),
 		d.makePin([]string{"P9.19", "uart1_rtsn", "gpio0_13"}, []string{"gpio", "i2c2"}, 13, 0), // preassigned via DT in default config
 		d.makePin([]string{"P9.20", "uart1_ctsn", "gpio0_12"}, []string{"gpio", "i2c2"}, 12, 0), // preassigned via DT in default config
-		d.makePin([]string{"P9.21", "spi0_d0", "gpio0_3", "ehrpwm0B"}, []string{"gpio", "pwm0"}, 3, 0),
-		d.makePin([]string{"P9.22", "spi0_sclk", "gpio0_2", "ehrpwm0A"}, []string{"gpio", "pwm0"}, 2, 0),
+		d.makePin([]string{"P9.21", "spi0_d0", "gpio0_3", "ehrpwm0B"}, []string{"gpio", "pwm0", "spi0"}, 3, 0),
+		d.makePin([]string{"P9.22", "spi0_sclk", "gpio0_2", "ehrpwm0A"}, []string{"gpio", "pwm0", "spi0"}, 2, 0),
 		d.makePin([]string{"P9.23", "gpmc_a1", "gpio1_17"}, []string{"gpio"}, 49, 0),
-		d.makePin([]string{"P9.24", "uart1_txd", "gpio0_15"}, []string{"gpio"}, 15, 0),
+		d.makePin([]string{"P9.24", "uart1_txd", "gpio0_15"}, []string{"gpio", "serial1"}, 15, 0),
 		d.makePin([]string{"P9.25", "mcasp0_ahclkx", "gpio3_21"}, []string{"gpio", "mcasp0", "preallocated"}, 117, 0), // preassigned via DT in default config
-		d.makePin([]string{"P9.26", "uart1_rxd", "gpio0_14"}, []string{"gpio"}, 14, 0),
+		d.makePin([]string{"P9.26", "uart1_rxd", "gpio0_14"}, []string{"gpio", "serial1"}, 14, 0),
 		d.makePin([]string{"P9.27", "mcasp0_fsr", "gpio3_19"}, []string{"gpio"}, 115, 0),
 		d.makePin([]string{"P9.28", "mcasp0_ahclkr", "gpio3_17"}, []string{"gpio", "mcasp0", "preallocated"}, 113, 0),      // preassigned via DT in default config
 		d.makePin([]string{"P9.29", "mcasp0_fsx", "gpio3_15"}, []string{"gpio", "mcasp0", "pwm0", "preallocated"}, 111, 0), // preassigned via DT in default config
@@ -163,8 +168,7 @@ func (d *BeagleBoneBlackDriver) createPinData() {
 func (d *BeagleBoneBlackDriver) initialiseModules() error {
 	d.modules = make(map[string]Module)
 
-	gpio := NewDTGPIOModule("gpio")
-	e := gpio.SetOptions(d.getGPIOOptions())
+	gpio, e := newDTGPIOModuleFromOptions("gpio", d.getGPIOOptions())
 	if e != nil {
 		return e
 	}
@@ -181,25 +185,33 @@ func (d *BeagleBoneBlackDriver) initialiseModules() error {
 		return e
 	}
 
+	spi0 := NewDTSPIModule("spi0")
+	e = spi0.SetOptions(d.getSPIOptions("spi0"))
+	if e != nil {
+		return e
+	}
+
+	serial1 := NewDTSerialModule("serial1")
+	e = serial1.SetOptions(d.getSerialOptions("serial1"))
+	if e != nil {
+		return e
+	}
+
 	preallocated := NewPreassignedModule("preallocated")
 	e = preallocated.SetOptions(d.getPreallocatedOptions())
 	if e != nil {
 		return e
 	}
 
-	pwm0 := NewBBPWMModule("pwm0")
-	e = pwm0.SetOptions(d.getPWMOptions("pwm0"))
+	pwm0, e := d.newPWMModule("pwm0")
 	if e != nil {
 		return e
 	}
-
-	pwm1 := NewBBPWMModule("pwm1")
-	e = pwm1.SetOptions(d.getPWMOptions("pwm1"))
+	pwm1, e := d.newPWMModule("pwm1")
 	if e != nil {
 		return e
 	}
-	pwm2 := NewBBPWMModule("pwm2")
-	e = pwm2.SetOptions(d.getPWMOptions("pwm2"))
+	pwm2, e := d.newPWMModule("pwm2")
 	if e != nil {
 		return e
 	}
@@ -214,6 +226,8 @@ func (d *BeagleBoneBlackDriver) initialiseModules() error {
 	d.modules["gpio"] = gpio
 	d.modules["analog"] = analog
 	d.modules["i2c2"] = i2c2
+	d.modules["spi0"] = spi0
+	d.modules["serial1"] = serial1
 	d.modules["pwm0"] = pwm0
 	d.modules["pwm1"] = pwm1
 	d.modules["pwm2"] = pwm2
@@ -222,6 +236,8 @@ func (d *BeagleBoneBlackDriver) initialiseModules() error {
 	// alias i2c to i2c2. This is for portability; getting the i2c module on any device should return the default i2c interface,
 	// but should not preclude addition of other i2c busses.
 	d.modules["i2c"] = i2c2
+	d.modules["spi"] = spi0
+	d.modules["serial"] = serial1
 
 	// these are the pre-allocated pins
 	d.modules["preallocated"] = preallocated
@@ -238,14 +254,20 @@ func (d *BeagleBoneBlackDriver) getGPIOOptions() map[string]interface{} {
 	result := make(map[string]interface{})
 
 	pins := make(DTGPIOModulePinDefMap)
+	pull := &bbConfigPinPullSetter{headers: make(map[int]string)}
 
 	// Add the GPIO pins to this map
 	for i, hw := range d.beaglePins {
 		if d.usedBy(hw, "gpio") {
 			pins[Pin(i)] = &DTGPIOModulePinDef{pin: Pin(i), gpioLogical: hw.gpioLogical}
+			pull.headers[hw.gpioLogical] = hw.names[0]
 		}
 	}
 	result["pins"] = pins
+	result["pull"] = pull
+
+	// The four 32-line GPIO banks, in the same order as the logical GPIO numbers.
+	result["chips"] = DTGPIOCdevChipList{"/dev/gpiochip0", "/dev/gpiochip1", "/dev/gpiochip2", "/dev/gpiochip3"}
 
 	return result
 }
@@ -286,6 +308,93 @@ func (d *BeagleBoneBlackDriver) getI2C2Options() map[string]interface{} {
 	return result
 }
 
+// Return the options for an SPI module. SPI0 is only available once its device tree overlay (BB-SPIDEV0) is loaded,
+// which makes P9.17, P9.18, P9.21 and P9.22 SPI pins. Like I2C, the kernel numbers the bus from 1, so SPI0 is
+// /dev/spidev1.0.
+func (d *BeagleBoneBlackDriver) getSPIOptions(name string) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(DTSPIModulePins, 0)
+	for i, hw := range d.beaglePins {
+		if d.usedBy(hw, name) {
+			pins = append(pins, Pin(i))
+		}
+	}
+	result["pins"] = pins
+	result["devices"] = DTSPIModuleDevices{"/dev/spidev1.0"}
+
+	return result
+}
+
+// Return the options for a serial module. UART1 is available once its device tree overlay (BB-UART1) is loaded, which
+// makes P9.24 and P9.26 its TX and RX pins. Older kernels call the port /dev/ttyO1 rather than /dev/ttyS1.
+func (d *BeagleBoneBlackDriver) getSerialOptions(name string) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(DTSerialModulePins, 0)
+	for i, hw := range d.beaglePins {
+		if d.usedBy(hw, name) {
+			pins = append(pins, Pin(i))
+		}
+	}
+	result["pins"] = pins
+	result["device"] = serialDeviceFile("/dev/ttyS1", "/dev/ttyO1")
+
+	return result
+}
+
+// The eHRPWM chip of each PWM module, by the address of its registers, and the channels of the header pins they drive:
+// channel 0 is output A and channel 1 is output B.
+var bbPWMChips = map[string]string{"pwm0": "48300200", "pwm1": "48302200", "pwm2": "48304200"}
+var bbPWMChannels = map[string]int{
+	"P9.22": 0, "P9.21": 1, "P9.31": 0, "P9.29": 1,
+	"P8.36": 0, "P8.34": 1,
+	"P8.19": 0, "P8.13": 1, "P8.45": 0,
+}
+
+// Create a PWM module. Kernels with the cape manager in /sys/devices/bone_capemgr.* (3.8) use the pwm_test overlays
+// of BBPWMModule. Later kernels have the PWM chips in /sys/class/pwm, though the pins have to be switched to PWM first,
+// e.g. with config-pin.
+func (d *BeagleBoneBlackDriver) newPWMModule(name string) (PWMModule, error) {
+	if slots, _ := findFirstMatchingFile("/sys/devices/bone_capemgr.*"); slots != "" {
+		pwm := NewBBPWMModule(name)
+		return pwm, pwm.SetOptions(d.getPWMOptions(name))
+	}
+	pwm := NewDTPWMModule(name)
+	return pwm, pwm.SetOptions(d.getDTPWMOptions(name))
+}
+
+func (d *BeagleBoneBlackDriver) getDTPWMOptions(name string) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	chip := bbPWMChip(bbPWMChips[name])
+	pins := make(DTPWMModulePinDefMap)
+	for i, hw := range d.beaglePins {
+		channel, ok := bbPWMChannels[hw.names[0]]
+		if ok && d.usedBy(hw, name) {
+			pins[Pin(i)] = &DTPWMModulePinDef{Chip: chip, Channel: channel}
+		}
+	}
+
+	result["pins"] = pins
+
+	return result
+}
+
+// Get the pattern for the directory of an eHRPWM chip. Kernels from 5.x put the PWM subsystem in a target module.
+func bbPWMChip(address string) string {
+	patterns := []string{
+		"/sys/devices/platform/ocp/*.epwmss/" + address + ".*/pwm/pwmchip*",
+		"/sys/devices/platform/ocp/*.target-module/*.epwmss/" + address + ".*/pwm/pwmchip*",
+	}
+	for _, pattern := range patterns {
+		if chip, _ := findFirstMatchingFile(pattern); chip != "" {
+			return pattern
+		}
+	}
+	return patterns[0]
+}
+
 func (d *BeagleBoneBlackDriver) getPWMOptions(name string) map[string]interface{} {
 	result := make(map[string]interface{})
 
@@ -317,6 +426,42 @@ func (d *BeagleBoneBlackDriver) getLEDOptions(name string) map[string]interface{
 	return result
 }
 
+// A DTGPIOPullSetter for BeagleBone, which sets pull resistors in the pinmux using config-pin. This needs a kernel
+// with the universal cape loaded.
+type bbConfigPinPullSetter struct {
+	// expansion header name of each logical GPIO, e.g. "P8.7"
+	headers map[int]string
+}
+
+func (s *bbConfigPinPullSetter) SetPull(gpioLogical int, mode PinIOMode) error {
+	header := s.headers[gpioLogical]
+	if header == "" {
+		return fmt.Errorf("GPIO %d is not on an expansion header", gpioLogical)
+	}
+
+	// config-pin wants P8_07 for P8.7
+	parts := strings.SplitN(header, ".", 2)
+	n, e := strconv.Atoi(parts[1])
+	if e != nil {
+		return e
+	}
+	name := fmt.Sprintf("%s_%02d", parts[0], n)
+
+	state := "in"
+	switch mode {
+	case INPUT_PULLUP:
+		state = "in+"
+	case INPUT_PULLDOWN:
+		state = "in-"
+	}
+
+	out, e := exec.Command("config-pin", name, state).CombinedOutput()
+	if e != nil {
+		return fmt.Errorf("config-pin %s %s failed: %s %s", name, state, e, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 // internal function to get a Pin. It does not use GetPin because that relies on the driver having already been initialised. This
 // method can be called while stil initialising. Only matches names[0], which is the Pn.nn expansion header name.
 func (d *BeagleBoneBlackDriver) getPin(name string) Pin {
@@ -3,8 +3,7 @@ package hwio
 // A driver for Odroid C1's running Ubuntu 14.04 with Linux kernel 3.8 or higher.
 //
 // Known issues:
-// - INPUT_PULLUP and INPUT_PULLDOWN not implemented yet.
-// - no support yet for SPI, serial, I2C
+// - INPUT_PULLUP and INPUT_PULLDOWN only work through the gpiochip interface, if the kernel supports bias.
 //
 // GPIO are 3.3V, analog is 1.8V
 //
@@ -66,7 +65,7 @@ func (d *OdroidC1Driver) createPinData() {
 		&DTPinConfig{[]string{"gpio104"}, []string{"gpio"}, 104, 0},        // 16
 		&DTPinConfig{[]string{"3.3v-2"}, []string{"unassignable"}, 0, 0},   // 17
 		&DTPinConfig{[]string{"gpio102"}, []string{"gpio"}, 102, 0},        // 18
-		&DTPinConfig{[]string{"mosi"}, []string{"spi"}, 0, 0},              // 19 - may be GPIO by default - CHECK
+		&DTPinConfig{[]string{"mosi"}, []string{"spi", "pwm"}, 0, 0},       // 19 - may be GPIO by default - CHECK
 		&DTPinConfig{[]string{"ground-4"}, []string{"unassignable"}, 0, 0}, // 20
 		&DTPinConfig{[]string{"miso"}, []string{"spi"}, 0, 0},              // 21 - may be GPIO by default - CHECK
 		&DTPinConfig{[]string{"gpio103"}, []string{"gpio"}, 103, 0},        // 22
@@ -80,7 +79,7 @@ func (d *OdroidC1Driver) createPinData() {
 		&DTPinConfig{[]string{"ground-6"}, []string{"unassignable"}, 0, 0}, // 30
 		&DTPinConfig{[]string{"gpio100"}, []string{"gpio"}, 100, 0},        // 31
 		&DTPinConfig{[]string{"gpio99"}, []string{"gpio"}, 99, 0},          // 32
-		&DTPinConfig{[]string{"gpio108"}, []string{"gpio"}, 108, 0},        // 33
+		&DTPinConfig{[]string{"gpio108"}, []string{"gpio", "pwm"}, 108, 0}, // 33
 		&DTPinConfig{[]string{"ground-7"}, []string{"unassignable"}, 0, 0}, // 34
 		&DTPinConfig{[]string{"gpio97"}, []string{"gpio"}, 97, 0},          // 35
 		&DTPinConfig{[]string{"gpio98"}, []string{"gpio"}, 98, 0},          // 36
@@ -94,8 +93,7 @@ func (d *OdroidC1Driver) createPinData() {
 func (d *OdroidC1Driver) initialiseModules() error {
 	d.modules = make(map[string]Module)
 
-	gpio := NewDTGPIOModule("gpio")
-	e := gpio.SetOptions(d.getGPIOOptions())
+	gpio, e := newDTGPIOModuleFromOptions("gpio", d.getGPIOOptions())
 	if e != nil {
 		return e
 	}
@@ -117,8 +115,29 @@ func (d *OdroidC1Driver) initialiseModules() error {
 		return e
 	}
 
+	spi := NewDTSPIModule("spi")
+	e = spi.SetOptions(d.getSPIOptions())
+	if e != nil {
+		return e
+	}
+
+	serial := NewDTSerialModule("serial")
+	e = serial.SetOptions(d.getSerialOptions())
+	if e != nil {
+		return e
+	}
+
+	pwm := NewDTPWMModule("pwm")
+	e = pwm.SetOptions(d.getPWMOptions())
+	if e != nil {
+		return e
+	}
+
 	d.modules["gpio"] = gpio
 	d.modules["analog"] = analog
+	d.modules["spi"] = spi
+	d.modules["serial"] = serial
+	d.modules["pwm"] = pwm
 	d.modules["i2ca"] = i2ca
 	d.modules["i2cb"] = i2cb
 
@@ -148,6 +167,9 @@ func (d *OdroidC1Driver) getGPIOOptions() map[string]interface{} {
 	}
 	result["pins"] = pins
 
+	// The header GPIOs are all on the first chip. This assumes its line offsets follow the sysfs GPIO numbering.
+	result["chips"] = DTGPIOCdevChipList{"/dev/gpiochip0"}
+
 	return result
 }
 
@@ -193,6 +215,51 @@ func (d *OdroidC1Driver) getI2COptions(module string) map[string]interface{} {
 	return result
 }
 
+// Return the options for the SPI module, which has a single chip select.
+func (d *OdroidC1Driver) getSPIOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(DTSPIModulePins, 0)
+	for i, pinConf := range d.pinConfigs {
+		if pinConf.usedBy("spi") {
+			pins = append(pins, Pin(i))
+		}
+	}
+	result["pins"] = pins
+	result["devices"] = DTSPIModuleDevices{"/dev/spidev0.0"}
+
+	return result
+}
+
+// Return the options for the serial module, the UART on the header's TXD and RXD pins.
+func (d *OdroidC1Driver) getSerialOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(DTSerialModulePins, 0)
+	for i, pinConf := range d.pinConfigs {
+		if pinConf.usedBy("serial") {
+			pins = append(pins, Pin(i))
+		}
+	}
+	result["pins"] = pins
+	result["device"] = "/dev/ttyS2"
+
+	return result
+}
+
+// Get the options for the PWM module. The chip has two channels, PWM0 on pin 33 and PWM1 on pin 19, once the driver is
+// loaded with both of them, e.g. with "modprobe pwm-meson npwm=2".
+func (d *OdroidC1Driver) getPWMOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(DTPWMModulePinDefMap)
+	pins[Pin(33)] = &DTPWMModulePinDef{Chip: "/sys/class/pwm/pwmchip0", Channel: 0}
+	pins[Pin(19)] = &DTPWMModulePinDef{Chip: "/sys/class/pwm/pwmchip0", Channel: 1}
+	result["pins"] = pins
+
+	return result
+}
+
 // internal function to get a Pin. It does not use GetPin because that relies on the driver having already been initialised. This
 // method can be called while still initialising. Only matches names[0], which is the Pn.nn expansion header name.
 func (d *OdroidC1Driver) getPin(name string) Pin {
@@ -6,10 +6,6 @@ package hwio
 // - digital write on all support ed GPIO pins
 // - digital read on all GPIO pins, for modes INPUT.
 //
-// Known issues:
-// - INPUT_PULLUP and INPUT_PULLDOWN not implemented yet.
-// - no support yet for SPI, serial
-//
 // References:
 // - http://elinux.org/RPi_Low-level_peripherals
 // - https://projects.drogon.net/raspberry-pi/wiringpi/
@@ -36,6 +32,9 @@ type RaspberryPiDTDriver struct { // all pins understood by the driver
 
 	// a map of module names to module objects, created at initialisation
 	modules map[string]Module
+
+	// if true, the gpio module is a BCMGPIOModule using /dev/gpiomem
+	memoryMappedGPIO bool
 }
 
 func NewRaspPiDTDriver() *RaspberryPiDTDriver {
@@ -55,6 +54,16 @@ func (d *RaspberryPiDTDriver) MatchesHardwareConfig() bool {
 	return false
 }
 
+// Use the memory mapped GPIO module, which accesses the GPIO registers through /dev/gpiomem, instead of the kernel's
+// GPIO interfaces. This is much faster, but pins can't be watched for edges. It must be called before the driver is
+// initialised, e.g.
+//     d := hwio.NewRaspPiDTDriver()
+//     d.UseMemoryMappedGPIO(true)
+//     hwio.SetDriver(d)
+func (d *RaspberryPiDTDriver) UseMemoryMappedGPIO(use bool) {
+	d.memoryMappedGPIO = use
+}
+
 func (d *RaspberryPiDTDriver) Init() error {
 	d.createPinData()
 	d.initialiseModules()
@@ -74,12 +83,12 @@ func (d *RaspberryPiDTDriver) createPinData() {
 			{[]string{"do-not-connect-1"}, []string{"unassignable"}, 0, 0},
 			{[]string{"scl"}, []string{"i2c"}, 0, 0},
 			{[]string{"ground"}, []string{"unassignable"}, 0, 0},
-			{[]string{"gpio4"}, []string{"gpio"}, 4, 0},
+			{[]string{"gpio4"}, []string{"gpio", "onewire"}, 4, 0},
 			{[]string{"txd"}, []string{"serial"}, 0, 0},
 			{[]string{"do-not-connect-2"}, []string{"unassignable"}, 0, 0},
 			{[]string{"rxd"}, []string{"serial"}, 0, 0},
 			{[]string{"gpio17"}, []string{"gpio"}, 17, 0},
-			{[]string{"gpio18"}, []string{"gpio"}, 18, 0}, // also supports PWM
+			{[]string{"gpio18"}, []string{"gpio", "pwm"}, 18, 0}, // PWM0
 			{[]string{"gpio21"}, []string{"gpio"}, 21, 0},
 			{[]string{"do-not-connect-3"}, []string{"unassignable"}, 0, 0},
 			{[]string{"gpio22"}, []string{"gpio"}, 22, 0},
@@ -104,12 +113,12 @@ func (d *RaspberryPiDTDriver) createPinData() {
 			{[]string{"5v-2"}, []string{"unassignable"}, 0, 0},
 			{[]string{"scl"}, []string{"i2c"}, 0, 0},
 			{[]string{"ground-1"}, []string{"unassignable"}, 0, 0},
-			{[]string{"gpio4"}, []string{"gpio"}, 4, 0},
+			{[]string{"gpio4"}, []string{"gpio", "onewire"}, 4, 0},
 			{[]string{"txd"}, []string{"serial"}, 0, 0},
 			{[]string{"ground-2"}, []string{"unassignable"}, 0, 0},
 			{[]string{"rxd"}, []string{"serial"}, 0, 0},
 			{[]string{"gpio17"}, []string{"gpio"}, 17, 0},
-			{[]string{"gpio18"}, []string{"gpio"}, 18, 0}, // also supports PWM
+			{[]string{"gpio18"}, []string{"gpio", "pwm"}, 18, 0}, // PWM0
 			{[]string{"gpio27"}, []string{"gpio"}, 27, 0},
 			{[]string{"ground-3"}, []string{"unassignable"}, 0, 0},
 			{[]string{"gpio22"}, []string{"gpio"}, 22, 0},
@@ -121,9 +130,9 @@ func (d *RaspberryPiDTDriver) createPinData() {
 			{[]string{"miso"}, []string{"spi"}, 0, 0},
 			{[]string{"gpio25"}, []string{"gpio"}, 25, 0},
 			{[]string{"sclk"}, []string{"spi"}, 0, 0},
-			{[]string{"gpio8"}, []string{"gpio"}, 8, 0},
+			{[]string{"gpio8", "ce0n"}, []string{"gpio", "spi"}, 8, 0},
 			{[]string{"ground-5"}, []string{"unassignable"}, 0, 0},
-			{[]string{"gpio7"}, []string{"gpio"}, 7, 0},
+			{[]string{"gpio7", "ce1n"}, []string{"gpio", "spi"}, 7, 0},
 		}
 	case typeAplusBPlusZeroPi2: // B+
 		d.pinConfigs = []*DTPinConfig{
@@ -134,12 +143,12 @@ func (d *RaspberryPiDTDriver) createPinData() {
 			{[]string{"5v-2"}, []string{"unassignable"}, 0, 0},
 			{[]string{"scl"}, []string{"i2c"}, 0, 0},
 			{[]string{"ground-1"}, []string{"unassignable"}, 0, 0},
-			{[]string{"gpio4"}, []string{"gpio"}, 4, 0},
+			{[]string{"gpio4"}, []string{"gpio", "onewire"}, 4, 0},
 			{[]string{"txd"}, []string{"serial"}, 0, 0},
 			{[]string{"ground-2"}, []string{"unassignable"}, 0, 0},
 			{[]string{"rxd"}, []string{"serial"}, 0, 0},
 			{[]string{"gpio17"}, []string{"gpio"}, 17, 0},
-			{[]string{"gpio18"}, []string{"gpio"}, 18, 0}, // also supports PWM
+			{[]string{"gpio18"}, []string{"gpio", "pwm"}, 18, 0}, // PWM0
 			{[]string{"gpio27"}, []string{"gpio"}, 21, 0},
 			{[]string{"ground-3"}, []string{"unassignable"}, 0, 0},
 			{[]string{"gpio22"}, []string{"gpio"}, 22, 0},
@@ -151,18 +160,18 @@ func (d *RaspberryPiDTDriver) createPinData() {
 			{[]string{"miso"}, []string{"spi"}, 0, 0},
 			{[]string{"gpio25"}, []string{"gpio"}, 25, 0},
 			{[]string{"sclk"}, []string{"spi"}, 0, 0},
-			{[]string{"gpio8"}, []string{"gpio"}, 8, 0},
+			{[]string{"gpio8", "ce0n"}, []string{"gpio", "spi"}, 8, 0},
 			{[]string{"ground-5"}, []string{"unassignable"}, 0, 0},
-			{[]string{"gpio7"}, []string{"gpio"}, 7, 0},
+			{[]string{"gpio7", "ce1n"}, []string{"gpio", "spi"}, 7, 0},
 			{[]string{"do-not-connect-1"}, []string{"unassignable"}, 0, 0},
 			{[]string{"do-not-connect-2"}, []string{"unassignable"}, 0, 0},
 			{[]string{"gpio5"}, []string{"gpio"}, 5, 0},
 			{[]string{"ground-6"}, []string{"unassignable"}, 0, 0},
 			{[]string{"gpio6"}, []string{"gpio"}, 6, 0},
-			{[]string{"gpio12"}, []string{"gpio"}, 12, 0},
-			{[]string{"gpio13"}, []string{"gpio"}, 13, 0},
+			{[]string{"gpio12"}, []string{"gpio", "pwm"}, 12, 0}, // PWM0
+			{[]string{"gpio13"}, []string{"gpio", "pwm"}, 13, 0}, // PWM1
 			{[]string{"ground-7"}, []string{"unassignable"}, 0, 0},
-			{[]string{"gpio19"}, []string{"gpio"}, 19, 0},
+			{[]string{"gpio19"}, []string{"gpio", "pwm"}, 19, 0}, // PWM1
 			{[]string{"gpio16"}, []string{"gpio"}, 16, 0},
 			{[]string{"gpio26"}, []string{"gpio"}, 26, 0},
 			{[]string{"gpio20"}, []string{"gpio"}, 20, 0},
@@ -175,8 +184,14 @@ func (d *RaspberryPiDTDriver) createPinData() {
 func (d *RaspberryPiDTDriver) initialiseModules() error {
 	d.modules = make(map[string]Module)
 
-	gpio := NewDTGPIOModule("gpio")
-	e := gpio.SetOptions(d.getGPIOOptions())
+	var gpio GPIOModule
+	var e error
+	if d.memoryMappedGPIO {
+		gpio = NewBCMGPIOModule("gpio")
+		e = gpio.SetOptions(d.getGPIOOptions())
+	} else {
+		gpio, e = newDTGPIOModuleFromOptions("gpio", d.getGPIOOptions())
+	}
 	if e != nil {
 		return e
 	}
@@ -187,6 +202,30 @@ func (d *RaspberryPiDTDriver) initialiseModules() error {
 		return e
 	}
 
+	spi := NewDTSPIModule("spi")
+	e = spi.SetOptions(d.getSPIOptions())
+	if e != nil {
+		return e
+	}
+
+	serial := NewDTSerialModule("serial")
+	e = serial.SetOptions(d.getSerialOptions())
+	if e != nil {
+		return e
+	}
+
+	onewire := NewDTOneWireModule("onewire")
+	e = onewire.SetOptions(d.getOneWireOptions())
+	if e != nil {
+		return e
+	}
+
+	pwm := NewDTPWMModule("pwm")
+	e = pwm.SetOptions(d.getPWMOptions())
+	if e != nil {
+		return e
+	}
+
 	// Create the leds module which is BBB-specific. There are no options.
 	leds := NewDTLEDModule("leds")
 	e = leds.SetOptions(d.getLEDOptions("leds"))
@@ -196,6 +235,10 @@ func (d *RaspberryPiDTDriver) initialiseModules() error {
 
 	d.modules["gpio"] = gpio
 	d.modules["i2c"] = i2c
+	d.modules["spi"] = spi
+	d.modules["serial"] = serial
+	d.modules["onewire"] = onewire
+	d.modules["pwm"] = pwm
 	d.modules["leds"] = leds
 
 	return nil
@@ -215,6 +258,12 @@ func (d *RaspberryPiDTDriver) getGPIOOptions() map[string]interface{} {
 	}
 	result["pins"] = pins
 
+	// All BCM GPIOs are on the first chip, with line offsets matching the GPIO numbers.
+	result["chips"] = DTGPIOCdevChipList{"/dev/gpiochip0"}
+
+	// Pull resistors are set directly in the GPIO registers.
+	result["pull"] = &bcmGPIOPullSetter{}
+
 	return result
 }
 
@@ -236,6 +285,79 @@ func (d *RaspberryPiDTDriver) getI2COptions() map[string]interface{} {
 	return result
 }
 
+// Get the options for the SPI module. SPI0 has two chip selects, and needs to be enabled in the kernel first, e.g. with
+// dtparam=spi=on in /boot/config.txt.
+func (d *RaspberryPiDTDriver) getSPIOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(DTSPIModulePins, 0)
+	for i, hw := range d.pinConfigs {
+		if hw.usedBy("spi") {
+			pins = append(pins, Pin(i))
+		}
+	}
+	result["pins"] = pins
+	result["devices"] = DTSPIModuleDevices{"/dev/spidev0.0", "/dev/spidev0.1"}
+
+	return result
+}
+
+// Get the options for the serial module, which is the UART on the header's TXD and RXD pins. /dev/serial0 is a link to
+// whichever UART is on those pins, as on boards with Bluetooth it is the mini UART, /dev/ttyS0. It needs the serial
+// console to be turned off first, e.g. with raspi-config.
+func (d *RaspberryPiDTDriver) getSerialOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(DTSerialModulePins, 0)
+	for i, hw := range d.pinConfigs {
+		if hw.usedBy("serial") {
+			pins = append(pins, Pin(i))
+		}
+	}
+	result["pins"] = pins
+	result["device"] = serialDeviceFile("/dev/serial0", "/dev/ttyAMA0")
+
+	return result
+}
+
+// Get the options for the 1-Wire module, which is the bus the w1-gpio overlay puts on GPIO4 by default. It needs to be
+// enabled first, e.g. with dtoverlay=w1-gpio in /boot/config.txt.
+func (d *RaspberryPiDTDriver) getOneWireOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(DTOneWireModulePins, 0)
+	for i, hw := range d.pinConfigs {
+		if hw.usedBy("onewire") {
+			pins = append(pins, Pin(i))
+		}
+	}
+	result["pins"] = pins
+
+	return result
+}
+
+// Get the options for the PWM module. The PWM controller has two channels, which can each be on either of two GPIOs:
+// PWM0 on GPIO12 (pin 32) or GPIO18 (pin 12), and PWM1 on GPIO13 (pin 33) or GPIO19 (pin 35). The pwm or pwm-2chan
+// overlay has to be loaded first, and it chooses the GPIO for each channel, e.g. with
+// dtoverlay=pwm-2chan,pin=12,func=4,pin2=13,func2=4 in /boot/config.txt for pins 32 and 33.
+func (d *RaspberryPiDTDriver) getPWMOptions() map[string]interface{} {
+	result := make(map[string]interface{})
+
+	// the controller is found by its address, which differs between models, rather than by its chip number
+	chip := "/sys/devices/platform/soc/*20c000.pwm/pwm/pwmchip*"
+	channels := map[int]int{12: 0, 18: 0, 13: 1, 19: 1}
+
+	pins := make(DTPWMModulePinDefMap)
+	for i, hw := range d.pinConfigs {
+		if hw.usedBy("pwm") {
+			pins[Pin(i)] = &DTPWMModulePinDef{Chip: chip, Channel: channels[hw.gpioLogical]}
+		}
+	}
+	result["pins"] = pins
+
+	return result
+}
+
 func (d *RaspberryPiDTDriver) getLEDOptions(name string) map[string]interface{} {
 	result := make(map[string]interface{})
 
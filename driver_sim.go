@@ -0,0 +1,151 @@
+package hwio
+
+// A driver that simulates a board, so that code using hwio can be tested without hardware. The pin layout is declared
+// when the driver is created, and the modules the pins refer to are simulated. Each simulated module has Mock* methods
+// to inject inputs and inspect outputs.
+//
+// Usage:
+//	driver := hwio.NewSimDriver([]*hwio.SimPinDef{
+//		{Names: []string{"P1", "gpio1"}, Modules: []string{"gpio"}},
+//		{Names: []string{"P2", "pwm0"}, Modules: []string{"gpio", "pwm"}},
+//		{Names: []string{"P3", "ain0"}, Modules: []string{"analog"}},
+//	}, []string{"usr0"})
+//	hwio.SetDriver(driver)
+//	gpio := driver.GetModules()["gpio"].(*hwio.SimGPIOModule)
+//	gpio.MockSetPinValue(0, hwio.HIGH)
+//
+// Pins can also be connected to each other with wires, see SimWire.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Definition of a simulated pin. The kind of each module is taken from the start of its name: "gpio", "analog", "pwm",
+// "i2c" or "spi", so "i2c1" is a simulated I2C bus.
+type SimPinDef struct {
+	Names   []string
+	Modules []string
+}
+
+// Map of pins to their definitions, passed to simulated modules as their "pins" option.
+type SimPinMap map[Pin]*SimPinDef
+
+type SimDriver struct {
+	// Pins are numbered by their position in this list
+	pinDefs []*SimPinDef
+
+	// Names of the on-board LEDs. If there are any, they are provided by a "leds" module.
+	leds []string
+
+	// Wires between pins, which are connected when the driver is initialised
+	wires []SimWire
+
+	modules map[string]Module
+}
+
+// Create a simulated driver with the given pins and LEDs.
+func NewSimDriver(pins []*SimPinDef, leds []string) *SimDriver {
+	return &SimDriver{pinDefs: pins, leds: leds}
+}
+
+func (d *SimDriver) Init() error {
+	d.modules = make(map[string]Module)
+
+	for _, hw := range d.pinDefs {
+		for _, name := range hw.Modules {
+			if d.modules[name] != nil {
+				continue
+			}
+
+			module, e := newSimModule(name)
+			if e != nil {
+				return e
+			}
+			e = module.SetOptions(d.getModuleOptions(name))
+			if e != nil {
+				return e
+			}
+			d.modules[name] = module
+		}
+	}
+
+	if len(d.leds) > 0 {
+		leds := NewSimLEDModule("leds")
+		e := leds.SetOptions(map[string]interface{}{"leds": d.leds})
+		if e != nil {
+			return e
+		}
+		d.modules["leds"] = leds
+	}
+
+	for _, wire := range d.wires {
+		e := d.connectWire(wire)
+		if e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// Create a simulated module of the kind given by the start of its name.
+func newSimModule(name string) (Module, error) {
+	switch {
+	case strings.HasPrefix(name, "gpio"):
+		return NewSimGPIOModule(name), nil
+	case strings.HasPrefix(name, "analog"):
+		return NewSimAnalogModule(name), nil
+	case strings.HasPrefix(name, "pwm"):
+		return NewSimPWMModule(name), nil
+	case strings.HasPrefix(name, "i2c"):
+		return NewSimI2CModule(name), nil
+	case strings.HasPrefix(name, "spi"):
+		return NewSimSPIModule(name), nil
+	}
+	return nil, fmt.Errorf("Simulating module '%s' is %w, its name should start with gpio, analog, pwm, i2c or spi", name, ErrUnsupported)
+}
+
+// Get the options for a module, which are the pins that list it.
+func (d *SimDriver) getModuleOptions(module string) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	pins := make(SimPinMap)
+	for i, hw := range d.pinDefs {
+		for _, name := range hw.Modules {
+			if name == module {
+				pins[Pin(i)] = hw
+			}
+		}
+	}
+	result["pins"] = pins
+
+	return result
+}
+
+func (d *SimDriver) Close() {
+	// Disable all the modules
+	for _, module := range d.modules {
+		module.Disable()
+	}
+}
+
+// A simulated board can be used anywhere, but is never chosen by autodetection, as it isn't one of the drivers that
+// are tried.
+func (d *SimDriver) MatchesHardwareConfig() bool {
+	return true
+}
+
+func (d *SimDriver) PinMap() HardwarePinMap {
+	result := make(HardwarePinMap)
+
+	for i, hw := range d.pinDefs {
+		result.Add(Pin(i), hw.Names, hw.Modules)
+	}
+
+	return result
+}
+
+func (d *SimDriver) GetModules() map[string]Module {
+	return d.modules
+}
@@ -0,0 +1,516 @@
+package hwio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+func newTestSimBoard(t *testing.T) (*Board, *SimDriver) {
+	driver := NewSimDriver([]*SimPinDef{
+		{Names: []string{"P1", "gpio1"}, Modules: []string{"gpio"}},
+		{Names: []string{"P2", "pwm0"}, Modules: []string{"gpio", "pwm"}},
+		{Names: []string{"P3", "ain0"}, Modules: []string{"analog"}},
+		{Names: []string{"P4", "sda"}, Modules: []string{"i2c1"}},
+		{Names: []string{"P5", "scl"}, Modules: []string{"i2c1"}},
+		{Names: []string{"P6", "mosi"}, Modules: []string{"spi0"}},
+	}, []string{"usr0"})
+
+	board, e := NewBoard(driver)
+	if e != nil {
+		t.Fatalf("NewBoard returned an unexpected error: %s", e)
+	}
+	return board, driver
+}
+
+func TestSimDriverModules(t *testing.T) {
+	board, driver := newTestSimBoard(t)
+	defer board.Close()
+
+	modules := driver.GetModules()
+	for _, name := range []string{"gpio", "pwm", "analog", "i2c1", "spi0", "leds"} {
+		if modules[name] == nil {
+			t.Errorf("Expected the driver to have a '%s' module", name)
+		}
+	}
+
+	pin, e := board.GetPin("pwm0")
+	if e != nil || pin != 1 {
+		t.Errorf("Expected pwm0 to be pin 1, got %d, %v", pin, e)
+	}
+
+	if _, e = NewBoard(NewSimDriver([]*SimPinDef{{Names: []string{"P1"}, Modules: []string{"uart"}}}, nil)); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for a module that can't be simulated, got %v", e)
+	}
+}
+
+func TestSimGPIO(t *testing.T) {
+	board, driver := newTestSimBoard(t)
+	defer board.Close()
+	gpio := driver.GetModules()["gpio"].(*SimGPIOModule)
+
+	if e := board.DigitalWrite(0, HIGH); !errors.Is(e, ErrNotOpen) {
+		t.Errorf("Expected ErrNotOpen writing a pin before PinMode, got %v", e)
+	}
+
+	board.PinMode(0, INPUT)
+	if e := board.DigitalWrite(0, HIGH); !errors.Is(e, ErrWrongMode) {
+		t.Errorf("Expected ErrWrongMode writing an input, got %v", e)
+	}
+	gpio.MockSetPinValue(0, HIGH)
+	if v, _ := board.DigitalRead(0); v != HIGH {
+		t.Error("Expected DigitalRead to return the value set by MockSetPinValue")
+	}
+
+	board.PinMode(0, OUTPUT)
+	board.DigitalWrite(0, LOW)
+	if gpio.MockGetPinValue(0) != LOW {
+		t.Error("Expected MockGetPinValue to return the value written")
+	}
+
+	board.ClosePin(0)
+	if e := board.PinMode(0, OUTPUT); e != nil {
+		t.Errorf("PinMode after ClosePin returned an unexpected error: %s", e)
+	}
+}
+
+func TestSimPWM(t *testing.T) {
+	board, driver := newTestSimBoard(t)
+	defer board.Close()
+	pwm := driver.GetModules()["pwm"].(*SimPWMModule)
+
+	if e := pwm.SetPeriod(1, 20000000); !errors.Is(e, ErrNotOpen) {
+		t.Errorf("Expected ErrNotOpen setting the period before EnablePin, got %v", e)
+	}
+
+	pwm.EnablePin(1, true)
+	pwm.SetPeriod(1, 20000000)
+	pwm.SetDuty(1, 1500000)
+	enabled, period, duty := pwm.MockGetPWM(1)
+	if !enabled || period != 20000000 || duty != 1500000 {
+		t.Errorf("Unexpected PWM settings: %v, %d, %d", enabled, period, duty)
+	}
+
+	// the pin is shared with gpio, which can't have it while PWM does
+	if e := board.PinMode(1, OUTPUT); !errors.Is(e, ErrPinInUse) {
+		t.Errorf("Expected ErrPinInUse opening a PWM pin as GPIO, got %v", e)
+	}
+}
+
+func TestSimPWMSettings(t *testing.T) {
+	board, driver := newTestSimBoard(t)
+	defer board.Close()
+	var pwm PWMModule = driver.GetModules()["pwm"].(*SimPWMModule)
+
+	if enabled, e := pwm.IsPinEnabled(1); e != nil || enabled {
+		t.Errorf("Expected a pin that has never been enabled to be disabled, got %v, %v", enabled, e)
+	}
+	pwm.EnablePin(1, true)
+	pwm.SetPeriod(1, 20000000)
+
+	var dutyError *PWMDutyError
+	if e := pwm.SetDuty(1, 30000000); !errors.As(e, &dutyError) || dutyError.Period != 20000000 {
+		t.Errorf("Expected a PWMDutyError for a duty time longer than the period, got %v", e)
+	}
+	if e := pwm.SetDutyCycle(1, 0.25); e != nil {
+		t.Errorf("SetDutyCycle returned an unexpected error: %s", e)
+	}
+	if duty, e := pwm.GetDuty(1); e != nil || duty != 5000000 {
+		t.Errorf("Expected a quarter of the period, got %d, %v", duty, e)
+	}
+	if e := pwm.SetDutyCycle(1, 1.5); !errors.As(e, &dutyError) {
+		t.Errorf("Expected a PWMDutyError for a duty cycle over 1, got %v", e)
+	}
+
+	// a period shorter than the duty time is rejected, unless the duty time is scaled with it
+	if e := pwm.SetPeriod(1, 4000000); !errors.As(e, &dutyError) {
+		t.Errorf("Expected a PWMDutyError for a period shorter than the duty time, got %v", e)
+	}
+	if e := pwm.SetPeriodKeepDutyCycle(1, 4000000); e != nil {
+		t.Errorf("SetPeriodKeepDutyCycle returned an unexpected error: %s", e)
+	}
+	period, _ := pwm.GetPeriod(1)
+	duty, _ := pwm.GetDuty(1)
+	if period != 4000000 || duty != 1000000 {
+		t.Errorf("Expected the duty cycle to stay at a quarter, got %d of %d", duty, period)
+	}
+
+	if polarity, e := pwm.GetPolarity(1); e != nil || polarity != PWM_POLARITY_NORMAL {
+		t.Errorf("Expected the polarity to start normal, got %d, %v", polarity, e)
+	}
+	pwm.SetPolarity(1, PWM_POLARITY_INVERSED)
+	if polarity, _ := pwm.GetPolarity(1); polarity != PWM_POLARITY_INVERSED {
+		t.Errorf("Expected the polarity to be inversed, got %d", polarity)
+	}
+
+	pwm.EnablePin(1, false)
+	if enabled, e := pwm.IsPinEnabled(1); e != nil || enabled {
+		t.Errorf("Expected the pin to be disabled, got %v, %v", enabled, e)
+	}
+}
+
+func TestSimAnalog(t *testing.T) {
+	board, driver := newTestSimBoard(t)
+	defer board.Close()
+	analog := driver.GetModules()["analog"].(*SimAnalogModule)
+
+	analog.MockSetAnalogValue(2, 512)
+	if v, e := board.AnalogRead(2); e != nil || v != 512 {
+		t.Errorf("Expected AnalogRead to return 512, got %d, %v", v, e)
+	}
+	if _, e := board.AnalogRead(0); !errors.Is(e, ErrUnknownPin) {
+		t.Errorf("Expected ErrUnknownPin reading a pin that isn't analog, got %v", e)
+	}
+}
+
+// A device with a single register that remembers what was written.
+type testSimI2CDevice struct {
+	value byte
+}
+
+func (d *testSimI2CDevice) ReadByte(command byte) (byte, error) {
+	return d.value, nil
+}
+
+func (d *testSimI2CDevice) WriteByte(command byte, value byte) error {
+	d.value = value
+	return nil
+}
+
+func (d *testSimI2CDevice) Read(command byte, numBytes int) ([]byte, error) {
+	result := make([]byte, numBytes)
+	for i := range result {
+		result[i] = d.value
+	}
+	return result, nil
+}
+
+func (d *testSimI2CDevice) Write(command byte, buffer []byte) error {
+	if len(buffer) > 0 {
+		d.value = buffer[len(buffer)-1]
+	}
+	return nil
+}
+
+func TestSimAnalogWrite(t *testing.T) {
+	board, driver := newTestSimBoard(t)
+	defer board.Close()
+	pwm := driver.GetModules()["pwm"].(*SimPWMModule)
+
+	// the pin is enabled on the first write, at 1kHz
+	if e := board.AnalogWrite(1, 51); e != nil {
+		t.Fatalf("AnalogWrite returned an unexpected error: %s", e)
+	}
+	if enabled, _ := pwm.IsPinEnabled(1); !enabled {
+		t.Errorf("Expected AnalogWrite to enable the pin")
+	}
+	period, _ := pwm.GetPeriod(1)
+	duty, _ := pwm.GetDuty(1)
+	if period != 1000000 || duty != 200000 {
+		t.Errorf("Expected a period of 1ms with 20%% duty, got %d and %d", period, duty)
+	}
+
+	if e := board.AnalogWriteResolution(10); e != nil {
+		t.Fatalf("AnalogWriteResolution returned an unexpected error: %s", e)
+	}
+	if e := board.AnalogWriteFrequency(50); e != nil {
+		t.Fatalf("AnalogWriteFrequency returned an unexpected error: %s", e)
+	}
+	if e := board.AnalogWrite(1, 1023); e != nil {
+		t.Fatalf("AnalogWrite returned an unexpected error: %s", e)
+	}
+	period, _ = pwm.GetPeriod(1)
+	duty, _ = pwm.GetDuty(1)
+	if period != 20000000 || duty != period {
+		t.Errorf("Expected a period of 20ms at full duty, got %d and %d", period, duty)
+	}
+
+	var pinError *PinError
+	if e := board.AnalogWrite(1, 1024); !errors.As(e, &pinError) || pinError.Pin != 1 {
+		t.Errorf("Expected a PinError for a value out of range, got %v", e)
+	}
+	if e := board.AnalogWrite(0, 10); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported writing to a pin without PWM, got %v", e)
+	}
+	if e := board.AnalogWrite(10, 10); !errors.Is(e, ErrUnknownPin) {
+		t.Errorf("Expected ErrUnknownPin writing to a pin that doesn't exist, got %v", e)
+	}
+	if e := board.AnalogWriteResolution(0); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for a resolution of 0 bits, got %v", e)
+	}
+	if e := board.AnalogWriteFrequency(0); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for a frequency of 0, got %v", e)
+	}
+}
+
+// A PWM module that counts the times it is enabled. Like the BeagleBone module, it only reports a pin as enabled
+// once it is running, which here is never.
+type testCountingPWMModule struct {
+	*SimPWMModule
+	enables int
+}
+
+func (module *testCountingPWMModule) Enable() error {
+	module.enables++
+	return module.SimPWMModule.Enable()
+}
+
+func (module *testCountingPWMModule) IsPinEnabled(pin Pin) (bool, error) {
+	return false, nil
+}
+
+func TestSimAnalogWriteEnablesModuleOnce(t *testing.T) {
+	board, driver := newTestSimBoard(t)
+	defer board.Close()
+	pwm := &testCountingPWMModule{SimPWMModule: driver.GetModules()["pwm"].(*SimPWMModule)}
+	driver.GetModules()["pwm"] = pwm
+
+	for _, value := range []int{51, 102, 255} {
+		if e := board.AnalogWrite(1, value); e != nil {
+			t.Fatalf("AnalogWrite returned an unexpected error: %s", e)
+		}
+	}
+	if pwm.enables != 1 {
+		t.Errorf("Expected AnalogWrite to enable the module once, got %d times", pwm.enables)
+	}
+	if duty, _ := pwm.GetDuty(1); duty != 1000000 {
+		t.Errorf("Expected full duty after the last write, got %d", duty)
+	}
+}
+
+func TestSimI2C(t *testing.T) {
+	board, driver := newTestSimBoard(t)
+	defer board.Close()
+	i2c := driver.GetModules()["i2c1"].(*SimI2CModule)
+
+	device := i2c.GetDevice(0x40)
+	if _, e := device.ReadByte(0); !errors.Is(e, ErrClosed) {
+		t.Errorf("Expected ErrClosed using a bus that isn't enabled, got %v", e)
+	}
+
+	i2c.Enable()
+	if e := board.PinMode(3, OUTPUT); !errors.Is(e, ErrUnknownPin) {
+		t.Errorf("Expected I2C pin not to be usable as GPIO, got %v", e)
+	}
+	if _, e := device.ReadByte(0); !errors.Is(e, syscall.ENXIO) {
+		t.Errorf("Expected ENXIO from an address with no device, got %v", e)
+	}
+
+	i2c.MockAttachDevice(0x40, &testSimI2CDevice{})
+	device.WriteByte(0, 0x5a)
+	if v, e := device.ReadByte(0); e != nil || v != 0x5a {
+		t.Errorf("Expected to read back 0x5a, got 0x%02x, %v", v, e)
+	}
+}
+
+// A device that sends back each byte it receives, plus one.
+type testSimSPIDevice struct{}
+
+func (d testSimSPIDevice) Transfer(write []byte, read []byte) error {
+	for i, b := range write {
+		read[i] = b + 1
+	}
+	return nil
+}
+
+func TestSimSPI(t *testing.T) {
+	board, driver := newTestSimBoard(t)
+	defer board.Close()
+	spi := driver.GetModules()["spi0"].(*SimSPIModule)
+	spi.Enable()
+
+	spi.GetDevice(0).Tx([]byte{1, 2}, nil)
+	if w := spi.MockGetWritten(0); len(w) != 2 || w[0] != 1 || w[1] != 2 {
+		t.Errorf("Unexpected bytes written: %v", w)
+	}
+
+	spi.MockAttachDevice(1, testSimSPIDevice{})
+	data := make([]byte, 2)
+	if e := spi.GetDevice(1).Tx(nil, data); e != nil || data[0] != 1 {
+		t.Errorf("Unexpected read from device: %v, %v", data, e)
+	}
+
+	// devices on the same bus keep their own settings
+	adc := spi.GetDevice(1)
+	adc.Configure(SPIConfig{Mode: SPI_MODE_3, MaxSpeedHz: 1000000})
+	display := spi.GetDevice(0)
+	display.Configure(SPIConfig{Mode: SPI_MODE_0})
+
+	read := make([]byte, 3)
+	e := adc.TxSegments([]SPISegment{{Write: []byte{0x10}}, {Write: []byte{0, 0}, Read: read[1:]}})
+	if e != nil || read[1] != 1 || read[2] != 1 {
+		t.Errorf("Unexpected read from segments: %v, %v", read, e)
+	}
+	if c := spi.MockGetConfig(1); c.Mode != SPI_MODE_3 || c.MaxSpeedHz != 1000000 {
+		t.Errorf("Expected the ADC's settings for its transfer, got %+v", c)
+	}
+	display.Tx([]byte{0xff}, nil)
+	if c := spi.MockGetConfig(0); c.Mode != SPI_MODE_0 {
+		t.Errorf("Expected the display's settings for its transfer, got %+v", c)
+	}
+	if e = adc.Tx(make([]byte, 2), make([]byte, 1)); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for buffers of different lengths, got %v", e)
+	}
+}
+
+func TestSimLED(t *testing.T) {
+	board, driver := newTestSimBoard(t)
+	defer board.Close()
+	leds := driver.GetModules()["leds"].(*SimLEDModule)
+
+	if e := board.Led("usr0", true); e != nil {
+		t.Fatalf("Led returned an unexpected error: %s", e)
+	}
+	led, _ := leds.GetLED("usr0")
+	if !led.(*SimLED).MockIsOn() || led.(*SimLED).MockGetTrigger() != "none" {
+		t.Error("Expected the LED to be on with its trigger set to 'none'")
+	}
+	if _, e := leds.GetLED("usr9"); !errors.Is(e, ErrUnknownPin) {
+		t.Errorf("Expected ErrUnknownPin for an unknown LED, got %v", e)
+	}
+}
+
+// A device that records the transactions it gets.
+type testSimI2CTxDevice struct {
+	testSimI2CDevice
+	messages []I2CMessage
+}
+
+func (d *testSimI2CTxDevice) Tx(messages []I2CMessage) error {
+	d.messages = append(d.messages, messages...)
+	return nil
+}
+
+func TestSimI2CTx(t *testing.T) {
+	board, driver := newTestSimBoard(t)
+	defer board.Close()
+	i2c := driver.GetModules()["i2c1"].(*SimI2CModule)
+	i2c.Enable()
+
+	// a device with only I2CDevice gets register reads and writes
+	i2c.MockAttachDevice(0x40, &testSimI2CDevice{})
+	device := i2c.GetDevice(0x40).(I2CTxDevice)
+	e := device.Tx([]I2CMessage{{Data: []byte{0, 0x33}}})
+	if e != nil {
+		t.Fatalf("Tx returned an unexpected error: %s", e)
+	}
+	read := make([]byte, 1)
+	e = device.Tx([]I2CMessage{{Data: []byte{0}}, {Flags: I2C_M_RD, Data: read}})
+	if e != nil || read[0] != 0x33 {
+		t.Errorf("Expected to read back 0x33 with a write-read transaction, got 0x%02x, %v", read[0], e)
+	}
+	if e = device.Tx([]I2CMessage{{Flags: I2C_M_RD, Data: read}}); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for a read without a register, got %v", e)
+	}
+
+	// a device with I2CTxDevice gets the messages as they are
+	tx := &testSimI2CTxDevice{}
+	i2c.MockAttachDevice(0x41, tx)
+	e = i2c.GetDevice(0x41).(I2CTxDevice).Tx([]I2CMessage{{Flags: I2C_M_RD, Data: make([]byte, 100)}})
+	if e != nil || len(tx.messages) != 1 || len(tx.messages[0].Data) != 100 {
+		t.Errorf("Expected the transaction to be passed to the device, got %v", e)
+	}
+}
+
+// A device that implements I2CSMBusDevice on top of testSimI2CDevice.
+type testSimSMBusDevice struct {
+	testSimI2CDevice
+	block []byte
+}
+
+func (d *testSimSMBusDevice) QuickCommand(read bool) error            { return nil }
+func (d *testSimSMBusDevice) ReceiveByte() (byte, error)              { return d.value, nil }
+func (d *testSimSMBusDevice) SendByte(value byte) error               { d.value = value; return nil }
+func (d *testSimSMBusDevice) ReadWord(command byte) (uint16, error)   { return 0x1234, nil }
+func (d *testSimSMBusDevice) WriteWord(command byte, v uint16) error  { return nil }
+func (d *testSimSMBusDevice) BlockRead(command byte) ([]byte, error)  { return d.block, nil }
+func (d *testSimSMBusDevice) BlockWrite(command byte, b []byte) error { d.block = b; return nil }
+func (d *testSimSMBusDevice) ProcessCall(command byte, v uint16) (uint16, error) {
+	return v + 1, nil
+}
+func (d *testSimSMBusDevice) BlockProcessCall(command byte, b []byte) ([]byte, error) {
+	return append(b, 0), nil
+}
+
+func TestSimI2CSMBus(t *testing.T) {
+	board, driver := newTestSimBoard(t)
+	defer board.Close()
+	i2c := driver.GetModules()["i2c1"].(*SimI2CModule)
+	i2c.Enable()
+
+	// words are translated to two byte reads and writes for devices that only implement I2CDevice
+	i2c.MockAttachDevice(0x40, &testSimI2CDevice{})
+	device := i2c.GetDevice(0x40).(I2CSMBusDevice)
+	if e := device.QuickCommand(false); e != nil {
+		t.Errorf("Expected a quick command to an attached device to succeed, got %s", e)
+	}
+	device.WriteWord(0, 0x1122)
+	if v, e := device.ReadWord(0); e != nil || v != 0x1111 {
+		t.Errorf("Expected ReadWord to give 0x1111, got 0x%04x, %v", v, e)
+	}
+	if _, e := device.BlockRead(0); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for a block read, got %v", e)
+	}
+	if e := i2c.GetDevice(0x41).(I2CSMBusDevice).QuickCommand(true); !errors.Is(e, syscall.ENXIO) {
+		t.Errorf("Expected ENXIO from a quick command with no device, got %v", e)
+	}
+
+	// devices that implement I2CSMBusDevice get the operations
+	i2c.MockAttachDevice(0x42, &testSimSMBusDevice{})
+	device = i2c.GetDevice(0x42).(I2CSMBusDevice)
+	device.SendByte(0x10)
+	if v, _ := device.ReceiveByte(); v != 0x10 {
+		t.Errorf("Expected to receive the byte sent, got 0x%02x", v)
+	}
+	device.BlockWrite(1, []byte{1, 2, 3})
+	if b, _ := device.BlockRead(1); len(b) != 3 {
+		t.Errorf("Expected to read back a block of 3 bytes, got %v", b)
+	}
+	if v, _ := device.ProcessCall(2, 5); v != 6 {
+		t.Errorf("Expected the process call to return 6, got %d", v)
+	}
+
+	if v, e := ReadI2CWord(device, 0, binary.LittleEndian); e != nil || v != 0x1234 {
+		t.Errorf("Expected a little endian word of 0x1234, got 0x%04x, %v", v, e)
+	}
+	if v, e := ReadI2CWord(device, 0, binary.BigEndian); e != nil || v != 0x3412 {
+		t.Errorf("Expected a big endian word of 0x3412, got 0x%04x, %v", v, e)
+	}
+}
+
+// A device that doesn't answer receive bytes, so is hidden from a scan at the addresses probed with them.
+type testSimNoReceiveDevice struct {
+	testSimSMBusDevice
+}
+
+func (d *testSimNoReceiveDevice) ReceiveByte() (byte, error) { return 0, syscall.ENXIO }
+
+func TestSimI2CScan(t *testing.T) {
+	board, driver := newTestSimBoard(t)
+	defer board.Close()
+	i2c := driver.GetModules()["i2c1"].(*SimI2CModule)
+
+	if _, e := i2c.Scan(); !errors.Is(e, ErrClosed) {
+		t.Errorf("Expected ErrClosed scanning a bus that isn't enabled, got %v", e)
+	}
+	i2c.Enable()
+
+	i2c.MockAttachDevice(0x02, &testSimI2CDevice{})
+	i2c.MockAttachDevice(0x10, &testSimSMBusDevice{})
+	i2c.MockAttachDevice(0x20, &testSimI2CDevice{})
+	i2c.MockAttachDevice(0x50, &testSimI2CDevice{})
+	i2c.MockAttachDevice(0x51, &testSimNoReceiveDevice{})
+	i2c.MockAttachDevice(0x60, &testSimNoReceiveDevice{})
+	i2c.MockAttachDevice(0x78, &testSimI2CDevice{})
+
+	found, e := i2c.Scan()
+	if e != nil {
+		t.Fatalf("Scan returned an unexpected error: %s", e)
+	}
+	expected := []int{0x10, 0x20, 0x50, 0x60}
+	if fmt.Sprint(found) != fmt.Sprint(expected) {
+		t.Errorf("Expected scan to find %x, got %x", expected, found)
+	}
+}
@@ -0,0 +1,188 @@
+// Errors returned by hwio. Errors about a particular pin are *PinError values, errors from I2C and SPI transfers are
+// *I2CError and *SPIError values, errors from serial ports are *SerialError values, and errors from 1-Wire devices are
+// *OneWireError values. They wrap one of the sentinel errors below or the underlying system error, so callers can
+// branch on them with errors.Is and errors.As:
+//
+//	e := hwio.PinMode(pin, hwio.OUTPUT)
+//	if errors.Is(e, hwio.ErrPinInUse) {
+//		...
+//	}
+
+package hwio
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// No driver has been set, and none could be detected.
+	ErrNoDriver = errors.New("no driver is set")
+
+	// The pin is not defined by the driver or module.
+	ErrUnknownPin = errors.New("unknown pin")
+
+	// The pin is already assigned to a module.
+	ErrPinInUse = errors.New("pin is in use")
+
+	// The pin has not been opened with PinMode (or EnablePin for PWM).
+	ErrNotOpen = errors.New("pin is not open")
+
+	// The pin is being watched or is part of a bank, which prevents the operation.
+	ErrPinBusy = errors.New("pin is busy")
+
+	// The pin's mode doesn't allow the operation, e.g. watching an output.
+	ErrWrongMode = errors.New("pin is in the wrong mode")
+
+	// The pin is not being watched.
+	ErrNotWatched = errors.New("pin is not being watched")
+
+	// The driver or module doesn't support the operation.
+	ErrUnsupported = errors.New("not supported")
+
+	// The bank, module or bus has been closed or disabled.
+	ErrClosed = errors.New("closed")
+
+	// Data read from a device failed its CRC check.
+	ErrCRC = errors.New("CRC check failed")
+)
+
+// An error from an operation on a pin.
+type PinError struct {
+	// Name of the operation, e.g. "PinMode"
+	Op string
+
+	Pin Pin
+
+	// Name of the module the pin belongs to. May be empty.
+	Module string
+
+	Err error
+}
+
+func (e *PinError) Error() string {
+	if e.Module == "" {
+		return fmt.Sprintf("%s pin %d: %s", e.Op, e.Pin, e.Err)
+	}
+	return fmt.Sprintf("%s pin %d on module '%s': %s", e.Op, e.Pin, e.Module, e.Err)
+}
+
+func (e *PinError) Unwrap() error {
+	return e.Err
+}
+
+// Create a PinError for an operation by a module.
+func pinError(op string, pin Pin, module Module, err error) *PinError {
+	return &PinError{Op: op, Pin: pin, Module: module.GetName(), Err: err}
+}
+
+// Create a PinError for a pin that has not been opened, hinting at the function that should have been called.
+func notOpenError(op string, pin Pin, module Module) *PinError {
+	return pinError(op, pin, module, fmt.Errorf("%w, have you called PinMode?", ErrNotOpen))
+}
+
+// Create a PinError for a PWM pin that has not been enabled.
+func notEnabledError(op string, pin Pin, module Module) *PinError {
+	return pinError(op, pin, module, fmt.Errorf("%w, have you called EnablePin?", ErrNotOpen))
+}
+
+// An error for a PWM duty time that is negative or longer than the period. It is wrapped in a *PinError.
+type PWMDutyError struct {
+	// Duty time in nanoseconds
+	Duty int64
+
+	// Period in nanoseconds
+	Period int64
+}
+
+func (e *PWMDutyError) Error() string {
+	if e.Duty < 0 {
+		return fmt.Sprintf("duty time of %dns is negative", e.Duty)
+	}
+	return fmt.Sprintf("duty time of %dns is longer than the period of %dns", e.Duty, e.Period)
+}
+
+// An error from a transfer on an I2C bus. Errors from the kernel are syscall.Errno values.
+type I2CError struct {
+	Op string
+
+	// Name of the I2C module
+	Module string
+
+	// 7-bit address of the device
+	Address int
+
+	Err error
+}
+
+func (e *I2CError) Error() string {
+	return fmt.Sprintf("I2C %s to device 0x%02x on module '%s': %s", e.Op, e.Address, e.Module, e.Err)
+}
+
+func (e *I2CError) Unwrap() error {
+	return e.Err
+}
+
+// An error from a transfer on an SPI bus. Errors from the kernel are syscall.Errno values.
+type SPIError struct {
+	Op string
+
+	// Name of the SPI module
+	Module string
+
+	// Chip select of the device
+	ChipSelect int
+
+	Err error
+}
+
+func (e *SPIError) Error() string {
+	return fmt.Sprintf("SPI %s to chip select %d on module '%s': %s", e.Op, e.ChipSelect, e.Module, e.Err)
+}
+
+func (e *SPIError) Unwrap() error {
+	return e.Err
+}
+
+// An error from a serial port. Errors from the kernel are syscall.Errno values.
+type SerialError struct {
+	Op string
+
+	// Name of the serial module
+	Module string
+
+	Err error
+}
+
+func (e *SerialError) Error() string {
+	return fmt.Sprintf("Serial %s on module '%s': %s", e.Op, e.Module, e.Err)
+}
+
+func (e *SerialError) Unwrap() error {
+	return e.Err
+}
+
+// An error from a device on a 1-Wire bus, or from the bus itself if Device is empty. Errors from the kernel are
+// syscall.Errno values.
+type OneWireError struct {
+	Op string
+
+	// Name of the 1-Wire module
+	Module string
+
+	// ID of the device, e.g. "28-0000055f1b2c"
+	Device string
+
+	Err error
+}
+
+func (e *OneWireError) Error() string {
+	if e.Device == "" {
+		return fmt.Sprintf("1-Wire %s on module '%s': %s", e.Op, e.Module, e.Err)
+	}
+	return fmt.Sprintf("1-Wire %s to device %s on module '%s': %s", e.Op, e.Device, e.Module, e.Err)
+}
+
+func (e *OneWireError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,94 @@
+package hwio
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestPinErrors(t *testing.T) {
+	module, _ := newTestGPIOCdevModule(t)
+	defer module.Disable()
+
+	e := module.PinMode(3, OUTPUT)
+	if !errors.Is(e, ErrUnknownPin) {
+		t.Errorf("Expected ErrUnknownPin from PinMode on an unknown pin, got %v", e)
+	}
+
+	e = module.DigitalWrite(1, HIGH)
+	if !errors.Is(e, ErrNotOpen) {
+		t.Errorf("Expected ErrNotOpen from DigitalWrite on a pin that is not open, got %v", e)
+	}
+	var pe *PinError
+	if !errors.As(e, &pe) {
+		t.Fatalf("Expected a *PinError, got %T", e)
+	}
+	if pe.Op != "DigitalWrite" || pe.Pin != 1 || pe.Module != "gpio" {
+		t.Errorf("Unexpected PinError fields: %+v", pe)
+	}
+
+	module.PinMode(1, OUTPUT)
+	_, e = module.WatchPin(1, EDGE_BOTH)
+	if !errors.Is(e, ErrWrongMode) {
+		t.Errorf("Expected ErrWrongMode from watching an output, got %v", e)
+	}
+	e = module.UnwatchPin(1)
+	if !errors.Is(e, ErrNotWatched) {
+		t.Errorf("Expected ErrNotWatched from unwatching a pin that is not watched, got %v", e)
+	}
+}
+
+func TestPinErrorKeepsErrno(t *testing.T) {
+	module, sys := newTestGPIOCdevModule(t)
+	defer module.Disable()
+
+	// a second module using the same line, which the kernel will report as busy
+	pins := make(DTGPIOModulePinDefMap)
+	pins[3] = &DTGPIOModulePinDef{pin: 3, gpioLogical: 5}
+	other := NewDTGPIOCdevModule("other")
+	other.sys = sys
+	other.SetOptions(map[string]interface{}{"pins": pins, "chips": DTGPIOCdevChipList{"/dev/gpiochip0"}})
+	defer other.Disable()
+
+	module.PinMode(1, OUTPUT)
+	e := other.PinMode(3, OUTPUT)
+	if !errors.Is(e, syscall.EBUSY) {
+		t.Errorf("Expected EBUSY to be inspectable through the returned error, got %v", e)
+	}
+	var pe *PinError
+	if !errors.As(e, &pe) || pe.Module != "other" {
+		t.Errorf("Expected a *PinError for module 'other', got %v", e)
+	}
+}
+
+func TestAssignPinInUse(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio, _ := GetGPIOModule()
+	analog, _ := GetAnalogModule()
+
+	pin, _ := GetPin("p1")
+	e := AssignPin(pin, gpio)
+	if e != nil {
+		t.Fatalf("AssignPin returned an unexpected error: %s", e)
+	}
+	e = AssignPin(pin, analog)
+	if !errors.Is(e, ErrPinInUse) {
+		t.Errorf("Expected ErrPinInUse from assigning a pin twice, got %v", e)
+	}
+
+	_, e = GetPin("nope")
+	if !errors.Is(e, ErrUnknownPin) {
+		t.Errorf("Expected ErrUnknownPin from GetPin, got %v", e)
+	}
+}
+
+func TestI2CErrorKeepsErrno(t *testing.T) {
+	e := error(&I2CError{Op: "Read", Module: "i2c", Address: 0x40, Err: syscall.ENXIO})
+	if !errors.Is(e, syscall.ENXIO) {
+		t.Error("Expected ENXIO to be inspectable through an I2CError")
+	}
+	if e.Error() != "I2C Read to device 0x40 on module 'i2c': "+syscall.ENXIO.Error() {
+		t.Errorf("Unexpected error text: %s", e)
+	}
+}
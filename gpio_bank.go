@@ -0,0 +1,84 @@
+package hwio
+
+import (
+	"fmt"
+)
+
+// Maximum number of pins in a bank, as a bank's value is a uint32.
+const maxBankPins = 32
+
+// A GPIOBank for modules that can't write a set of pins in one operation. Pins are written and read one at a time
+// through the module, in the same order as WriteUIntToPins.
+type sequentialGPIOBank struct {
+	gpio GPIOModule
+	pins PinList
+}
+
+func newSequentialGPIOBank(gpio GPIOModule, pins PinList) (*sequentialGPIOBank, error) {
+	e := checkBankPins(pins)
+	if e != nil {
+		return nil, e
+	}
+	return &sequentialGPIOBank{gpio: gpio, pins: append(PinList{}, pins...)}, nil
+}
+
+func (bank *sequentialGPIOBank) Write(value uint32) error {
+	for i, pin := range bank.pins {
+		e := bank.gpio.DigitalWrite(pin, bankBit(value, i, len(bank.pins)))
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (bank *sequentialGPIOBank) Read() (uint32, error) {
+	result := uint32(0)
+	for i, pin := range bank.pins {
+		v, e := bank.gpio.DigitalRead(pin)
+		if e != nil {
+			return 0, e
+		}
+		if v != LOW {
+			result |= bankMask(i, len(bank.pins))
+		}
+	}
+	return result, nil
+}
+
+func (bank *sequentialGPIOBank) Atomic() bool {
+	return false
+}
+
+func (bank *sequentialGPIOBank) Close() error {
+	return nil
+}
+
+// Check that a list of pins can be used as a bank.
+func checkBankPins(pins PinList) error {
+	if len(pins) == 0 || len(pins) > maxBankPins {
+		return fmt.Errorf("A bank must have between 1 and %d pins, got %d", maxBankPins, len(pins))
+	}
+
+	seen := make(map[Pin]bool)
+	for _, pin := range pins {
+		if seen[pin] {
+			return &PinError{Op: "OpenBank", Pin: pin, Err: fmt.Errorf("%w, it appears more than once in the bank", ErrPinInUse)}
+		}
+		seen[pin] = true
+	}
+	return nil
+}
+
+// Return the bit of a bank value for the i'th of n pins. The first pin is the most significant bit.
+func bankMask(i int, n int) uint32 {
+	return 1 << uint(n-1-i)
+}
+
+// Return HIGH or LOW for the i'th of n pins in a bank value.
+func bankBit(value uint32, i int, n int) int {
+	if value&bankMask(i, n) != 0 {
+		return HIGH
+	}
+	return LOW
+}
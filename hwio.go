@@ -5,12 +5,14 @@
 package hwio
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"syscall"
 	"time"
+	"unsafe"
 )
 
 type BitShiftOrder byte
@@ -20,41 +22,6 @@ const (
 	MSBFIRST
 )
 
-// Reference to driver we're using
-var driver HardwareDriver
-
-// Retrieved from the driver, this is the map of the hardware pins supported by
-// the driver and their capabilities
-var definedPins HardwarePinMap
-
-// A private type for associating a pin's definition with the current IO mode
-// and any other dynamic properties of the pin.
-type assignedPin struct {
-	pin    Pin    // pin being assigned
-	module Module // module that has assigned this pin
-	// pinIOMode PinIOMode // mode that was assigned to this pin
-}
-
-// A map of pin numbers to the assigned dynamic properties of the pin. This is
-// set by PinMode when errorChecking is on, and can be used by other functions
-// to determine if the request is valid given the assigned properties of the pin.
-var assignedPins map[Pin]*assignedPin
-
-// If set to true, functions should test that their constraints are met.
-// e.g. test that the pin is capable of doing what is asked. This can be set
-// with SetErrorChecking(). Setting to false bypasses checks for performance.
-// By default turned on, which is a better default for beginners.
-var errorChecking bool = true
-
-// init() attempts to determine from the environment what the driver is. The
-// intent is that the consumer of the library would not generally have to worry
-// about it, it would just work. If it cannot determine the driver, it doesn't
-// set the driver to anything.
-func init() {
-	assignedPins = make(map[Pin]*assignedPin)
-	determineDriver()
-}
-
 func fileExists(name string) bool {
 	_, err := os.Stat(name)
 	if err != nil {
@@ -65,56 +32,47 @@ func fileExists(name string) bool {
 }
 
 // Work out the driver from environment if we can. If we have any problems,
-// don't generate an error, just return with the driver not set.
-func determineDriver() {
+// don't generate an error, just return nil.
+func determineDriver() HardwareDriver {
 	drivers := [...]HardwareDriver{NewBeagleboneBlackDTDriver(), NewRaspPiDTDriver(), NewOdroidC1Driver()}
 	for _, d := range drivers {
 		if d.MatchesHardwareConfig() {
-			SetDriver(d)
-			return
+			return d
 		}
 	}
-
-	fmt.Printf("Unable to select a suitable driver for this board.\n")
-}
-
-// Check if the driver is assigned. If not, return an error to indicate that,
-// otherwise return no error.
-func assertDriver() error {
-	if driver == nil {
-		return errors.New("hwio has no configured driver")
-	}
 	return nil
 }
 
-// Set the driver. Also calls Init on the driver, and loads the capabilities
-// of the device.
+// Set the driver of the default board. Also calls Init on the driver, and loads the capabilities
+// of the device. Any pin assignments on the previous default board are forgotten.
 func SetDriver(d HardwareDriver) {
-	driver = d
-	e := driver.Init()
+	defaultBoardLock.Lock()
+	defer defaultBoardLock.Unlock()
+
+	b := newBoard()
+	e := b.setDriver(d)
 	if e != nil {
 		fmt.Printf("Could not initialise driver: %s", e)
 	}
-	definedPins = driver.PinMap()
+	replaceDefaultBoard(b)
 }
 
 // Retrieve the current hardware driver.
 func GetDriver() HardwareDriver {
-	return driver
+	return DefaultBoard().GetDriver()
 }
 
 // Returns a map of the hardware pins. This will only work once the driver is
 // set.
 func GetDefinedPins() HardwarePinMap {
-	return definedPins
+	return DefaultBoard().GetDefinedPins()
 }
 
 // Ensure that any resources external to the program that have been allocated are tidied up.
 func CloseAll() {
-	if driver == nil {
-		return
+	if b := getDefaultBoard(); b != nil {
+		b.Close()
 	}
-	driver.Close()
 }
 
 // Returns a Pin given a canonical name for the pin.
@@ -128,106 +86,114 @@ func CloseAll() {
 // @todo GetPin: consider making it case-insensitive on name
 // @todo GetPin: consider allowing an int or int as string to identify logical pin directly
 func GetPin(pinName string) (Pin, error) {
-	pl := strings.ToLower(pinName)
-	for pin, pinDef := range definedPins {
-		for _, name := range pinDef.names {
-			if strings.ToLower(name) == pl {
-				return pin, nil
-			}
-		}
-	}
-
-	return Pin(0), fmt.Errorf("Could not find a pin called %s", pinName)
+	return DefaultBoard().GetPin(pinName)
 }
 
 // Shortcut for calling GetPin and then PinMode.
 func GetPinWithMode(cname string, mode PinIOMode) (pin Pin, e error) {
-	p, e := GetPin(cname)
-	if e != nil {
-		return
-	}
-
-	e = PinMode(p, mode)
-	return p, e
+	return DefaultBoard().GetPinWithMode(cname, mode)
 }
 
 // Set error checking. This should be called before pin assignments.
 func SetErrorChecking(check bool) {
-	errorChecking = check
+	DefaultBoard().SetErrorChecking(check)
 }
 
 // Helper function to get GPIO module
 func GetGPIOModule() (GPIOModule, error) {
-	m, e := GetModule("gpio")
-	if e != nil {
-		return nil, e
-	}
-
-	if m == nil {
-		return nil, errors.New("Driver does not support GPIO")
-	}
-
-	return m.(GPIOModule), nil
+	return DefaultBoard().GetGPIOModule()
 }
 
 // Given an internal pin number, return the canonical name for the pin, as defined by the driver. If the pin
 // is not to the driver, return "".
 func PinName(pin Pin) string {
-	p := definedPins[pin]
-	if p == nil {
-		return ""
-	}
-	return p.names[0]
+	return DefaultBoard().PinName(pin)
 }
 
 // Set the mode of a pin. Analogous to Arduino pin mode.
 func PinMode(pin Pin, mode PinIOMode) error {
-	gpio, e := GetGPIOModule()
-	if e != nil {
-		return e
-	}
-
-	return gpio.PinMode(pin, mode)
+	return DefaultBoard().PinMode(pin, mode)
 }
 
 // Close a specific pin that has been assigned as GPIO by PinMode
 func ClosePin(pin Pin) error {
-	gpio, e := GetGPIOModule()
+	return DefaultBoard().ClosePin(pin)
+}
+
+// Helper function to get the GPIO module, if it supports watching pins for edges.
+func GetGPIOWatchModule() (GPIOWatchModule, error) {
+	return DefaultBoard().GetGPIOWatchModule()
+}
+
+// Watch a GPIO pin for rising, falling or both edges. The pin must have been set to an input mode by PinMode. Events
+// are delivered on the returned channel until UnwatchPin or ClosePin is called on the pin.
+func WatchPin(pin Pin, edge Edge) (<-chan PinEvent, error) {
+	return DefaultBoard().WatchPin(pin, edge)
+}
+
+// Watch a GPIO pin for edges, calling handler for each event. The handler is called from a separate goroutine, one
+// event at a time.
+func WatchPinFunc(pin Pin, edge Edge, handler func(PinEvent)) error {
+	events, e := WatchPin(pin, edge)
 	if e != nil {
 		return e
 	}
 
-	return gpio.ClosePin(pin)
+	go func() {
+		for event := range events {
+			handler(event)
+		}
+	}()
+	return nil
+}
+
+// Stop watching a GPIO pin for edges.
+func UnwatchPin(pin Pin) error {
+	return DefaultBoard().UnwatchPin(pin)
+}
+
+// Open a bank over a set of GPIO pins, so they can be written and read together as the bits of an unsigned integer.
+// The first pin is the most significant bit. The pins must have been set up with PinMode. Where the GPIO module can
+// change all the pins in one operation the bank does so; otherwise the pins are written one after another, and the
+// bank's Atomic method returns false.
+func OpenBank(pins PinList) (GPIOBank, error) {
+	return DefaultBoard().OpenBank(pins)
+}
+
+// Determine if a bank over the pins would be written atomically by the GPIO module.
+func PinsAtomic(pins PinList) bool {
+	return DefaultBoard().PinsAtomic(pins)
 }
 
 // Assign a pin to a module. This is typically called by modules when they allocate pins. If the pin is already assigned,
 // an error is generated. ethod is public in case it is needed to hack around default driver settings.
 func AssignPin(pin Pin, module Module) error {
-	if a := assignedPins[pin]; a != nil {
-		return fmt.Errorf("Pin %d is already assigned to module %s", pin, a.module.GetName())
-	}
-	assignedPins[pin] = &assignedPin{pin, module}
-	return nil
+	return boardForModule(module).AssignPin(pin, module)
 }
 
-// Assign a set of pins. Method is public in case it is needed to hack around default driver settings.
+// Assign a set of pins. If one of them can't be assigned, the pins assigned before it are released again, so none
+// are left assigned. Method is public in case it is needed to hack around default driver settings.
 func AssignPins(pins PinList, module Module) error {
-	for _, pin := range pins {
+	for i, pin := range pins {
 		e := AssignPin(pin, module)
 		if e != nil {
+			for _, assigned := range pins[:i] {
+				unassignPin(assigned, module)
+			}
 			return e
 		}
 	}
 	return nil
 }
 
-// Unassign a pin. Method is public in case it is needed to hack around default driver settings.
+// Unassign a pin. Method is public in case it is needed to hack around default driver settings. This only affects
+// the default board, even if the pin was assigned for a module on another board; use Board.UnassignPin for those.
 func UnassignPin(pin Pin) error {
-	delete(assignedPins, pin)
-	return nil
+	return DefaultBoard().UnassignPin(pin)
 }
 
-// Unassign a set of pins. Method is public in case it is needed to hack around default driver settings.
+// Unassign a set of pins on the default board, as UnassignPin does. Method is public in case it is needed to hack
+// around default driver settings.
 func UnassignPins(pins PinList) (er error) {
 	er = nil
 
@@ -241,25 +207,21 @@ func UnassignPins(pins PinList) (er error) {
 	return
 }
 
+// Unassign a pin that a module assigned, on the board that the module belongs to. Modules use this rather than
+// UnassignPin, which acts on the default board.
+func unassignPin(pin Pin, module Module) error {
+	return boardForModule(module).UnassignPin(pin)
+}
+
 // Write a value to a digital pin
 func DigitalWrite(pin Pin, value int) (e error) {
-	gpio, e := GetGPIOModule()
-	if e != nil {
-		return e
-	}
-
-	return gpio.DigitalWrite(pin, value)
+	return DefaultBoard().DigitalWrite(pin, value)
 }
 
 // Read a value from a digital pin
 func DigitalRead(pin Pin) (result int, e error) {
 	// @todo consider memoizing
-	gpio, e := GetGPIOModule()
-	if e != nil {
-		return 0, e
-	}
-
-	return gpio.DigitalRead(pin)
+	return DefaultBoard().DigitalRead(pin)
 }
 
 // given a logic level of HIGH or LOW, return the opposite. Invalid values returned as LOW.
@@ -294,47 +256,37 @@ func Pulse(pin Pin, active int, durationMicroseconds int) error {
 
 // Helper function to get GPIO module
 func GetAnalogModule() (AnalogModule, error) {
-	m, e := GetModule("analog")
-	if e != nil {
-		return nil, e
-	}
-
-	if m == nil {
-		return nil, errors.New("Driver does not support analog")
-	}
-
-	return m.(AnalogModule), nil
+	return DefaultBoard().GetAnalogModule()
 }
 
 // Read an analog value from a pin. The range of values is hardware driver dependent.
 func AnalogRead(pin Pin) (int, error) {
-	analog, e := GetAnalogModule()
-	if e != nil {
-		return 0, e
-	}
-
-	return analog.AnalogRead(pin)
+	return DefaultBoard().AnalogRead(pin)
 }
 
-// Helper to turn an on-board LED on or off. Uses LED module
-func Led(name string, on bool) error {
-	m, e := GetModule("leds")
-	if e != nil {
-		return e
-	}
+// Write an analog value to a pin as PWM, as Arduino's analogWrite does. The value is from 0 to 255, or up to the
+// largest value for the bits set by AnalogWriteResolution, and sets the duty cycle, so 0 is always LOW and the largest
+// value always HIGH. The pin must be listed by a PWM module of the driver, which is enabled along with the pin the
+// first time, with the frequency set by AnalogWriteFrequency. Pins without PWM give an error that matches
+// ErrUnsupported.
+func AnalogWrite(pin Pin, value int) error {
+	return DefaultBoard().AnalogWrite(pin, value)
+}
 
-	leds := m.(LEDModule)
-	led, e := leds.GetLED(name)
-	if e != nil {
-		return e
-	}
+// Set the number of bits of the values for AnalogWrite, by default 8. The largest value is 2^bits - 1.
+func AnalogWriteResolution(bits int) error {
+	return DefaultBoard().AnalogWriteResolution(bits)
+}
 
-	e = led.SetTrigger("none")
-	if e != nil {
-		return e
-	}
+// Set the frequency of the PWM output of AnalogWrite, in Hz, by default 1000. It applies to pins from their next
+// AnalogWrite.
+func AnalogWriteFrequency(hz int) error {
+	return DefaultBoard().AnalogWriteFrequency(hz)
+}
 
-	return led.SetOn(on)
+// Helper to turn an on-board LED on or off. Uses LED module
+func Led(name string, on bool) error {
+	return DefaultBoard().Led(name, on)
 }
 
 // Delay execution by the specified number of milliseconds. This is a helper
@@ -353,11 +305,7 @@ func DelayMicroseconds(duration int) {
 
 // @todo DebugPinMap: sort
 func DebugPinMap() {
-	fmt.Println("HardwarePinMap:")
-	for key, val := range definedPins {
-		fmt.Printf("Pin %d: %s\n", key, val.String())
-	}
-	fmt.Printf("\n")
+	DefaultBoard().DebugPinMap()
 }
 
 // The approximate mapping of Arduino shiftOut, this shifts a byte out on the
@@ -405,7 +353,7 @@ func ShiftOutSize(dataPin Pin, clockPin Pin, value uint, order BitShiftOrder, n
 // Bits are written MSB first.
 // Maximum number of bits that can be shifted is 32.
 // Note that the bits are not written out instantaneously, although very quickly. If you need instantaneous changing of
-// all pins, you need to consider an output buffer.
+// all pins, use OpenBank, or consider an output buffer.
 func WriteUIntToPins(value uint32, pins []Pin) error {
 	if len(pins) > 31 {
 		return errors.New("WriteUIntToPins only supports up to 32 bits")
@@ -443,6 +391,36 @@ func WriteStringToFile(filename string, value string) error {
 	return e
 }
 
+// Events for pollFd, from poll.h
+const (
+	pollIn  = 0x1
+	pollPri = 0x2
+	pollErr = 0x8
+)
+
+// Wait up to timeout for any of the events to occur on a file descriptor. Returns the events that occurred, which is
+// 0 if the timeout expired first.
+func pollFd(fd uintptr, events int16, timeout time.Duration) (int16, error) {
+	pfd := struct {
+		fd      int32
+		events  int16
+		revents int16
+	}{int32(fd), events, 0}
+	ts := syscall.NsecToTimespec(int64(timeout))
+
+	n, _, err := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1, uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+	if err == syscall.EINTR {
+		return 0, nil
+	}
+	if err != 0 {
+		return 0, syscall.Errno(err)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return pfd.revents, nil
+}
+
 // Given a glob pattern, return the full path of the first matching file
 func findFirstMatchingFile(glob string) (string, error) {
 	matches, e := filepath.Glob(glob)
@@ -465,26 +443,51 @@ func UInt16FromUInt8(highByte byte, lowByte byte) uint16 {
 	return uint16(uint16(highByte)<<8) | uint16(lowByte)
 }
 
+// Swap the two bytes of a 16-bit value.
 func ReverseBytes16(value uint16) uint16 {
-	// @todo implement ReverseBytes16()
-	return 0
+	return value<<8 | value>>8
 }
 
+// Reverse the order of the four bytes of a 32-bit value.
 func ReverseBytes32(value uint32) uint32 {
-	// @todo implement ReverseBytes32()
-	return 0
+	return value<<24 | (value<<8)&0xff0000 | (value>>8)&0xff00 | value>>24
+}
+
+// Read a 16-bit register from an I2C device. order is the order of the two bytes on the bus: binary.LittleEndian is
+// the SMBus word order, while many sensors use binary.BigEndian. If the device implements I2CSMBusDevice, the register
+// is read with ReadWord.
+func ReadI2CWord(device I2CDevice, command byte, order binary.ByteOrder) (uint16, error) {
+	buffer := make([]byte, 2)
+	if smbus, ok := device.(I2CSMBusDevice); ok {
+		v, e := smbus.ReadWord(command)
+		if e != nil {
+			return 0, e
+		}
+		binary.LittleEndian.PutUint16(buffer, v)
+	} else {
+		b, e := device.Read(command, 2)
+		if e != nil {
+			return 0, e
+		}
+		copy(buffer, b)
+	}
+	return order.Uint16(buffer), nil
+}
+
+// Write a 16-bit register on an I2C device, with the bytes in the given order on the bus. See ReadI2CWord.
+func WriteI2CWord(device I2CDevice, command byte, value uint16, order binary.ByteOrder) error {
+	buffer := make([]byte, 2)
+	order.PutUint16(buffer, value)
+	if smbus, ok := device.(I2CSMBusDevice); ok {
+		return smbus.WriteWord(command, binary.LittleEndian.Uint16(buffer))
+	}
+	return device.Write(command, buffer)
 }
 
 // Get a module by name. If driver is not set, it will return an error. If the driver does not support that module,
 //
 func GetModule(name string) (Module, error) {
-	driver := GetDriver()
-	if driver == nil {
-		return nil, errors.New("GetModule: Driver is not set")
-	}
-
-	modules := driver.GetModules()
-	return modules[name], nil
+	return DefaultBoard().GetModule(name)
 }
 
 // This is the interface that hardware drivers implement. Generally all drivers are created
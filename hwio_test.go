@@ -119,7 +119,7 @@ func TestDigitalRead(t *testing.T) {
 	writePinAndCheck(t, pin1, HIGH, driver)
 }
 
-func getMockGPIO(t *testing.T) *testGPIOModule {
+func getMockGPIO(t *testing.T) *SimGPIOModule {
 	g, e := GetModule("gpio")
 	if e != nil {
 		t.Error(fmt.Sprintf("Fetching gpio module should not return an error, returned %s", e))
@@ -128,7 +128,7 @@ func getMockGPIO(t *testing.T) *testGPIOModule {
 		t.Error("Could not get 'gpio' module")
 	}
 
-	return g.(*testGPIOModule)
+	return g.(*SimGPIOModule)
 }
 
 func writePinAndCheck(t *testing.T, pin Pin, value int, driver *TestDriver) {
@@ -151,6 +151,15 @@ func TestBitManipulation(t *testing.T) {
 	}
 }
 
+func TestReverseBytes(t *testing.T) {
+	if v := ReverseBytes16(0x1234); v != 0x3412 {
+		t.Error(fmt.Sprintf("ReverseBytes16 does not work correctly, expected 0x3412, got %04x", v))
+	}
+	if v := ReverseBytes32(0x12345678); v != 0x78563412 {
+		t.Error(fmt.Sprintf("ReverseBytes32 does not work correctly, expected 0x78563412, got %08x", v))
+	}
+}
+
 func TestCpuInfo(t *testing.T) {
 	s := CpuInfo(0, "processor")
 	if s != "0" {
@@ -189,3 +198,85 @@ func TestNoErrorCheck(t *testing.T) {
 
 	// @todo implement TestNoErrorCheck
 }
+
+func TestWatchPin(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+
+	pin1, _ := GetPin("p1")
+	PinMode(pin1, INPUT)
+
+	events, e := WatchPin(pin1, EDGE_FALLING)
+	if e != nil {
+		t.Fatal(fmt.Sprintf("WatchPin should not return an error, returned '%s'", e))
+	}
+
+	// only the falling edge should generate an event
+	gpio.MockSetPinValue(pin1, HIGH)
+	gpio.MockSetPinValue(pin1, LOW)
+
+	select {
+	case event := <-events:
+		if event.Pin != pin1 || event.Edge != EDGE_FALLING {
+			t.Error(fmt.Sprintf("Expected falling edge on pin %d, got %s on pin %d", pin1, event.Edge, event.Pin))
+		}
+	default:
+		t.Error("Expected an event after the pin went LOW")
+	}
+
+	select {
+	case event := <-events:
+		t.Error(fmt.Sprintf("Did not expect another event, got %s", event.Edge))
+	default:
+	}
+
+	e = UnwatchPin(pin1)
+	if e != nil {
+		t.Error(fmt.Sprintf("UnwatchPin should not return an error, returned '%s'", e))
+	}
+	if _, ok := <-events; ok {
+		t.Error("Expected events channel to be closed after UnwatchPin")
+	}
+}
+
+func TestOpenBank(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	gpio := getMockGPIO(t)
+
+	pins := PinList{}
+	for _, name := range []string{"p1", "p2", "p3"} {
+		pin, _ := GetPin(name)
+		PinMode(pin, OUTPUT)
+		pins = append(pins, pin)
+	}
+
+	// the mock module can't write pins together, so it gets a sequential bank
+	if PinsAtomic(pins) {
+		t.Error("Pins on the mock GPIO module should not be atomic")
+	}
+	bank, e := OpenBank(pins)
+	if e != nil {
+		t.Fatal(fmt.Sprintf("OpenBank should not return an error, returned '%s'", e))
+	}
+	defer bank.Close()
+	if bank.Atomic() {
+		t.Error("Expected a sequential bank not to be atomic")
+	}
+
+	bank.Write(6)
+	if gpio.MockGetPinValue(pins[0]) != HIGH || gpio.MockGetPinValue(pins[1]) != HIGH || gpio.MockGetPinValue(pins[2]) != LOW {
+		t.Error("Expected the first pin of the bank to be the most significant bit")
+	}
+
+	gpio.MockSetPinValue(pins[0], LOW)
+	v, e := bank.Read()
+	if e != nil || v != 2 {
+		t.Error(fmt.Sprintf("Expected Read to return 2, got %d, %v", v, e))
+	}
+
+	if _, e = OpenBank(PinList{pins[0], pins[0]}); e == nil {
+		t.Error("OpenBank with a repeated pin should return an error")
+	}
+}
@@ -0,0 +1,27 @@
+package hwio
+
+// Scanning I2C buses for devices. The probing policy is the same as the default for i2cdetect.
+
+// Range of addresses probed by Scan. The addresses outside this range are reserved.
+const (
+	I2C_SCAN_FIRST = 0x03
+	I2C_SCAN_LAST  = 0x77
+)
+
+// Determine if an address should be probed by reading a byte rather than with a quick write. Quick writes can confuse
+// some devices: 0x30-0x37 are often EEPROM write-protect switches, and some EEPROMs at 0x50-0x5f lock up.
+func i2cScanReadsByte(address int) bool {
+	return (address >= 0x30 && address <= 0x37) || (address >= 0x50 && address <= 0x5f)
+}
+
+// Probe each address in the scan range, and return the addresses where probe reports a device. readByte tells probe
+// how to probe the address.
+func scanI2CAddresses(probe func(address int, readByte bool) bool) []int {
+	result := []int{}
+	for address := I2C_SCAN_FIRST; address <= I2C_SCAN_LAST; address++ {
+		if probe(address, i2cScanReadsByte(address)) {
+			result = append(result, address)
+		}
+	}
+	return result
+}
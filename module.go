@@ -3,6 +3,11 @@
 
 package hwio
 
+import (
+	"io"
+	"time"
+)
+
 // Generic interface type for all modules.
 type Module interface {
 	// Set parameters require to initialise the module. Generally should be called before Enable() is called,
@@ -28,18 +33,98 @@ type GPIOModule interface {
 	ClosePin(pin Pin) (e error)
 }
 
+// Optional interface for GPIO modules that can report edges on input pins, so that callers don't need to poll
+// DigitalRead. The pin must have been set to an input mode with PinMode first.
+type GPIOWatchModule interface {
+	GPIOModule
+
+	// Start watching a pin for edges. Events are delivered on the returned channel, which is closed when the pin is
+	// unwatched or closed, or if the module can no longer read edges from the kernel. Only one watch can be active on a
+	// pin at a time.
+	WatchPin(pin Pin, edge Edge) (<-chan PinEvent, error)
+
+	// Stop watching a pin.
+	UnwatchPin(pin Pin) error
+}
+
+// A set of GPIO pins that are written and read together as the bits of an unsigned integer. As with WriteUIntToPins,
+// the first pin is the most significant bit. Banks are opened with OpenBank.
+type GPIOBank interface {
+	// Write a value across the pins. Only output pins are changed.
+	Write(value uint32) error
+
+	// Read the levels of the pins.
+	Read() (uint32, error)
+
+	// Determine if Write changes all the pins in a single hardware operation. If false, the pins are written one after
+	// another, and intermediate values will be seen on the pins.
+	Atomic() bool
+
+	// Release the bank. The pins stay open with their current levels, and can be used individually again.
+	Close() error
+}
+
+// Optional interface for GPIO modules that can write and read a set of pins as one operation.
+type GPIOBankModule interface {
+	GPIOModule
+
+	// Open a bank over pins that have already been set up with PinMode.
+	OpenBank(pins PinList) (GPIOBank, error)
+
+	// Determine if a bank over these pins would be written atomically, without opening it.
+	AtomicPins(pins PinList) bool
+}
+
+// Optional interface for GPIO modules that can drive pins as open-drain outputs, as the lines of an I2C bus need.
+type GPIOOpenDrainModule interface {
+	GPIOModule
+
+	// Drive a pin that has been opened with PinMode LOW, or release it as an input for HIGH, leaving its level to a
+	// pull-up or another device. The pin is never driven HIGH, even briefly, and stays assigned to the module.
+	SetOpenDrain(pin Pin, value int) error
+}
+
 type PWMModule interface {
 	Module
 
 	EnablePin(pin Pin, enabled bool) error
 
-	// Set the period of this pin, in nanoseconds
+	// Set the period of this pin, in nanoseconds. The duty time stays the same, so this fails with a *PWMDutyError if
+	// the duty time is longer than the new period.
 	SetPeriod(pin Pin, ns int64) error
 
-	// Set the duty time, the amount of time during each period that that output is HIGH.
+	// Set the period of this pin, in nanoseconds, scaling the duty time so that the duty cycle stays the same.
+	SetPeriodKeepDutyCycle(pin Pin, ns int64) error
+
+	// Set the duty time, the amount of time during each period that that output is HIGH (or LOW if the polarity is
+	// inversed). This fails with a *PWMDutyError if it is longer than the period.
 	SetDuty(pin Pin, ns int64) error
+
+	// Set the duty time as a fraction of the period, from 0 to 1.
+	SetDutyCycle(pin Pin, fraction float64) error
+
+	// Set whether the output is HIGH or LOW for the duty time. Pins start with PWM_POLARITY_NORMAL.
+	SetPolarity(pin Pin, polarity PWMPolarity) error
+
+	// Determine if output is enabled on the pin. This is false for a pin that has never been enabled.
+	IsPinEnabled(pin Pin) (bool, error)
+
+	// Get the period of this pin, in nanoseconds
+	GetPeriod(pin Pin) (int64, error)
+
+	// Get the duty time of this pin, in nanoseconds
+	GetDuty(pin Pin) (int64, error)
+
+	GetPolarity(pin Pin) (PWMPolarity, error)
 }
 
+type PWMPolarity int
+
+const (
+	PWM_POLARITY_NORMAL   PWMPolarity = iota // HIGH for the duty time, then LOW for the rest of the period
+	PWM_POLARITY_INVERSED                    // LOW for the duty time, then HIGH
+)
+
 type AnalogModule interface {
 	Module
 
@@ -58,6 +143,15 @@ type I2CModule interface {
 	GetDevice(address int) I2CDevice
 }
 
+// Optional interface for I2C modules that can scan the bus for devices, as i2cdetect does.
+type I2CScanModule interface {
+	I2CModule
+
+	// Probe the addresses from I2C_SCAN_FIRST to I2C_SCAN_LAST, and return the addresses of the devices that answer.
+	// Addresses in use by a kernel driver are included, as there is a device there.
+	Scan() ([]int, error)
+}
+
 // An object that represents a device on a bus. Once an i2c module has been enabled, you can use GetDevice to get an instance
 // of i2c device. You can then talk to the device directly with the supported operations.
 type I2CDevice interface {
@@ -74,15 +168,223 @@ type I2CDevice interface {
 	Write(command byte, buffer []byte) (e error)
 }
 
+// A message in an I2C transaction. See I2CTxDevice.
+type I2CMessage struct {
+	// Flags for the message, such as I2C_M_RD for a read. A message without I2C_M_RD is a write.
+	Flags uint16
+
+	// The bytes to write, or the buffer to read into. Its length is the number of bytes transferred.
+	Data []byte
+}
+
+// Optional interface for I2C devices that can perform raw transactions, rather than the SMBus style operations of
+// I2CDevice. This allows reads without a register, reads longer than I2C_SMBUS_BLOCK_MAX, and a write followed by a
+// read with a repeated start between them.
+type I2CTxDevice interface {
+	I2CDevice
+
+	// Perform a transaction of one or more messages. The messages are separated by repeated starts, with a stop after
+	// the last one. Reads fill the Data of their messages.
+	Tx(messages []I2CMessage) error
+}
+
+// Optional interface for I2C devices that support the full set of SMBus operations. Words are in SMBus order, with
+// the low byte first on the bus; see ReadI2CWord for other orders.
+type I2CSMBusDevice interface {
+	I2CDevice
+
+	// Send just the address, with the read/write bit set by read. Some devices use this as an on/off command.
+	QuickCommand(read bool) error
+
+	// Read a byte without sending a register first.
+	ReceiveByte() (byte, error)
+
+	// Write a single byte, without a register.
+	SendByte(value byte) error
+
+	// Read a 16-bit word from a register.
+	ReadWord(command byte) (uint16, error)
+
+	// Write a 16-bit word to a register.
+	WriteWord(command byte, value uint16) error
+
+	// Write a word to a register and read a word back in the same transaction.
+	ProcessCall(command byte, value uint16) (uint16, error)
+
+	// Read a block from a register, where the device sends the length first. Blocks are at most I2C_SMBUS_BLOCK_MAX
+	// bytes.
+	BlockRead(command byte) ([]byte, error)
+
+	// Write a block to a register, sending its length first.
+	BlockWrite(command byte, data []byte) error
+
+	// Write a block to a register and read a block back in the same transaction.
+	BlockProcessCall(command byte, data []byte) ([]byte, error)
+}
+
+// Settings for a device on an SPI bus.
+type SPIConfig struct {
+	// Clock polarity and phase, one of SPI_MODE_0 to SPI_MODE_3
+	Mode int
+
+	// Number of bits in each word. If zero, words are 8 bits.
+	BitsPerWord int
+
+	// Maximum clock speed in Hz. If zero, the default for the bus is used, which for spidev is the speed from the device
+	// tree.
+	MaxSpeedHz int
+
+	// Send and receive the least significant bit of each word first
+	LSBFirst bool
+
+	// The chip select is active high rather than active low
+	CSHigh bool
+
+	// Don't use the chip select, e.g. when the device is the only one on the bus
+	NoCS bool
+}
+
+// SPI modes, which are the combinations of the clock polarity (CPOL) and phase (CPHA)
+const (
+	SPI_CPHA = 0x01 // sample on the second edge of the clock
+	SPI_CPOL = 0x02 // clock is high when idle
+
+	SPI_MODE_0 = 0
+	SPI_MODE_1 = SPI_CPHA
+	SPI_MODE_2 = SPI_CPOL
+	SPI_MODE_3 = SPI_CPOL | SPI_CPHA
+)
+
 // Interface for SPI implementations
 type SPIModule interface {
 	Module
 
-	// Select the device, and send data to it
-	Write(slaveSelect int, data []byte) (e error)
+	// Get the device on a chip select. Each device has its own settings, so there can be more than one for the same
+	// chip select, e.g. for a device that needs different modes for different operations.
+	GetDevice(chipSelect int) SPIDevice
+}
+
+// A device on an SPI bus. Transfers use the device's settings, so devices that need different modes and speeds can
+// share a bus, and a transfer is never interleaved with another device's.
+type SPIDevice interface {
+	// Change the settings used for transfers with the device.
+	Configure(config SPIConfig) error
+
+	// Select the device and do a full duplex transfer, sending write while receiving into read. If both are given they
+	// must be the same length. Either can be nil, to only send (discarding what is received) or only receive (sending
+	// zeros).
+	Tx(write []byte, read []byte) error
+
+	// Do a transfer made of several segments, with the device selected throughout unless a segment says otherwise.
+	TxSegments(segments []SPISegment) error
+}
+
+// A segment of a transfer with SPIDevice.TxSegments.
+type SPISegment struct {
+	// Bytes to send and a buffer for the bytes received, as for SPIDevice.Tx.
+	Write []byte
+	Read  []byte
+
+	// Clock speed for the segment in Hz. If zero, the device's MaxSpeedHz is used.
+	SpeedHz int
+
+	// Time to wait after the segment, before the next one starts or the device is deselected.
+	Delay time.Duration
+
+	// Deselect the device between this segment and the next. It is ignored on the last segment, after which the
+	// device is always deselected.
+	CSChange bool
+}
+
+// Interface for serial ports (UARTs). Enabling the module assigns its pins and opens the port, after which it is read
+// and written as a stream. Close is the same as Disable.
+type SerialModule interface {
+	Module
+	io.ReadWriteCloser
+
+	// Change the settings of the port. If the module is enabled they are applied straight away, otherwise when it is
+	// enabled.
+	Configure(config SerialConfig) error
+}
+
+// Settings for a serial port. The zero value is 9600 baud, 8 data bits, no parity and 1 stop bit (9600 8N1), without
+// flow control, with reads that block until there is data.
+type SerialConfig struct {
+	// Bits per second. If zero, 9600.
+	BaudRate int
+
+	// Number of data bits, from 5 to 8. If zero, 8.
+	DataBits int
+
+	Parity SerialParity
+
+	// Number of stop bits, 1 or 2. If zero, 1.
+	StopBits int
+
+	FlowControl SerialFlowControl
+
+	// If not zero, Read waits at most this long for data, and fails with an error that matches os.ErrDeadlineExceeded
+	// if none arrives. The kernel counts in tenths of a second, up to 25.5 seconds.
+	ReadTimeout time.Duration
+}
+
+type SerialParity int
+
+const (
+	PARITY_NONE SerialParity = iota
+	PARITY_ODD
+	PARITY_EVEN
+)
+
+type SerialFlowControl int
+
+const (
+	FLOW_NONE     SerialFlowControl = iota
+	FLOW_HARDWARE                   // RTS and CTS lines
+	FLOW_SOFTWARE                   // XON and XOFF characters
+)
+
+// Interface for 1-Wire buses. The bus master searches the bus for devices, each of which has a unique 64-bit ROM code.
+type OneWireModule interface {
+	Module
+
+	// Get the devices that have been found on the bus.
+	Devices() ([]OneWireDevice, error)
+
+	// Get a device by its ID, which is its family code and serial number in hex, e.g. "28-0000055f1b2c". Errors, such
+	// as the device not being on the bus, are returned when it is used.
+	GetDevice(id string) OneWireDevice
+}
+
+// A device on a 1-Wire bus.
+type OneWireDevice interface {
+	// The device's ID, e.g. "28-0000055f1b2c"
+	ID() string
+
+	// The family code, the first byte of the ROM code, which says what kind of device it is, e.g. 0x28 for a DS18B20.
+	Family() byte
+
+	// Read the 64-bit ROM code, with the family code in the low byte, then the 48-bit serial number, and the CRC in the
+	// high byte, which is checked.
+	ROM() (uint64, error)
+
+	// Reset the bus, select the device and write bytes to it.
+	Write(data []byte) error
+
+	// Read bytes from the device, carrying on from the last Write, e.g. to read the reply to a command.
+	Read(n int) ([]byte, error)
+}
+
+// Optional interface for 1-Wire devices that have a kernel driver, which provides files for the device's functions,
+// such as w1_slave for temperature sensors.
+type OneWireAttributeDevice interface {
+	OneWireDevice
+
+	// Read one of the files of the device's driver.
+	ReadAttribute(name string) ([]byte, error)
 
-	// Select the device, and read data from it
-	Read(slaveSelect int, data []byte) (nBytes int, e error)
+	// Write to one of the files of the device's driver.
+	WriteAttribute(name string, data []byte) error
 }
 
 // Interface for controlling on-board LEDs, modelled on /sys/class/leds
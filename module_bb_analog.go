@@ -8,10 +8,13 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // BBAnalogModule handles BeagleBone-specific analog.
 type BBAnalogModule struct {
+	sync.Mutex
+
 	name string
 
 	analogInitialised    bool
@@ -62,6 +65,9 @@ func (module *BBAnalogModule) SetOptions(options map[string]interface{}) error {
 
 // enable GPIO module. It doesn't allocate any pins immediately.
 func (module *BBAnalogModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
 	// once-off initialisation of analog
 	if !module.analogInitialised {
 		path, e := findFirstMatchingFile("/sys/devices/bone_capemgr.*/slots")
@@ -117,10 +123,13 @@ func (module *BBAnalogModule) hasCapeBoneIIO(path string) bool {
 
 // disables module and release any pins assigned.
 func (module *BBAnalogModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
 	// Unassign any pins we may have assigned
 	for pin, _ := range module.definedPins {
 		// attempt to assign this pin for this module.
-		UnassignPin(pin)
+		unassignPin(pin, module)
 	}
 
 	// if there are any open analog pins, close them
@@ -134,11 +143,10 @@ func (module *BBAnalogModule) GetName() string {
 	return module.name
 }
 
-// func (module *BBAnalogModule) AnalogWrite(pin Pin, value int) (e error) {
-// 	return nil
-// }
-
 func (module *BBAnalogModule) AnalogRead(pin Pin) (int, error) {
+	module.Lock()
+	defer module.Unlock()
+
 	var e error
 
 	// Get it if it's already open
@@ -157,7 +165,7 @@ func (module *BBAnalogModule) AnalogRead(pin Pin) (int, error) {
 func (module *BBAnalogModule) makeOpenAnalogPin(pin Pin) (*BBAnalogModuleOpenPin, error) {
 	p := module.definedPins[pin]
 	if p == nil {
-		return nil, fmt.Errorf("Pin %d is not known to analog module", pin)
+		return nil, pinError("AnalogRead", pin, module, ErrUnknownPin)
 	}
 
 	path := module.analogValueFilesPath + fmt.Sprintf("AIN%d", p.analogLogical)
@@ -18,9 +18,12 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type BBPWMModule struct {
+	sync.Mutex
+
 	name        string
 	definedPins BBPWMModulePinDefMap
 	openPins    map[Pin]*BBPWMModuleOpenPin
@@ -42,6 +45,12 @@ type BBPWMModuleOpenPin struct {
 	dutyFile     string
 	polarityFile string
 	runFile      string
+
+	// current settings
+	period   int64
+	duty     int64
+	polarity PWMPolarity
+	running  bool
 }
 
 func (pinDef BBPWMModulePinDef) overlayName() string {
@@ -81,6 +90,9 @@ func (module *BBPWMModule) Enable() error {
 
 // disables module and release any pins assigned.
 func (module *BBPWMModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
 	for _, openPin := range module.openPins {
 		openPin.closePin()
 	}
@@ -94,55 +106,166 @@ func (module *BBPWMModule) GetName() string {
 // Enable a specific PWM pin. You need to call this explicitly after enabling the module, as the
 // module will not by default allocate all pins, since there are a few.
 func (module *BBPWMModule) EnablePin(pin Pin, enabled bool) error {
+	module.Lock()
+	defer module.Unlock()
+
 	if module.definedPins[pin] == nil {
-		return fmt.Errorf("Pin %d is not known as a PWM pin on module %s", pin, module.GetName())
+		return pinError("EnablePin", pin, module, ErrUnknownPin)
 	}
 
 	openPin := module.openPins[pin]
-	if enabled {
-		// ensure pin is enabled by creating an open pin
-		if openPin == nil {
-			p, e := module.makeOpenPin(pin)
-			if e != nil {
-				return e
-			}
-			module.openPins[pin] = p
-			return p.enabled(true)
+	if openPin == nil {
+		if !enabled {
+			return nil
 		}
-	} else {
-		// disable the pin if enabled
-		if openPin != nil {
-			return openPin.enabled(false)
+		p, e := module.makeOpenPin(pin)
+		if e != nil {
+			return e
 		}
+		openPin = p
 	}
-	return nil
+	return openPin.enabled(enabled)
 }
 
 // Set the period of this pin, in nanoseconds
 func (module *BBPWMModule) SetPeriod(pin Pin, ns int64) error {
+	return module.setTiming("SetPeriod", pin, func(op *BBPWMModuleOpenPin) (int64, int64, error) {
+		return ns, op.duty, nil
+	})
+}
+
+// Set the period of this pin, in nanoseconds, scaling the duty time to keep the duty cycle.
+func (module *BBPWMModule) SetPeriodKeepDutyCycle(pin Pin, ns int64) error {
+	return module.setTiming("SetPeriodKeepDutyCycle", pin, func(op *BBPWMModuleOpenPin) (int64, int64, error) {
+		return ns, scalePWMDuty(op.duty, op.period, ns), nil
+	})
+}
+
+// Set the duty time, the amount of time during each period that that output is HIGH.
+func (module *BBPWMModule) SetDuty(pin Pin, ns int64) error {
+	return module.setTiming("SetDuty", pin, func(op *BBPWMModuleOpenPin) (int64, int64, error) {
+		return op.period, ns, nil
+	})
+}
+
+// Set the duty time as a fraction of the period.
+func (module *BBPWMModule) SetDutyCycle(pin Pin, fraction float64) error {
+	return module.setTiming("SetDutyCycle", pin, func(op *BBPWMModuleOpenPin) (int64, int64, error) {
+		duty, e := pwmDutyCycle(op.period, fraction)
+		return op.period, duty, e
+	})
+}
+
+// Change the period and duty time of a pin to those given by timing, which gets the current settings.
+func (module *BBPWMModule) setTiming(op string, pin Pin, timing func(op *BBPWMModuleOpenPin) (int64, int64, error)) error {
+	module.Lock()
+	defer module.Unlock()
+
 	openPin := module.openPins[pin]
 	if openPin == nil {
-		return fmt.Errorf("PWM pin is being written but is not enabled. Have you called EnablePin?")
+		return notEnabledError(op, pin, module)
 	}
 
-	return openPin.setPeriod(ns)
+	period, duty, e := timing(openPin)
+	if e == nil {
+		e = checkPWMDuty(duty, period)
+	}
+	if e != nil {
+		return pinError(op, pin, module, e)
+	}
+
+	// the driver won't take a duty time longer than the period, so they are written in the order that avoids it
+	if duty > openPin.period {
+		e = openPin.setPeriod(period)
+		if e == nil {
+			e = openPin.setDuty(duty)
+		}
+	} else {
+		e = openPin.setDuty(duty)
+		if e == nil {
+			e = openPin.setPeriod(period)
+		}
+	}
+	if e != nil {
+		return pinError(op, pin, module, e)
+	}
+	return nil
 }
 
-// Set the duty time, the amount of time during each period that that output is HIGH.
-func (module *BBPWMModule) SetDuty(pin Pin, ns int64) error {
+// Set whether the output is HIGH or LOW for the duty time.
+func (module *BBPWMModule) SetPolarity(pin Pin, polarity PWMPolarity) error {
+	module.Lock()
+	defer module.Unlock()
+
 	openPin := module.openPins[pin]
 	if openPin == nil {
-		return fmt.Errorf("PWM pin is being written but is not enabled. Have you called EnablePin?")
+		return notEnabledError("SetPolarity", pin, module)
+	}
+
+	value, e := pwmPolarityName(polarity, "0", "1")
+	if e == nil {
+		e = WriteStringToFile(openPin.polarityFile, value)
+	}
+	if e != nil {
+		return pinError("SetPolarity", pin, module, e)
+	}
+	openPin.polarity = polarity
+	return nil
+}
+
+func (module *BBPWMModule) IsPinEnabled(pin Pin) (bool, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.definedPins[pin] == nil {
+		return false, pinError("IsPinEnabled", pin, module, ErrUnknownPin)
+	}
+	openPin := module.openPins[pin]
+	return openPin != nil && openPin.running, nil
+}
+
+func (module *BBPWMModule) GetPeriod(pin Pin) (int64, error) {
+	openPin, e := module.getOpenPin("GetPeriod", pin)
+	if e != nil {
+		return 0, e
+	}
+	return openPin.period, nil
+}
+
+func (module *BBPWMModule) GetDuty(pin Pin) (int64, error) {
+	openPin, e := module.getOpenPin("GetDuty", pin)
+	if e != nil {
+		return 0, e
 	}
+	return openPin.duty, nil
+}
 
-	return openPin.setDuty(ns)
+func (module *BBPWMModule) GetPolarity(pin Pin) (PWMPolarity, error) {
+	openPin, e := module.getOpenPin("GetPolarity", pin)
+	if e != nil {
+		return PWM_POLARITY_NORMAL, e
+	}
+	return openPin.polarity, nil
 }
 
-// create an openPin object and put it in the map.
+// Get a copy of the settings of a pin that has been enabled.
+func (module *BBPWMModule) getOpenPin(op string, pin Pin) (BBPWMModuleOpenPin, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return BBPWMModuleOpenPin{}, notEnabledError(op, pin, module)
+	}
+	return *openPin, nil
+}
+
+// create an openPin object and put it in the map. If any step fails, the pin is released again and left out of the
+// map.
 func (module *BBPWMModule) makeOpenPin(pin Pin) (*BBPWMModuleOpenPin, error) {
 	p := module.definedPins[pin]
 	if p == nil {
-		return nil, fmt.Errorf("Pin %d is not known to PWM module %s", pin, module.GetName())
+		return nil, pinError("EnablePin", pin, module, ErrUnknownPin)
 	}
 
 	e := AssignPin(pin, module)
@@ -150,8 +273,20 @@ func (module *BBPWMModule) makeOpenPin(pin Pin) (*BBPWMModuleOpenPin, error) {
 		return nil, e
 	}
 
+	result, e := module.setupPin(pin, p)
+	if e != nil {
+		unassignPin(pin, module)
+		return nil, pinError("EnablePin", pin, module, e)
+	}
+
+	module.openPins[pin] = result
+	return result, nil
+}
+
+// Load the overlay for a pin and read its settings.
+func (module *BBPWMModule) setupPin(pin Pin, p *BBPWMModulePinDef) (*BBPWMModuleOpenPin, error) {
 	// Ensure that the cape manager knows about it
-	e = module.ensureSlot(p.overlayName())
+	e := module.ensureSlot(p.overlayName())
 	if e != nil {
 		return nil, e
 	}
@@ -163,14 +298,21 @@ func (module *BBPWMModule) makeOpenPin(pin Pin) (*BBPWMModuleOpenPin, error) {
 	result.runFile = dir + "run"
 	result.polarityFile = dir + "polarity"
 
-	module.openPins[pin] = result
-
 	// ensure polarity is 0, so that the duty time represents the time the signal is high.
 	e = WriteStringToFile(result.polarityFile, "0")
 	if e != nil {
 		return nil, e
 	}
 
+	// the device starts with its own period and duty time
+	result.period, e = readPWMFile(result.periodFile)
+	if e == nil {
+		result.duty, e = readPWMFile(result.dutyFile)
+	}
+	if e != nil {
+		return nil, e
+	}
+
 	return result, nil
 }
 
@@ -218,6 +360,7 @@ func (op *BBPWMModuleOpenPin) setPeriod(ns int64) error {
 		return e
 	}
 
+	op.period = ns
 	return nil
 }
 
@@ -228,13 +371,19 @@ func (op *BBPWMModuleOpenPin) setDuty(ns int64) error {
 		return e
 	}
 
+	op.duty = ns
 	return nil
 }
 
 func (op *BBPWMModuleOpenPin) enabled(e bool) error {
+	value := "0"
 	if e {
-		return WriteStringToFile(op.runFile, "1")
-	} else {
-		return WriteStringToFile(op.runFile, "0")
+		value = "1"
 	}
+	err := WriteStringToFile(op.runFile, value)
+	if err != nil {
+		return err
+	}
+	op.running = e
+	return nil
 }
@@ -0,0 +1,24 @@
+package hwio
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBBPWMEnablePinFailure(t *testing.T) {
+	SetDriver(newTestWiredDriver())
+
+	pwm := NewBBPWMModule("bbpwm")
+	pwm.SetOptions(map[string]interface{}{"pins": BBPWMModulePinDefMap{0: {pin: 0, name: "P9_14"}}})
+
+	// there is no cape manager here, so the pin can't be set up
+	if e := pwm.EnablePin(0, true); e == nil {
+		t.Fatalf("Expected EnablePin to fail without a cape manager")
+	}
+	if _, e := pwm.GetPeriod(0); !errors.Is(e, ErrNotOpen) {
+		t.Errorf("Expected ErrNotOpen getting the period of a pin that failed to enable, got %v", e)
+	}
+	if e := PinMode(0, OUTPUT); e != nil {
+		t.Errorf("Expected the pin to be released after EnablePin failed, got %v", e)
+	}
+}
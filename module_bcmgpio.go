@@ -0,0 +1,325 @@
+// A GPIO module for Raspberry Pi that accesses the GPIO registers directly through a memory map of /dev/gpiomem. This
+// avoids the kernel on every read and write, which makes it very much faster than the sysfs and gpiochip modules, and
+// suits bit-banged protocols. The trade-off is that the kernel doesn't know which pins are in use, and edges can't be
+// watched. Pin definitions are the same as DTGPIOModule's, where the logical GPIO number is the BCM GPIO number.
+
+package hwio
+
+// References:
+// - http://hackaday.com/2013/12/07/speeding-up-beaglebone-black-gpio-a-thousand-times/
+// - BCM2835 ARM Peripherals, chapter 6
+
+import (
+	"fmt"
+	"sync"
+)
+
+type BCMGPIOModule struct {
+	sync.Mutex
+
+	name        string
+	definedPins DTGPIOModulePinDefMap
+	openPins    map[Pin]*BCMGPIOModuleOpenPin
+
+	// register access, mapped on Enable or first use
+	gpio *bcmGPIO
+}
+
+type BCMGPIOModuleOpenPin struct {
+	pin  Pin
+	gpio int // BCM GPIO number
+
+	// offsets of the registers for this pin, and the pin's bit in them
+	setReg int
+	clrReg int
+	levReg int
+	bit    uint32
+}
+
+func NewBCMGPIOModule(name string) (result *BCMGPIOModule) {
+	result = &BCMGPIOModule{name: name}
+	result.openPins = make(map[Pin]*BCMGPIOModuleOpenPin)
+	return result
+}
+
+// Set options of the module. Parameters we look for include:
+// - "pins" - an object of type DTGPIOModulePinDefMap
+func (module *BCMGPIOModule) SetOptions(options map[string]interface{}) error {
+	v := options["pins"]
+	if v == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.definedPins = v.(DTGPIOModulePinDefMap)
+	return nil
+}
+
+// enable GPIO module. This maps the GPIO registers, but doesn't allocate any pins.
+func (module *BCMGPIOModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	return module.enable()
+}
+
+func (module *BCMGPIOModule) enable() error {
+	if module.gpio != nil {
+		return nil
+	}
+
+	g, e := openBCMGPIO()
+	if e != nil {
+		return fmt.Errorf("Module '%s' could not map /dev/gpiomem: %w", module.GetName(), e)
+	}
+	module.gpio = g
+	return nil
+}
+
+// disables module and release any pins assigned. Pins are returned to inputs.
+func (module *BCMGPIOModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	for pin := range module.openPins {
+		module.closePin(pin)
+	}
+
+	if module.gpio == nil {
+		return nil
+	}
+	e := module.gpio.close()
+	module.gpio = nil
+	return e
+}
+
+func (module *BCMGPIOModule) GetName() string {
+	return module.name
+}
+
+// Set the mode of a pin. INPUT leaves the pull resistor as it is.
+func (module *BCMGPIOModule) PinMode(pin Pin, mode PinIOMode) error {
+	module.Lock()
+	defer module.Unlock()
+
+	p := module.definedPins[pin]
+	if p == nil {
+		return pinError("PinMode", pin, module, ErrUnknownPin)
+	}
+
+	e := module.enable()
+	if e != nil {
+		return e
+	}
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		// attempt to assign this pin for this module.
+		e = AssignPin(pin, module)
+		if e != nil {
+			return e
+		}
+
+		openPin = module.makeOpenGPIOPin(pin, p.gpioLogical)
+		module.openPins[pin] = openPin
+	}
+
+	if mode == OUTPUT {
+		module.setFunction(openPin.gpio, 1)
+		return nil
+	}
+
+	module.setFunction(openPin.gpio, 0)
+	if mode == INPUT_PULLUP || mode == INPUT_PULLDOWN {
+		return module.gpio.setPull(openPin.gpio, mode)
+	}
+	return nil
+}
+
+// Drive a pin LOW, or release it as an input. The output latch is cleared before the pin is switched to an output, so
+// the pin is never driven by a HIGH left in the latch.
+func (module *BCMGPIOModule) SetOpenDrain(pin Pin, value int) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notOpenError("SetOpenDrain", pin, module)
+	}
+
+	if value != LOW {
+		module.setFunction(openPin.gpio, 0)
+		return nil
+	}
+	module.gpio.write(openPin.clrReg, openPin.bit)
+	module.setFunction(openPin.gpio, 1)
+	return nil
+}
+
+func (module *BCMGPIOModule) DigitalWrite(pin Pin, value int) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notOpenError("DigitalWrite", pin, module)
+	}
+
+	if value == LOW {
+		module.gpio.write(openPin.clrReg, openPin.bit)
+	} else {
+		module.gpio.write(openPin.setReg, openPin.bit)
+	}
+	return nil
+}
+
+func (module *BCMGPIOModule) DigitalRead(pin Pin) (int, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return 0, notOpenError("DigitalRead", pin, module)
+	}
+
+	if module.gpio.read(openPin.levReg)&openPin.bit != 0 {
+		return HIGH, nil
+	}
+	return LOW, nil
+}
+
+// Close a pin, returning it to an input.
+func (module *BCMGPIOModule) ClosePin(pin Pin) error {
+	module.Lock()
+	defer module.Unlock()
+
+	return module.closePin(pin)
+}
+
+func (module *BCMGPIOModule) closePin(pin Pin) error {
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notOpenError("ClosePin", pin, module)
+	}
+
+	module.setFunction(openPin.gpio, 0)
+	delete(module.openPins, pin)
+	return unassignPin(pin, module)
+}
+
+// create an openPin object, precalculating the register offsets for the pin.
+func (module *BCMGPIOModule) makeOpenGPIOPin(pin Pin, gpio int) *BCMGPIOModuleOpenPin {
+	bank := (gpio / 32) * 4
+	return &BCMGPIOModuleOpenPin{
+		pin:    pin,
+		gpio:   gpio,
+		setReg: bcmGPSET0 + bank,
+		clrReg: bcmGPCLR0 + bank,
+		levReg: bcmGPLEV0 + bank,
+		bit:    1 << uint(gpio%32),
+	}
+}
+
+// Set the function select bits of a GPIO. 0 is input, 1 is output, others are alternate functions.
+func (module *BCMGPIOModule) setFunction(gpio int, function uint32) {
+	// 10 GPIOs per register, 3 bits each
+	offset := bcmGPFSEL0 + (gpio/10)*4
+	shift := uint(gpio%10) * 3
+	module.gpio.write(offset, module.gpio.read(offset)&^(7<<shift)|function<<shift)
+}
+
+// A bank of pins on a BCMGPIOModule. Pins going high are changed by one write to the GPSET register, and pins going
+// low by a write to the GPCLR register straight after it, so for a moment the pins going high are already high while
+// the pins going low are still high too. A bank is therefore only atomic if it has a single pin.
+type bcmGPIOBank struct {
+	module *BCMGPIOModule
+	pins   []*BCMGPIOModuleOpenPin
+}
+
+// Open a bank over pins that have been set up with PinMode.
+func (module *BCMGPIOModule) OpenBank(pins PinList) (GPIOBank, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	e := checkBankPins(pins)
+	if e != nil {
+		return nil, e
+	}
+
+	bank := &bcmGPIOBank{module: module}
+	for _, pin := range pins {
+		openPin := module.openPins[pin]
+		if openPin == nil {
+			return nil, notOpenError("OpenBank", pin, module)
+		}
+		bank.pins = append(bank.pins, openPin)
+	}
+	return bank, nil
+}
+
+// Determine if a bank over the pins would be atomic. Writes to more than one pin can take both a GPSET and a GPCLR
+// write, so this is only the case for a single pin.
+func (module *BCMGPIOModule) AtomicPins(pins PinList) bool {
+	module.Lock()
+	defer module.Unlock()
+
+	return checkBankPins(pins) == nil && len(pins) == 1 && module.definedPins[pins[0]] != nil
+}
+
+func (bank *bcmGPIOBank) Write(value uint32) error {
+	bank.module.Lock()
+	defer bank.module.Unlock()
+
+	if bank.module.gpio == nil {
+		return fmt.Errorf("Bank is being written but the module is %w", ErrClosed)
+	}
+
+	// set and clear masks for each of the two registers
+	var set, clr [2]uint32
+	for i, openPin := range bank.pins {
+		register := openPin.gpio / 32
+		if bankBit(value, i, len(bank.pins)) == HIGH {
+			set[register] |= openPin.bit
+		} else {
+			clr[register] |= openPin.bit
+		}
+	}
+
+	g := bank.module.gpio
+	for register := range set {
+		if set[register] != 0 {
+			g.write(bcmGPSET0+register*4, set[register])
+		}
+		if clr[register] != 0 {
+			g.write(bcmGPCLR0+register*4, clr[register])
+		}
+	}
+	return nil
+}
+
+func (bank *bcmGPIOBank) Read() (uint32, error) {
+	bank.module.Lock()
+	defer bank.module.Unlock()
+
+	if bank.module.gpio == nil {
+		return 0, fmt.Errorf("Bank is being read from but the module is %w", ErrClosed)
+	}
+
+	g := bank.module.gpio
+	levels := [2]uint32{g.read(bcmGPLEV0), g.read(bcmGPLEV0 + 4)}
+
+	result := uint32(0)
+	for i, openPin := range bank.pins {
+		if levels[openPin.gpio/32]&openPin.bit != 0 {
+			result |= bankMask(i, len(bank.pins))
+		}
+	}
+	return result, nil
+}
+
+func (bank *bcmGPIOBank) Atomic() bool {
+	return len(bank.pins) == 1
+}
+
+func (bank *bcmGPIOBank) Close() error {
+	return nil
+}
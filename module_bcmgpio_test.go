@@ -0,0 +1,131 @@
+package hwio
+
+// Unit tests for BCMGPIOModule, with the GPIO registers held in memory.
+
+import (
+	"testing"
+)
+
+func newTestBCMGPIOModule() *BCMGPIOModule {
+	// the module assigns its pins on the default board, so start with a fresh one
+	SetDriver(new(TestDriver))
+
+	pins := make(DTGPIOModulePinDefMap)
+	pins[1] = &DTGPIOModulePinDef{pin: 1, gpioLogical: 17}
+	pins[2] = &DTGPIOModulePinDef{pin: 2, gpioLogical: 4}
+
+	module := NewBCMGPIOModule("gpio")
+	module.SetOptions(map[string]interface{}{"pins": pins})
+	module.gpio = newBCMGPIO(make([]byte, bcmGPIOMapLen))
+	return module
+}
+
+func TestBCMGPIOWrite(t *testing.T) {
+	module := newTestBCMGPIOModule()
+	defer module.Disable()
+
+	e := module.PinMode(1, OUTPUT)
+	if e != nil {
+		t.Fatalf("PinMode returned an unexpected error: %s", e)
+	}
+
+	// GPIO 17 is bits 21-23 of GPFSEL1
+	if v := module.gpio.read(bcmGPFSEL0 + 4); (v>>21)&7 != 1 {
+		t.Errorf("Expected GPIO 17 function to be output, GPFSEL1 is %08x", v)
+	}
+
+	module.DigitalWrite(1, HIGH)
+	if v := module.gpio.read(bcmGPSET0); v != 1<<17 {
+		t.Errorf("Expected DigitalWrite HIGH to write bit 17 of GPSET0, got %08x", v)
+	}
+	module.DigitalWrite(1, LOW)
+	if v := module.gpio.read(bcmGPCLR0); v != 1<<17 {
+		t.Errorf("Expected DigitalWrite LOW to write bit 17 of GPCLR0, got %08x", v)
+	}
+}
+
+func TestBCMGPIORead(t *testing.T) {
+	module := newTestBCMGPIOModule()
+	defer module.Disable()
+
+	module.gpio.write(bcmGPFSEL0, 7<<12)
+	e := module.PinMode(2, INPUT)
+	if e != nil {
+		t.Fatalf("PinMode returned an unexpected error: %s", e)
+	}
+	if v := module.gpio.read(bcmGPFSEL0); v != 0 {
+		t.Errorf("Expected GPIO 4 function to be input, GPFSEL0 is %08x", v)
+	}
+
+	module.gpio.write(bcmGPLEV0, 1<<4)
+	v, e := module.DigitalRead(2)
+	if e != nil || v != HIGH {
+		t.Errorf("Expected DigitalRead to return HIGH, got %d, %v", v, e)
+	}
+
+	module.gpio.write(bcmGPLEV0, 0)
+	if v, _ = module.DigitalRead(2); v != LOW {
+		t.Error("Expected DigitalRead to return LOW")
+	}
+}
+
+func TestBCMGPIOClosePin(t *testing.T) {
+	module := newTestBCMGPIOModule()
+	defer module.Disable()
+
+	module.PinMode(1, OUTPUT)
+	e := module.ClosePin(1)
+	if e != nil {
+		t.Errorf("ClosePin returned an unexpected error: %s", e)
+	}
+	if v := module.gpio.read(bcmGPFSEL0 + 4); v != 0 {
+		t.Errorf("Expected GPIO 17 to be returned to input after ClosePin, GPFSEL1 is %08x", v)
+	}
+	if e = module.DigitalWrite(1, HIGH); e == nil {
+		t.Error("DigitalWrite after ClosePin should return an error")
+	}
+}
+
+func TestBCMGPIOBank(t *testing.T) {
+	module := newTestBCMGPIOModule()
+	defer module.Disable()
+	module.definedPins[3] = &DTGPIOModulePinDef{pin: 3, gpioLogical: 27}
+	module.definedPins[4] = &DTGPIOModulePinDef{pin: 4, gpioLogical: 40}
+
+	module.PinMode(1, OUTPUT)
+	module.PinMode(2, OUTPUT)
+	module.PinMode(3, OUTPUT)
+
+	if module.AtomicPins(PinList{1, 4}) {
+		t.Error("GPIOs in different registers should not be atomic")
+	}
+	if module.AtomicPins(PinList{1, 2}) {
+		t.Error("GPIOs that can be set and cleared by one write should not be atomic")
+	}
+	if !module.AtomicPins(PinList{1}) {
+		t.Error("A single GPIO should be atomic")
+	}
+
+	bank, e := module.OpenBank(PinList{1, 2, 3})
+	if e != nil {
+		t.Fatalf("OpenBank returned an unexpected error: %s", e)
+	}
+	if bank.Atomic() {
+		t.Error("Expected a bank of several pins not to be atomic, as it is set and cleared by separate writes")
+	}
+
+	// one write each to GPSET0 and GPCLR0 changes all three pins
+	bank.Write(5)
+	if v := module.gpio.read(bcmGPSET0); v != 1<<17|1<<27 {
+		t.Errorf("Expected GPIOs 17 and 27 to be set, GPSET0 is %08x", v)
+	}
+	if v := module.gpio.read(bcmGPCLR0); v != 1<<4 {
+		t.Errorf("Expected GPIO 4 to be cleared, GPCLR0 is %08x", v)
+	}
+
+	module.gpio.write(bcmGPLEV0, 1<<4|1<<27)
+	v, e := bank.Read()
+	if e != nil || v != 3 {
+		t.Errorf("Expected Read to return 3, got %d, %v", v, e)
+	}
+}
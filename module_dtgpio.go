@@ -8,12 +8,26 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 )
 
 type DTGPIOModule struct {
+	sync.Mutex
+
 	name        string
 	definedPins DTGPIOModulePinDefMap
 	openPins    map[Pin]*DTGPIOModuleOpenPin
+
+	// sets pull resistors for INPUT_PULLUP and INPUT_PULLDOWN. nil if the board has no way to set them.
+	pull DTGPIOPullSetter
+}
+
+// Sets the pull resistor of a GPIO. The sysfs GPIO interface has no control of pull resistors, so drivers that can set
+// them some other way pass one of these in the "pull" option. mode is one of INPUT (no pull), INPUT_PULLUP or
+// INPUT_PULLDOWN.
+type DTGPIOPullSetter interface {
+	SetPull(gpioLogical int, mode PinIOMode) error
 }
 
 // Represents the definition of a GPIO pin, which should contain all the info required to open, close, read and write the pin
@@ -31,6 +45,10 @@ type DTGPIOModuleOpenPin struct {
 	gpioLogical  int
 	gpioBaseName string
 	valueFile    *os.File
+	mode         PinIOMode
+
+	// set while the pin is being watched for edges
+	watch *pinWatch
 }
 
 func NewDTGPIOModule(name string) (result *DTGPIOModule) {
@@ -41,6 +59,7 @@ func NewDTGPIOModule(name string) (result *DTGPIOModule) {
 
 // Set options of the module. Parameters we look for include:
 // - "pins" - an object of type DTGPIOModulePinDefMap
+// - "pull" - an optional DTGPIOPullSetter, required for INPUT_PULLUP and INPUT_PULLDOWN
 func (module *DTGPIOModule) SetOptions(options map[string]interface{}) error {
 	v := options["pins"]
 	if v == nil {
@@ -48,6 +67,10 @@ func (module *DTGPIOModule) SetOptions(options map[string]interface{}) error {
 	}
 
 	module.definedPins = v.(DTGPIOModulePinDefMap)
+
+	if vp := options["pull"]; vp != nil {
+		module.pull = vp.(DTGPIOPullSetter)
+	}
 	return nil
 }
 
@@ -58,9 +81,18 @@ func (module *DTGPIOModule) Enable() error {
 
 // disables module and release any pins assigned.
 func (module *DTGPIOModule) Disable() error {
-	for _, openPin := range module.openPins {
+	module.Lock()
+	defer module.Unlock()
+
+	for pin, openPin := range module.openPins {
+		if openPin.watch != nil {
+			openPin.unwatch()
+		}
+		openPin.closeValueFile()
 		openPin.gpioUnexport()
+		unassignPin(pin, module)
 	}
+	module.openPins = make(map[Pin]*DTGPIOModuleOpenPin)
 	return nil
 }
 
@@ -68,57 +100,73 @@ func (module *DTGPIOModule) GetName() string {
 	return module.name
 }
 
+// Set the mode of a pin. INPUT leaves the pull resistor as it is. INPUT_PULLUP and INPUT_PULLDOWN need the driver to
+// have provided a pull setter, and return an error if it hasn't.
 func (module *DTGPIOModule) PinMode(pin Pin, mode PinIOMode) error {
+	module.Lock()
+	defer module.Unlock()
+
 	if module.definedPins[pin] == nil {
-		return fmt.Errorf("Pin %d is not known as a GPIO pin", pin)
+		return pinError("PinMode", pin, module, ErrUnknownPin)
 	}
 
-	// attempt to assign this pin for this module.
-	e := AssignPin(pin, module)
-	if e != nil {
-		return e
+	if (mode == INPUT_PULLUP || mode == INPUT_PULLDOWN) && module.pull == nil {
+		return pinError("PinMode", pin, module, fmt.Errorf("%s is %w, as this board has no way to set pull resistors", mode, ErrUnsupported))
 	}
 
-	// Create an open pin object
-	openPin, e := module.makeOpenGPIOPin(pin)
-	if e != nil {
-		return e
-	}
+	// A pin that is already open just has its direction changed; it is still assigned to this module.
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		e := AssignPin(pin, module)
+		if e != nil {
+			return e
+		}
 
-	e = openPin.gpioExport()
-	if e != nil {
-		return e
+		openPin, e = module.makeOpenGPIOPin(pin)
+		if e != nil {
+			unassignPin(pin, module)
+			return e
+		}
+		e = openPin.gpioExport()
+		if e != nil {
+			delete(module.openPins, pin)
+			unassignPin(pin, module)
+			return pinError("PinMode", pin, module, e)
+		}
+	} else if openPin.watch != nil {
+		openPin.unwatch()
 	}
 
+	var e error
+	openPin.mode = mode
 	if mode == OUTPUT {
-		fmt.Printf("about to set pin %d to output\n", pin)
 		e = openPin.gpioDirection("out")
 		if e != nil {
-			return e
+			return pinError("PinMode", pin, module, e)
 		}
 	} else {
 		e = openPin.gpioDirection("in")
-		// @todo implement pull up and pull down support
-
-		// pull := BB_CONF_PULL_DISABLE
-		// // note: pull up/down modes assume that CONF_PULLDOWN resets the pull disable bit
-		// if mode == INPUT_PULLUP {
-		// 	pull = BB_CONF_PULLUP
-		// } else if mode == INPUT_PULLDOWN {
-		// 	pull = BB_CONF_PULLDOWN
-		// }
-
 		if e != nil {
-			return e
+			return pinError("PinMode", pin, module, e)
+		}
+
+		if mode == INPUT_PULLUP || mode == INPUT_PULLDOWN {
+			e = module.pull.SetPull(openPin.gpioLogical, mode)
+			if e != nil {
+				return pinError("PinMode", pin, module, fmt.Errorf("could not set %s: %w", mode, e))
+			}
 		}
 	}
 	return nil
 }
 
 func (module *DTGPIOModule) DigitalWrite(pin Pin, value int) (e error) {
+	module.Lock()
+	defer module.Unlock()
+
 	openPin := module.openPins[pin]
 	if openPin == nil {
-		return errors.New("Pin is being written but has not been opened. Have you called PinMode?")
+		return notOpenError("DigitalWrite", pin, module)
 	}
 	// 	if a.pinIOMode != OUTPUT {
 	// 		return errors.New(fmt.Sprintf("DigitalWrite: pin %d mode is not set for output", pin))
@@ -128,9 +176,12 @@ func (module *DTGPIOModule) DigitalWrite(pin Pin, value int) (e error) {
 }
 
 func (module *DTGPIOModule) DigitalRead(pin Pin) (value int, e error) {
+	module.Lock()
+	defer module.Unlock()
+
 	openPin := module.openPins[pin]
 	if openPin == nil {
-		return 0, errors.New("Pin is being read from but has not been opened. Have you called PinMode?")
+		return 0, notOpenError("DigitalRead", pin, module)
 	}
 	// 	if a.pinIOMode != INPUT && a.pinIOMode != INPUT_PULLUP && a.pinIOMode != INPUT_PULLDOWN {
 	// 		e = errors.New(fmt.Sprintf("DigitalRead: pin %d mode not set for input", pin))
@@ -141,22 +192,109 @@ func (module *DTGPIOModule) DigitalRead(pin Pin) (value int, e error) {
 }
 
 func (module *DTGPIOModule) ClosePin(pin Pin) error {
+	module.Lock()
+	defer module.Unlock()
+
 	openPin := module.openPins[pin]
 	if openPin == nil {
-		return errors.New("Pin is being closed but has not been opened. Have you called PinMode?")
+		return notOpenError("ClosePin", pin, module)
+	}
+	if openPin.watch != nil {
+		openPin.unwatch()
 	}
+	openPin.closeValueFile()
+	delete(module.openPins, pin)
 	e := openPin.gpioUnexport()
 	if e != nil {
 		return e
 	}
-	return UnassignPin(pin)
+	return unassignPin(pin, module)
+}
+
+// Drive a pin LOW, or release it as an input. Writing "low" to the direction file makes the pin an output that is
+// already LOW, so it is never driven HIGH.
+func (module *DTGPIOModule) SetOpenDrain(pin Pin, value int) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notOpenError("SetOpenDrain", pin, module)
+	}
+
+	var e error
+	if value != LOW {
+		openPin.mode = INPUT
+		e = openPin.gpioDirection("in")
+	} else {
+		if openPin.watch != nil {
+			openPin.unwatch()
+		}
+		openPin.mode = OUTPUT
+		e = openPin.gpioDirection("low")
+	}
+	if e != nil {
+		return pinError("SetOpenDrain", pin, module, e)
+	}
+	return nil
+}
+
+// Start watching a pin for edges. The kernel signals edges through the pin's value file, which is polled by a
+// goroutine that delivers the events.
+func (module *DTGPIOModule) WatchPin(pin Pin, edge Edge) (<-chan PinEvent, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return nil, notOpenError("WatchPin", pin, module)
+	}
+	if openPin.mode == OUTPUT {
+		return nil, pinError("WatchPin", pin, module, fmt.Errorf("%w, only inputs can be watched", ErrWrongMode))
+	}
+	if openPin.watch != nil {
+		return nil, pinError("WatchPin", pin, module, fmt.Errorf("%w, it is already being watched", ErrPinBusy))
+	}
+
+	e := openPin.gpioEdge(edge)
+	if e != nil {
+		return nil, e
+	}
+
+	// The watch reads the value through its own file, so it doesn't share a file offset with DigitalRead or need the
+	// module lock.
+	f, e := os.Open(openPin.gpioBaseName + "/value")
+	if e != nil {
+		openPin.gpioEdge(EDGE_NONE)
+		return nil, pinError("WatchPin", pin, module, e)
+	}
+
+	w := newPinWatch(pin, edge)
+	openPin.watch = w
+	w.start(func() {
+		watchDTGPIOEdges(f, w)
+	})
+
+	return w.events, nil
+}
+
+// Stop watching a pin for edges.
+func (module *DTGPIOModule) UnwatchPin(pin Pin) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil || openPin.watch == nil {
+		return pinError("UnwatchPin", pin, module, ErrNotWatched)
+	}
+	return openPin.unwatch()
 }
 
 // create an openPin object and put it in the map.
 func (module *DTGPIOModule) makeOpenGPIOPin(pin Pin) (*DTGPIOModuleOpenPin, error) {
 	p := module.definedPins[pin]
 	if p == nil {
-		return nil, fmt.Errorf("Pin %d is not known to GPIO module", pin)
+		return nil, pinError("PinMode", pin, module, ErrUnknownPin)
 	}
 
 	result := &DTGPIOModuleOpenPin{pin: pin, gpioLogical: p.gpioLogical}
@@ -197,13 +335,17 @@ func (op *DTGPIOModuleOpenPin) gpioUnexport() error {
 	return nil
 }
 
-// Once exported, the direction of a GPIO can be set
+// Once exported, the direction of a GPIO can be set. "low" and "high" make it an output at that level.
 func (op *DTGPIOModuleOpenPin) gpioDirection(dir string) error {
-	if dir != "in" && dir != "out" {
-		return errors.New("direction must be in or out")
+	if dir != "in" && dir != "out" && dir != "low" && dir != "high" {
+		return errors.New("direction must be in, out, low or high")
 	}
 	f := op.gpioBaseName + "/direction"
 	e := WriteStringToFile(f, dir)
+	if e != nil {
+		return e
+	}
+	op.closeValueFile()
 
 	mode := os.O_WRONLY | os.O_TRUNC
 	if dir == "in" {
@@ -219,11 +361,83 @@ func (op *DTGPIOModuleOpenPin) gpioDirection(dir string) error {
 	return e
 }
 
+// Close the value file, if it is open.
+func (op *DTGPIOModuleOpenPin) closeValueFile() {
+	if op.valueFile != nil {
+		op.valueFile.Close()
+		op.valueFile = nil
+	}
+}
+
+// Set the edge that the kernel will signal on the value file.
+func (op *DTGPIOModuleOpenPin) gpioEdge(edge Edge) error {
+	v := "none"
+	switch edge {
+	case EDGE_RISING:
+		v = "rising"
+	case EDGE_FALLING:
+		v = "falling"
+	case EDGE_BOTH:
+		v = "both"
+	}
+	return WriteStringToFile(op.gpioBaseName+"/edge", v)
+}
+
+// Wait for edges on a value file until the watch is stopped, then close it. The kernel flags an edge with POLLPRI,
+// which is cleared by reading the value again. If polling or reading fails, the watch ends and its channel is closed.
+func watchDTGPIOEdges(f *os.File, w *pinWatch) {
+	defer f.Close()
+	fd := f.Fd()
+
+	// clear anything pending from before the watch started
+	_, e := readGPIOValue(f)
+	if e != nil {
+		return
+	}
+
+	for !w.stopping() {
+		events, e := pollFd(fd, pollPri|pollErr, watchPollInterval)
+		if e != nil {
+			return
+		}
+		if events == 0 {
+			continue
+		}
+		t := time.Now()
+
+		value, e := readGPIOValue(f)
+		if e != nil {
+			return
+		}
+
+		edge := w.edge
+		if edge == EDGE_BOTH {
+			edge = EDGE_FALLING
+			if value == HIGH {
+				edge = EDGE_RISING
+			}
+		}
+		w.send(edge, t)
+	}
+}
+
+// Stop watching the pin.
+func (op *DTGPIOModuleOpenPin) unwatch() error {
+	op.watch.close()
+	op.watch = nil
+	return op.gpioEdge(EDGE_NONE)
+}
+
 // Get the value. Will return HIGH or LOW
 func (op *DTGPIOModuleOpenPin) gpioGetValue() (int, error) {
+	return readGPIOValue(op.valueFile)
+}
+
+// Read the value from the start of a value file.
+func readGPIOValue(f *os.File) (int, error) {
 	var b []byte
 	b = make([]byte, 1)
-	n, e := op.valueFile.ReadAt(b, 0)
+	n, e := f.ReadAt(b, 0)
 
 	value := 0
 	if n > 0 {
@@ -253,6 +467,7 @@ func (op *DTGPIOModuleOpenPin) gpioSetValue(value int) error {
 	// @todo investigate if we'd get better performance if we have precalculated []byte values with 0 and 1, and
 	// use write directly instead of WriteString. Probably only marginal.
 	// @todo also check out http://hackaday.com/2013/12/07/speeding-up-beaglebone-black-gpio-a-thousand-times/
+	// @todo (BCMGPIOModule does this for Raspberry Pi)
 	if value == 0 {
 		op.valueFile.WriteString("0")
 	} else {
@@ -0,0 +1,21 @@
+package hwio
+
+import (
+	"testing"
+)
+
+func TestDTGPIOPullUnsupported(t *testing.T) {
+	pins := make(DTGPIOModulePinDefMap)
+	pins[1] = &DTGPIOModulePinDef{pin: 1, gpioLogical: 5}
+
+	module := NewDTGPIOModule("gpio")
+	module.SetOptions(map[string]interface{}{"pins": pins})
+
+	// without a pull setter, the module should refuse before touching the pin
+	if e := module.PinMode(1, INPUT_PULLUP); e == nil {
+		t.Error("PinMode with INPUT_PULLUP should return an error when the board cannot set pull resistors")
+	}
+	if e := module.PinMode(1, INPUT_PULLDOWN); e == nil {
+		t.Error("PinMode with INPUT_PULLDOWN should return an error when the board cannot set pull resistors")
+	}
+}
@@ -0,0 +1,945 @@
+// A GPIO module that uses the GPIO character device interface (/dev/gpiochipN) and the v2 line request ioctls. Kernels
+// from 5.10 provide this interface, and it is the replacement for the deprecated /sys/class/gpio files used by
+// DTGPIOModule. Pin definitions are the same as DTGPIOModule's; the logical GPIO number is mapped onto a chip and line
+// offset using the list of chips passed through SetOptions.
+
+package hwio
+
+// References:
+// - https://www.kernel.org/doc/html/latest/userspace-api/gpio/chardev.html
+// - include/uapi/linux/gpio.h
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Constants used by ioctl, from linux/gpio.h
+const (
+	GPIO_MAX_NAME_SIZE           = 32
+	GPIO_V2_LINES_MAX            = 64
+	GPIO_V2_LINE_NUM_ATTRS_MAX   = 10
+	GPIO_V2_LINE_FLAG_USED       = 1 << 0
+	GPIO_V2_LINE_FLAG_ACTIVE_LOW = 1 << 1
+	GPIO_V2_LINE_FLAG_INPUT      = 1 << 2
+	GPIO_V2_LINE_FLAG_OUTPUT     = 1 << 3
+
+	GPIO_V2_LINE_FLAG_BIAS_PULL_UP   = 1 << 8
+	GPIO_V2_LINE_FLAG_BIAS_PULL_DOWN = 1 << 9
+	GPIO_V2_LINE_FLAG_BIAS_DISABLED  = 1 << 10
+
+	GPIO_V2_LINE_FLAG_EDGE_RISING          = 1 << 4
+	GPIO_V2_LINE_FLAG_EDGE_FALLING         = 1 << 5
+	GPIO_V2_LINE_FLAG_EVENT_CLOCK_REALTIME = 1 << 11
+
+	GPIO_V2_LINE_EVENT_RISING_EDGE  = 1
+	GPIO_V2_LINE_EVENT_FALLING_EDGE = 2
+
+	GPIO_V2_LINE_ATTR_ID_FLAGS         = 1
+	GPIO_V2_LINE_ATTR_ID_OUTPUT_VALUES = 2
+
+	// Get chip information
+	GPIO_GET_CHIPINFO_IOCTL = 0x8044b401
+
+	// Request one or more lines from a chip
+	GPIO_V2_GET_LINE_IOCTL = 0xc250b407
+
+	// Reconfigure lines of a line request
+	GPIO_V2_LINE_SET_CONFIG_IOCTL = 0xc110b40d
+
+	// Read and write values of lines of a line request
+	GPIO_V2_LINE_GET_VALUES_IOCTL = 0xc010b40e
+	GPIO_V2_LINE_SET_VALUES_IOCTL = 0xc010b40f
+)
+
+// Data that is passed to/from ioctl calls. These mirror the structures in linux/gpio.h.
+type gpiochip_info struct {
+	name  [GPIO_MAX_NAME_SIZE]byte
+	label [GPIO_MAX_NAME_SIZE]byte
+	lines uint32
+}
+
+type gpio_v2_line_attribute struct {
+	id      uint32
+	padding uint32
+	value   uint64 // flags, values or debounce_period_us depending on id
+}
+
+type gpio_v2_line_config_attribute struct {
+	attr gpio_v2_line_attribute
+	mask uint64
+}
+
+type gpio_v2_line_config struct {
+	flags     uint64
+	num_attrs uint32
+	padding   [5]uint32
+	attrs     [GPIO_V2_LINE_NUM_ATTRS_MAX]gpio_v2_line_config_attribute
+}
+
+type gpio_v2_line_request struct {
+	offsets           [GPIO_V2_LINES_MAX]uint32
+	consumer          [GPIO_MAX_NAME_SIZE]byte
+	config            gpio_v2_line_config
+	num_lines         uint32
+	event_buffer_size uint32
+	padding           [5]uint32
+	fd                int32
+}
+
+type gpio_v2_line_values struct {
+	bits uint64
+	mask uint64
+}
+
+type gpio_v2_line_event struct {
+	timestamp_ns uint64
+	id           uint32
+	offset       uint32
+	seqno        uint32
+	line_seqno   uint32
+	padding      [6]uint32
+}
+
+// gpioCdevSys is the set of system calls the module makes on the character devices. It is replaced in unit tests so the
+// module can be exercised without a kernel GPIO driver.
+type gpioCdevSys interface {
+	open(path string) (int, error)
+	close(fd int) error
+	ioctl(fd int, request uintptr, arg unsafe.Pointer) error
+	read(fd int, b []byte) (int, error)
+
+	// wait up to timeout for fd to become readable
+	poll(fd int, timeout time.Duration) (bool, error)
+}
+
+// The gpioCdevSys used on real hardware.
+type linuxGPIOCdevSys struct{}
+
+func (linuxGPIOCdevSys) open(path string) (int, error) {
+	return syscall.Open(path, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+}
+
+func (linuxGPIOCdevSys) close(fd int) error {
+	return syscall.Close(fd)
+}
+
+func (linuxGPIOCdevSys) read(fd int, b []byte) (int, error) {
+	return syscall.Read(fd, b)
+}
+
+func (linuxGPIOCdevSys) poll(fd int, timeout time.Duration) (bool, error) {
+	events, e := pollFd(uintptr(fd), pollIn, timeout)
+	return events != 0, e
+}
+
+func (linuxGPIOCdevSys) ioctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg))
+	if err != 0 {
+		return syscall.Errno(err)
+	}
+	return nil
+}
+
+// A list of gpiochip device files, e.g. "/dev/gpiochip0", in logical GPIO order. The first chip serves logical GPIO
+// numbers from 0, and each subsequent chip starts where the previous one ends.
+type DTGPIOCdevChipList []string
+
+type DTGPIOCdevModule struct {
+	sync.Mutex
+
+	name        string
+	definedPins DTGPIOModulePinDefMap
+	chipFiles   DTGPIOCdevChipList
+	openPins    map[Pin]*DTGPIOCdevModuleOpenPin
+
+	// chips that have been opened, in the same order as chipFiles. Populated on first use.
+	chips []*dtGPIOCdevChip
+
+	// sets pull resistors on lines where the kernel can't set the bias. May be nil.
+	pull DTGPIOPullSetter
+
+	sys gpioCdevSys
+}
+
+type dtGPIOCdevChip struct {
+	path  string
+	fd    int
+	base  int // first logical GPIO number on this chip
+	lines int // number of lines on this chip
+}
+
+type DTGPIOCdevModuleOpenPin struct {
+	pin    Pin
+	chip   *dtGPIOCdevChip
+	offset int // line offset within the chip
+	fd     int // file descriptor of the line request, or -1 once the line has been lost
+	index  int // index of the line within the line request
+	mode   PinIOMode
+	flags  uint64 // line flags for the mode, as accepted by the kernel
+
+	// set while the pin's line is part of a bank's line request
+	bank *dtGPIOCdevBank
+
+	// set while the pin is being watched for edges
+	watch *pinWatch
+
+	// true if the kernel is timestamping events with the realtime clock
+	realtime bool
+}
+
+func NewDTGPIOCdevModule(name string) (result *DTGPIOCdevModule) {
+	result = &DTGPIOCdevModule{name: name, sys: linuxGPIOCdevSys{}}
+	result.openPins = make(map[Pin]*DTGPIOCdevModuleOpenPin)
+	return result
+}
+
+// Set options of the module. Parameters we look for include:
+// - "pins" - an object of type DTGPIOModulePinDefMap
+// - "chips" - an object of type DTGPIOCdevChipList
+// - "pull" - an optional DTGPIOPullSetter, used for INPUT_PULLUP and INPUT_PULLDOWN if the kernel can't set the bias
+func (module *DTGPIOCdevModule) SetOptions(options map[string]interface{}) error {
+	v := options["pins"]
+	if v == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+	module.definedPins = v.(DTGPIOModulePinDefMap)
+
+	vc := options["chips"]
+	if vc == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'chips' values", module.GetName())
+	}
+	module.chipFiles = vc.(DTGPIOCdevChipList)
+
+	if vp := options["pull"]; vp != nil {
+		module.pull = vp.(DTGPIOPullSetter)
+	}
+	return nil
+}
+
+// enable GPIO module. It opens the gpiochip devices, but doesn't allocate any pins.
+func (module *DTGPIOCdevModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	return module.openChips()
+}
+
+// disables module and release any pins assigned.
+func (module *DTGPIOCdevModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	for pin, openPin := range module.openPins {
+		if openPin.watch != nil {
+			openPin.unwatch(module.sys)
+		}
+		if openPin.bank != nil {
+			openPin.bank.close()
+		}
+		if openPin.fd < 0 {
+			// closed when its bank couldn't request its line again
+			continue
+		}
+		module.sys.close(openPin.fd)
+		delete(module.openPins, pin)
+		unassignPin(pin, module)
+	}
+	for _, chip := range module.chips {
+		module.sys.close(chip.fd)
+	}
+	module.chips = nil
+	return nil
+}
+
+func (module *DTGPIOCdevModule) GetName() string {
+	return module.name
+}
+
+// Set the mode of a pin. INPUT_PULLUP and INPUT_PULLDOWN set the line bias; INPUT leaves the bias as it is.
+func (module *DTGPIOCdevModule) PinMode(pin Pin, mode PinIOMode) error {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.definedPins[pin] == nil {
+		return pinError("PinMode", pin, module, ErrUnknownPin)
+	}
+
+	// If the pin is already open, the line request just needs to be reconfigured.
+	if openPin := module.openPins[pin]; openPin != nil {
+		if openPin.bank != nil {
+			return pinError("PinMode", pin, module, fmt.Errorf("%w, its mode can't be changed until its bank is closed", ErrPinBusy))
+		}
+		if openPin.watch != nil {
+			openPin.unwatch(module.sys)
+		}
+		e := module.applyMode(pin, mode, func(flags uint64) error {
+			e := openPin.setFlags(module.sys, flags)
+			if e == nil {
+				openPin.flags = flags
+			}
+			return e
+		})
+		if e != nil {
+			return e
+		}
+		openPin.mode = mode
+		return nil
+	}
+
+	e := module.openChips()
+	if e != nil {
+		return e
+	}
+
+	// attempt to assign this pin for this module.
+	e = AssignPin(pin, module)
+	if e != nil {
+		return e
+	}
+
+	openPin, e := module.makeOpenGPIOPin(pin, mode)
+	if e != nil {
+		unassignPin(pin, module)
+		return e
+	}
+
+	module.openPins[pin] = openPin
+	return nil
+}
+
+// Drive a pin LOW, or release it as an input. The line is reconfigured without output values, which the kernel takes
+// as LOW, so it becomes an output that is already LOW.
+func (module *DTGPIOCdevModule) SetOpenDrain(pin Pin, value int) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notOpenError("SetOpenDrain", pin, module)
+	}
+	if openPin.bank != nil {
+		return pinError("SetOpenDrain", pin, module, fmt.Errorf("%w, its mode can't be changed until its bank is closed", ErrPinBusy))
+	}
+	if openPin.watch != nil {
+		openPin.unwatch(module.sys)
+	}
+
+	mode := INPUT
+	if value == LOW {
+		mode = OUTPUT
+	}
+	flags := gpioCdevModeFlags(mode)
+	e := openPin.setFlags(module.sys, flags)
+	if e != nil {
+		return pinError("SetOpenDrain", pin, module, e)
+	}
+	openPin.flags = flags
+	openPin.mode = mode
+	return nil
+}
+
+func (module *DTGPIOCdevModule) DigitalWrite(pin Pin, value int) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notOpenError("DigitalWrite", pin, module)
+	}
+
+	data := gpio_v2_line_values{mask: 1 << uint(openPin.index)}
+	if value != LOW {
+		data.bits = data.mask
+	}
+	e := module.sys.ioctl(openPin.fd, GPIO_V2_LINE_SET_VALUES_IOCTL, unsafe.Pointer(&data))
+	if e != nil {
+		return pinError("DigitalWrite", pin, module, e)
+	}
+	return nil
+}
+
+func (module *DTGPIOCdevModule) DigitalRead(pin Pin) (int, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	return module.digitalRead(pin)
+}
+
+func (module *DTGPIOCdevModule) digitalRead(pin Pin) (int, error) {
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return 0, notOpenError("DigitalRead", pin, module)
+	}
+
+	data := gpio_v2_line_values{mask: 1 << uint(openPin.index)}
+	e := module.sys.ioctl(openPin.fd, GPIO_V2_LINE_GET_VALUES_IOCTL, unsafe.Pointer(&data))
+	if e != nil {
+		return 0, pinError("DigitalRead", pin, module, e)
+	}
+	if data.bits&data.mask != 0 {
+		return HIGH, nil
+	}
+	return LOW, nil
+}
+
+func (module *DTGPIOCdevModule) ClosePin(pin Pin) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notOpenError("ClosePin", pin, module)
+	}
+	if openPin.bank != nil {
+		return pinError("ClosePin", pin, module, fmt.Errorf("%w, it can't be closed until its bank is closed", ErrPinBusy))
+	}
+	if openPin.watch != nil {
+		openPin.unwatch(module.sys)
+	}
+	e := module.sys.close(openPin.fd)
+	if e != nil {
+		return pinError("ClosePin", pin, module, e)
+	}
+	delete(module.openPins, pin)
+	return unassignPin(pin, module)
+}
+
+// Start watching a pin for edges. Edge detection is turned on for the pin's line request, and a goroutine reads the
+// events that the kernel queues on it.
+func (module *DTGPIOCdevModule) WatchPin(pin Pin, edge Edge) (<-chan PinEvent, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return nil, notOpenError("WatchPin", pin, module)
+	}
+	if openPin.mode == OUTPUT {
+		return nil, pinError("WatchPin", pin, module, fmt.Errorf("%w, only inputs can be watched", ErrWrongMode))
+	}
+	if openPin.watch != nil {
+		return nil, pinError("WatchPin", pin, module, fmt.Errorf("%w, it is already being watched", ErrPinBusy))
+	}
+	if openPin.bank != nil {
+		return nil, pinError("WatchPin", pin, module, fmt.Errorf("%w, pins in a bank can't be watched", ErrPinBusy))
+	}
+
+	flags := openPin.flags | gpioCdevEdgeFlags(edge)
+
+	// Prefer realtime timestamps, but kernels before 5.11 don't support them.
+	openPin.realtime = true
+	e := openPin.setFlags(module.sys, flags|GPIO_V2_LINE_FLAG_EVENT_CLOCK_REALTIME)
+	if e == syscall.EINVAL {
+		openPin.realtime = false
+		e = openPin.setFlags(module.sys, flags)
+	}
+	if e != nil {
+		return nil, pinError("WatchPin", pin, module, e)
+	}
+
+	w := newPinWatch(pin, edge)
+	openPin.watch = w
+	sys := module.sys
+	w.start(func() {
+		openPin.watchEdges(sys, w)
+	})
+
+	return w.events, nil
+}
+
+// Stop watching a pin for edges.
+func (module *DTGPIOCdevModule) UnwatchPin(pin Pin) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil || openPin.watch == nil {
+		return pinError("UnwatchPin", pin, module, ErrNotWatched)
+	}
+	return openPin.unwatch(module.sys)
+}
+
+// Open each of the chip files and determine how many lines each has, so logical GPIO numbers can be mapped to a chip.
+// Does nothing if the chips are already open.
+func (module *DTGPIOCdevModule) openChips() error {
+	if module.chips != nil {
+		return nil
+	}
+
+	chips := make([]*dtGPIOCdevChip, 0, len(module.chipFiles))
+	base := 0
+	for _, path := range module.chipFiles {
+		fd, e := module.sys.open(path)
+		if e != nil {
+			for _, c := range chips {
+				module.sys.close(c.fd)
+			}
+			return fmt.Errorf("Module '%s' could not open %s: %w", module.GetName(), path, e)
+		}
+
+		info := gpiochip_info{}
+		e = module.sys.ioctl(fd, GPIO_GET_CHIPINFO_IOCTL, unsafe.Pointer(&info))
+		if e != nil {
+			module.sys.close(fd)
+			for _, c := range chips {
+				module.sys.close(c.fd)
+			}
+			return e
+		}
+
+		chips = append(chips, &dtGPIOCdevChip{path: path, fd: fd, base: base, lines: int(info.lines)})
+		base += int(info.lines)
+	}
+
+	module.chips = chips
+	return nil
+}
+
+// Find the chip that serves a logical GPIO number, and the line offset on that chip.
+func (module *DTGPIOCdevModule) findLine(gpioLogical int) (*dtGPIOCdevChip, int, error) {
+	for _, chip := range module.chips {
+		if gpioLogical >= chip.base && gpioLogical < chip.base+chip.lines {
+			return chip, gpioLogical - chip.base, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("GPIO %d is not served by any gpiochip on module '%s'", gpioLogical, module.GetName())
+}
+
+// Request the line for a pin from its chip, and return an open pin for it.
+func (module *DTGPIOCdevModule) makeOpenGPIOPin(pin Pin, mode PinIOMode) (*DTGPIOCdevModuleOpenPin, error) {
+	p := module.definedPins[pin]
+
+	chip, offset, e := module.findLine(p.gpioLogical)
+	if e != nil {
+		return nil, pinError("PinMode", pin, module, e)
+	}
+
+	result := &DTGPIOCdevModuleOpenPin{pin: pin, chip: chip, offset: offset, mode: mode}
+	e = module.applyMode(pin, mode, func(flags uint64) error {
+		fd, e := module.requestLines(chip, []int{offset}, []uint64{flags}, nil)
+		if e == nil {
+			result.fd = fd
+			result.flags = flags
+		}
+		return e
+	})
+	if e != nil {
+		return nil, e
+	}
+
+	return result, nil
+}
+
+// Request a set of lines from a chip as a single line request, and return the request's file descriptor. flags holds
+// the flags for each line. values holds the initial level of each line, which is used for outputs; if it is nil,
+// outputs start LOW.
+func (module *DTGPIOCdevModule) requestLines(chip *dtGPIOCdevChip, offsets []int, flags []uint64, values []int) (int, error) {
+	req := gpio_v2_line_request{num_lines: uint32(len(offsets))}
+	copy(req.consumer[:], "hwio")
+	req.config.flags = flags[0]
+
+	outputValues := gpio_v2_line_config_attribute{}
+	outputValues.attr.id = GPIO_V2_LINE_ATTR_ID_OUTPUT_VALUES
+
+	for i, offset := range offsets {
+		req.offsets[i] = uint32(offset)
+		bit := uint64(1) << uint(i)
+
+		// Lines with different flags to the first line get them through an attribute, shared by all lines with the
+		// same flags.
+		if flags[i] != flags[0] {
+			found := false
+			for a := uint32(0); a < req.config.num_attrs; a++ {
+				if req.config.attrs[a].attr.value == flags[i] {
+					req.config.attrs[a].mask |= bit
+					found = true
+					break
+				}
+			}
+			if !found {
+				if req.config.num_attrs == GPIO_V2_LINE_NUM_ATTRS_MAX-1 {
+					return 0, fmt.Errorf("Module '%s' has too many different line modes in one request", module.GetName())
+				}
+				attr := &req.config.attrs[req.config.num_attrs]
+				attr.attr.id = GPIO_V2_LINE_ATTR_ID_FLAGS
+				attr.attr.value = flags[i]
+				attr.mask = bit
+				req.config.num_attrs++
+			}
+		}
+
+		if flags[i]&GPIO_V2_LINE_FLAG_OUTPUT != 0 && values != nil {
+			outputValues.mask |= bit
+			if values[i] != LOW {
+				outputValues.attr.value |= bit
+			}
+		}
+	}
+
+	if outputValues.mask != 0 {
+		req.config.attrs[req.config.num_attrs] = outputValues
+		req.config.num_attrs++
+	}
+
+	e := module.sys.ioctl(chip.fd, GPIO_V2_GET_LINE_IOCTL, unsafe.Pointer(&req))
+	if e != nil {
+		return 0, e
+	}
+	return int(req.fd), nil
+}
+
+// Apply a mode to a pin's line, using apply to request or reconfigure the line with the mode's flags. If the kernel
+// can't set the bias for a pull mode, the line is set as a plain input and the pull setter is used instead.
+func (module *DTGPIOCdevModule) applyMode(pin Pin, mode PinIOMode, apply func(flags uint64) error) error {
+	e := apply(gpioCdevModeFlags(mode))
+	if e == nil {
+		return nil
+	}
+	if (mode != INPUT_PULLUP && mode != INPUT_PULLDOWN) || !gpioCdevBiasUnsupported(e) {
+		return pinError("PinMode", pin, module, e)
+	}
+
+	if module.pull == nil {
+		return pinError("PinMode", pin, module, fmt.Errorf("%s is %w by the GPIO driver: %w", mode, ErrUnsupported, e))
+	}
+
+	e = apply(GPIO_V2_LINE_FLAG_INPUT)
+	if e != nil {
+		return pinError("PinMode", pin, module, e)
+	}
+
+	e = module.pull.SetPull(module.definedPins[pin].gpioLogical, mode)
+	if e != nil {
+		return pinError("PinMode", pin, module, fmt.Errorf("could not set %s: %w", mode, e))
+	}
+	return nil
+}
+
+// Reconfigure the line with the given flags.
+func (op *DTGPIOCdevModuleOpenPin) setFlags(sys gpioCdevSys, flags uint64) error {
+	config := gpio_v2_line_config{flags: flags}
+	return sys.ioctl(op.fd, GPIO_V2_LINE_SET_CONFIG_IOCTL, unsafe.Pointer(&config))
+}
+
+// Read events from the line request until the watch is stopped.
+func (op *DTGPIOCdevModuleOpenPin) watchEdges(sys gpioCdevSys, w *pinWatch) {
+	events := make([]gpio_v2_line_event, pinEventBufferSize)
+	size := int(unsafe.Sizeof(events[0]))
+	buffer := (*[pinEventBufferSize * unsafe.Sizeof(gpio_v2_line_event{})]byte)(unsafe.Pointer(&events[0]))[:]
+
+	for !w.stopping() {
+		ready, e := sys.poll(op.fd, watchPollInterval)
+		if e != nil {
+			return
+		}
+		if !ready {
+			continue
+		}
+
+		n, e := sys.read(op.fd, buffer)
+		if e != nil {
+			return
+		}
+
+		for _, event := range events[:n/size] {
+			t := time.Now()
+			if op.realtime {
+				t = time.Unix(0, int64(event.timestamp_ns))
+			}
+
+			if event.id == GPIO_V2_LINE_EVENT_RISING_EDGE {
+				w.send(EDGE_RISING, t)
+			} else {
+				w.send(EDGE_FALLING, t)
+			}
+		}
+	}
+}
+
+// Stop watching the pin, and turn edge detection off again.
+func (op *DTGPIOCdevModuleOpenPin) unwatch(sys gpioCdevSys) error {
+	op.watch.close()
+	op.watch = nil
+	return op.setFlags(sys, op.flags)
+}
+
+// Return the line flags for a pin mode.
+func gpioCdevModeFlags(mode PinIOMode) uint64 {
+	switch mode {
+	case OUTPUT:
+		return GPIO_V2_LINE_FLAG_OUTPUT
+	case INPUT_PULLUP:
+		return GPIO_V2_LINE_FLAG_INPUT | GPIO_V2_LINE_FLAG_BIAS_PULL_UP
+	case INPUT_PULLDOWN:
+		return GPIO_V2_LINE_FLAG_INPUT | GPIO_V2_LINE_FLAG_BIAS_PULL_DOWN
+	}
+	return GPIO_V2_LINE_FLAG_INPUT
+}
+
+// Determine if an error from requesting or configuring a line means the kernel can't set the bias. GPIO drivers
+// without bias support return ENOTSUPP (524, which is internal to the kernel but leaks out), or EOPNOTSUPP.
+func gpioCdevBiasUnsupported(e error) bool {
+	return e == syscall.EOPNOTSUPP || e == syscall.Errno(524)
+}
+
+// Return the line flags that enable detection of an edge.
+func gpioCdevEdgeFlags(edge Edge) uint64 {
+	switch edge {
+	case EDGE_RISING:
+		return GPIO_V2_LINE_FLAG_EDGE_RISING
+	case EDGE_FALLING:
+		return GPIO_V2_LINE_FLAG_EDGE_FALLING
+	case EDGE_BOTH:
+		return GPIO_V2_LINE_FLAG_EDGE_RISING | GPIO_V2_LINE_FLAG_EDGE_FALLING
+	}
+	return 0
+}
+
+// Determine if the character device interface is available for all of the given chips.
+func gpioCdevAvailable(chips DTGPIOCdevChipList) bool {
+	if len(chips) == 0 {
+		return false
+	}
+	for _, chip := range chips {
+		if !fileExists(chip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Create the GPIO module for a driver from its GPIO options. If the options list gpiochip devices and the kernel
+// provides them, a DTGPIOCdevModule is used, otherwise it falls back to the sysfs DTGPIOModule.
+func newDTGPIOModuleFromOptions(name string, options map[string]interface{}) (GPIOModule, error) {
+	var gpio GPIOModule
+
+	chips, _ := options["chips"].(DTGPIOCdevChipList)
+	if gpioCdevAvailable(chips) {
+		gpio = NewDTGPIOCdevModule(name)
+	} else {
+		gpio = NewDTGPIOModule(name)
+	}
+
+	e := gpio.SetOptions(options)
+	if e != nil {
+		return nil, e
+	}
+	return gpio, nil
+}
+
+// A bank of pins on a DTGPIOCdevModule. While the bank is open, the pins' lines are held in one line request, so the
+// kernel sets and gets all their values in a single operation. Only pins on the same chip can share a line request;
+// OpenBank returns a sequential bank for pins spread over more than one chip.
+type dtGPIOCdevBank struct {
+	module *DTGPIOCdevModule
+	pins   []*DTGPIOCdevModuleOpenPin
+	fd     int
+
+	// lines in the request that are outputs, and can be written
+	outputs uint64
+}
+
+// Open a bank over pins that have been set up with PinMode. The pins can't be watched, closed or have their mode
+// changed until the bank is closed.
+func (module *DTGPIOCdevModule) OpenBank(pins PinList) (GPIOBank, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	e := checkBankPins(pins)
+	if e != nil {
+		return nil, e
+	}
+
+	openPins := make([]*DTGPIOCdevModuleOpenPin, 0, len(pins))
+	for _, pin := range pins {
+		openPin := module.openPins[pin]
+		if openPin == nil {
+			return nil, notOpenError("OpenBank", pin, module)
+		}
+		if openPin.bank != nil {
+			return nil, pinError("OpenBank", pin, module, fmt.Errorf("%w, it is already part of an open bank", ErrPinBusy))
+		}
+		if openPin.watch != nil {
+			return nil, pinError("OpenBank", pin, module, fmt.Errorf("%w, it is being watched", ErrPinBusy))
+		}
+		openPins = append(openPins, openPin)
+	}
+
+	if !module.atomicPins(pins) {
+		return newSequentialGPIOBank(module, pins)
+	}
+
+	// The lines have to be released before they can be requested together. Output levels are carried over to the
+	// new request so the pins don't glitch.
+	offsets := make([]int, len(openPins))
+	flags := make([]uint64, len(openPins))
+	values := make([]int, len(openPins))
+	for i, openPin := range openPins {
+		offsets[i] = openPin.offset
+		flags[i] = openPin.flags
+		values[i], e = module.digitalRead(openPin.pin)
+		if e != nil {
+			return nil, e
+		}
+	}
+	for _, openPin := range openPins {
+		module.sys.close(openPin.fd)
+	}
+
+	chip := openPins[0].chip
+	fd, e := module.requestLines(chip, offsets, flags, values)
+	if e != nil {
+		// put the lines back as they were. A pin whose line can't be requested again is closed, and that error is
+		// returned as the more serious one.
+		for i, openPin := range openPins {
+			re := module.rerequestLine("OpenBank", openPin, values[i])
+			if re != nil {
+				e = re
+			}
+		}
+		return nil, e
+	}
+
+	bank := &dtGPIOCdevBank{module: module, pins: openPins, fd: fd}
+	for i, openPin := range openPins {
+		openPin.fd = fd
+		openPin.index = i
+		openPin.bank = bank
+		if openPin.flags&GPIO_V2_LINE_FLAG_OUTPUT != 0 {
+			bank.outputs |= 1 << uint(i)
+		}
+	}
+	return bank, nil
+}
+
+// Determine if a bank over the pins would be atomic, which is the case if all their lines are on the same chip.
+func (module *DTGPIOCdevModule) AtomicPins(pins PinList) bool {
+	module.Lock()
+	defer module.Unlock()
+
+	return module.atomicPins(pins)
+}
+
+func (module *DTGPIOCdevModule) atomicPins(pins PinList) bool {
+	if checkBankPins(pins) != nil || module.openChips() != nil {
+		return false
+	}
+
+	var first *dtGPIOCdevChip
+	for _, pin := range pins {
+		p := module.definedPins[pin]
+		if p == nil {
+			return false
+		}
+		chip, _, e := module.findLine(p.gpioLogical)
+		if e != nil {
+			return false
+		}
+		if first != nil && chip != first {
+			return false
+		}
+		first = chip
+	}
+	return true
+}
+
+func (bank *dtGPIOCdevBank) Write(value uint32) error {
+	bank.module.Lock()
+	defer bank.module.Unlock()
+
+	if bank.pins == nil {
+		return fmt.Errorf("Bank is being written but is %w", ErrClosed)
+	}
+
+	data := gpio_v2_line_values{mask: bank.outputs}
+	for i := range bank.pins {
+		if bankBit(value, i, len(bank.pins)) == HIGH {
+			data.bits |= 1 << uint(i)
+		}
+	}
+	data.bits &= data.mask
+	return bank.module.sys.ioctl(bank.fd, GPIO_V2_LINE_SET_VALUES_IOCTL, unsafe.Pointer(&data))
+}
+
+func (bank *dtGPIOCdevBank) Read() (uint32, error) {
+	bank.module.Lock()
+	defer bank.module.Unlock()
+
+	return bank.read()
+}
+
+func (bank *dtGPIOCdevBank) read() (uint32, error) {
+	if bank.pins == nil {
+		return 0, fmt.Errorf("Bank is being read from but is %w", ErrClosed)
+	}
+
+	data := gpio_v2_line_values{mask: 1<<uint(len(bank.pins)) - 1}
+	e := bank.module.sys.ioctl(bank.fd, GPIO_V2_LINE_GET_VALUES_IOCTL, unsafe.Pointer(&data))
+	if e != nil {
+		return 0, e
+	}
+
+	result := uint32(0)
+	for i := range bank.pins {
+		if data.bits&(1<<uint(i)) != 0 {
+			result |= bankMask(i, len(bank.pins))
+		}
+	}
+	return result, nil
+}
+
+func (bank *dtGPIOCdevBank) Atomic() bool {
+	return true
+}
+
+// Close the bank, giving each pin its own line request again with its current level.
+func (bank *dtGPIOCdevBank) Close() error {
+	bank.module.Lock()
+	defer bank.module.Unlock()
+
+	return bank.close()
+}
+
+func (bank *dtGPIOCdevBank) close() error {
+	if bank.pins == nil {
+		return nil
+	}
+
+	module := bank.module
+	value, e := bank.read()
+	if e != nil {
+		return e
+	}
+	module.sys.close(bank.fd)
+
+	var result error
+	for i, openPin := range bank.pins {
+		e = module.rerequestLine("Close", openPin, bankBit(value, i, len(bank.pins)))
+		if e != nil && result == nil {
+			result = e
+		}
+	}
+	bank.pins = nil
+	return result
+}
+
+// Give a pin its own line request again after its bank has released the line, keeping its level if it is an output.
+// If the line can't be requested, the pin is closed, as it no longer has a line to use.
+func (module *DTGPIOCdevModule) rerequestLine(op string, openPin *DTGPIOCdevModuleOpenPin, level int) error {
+	openPin.index = 0
+	openPin.bank = nil
+
+	fd, e := module.requestLines(openPin.chip, []int{openPin.offset}, []uint64{openPin.flags}, []int{level})
+	if e != nil {
+		openPin.fd = -1
+		delete(module.openPins, openPin.pin)
+		unassignPin(openPin.pin, module)
+		return pinError(op, openPin.pin, module, e)
+	}
+	openPin.fd = fd
+	return nil
+}
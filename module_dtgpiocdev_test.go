@@ -0,0 +1,668 @@
+package hwio
+
+// Unit tests for DTGPIOCdevModule, using a fake set of system calls in place of the gpiochip character devices.
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// A fake gpiochip file, which is either a chip (lines is nil) or a line request on a chip.
+type fakeGPIOCdevFile struct {
+	chip      string
+	lines     []int
+	flags     uint64
+	lineFlags []uint64 // flags of each line, which differ from flags where attributes are used
+
+	// events waiting to be read from a line request
+	events []gpio_v2_line_event
+}
+
+// fakeGPIOCdevSys simulates the kernel side of the GPIO character device interface. Line levels are held per chip so
+// they survive line requests being released and made again.
+type fakeGPIOCdevSys struct {
+	sync.Mutex
+
+	levels map[string][]int
+	files  map[int]*fakeGPIOCdevFile
+	nextFd int
+
+	// if true, line requests that set a bias fail as they do for GPIO drivers without bias support
+	noBias bool
+
+	// if set, poll returns this error
+	pollErr error
+
+	// if set, line requests fail with the error this returns for the line offsets requested
+	requestErr func(offsets []int) error
+}
+
+func newFakeGPIOCdevSys(chips map[string]int) *fakeGPIOCdevSys {
+	result := &fakeGPIOCdevSys{levels: make(map[string][]int), files: make(map[int]*fakeGPIOCdevFile), nextFd: 10}
+	for path, lines := range chips {
+		result.levels[path] = make([]int, lines)
+	}
+	return result
+}
+
+func (s *fakeGPIOCdevSys) open(path string) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.levels[path] == nil {
+		return 0, syscall.ENOENT
+	}
+	return s.newFile(&fakeGPIOCdevFile{chip: path}), nil
+}
+
+func (s *fakeGPIOCdevSys) newFile(f *fakeGPIOCdevFile) int {
+	fd := s.nextFd
+	s.nextFd++
+	s.files[fd] = f
+	return fd
+}
+
+func (s *fakeGPIOCdevSys) close(fd int) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.files[fd] == nil {
+		return syscall.EBADF
+	}
+	delete(s.files, fd)
+	return nil
+}
+
+func (s *fakeGPIOCdevSys) ioctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	s.Lock()
+	defer s.Unlock()
+
+	f := s.files[fd]
+	if f == nil {
+		return syscall.EBADF
+	}
+	levels := s.levels[f.chip]
+
+	biasFlags := uint64(GPIO_V2_LINE_FLAG_BIAS_PULL_UP | GPIO_V2_LINE_FLAG_BIAS_PULL_DOWN | GPIO_V2_LINE_FLAG_BIAS_DISABLED)
+
+	switch request {
+	case GPIO_GET_CHIPINFO_IOCTL:
+		info := (*gpiochip_info)(arg)
+		info.lines = uint32(len(levels))
+	case GPIO_V2_GET_LINE_IOCTL:
+		req := (*gpio_v2_line_request)(arg)
+		if s.noBias && req.config.flags&biasFlags != 0 {
+			return syscall.Errno(524)
+		}
+		line := &fakeGPIOCdevFile{chip: f.chip, flags: req.config.flags}
+		for i := 0; i < int(req.num_lines); i++ {
+			offset := int(req.offsets[i])
+			if offset >= len(levels) || s.lineRequested(f.chip, offset) {
+				return syscall.EBUSY
+			}
+			line.lines = append(line.lines, offset)
+			line.lineFlags = append(line.lineFlags, req.config.flags)
+		}
+		if s.requestErr != nil {
+			if e := s.requestErr(line.lines); e != nil {
+				return e
+			}
+		}
+
+		// apply attributes, then set outputs to their initial levels, which are LOW unless given
+		outputValues := uint64(0)
+		for _, attr := range req.config.attrs[:req.config.num_attrs] {
+			for i := range line.lines {
+				if attr.mask&(1<<uint(i)) == 0 {
+					continue
+				}
+				switch attr.attr.id {
+				case GPIO_V2_LINE_ATTR_ID_FLAGS:
+					line.lineFlags[i] = attr.attr.value
+				case GPIO_V2_LINE_ATTR_ID_OUTPUT_VALUES:
+					outputValues |= attr.attr.value & (1 << uint(i))
+				}
+			}
+		}
+		for i, offset := range line.lines {
+			if line.lineFlags[i]&GPIO_V2_LINE_FLAG_OUTPUT != 0 {
+				levels[offset] = int((outputValues >> uint(i)) & 1)
+			}
+		}
+		req.fd = int32(s.newFile(line))
+	case GPIO_V2_LINE_SET_CONFIG_IOCTL:
+		if s.noBias && (*gpio_v2_line_config)(arg).flags&biasFlags != 0 {
+			return syscall.Errno(524)
+		}
+		f.flags = (*gpio_v2_line_config)(arg).flags
+		for i := range f.lineFlags {
+			f.lineFlags[i] = f.flags
+		}
+	case GPIO_V2_LINE_GET_VALUES_IOCTL:
+		values := (*gpio_v2_line_values)(arg)
+		bits := uint64(0)
+		for i, offset := range f.lines {
+			if values.mask&(1<<uint(i)) != 0 && levels[offset] != 0 {
+				bits |= 1 << uint(i)
+			}
+		}
+		values.bits = bits
+	case GPIO_V2_LINE_SET_VALUES_IOCTL:
+		values := (*gpio_v2_line_values)(arg)
+		for i := range f.lines {
+			if values.mask&(1<<uint(i)) != 0 && f.lineFlags[i]&GPIO_V2_LINE_FLAG_OUTPUT == 0 {
+				return syscall.EPERM
+			}
+		}
+		for i, offset := range f.lines {
+			if values.mask&(1<<uint(i)) != 0 {
+				levels[offset] = int((values.bits >> uint(i)) & 1)
+			}
+		}
+	default:
+		return syscall.ENOTTY
+	}
+	return nil
+}
+
+func (s *fakeGPIOCdevSys) read(fd int, b []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	f := s.files[fd]
+	if f == nil {
+		return 0, syscall.EBADF
+	}
+
+	size := int(unsafe.Sizeof(gpio_v2_line_event{}))
+	n := 0
+	for len(f.events) > 0 && n+size <= len(b) {
+		copy(b[n:], (*[unsafe.Sizeof(gpio_v2_line_event{})]byte)(unsafe.Pointer(&f.events[0]))[:])
+		f.events = f.events[1:]
+		n += size
+	}
+	return n, nil
+}
+
+func (s *fakeGPIOCdevSys) poll(fd int, timeout time.Duration) (bool, error) {
+	s.Lock()
+	f := s.files[fd]
+	pollErr := s.pollErr
+	s.Unlock()
+	if f == nil {
+		return false, syscall.EBADF
+	}
+	if pollErr != nil {
+		return false, pollErr
+	}
+
+	// check for events frequently rather than waiting for the full timeout, so tests don't take long.
+	for end := time.Now().Add(timeout); time.Now().Before(end); time.Sleep(time.Millisecond) {
+		s.Lock()
+		ready := len(f.events) > 0
+		s.Unlock()
+		if ready {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Simulate an edge on a line, queueing an event if the line request is detecting that edge.
+func (s *fakeGPIOCdevSys) setLevel(chip string, offset int, value int) {
+	s.Lock()
+	defer s.Unlock()
+
+	levels := s.levels[chip]
+	old := levels[offset]
+	levels[offset] = value
+
+	f := s.findLine(chip, offset)
+	if f == nil || old == value {
+		return
+	}
+	if value == HIGH && f.flags&GPIO_V2_LINE_FLAG_EDGE_RISING != 0 {
+		f.events = append(f.events, gpio_v2_line_event{timestamp_ns: uint64(time.Now().UnixNano()), id: GPIO_V2_LINE_EVENT_RISING_EDGE, offset: uint32(offset)})
+	}
+	if value == LOW && f.flags&GPIO_V2_LINE_FLAG_EDGE_FALLING != 0 {
+		f.events = append(f.events, gpio_v2_line_event{timestamp_ns: uint64(time.Now().UnixNano()), id: GPIO_V2_LINE_EVENT_FALLING_EDGE, offset: uint32(offset)})
+	}
+}
+
+// Determine if a line is part of any open line request.
+func (s *fakeGPIOCdevSys) lineRequested(chip string, offset int) bool {
+	return s.findLine(chip, offset) != nil
+}
+
+// Return the line request that holds a line, or nil.
+func (s *fakeGPIOCdevSys) findLine(chip string, offset int) *fakeGPIOCdevFile {
+	for _, f := range s.files {
+		if f.chip != chip {
+			continue
+		}
+		for _, o := range f.lines {
+			if o == offset {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// Create a module with two 32 line chips, and pins 1 and 2 mapped to GPIO 5 (chip 0) and GPIO 40 (chip 1).
+func newTestGPIOCdevModule(t *testing.T) (*DTGPIOCdevModule, *fakeGPIOCdevSys) {
+	// the module assigns its pins on the default board, so start with a fresh one
+	SetDriver(new(TestDriver))
+
+	sys := newFakeGPIOCdevSys(map[string]int{"/dev/gpiochip0": 32, "/dev/gpiochip1": 32})
+
+	pins := make(DTGPIOModulePinDefMap)
+	pins[1] = &DTGPIOModulePinDef{pin: 1, gpioLogical: 5}
+	pins[2] = &DTGPIOModulePinDef{pin: 2, gpioLogical: 40}
+
+	module := NewDTGPIOCdevModule("gpio")
+	module.sys = sys
+	e := module.SetOptions(map[string]interface{}{"pins": pins, "chips": DTGPIOCdevChipList{"/dev/gpiochip0", "/dev/gpiochip1"}})
+	if e != nil {
+		t.Fatalf("SetOptions returned an unexpected error: %s", e)
+	}
+	return module, sys
+}
+
+func TestGPIOCdevStructSizes(t *testing.T) {
+	// sizes from linux/gpio.h, which are encoded in the ioctl request numbers.
+	if s := unsafe.Sizeof(gpiochip_info{}); s != 68 {
+		t.Errorf("gpiochip_info should be 68 bytes, is %d", s)
+	}
+	if s := unsafe.Sizeof(gpio_v2_line_config{}); s != 272 {
+		t.Errorf("gpio_v2_line_config should be 272 bytes, is %d", s)
+	}
+	if s := unsafe.Sizeof(gpio_v2_line_request{}); s != 592 {
+		t.Errorf("gpio_v2_line_request should be 592 bytes, is %d", s)
+	}
+	if s := unsafe.Sizeof(gpio_v2_line_values{}); s != 16 {
+		t.Errorf("gpio_v2_line_values should be 16 bytes, is %d", s)
+	}
+}
+
+func TestGPIOCdevWriteRead(t *testing.T) {
+	module, sys := newTestGPIOCdevModule(t)
+	defer module.Disable()
+
+	e := module.PinMode(2, OUTPUT)
+	if e != nil {
+		t.Fatalf("PinMode returned an unexpected error: %s", e)
+	}
+
+	// GPIO 40 is line 8 of the second chip
+	line := sys.findLine("/dev/gpiochip1", 8)
+	if line == nil {
+		t.Fatal("Expected line 8 of gpiochip1 to be requested")
+	}
+	if line.flags != GPIO_V2_LINE_FLAG_OUTPUT {
+		t.Errorf("Expected line to be requested as output, flags are %x", line.flags)
+	}
+
+	module.DigitalWrite(2, HIGH)
+	if sys.levels["/dev/gpiochip1"][8] != HIGH {
+		t.Error("After writing HIGH to pin, line should be high")
+	}
+	module.DigitalWrite(2, LOW)
+	if sys.levels["/dev/gpiochip1"][8] != LOW {
+		t.Error("After writing LOW to pin, line should be low")
+	}
+
+	e = module.PinMode(1, INPUT)
+	if e != nil {
+		t.Fatalf("PinMode returned an unexpected error: %s", e)
+	}
+	sys.levels["/dev/gpiochip0"][5] = HIGH
+	v, e := module.DigitalRead(1)
+	if e != nil {
+		t.Errorf("DigitalRead returned an unexpected error: %s", e)
+	}
+	if v != HIGH {
+		t.Error("Expected DigitalRead to return HIGH from the line")
+	}
+}
+
+func TestGPIOCdevChangeMode(t *testing.T) {
+	module, sys := newTestGPIOCdevModule(t)
+	defer module.Disable()
+
+	module.PinMode(1, INPUT)
+	e := module.PinMode(1, OUTPUT)
+	if e != nil {
+		t.Fatalf("Changing the mode of an open pin should not return an error, returned %s", e)
+	}
+	if line := sys.findLine("/dev/gpiochip0", 5); line == nil || line.flags != GPIO_V2_LINE_FLAG_OUTPUT {
+		t.Error("Expected the line to be reconfigured as output")
+	}
+}
+
+func TestGPIOCdevClosePin(t *testing.T) {
+	module, sys := newTestGPIOCdevModule(t)
+	defer module.Disable()
+
+	if e := module.DigitalWrite(1, HIGH); e == nil {
+		t.Error("DigitalWrite on a pin that has not been opened should return an error")
+	}
+
+	module.PinMode(1, OUTPUT)
+	e := module.ClosePin(1)
+	if e != nil {
+		t.Errorf("ClosePin returned an unexpected error: %s", e)
+	}
+	if sys.lineRequested("/dev/gpiochip0", 5) {
+		t.Error("Line should be released after ClosePin")
+	}
+	if e = module.ClosePin(1); e == nil {
+		t.Error("Closing a pin twice should return an error")
+	}
+
+	// pin should be assignable again
+	if e = module.PinMode(1, OUTPUT); e != nil {
+		t.Errorf("PinMode after ClosePin returned an unexpected error: %s", e)
+	}
+}
+
+func TestGPIOCdevUnknownPin(t *testing.T) {
+	module, _ := newTestGPIOCdevModule(t)
+	defer module.Disable()
+
+	if e := module.PinMode(3, OUTPUT); e == nil {
+		t.Error("PinMode on a pin not defined for the module should return an error")
+	}
+}
+
+func TestGPIOCdevWatchPin(t *testing.T) {
+	module, sys := newTestGPIOCdevModule(t)
+	defer module.Disable()
+
+	module.PinMode(1, INPUT)
+	events, e := module.WatchPin(1, EDGE_RISING)
+	if e != nil {
+		t.Fatalf("WatchPin returned an unexpected error: %s", e)
+	}
+	if _, e = module.WatchPin(1, EDGE_RISING); e == nil {
+		t.Error("Watching a pin twice should return an error")
+	}
+
+	sys.setLevel("/dev/gpiochip0", 5, HIGH)
+	sys.setLevel("/dev/gpiochip0", 5, LOW)
+	sys.setLevel("/dev/gpiochip0", 5, HIGH)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-events:
+			if event.Pin != 1 || event.Edge != EDGE_RISING {
+				t.Errorf("Expected a rising edge on pin 1, got %s on pin %d", event.Edge, event.Pin)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for an edge event")
+		}
+	}
+
+	e = module.UnwatchPin(1)
+	if e != nil {
+		t.Errorf("UnwatchPin returned an unexpected error: %s", e)
+	}
+	if _, ok := <-events; ok {
+		t.Error("Expected the events channel to be closed after UnwatchPin")
+	}
+	if line := sys.findLine("/dev/gpiochip0", 5); line.flags != GPIO_V2_LINE_FLAG_INPUT {
+		t.Errorf("Expected edge detection to be turned off after UnwatchPin, flags are %x", line.flags)
+	}
+}
+
+func TestGPIOCdevWatchReadError(t *testing.T) {
+	module, sys := newTestGPIOCdevModule(t)
+	defer module.Disable()
+
+	module.PinMode(1, INPUT)
+	events, e := module.WatchPin(1, EDGE_BOTH)
+	if e != nil {
+		t.Fatalf("WatchPin returned an unexpected error: %s", e)
+	}
+
+	sys.Lock()
+	sys.pollErr = syscall.EIO
+	sys.Unlock()
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("Expected no events from a watch that failed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the events channel to be closed when the watch fails")
+	}
+	if e = module.UnwatchPin(1); e != nil {
+		t.Errorf("UnwatchPin returned an unexpected error after the watch failed: %s", e)
+	}
+}
+
+func TestGPIOCdevWatchOutput(t *testing.T) {
+	module, _ := newTestGPIOCdevModule(t)
+	defer module.Disable()
+
+	module.PinMode(1, OUTPUT)
+	if _, e := module.WatchPin(1, EDGE_BOTH); e == nil {
+		t.Error("Watching an output pin should return an error")
+	}
+}
+
+// Records pulls that are set, in place of a board specific pull setter.
+type recordingPullSetter map[int]PinIOMode
+
+func (s recordingPullSetter) SetPull(gpioLogical int, mode PinIOMode) error {
+	s[gpioLogical] = mode
+	return nil
+}
+
+func TestGPIOCdevBias(t *testing.T) {
+	module, sys := newTestGPIOCdevModule(t)
+	defer module.Disable()
+
+	e := module.PinMode(1, INPUT_PULLUP)
+	if e != nil {
+		t.Fatalf("PinMode returned an unexpected error: %s", e)
+	}
+	if line := sys.findLine("/dev/gpiochip0", 5); line.flags != GPIO_V2_LINE_FLAG_INPUT|GPIO_V2_LINE_FLAG_BIAS_PULL_UP {
+		t.Errorf("Expected line to be requested with pull up bias, flags are %x", line.flags)
+	}
+
+	module.PinMode(1, INPUT_PULLDOWN)
+	if line := sys.findLine("/dev/gpiochip0", 5); line.flags != GPIO_V2_LINE_FLAG_INPUT|GPIO_V2_LINE_FLAG_BIAS_PULL_DOWN {
+		t.Errorf("Expected line to be reconfigured with pull down bias, flags are %x", line.flags)
+	}
+}
+
+func TestGPIOCdevBiasUnsupported(t *testing.T) {
+	module, sys := newTestGPIOCdevModule(t)
+	defer module.Disable()
+	sys.noBias = true
+
+	// with no pull setter, there is no way to apply the bias
+	if e := module.PinMode(1, INPUT_PULLUP); e == nil {
+		t.Error("PinMode should return an error when the bias cannot be set")
+	}
+	if sys.lineRequested("/dev/gpiochip0", 5) {
+		t.Error("Line should not be left requested after PinMode fails")
+	}
+
+	pulls := make(recordingPullSetter)
+	module.pull = pulls
+	e := module.PinMode(1, INPUT_PULLUP)
+	if e != nil {
+		t.Fatalf("PinMode returned an unexpected error: %s", e)
+	}
+	if line := sys.findLine("/dev/gpiochip0", 5); line.flags != GPIO_V2_LINE_FLAG_INPUT {
+		t.Errorf("Expected line to be requested as a plain input, flags are %x", line.flags)
+	}
+	if pulls[5] != INPUT_PULLUP {
+		t.Error("Expected the pull setter to be used for GPIO 5")
+	}
+}
+
+func TestGPIOCdevBank(t *testing.T) {
+	module, sys := newTestGPIOCdevModule(t)
+	defer module.Disable()
+	module.definedPins[5] = &DTGPIOModulePinDef{pin: 5, gpioLogical: 6}
+	module.definedPins[6] = &DTGPIOModulePinDef{pin: 6, gpioLogical: 7}
+
+	module.PinMode(1, OUTPUT)
+	module.PinMode(5, OUTPUT)
+	module.PinMode(6, INPUT)
+	module.DigitalWrite(1, HIGH)
+
+	pins := PinList{1, 5, 6}
+	if !module.AtomicPins(pins) {
+		t.Error("Pins on the same chip should be atomic")
+	}
+	bank, e := module.OpenBank(pins)
+	if e != nil {
+		t.Fatalf("OpenBank returned an unexpected error: %s", e)
+	}
+	if !bank.Atomic() {
+		t.Error("Expected a bank on one chip to be atomic")
+	}
+
+	line := sys.findLine("/dev/gpiochip0", 5)
+	if line == nil || len(line.lines) != 3 {
+		t.Fatal("Expected the three lines to be in one line request")
+	}
+	if line.lineFlags[2] != GPIO_V2_LINE_FLAG_INPUT {
+		t.Errorf("Expected the input line to keep its flags, flags are %x", line.lineFlags[2])
+	}
+	if sys.levels["/dev/gpiochip0"][5] != HIGH {
+		t.Error("Output level should be kept when the bank is opened")
+	}
+
+	// pin 6 is an input, so its bit is ignored
+	e = bank.Write(3)
+	if e != nil {
+		t.Errorf("Write returned an unexpected error: %s", e)
+	}
+	levels := sys.levels["/dev/gpiochip0"]
+	if levels[5] != LOW || levels[6] != HIGH || levels[7] != LOW {
+		t.Errorf("Expected lines 5-7 to be 0 1 0, are %d %d %d", levels[5], levels[6], levels[7])
+	}
+
+	levels[7] = HIGH
+	v, e := bank.Read()
+	if e != nil {
+		t.Errorf("Read returned an unexpected error: %s", e)
+	}
+	if v != 3 {
+		t.Errorf("Expected Read to return 3, got %d", v)
+	}
+	if v, _ := module.DigitalRead(5); v != HIGH {
+		t.Error("Expected DigitalRead to work on a pin in a bank")
+	}
+
+	if e = module.ClosePin(1); e == nil {
+		t.Error("Closing a pin in an open bank should return an error")
+	}
+
+	e = bank.Close()
+	if e != nil {
+		t.Errorf("Close returned an unexpected error: %s", e)
+	}
+	if line := sys.findLine("/dev/gpiochip0", 6); line == nil || len(line.lines) != 1 {
+		t.Error("Expected each line to have its own line request after the bank is closed")
+	}
+	if levels[6] != HIGH {
+		t.Error("Output level should be kept when the bank is closed")
+	}
+	if e = module.ClosePin(1); e != nil {
+		t.Errorf("ClosePin after closing the bank returned an unexpected error: %s", e)
+	}
+}
+
+// Make line requests that include a line offset fail.
+func failGPIOCdevLine(offset int) func(offsets []int) error {
+	return func(offsets []int) error {
+		for _, o := range offsets {
+			if o == offset {
+				return syscall.EIO
+			}
+		}
+		return nil
+	}
+}
+
+func TestGPIOCdevBankRequestFailure(t *testing.T) {
+	module, sys := newTestGPIOCdevModule(t)
+	defer module.Disable()
+	module.definedPins[5] = &DTGPIOModulePinDef{pin: 5, gpioLogical: 6}
+
+	module.PinMode(1, OUTPUT)
+	module.PinMode(5, OUTPUT)
+
+	// requests for pin 5's line fail, so the bank can't be opened and pin 5 loses its line
+	sys.requestErr = failGPIOCdevLine(6)
+	if _, e := module.OpenBank(PinList{1, 5}); !errors.Is(e, syscall.EIO) {
+		t.Fatalf("Expected OpenBank to return the request error, got %v", e)
+	}
+	sys.requestErr = nil
+	if e := module.DigitalWrite(1, HIGH); e != nil || sys.levels["/dev/gpiochip0"][5] != HIGH {
+		t.Errorf("Expected pin 1 to get its line back, got %v", e)
+	}
+	if e := module.DigitalWrite(5, HIGH); !errors.Is(e, ErrNotOpen) {
+		t.Errorf("Expected ErrNotOpen writing to a pin whose line was lost, got %v", e)
+	}
+	if e := AssignPin(5, module); e != nil {
+		t.Errorf("Expected a pin whose line was lost to be released, got %v", e)
+	}
+	unassignPin(5, module)
+
+	// closing the bank fails to request pin 5's line again
+	module.PinMode(5, OUTPUT)
+	bank, e := module.OpenBank(PinList{1, 5})
+	if e != nil {
+		t.Fatalf("OpenBank returned an unexpected error: %s", e)
+	}
+	sys.requestErr = failGPIOCdevLine(6)
+	if e = bank.Close(); !errors.Is(e, syscall.EIO) {
+		t.Errorf("Expected Close to return the request error, got %v", e)
+	}
+	if e = module.DigitalWrite(5, LOW); !errors.Is(e, ErrNotOpen) {
+		t.Errorf("Expected ErrNotOpen writing to a pin whose line was lost, got %v", e)
+	}
+	if e = module.ClosePin(1); e != nil {
+		t.Errorf("ClosePin returned an unexpected error: %s", e)
+	}
+	if e = module.Disable(); e != nil {
+		t.Errorf("Disable returned an unexpected error: %s", e)
+	}
+}
+
+func TestGPIOCdevBankAcrossChips(t *testing.T) {
+	module, sys := newTestGPIOCdevModule(t)
+	defer module.Disable()
+
+	module.PinMode(1, OUTPUT)
+	module.PinMode(2, OUTPUT)
+
+	if module.AtomicPins(PinList{1, 2}) {
+		t.Error("Pins on different chips should not be atomic")
+	}
+	bank, e := module.OpenBank(PinList{1, 2})
+	if e != nil {
+		t.Fatalf("OpenBank returned an unexpected error: %s", e)
+	}
+	if bank.Atomic() {
+		t.Error("Expected a bank across chips not to be atomic")
+	}
+
+	bank.Write(1)
+	if sys.levels["/dev/gpiochip0"][5] != LOW || sys.levels["/dev/gpiochip1"][8] != HIGH {
+		t.Error("Expected the bank to write each pin")
+	}
+	bank.Close()
+}
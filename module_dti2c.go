@@ -11,6 +11,7 @@ package hwio
 // - http://derekmolloy.ie/beaglebone/beaglebone-an-i2c-tutorial-interfacing-to-a-bma180-accelerometer/'
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"sync"
@@ -33,27 +34,74 @@ type DTI2CModule struct {
 	fd *os.File
 }
 
-// Data that is passed to/from ioctl calls
+// Data that is passed to/from ioctl calls, from i2c-dev.h. size is a __u32 in the kernel's struct.
 type i2c_smbus_ioctl_data struct {
 	read_write uint8
 	command    uint8
-	size       int
+	size       uint32
 	data       uintptr
 }
 
+// A message passed to the I2C_RDWR ioctl, from i2c.h
+type i2c_msg struct {
+	addr  uint16
+	flags uint16
+	len   uint16
+	buf   *byte
+}
+
+// Data that is passed to the I2C_RDWR ioctl
+type i2c_rdwr_ioctl_data struct {
+	msgs  *i2c_msg
+	nmsgs uint32
+}
+
+// Flags for I2CMessage, from i2c.h
+const (
+	I2C_M_RD           = 0x0001 // read from the device, rather than write to it
+	I2C_M_TEN          = 0x0010 // the address is a 10-bit address
+	I2C_M_RECV_LEN     = 0x0400 // the first byte read is the length of the rest
+	I2C_M_NO_RD_ACK    = 0x0800 // don't acknowledge bytes read
+	I2C_M_IGNORE_NAK   = 0x1000 // carry on if the device doesn't acknowledge
+	I2C_M_REV_DIR_ADDR = 0x2000 // invert the read/write bit of the address
+	I2C_M_NOSTART      = 0x4000 // don't send a repeated start before this message
+	I2C_M_STOP         = 0x8000 // send a stop after this message
+)
+
 // Constants used by ioctl, from i2c-dev.h
 const (
-	I2C_SMBUS_READ           = 1
-	I2C_SMBUS_WRITE          = 0
-	I2C_SMBUS_BYTE_DATA      = 2
-	I2C_SMBUS_I2C_BLOCK_DATA = 8
-	I2C_SMBUS_BLOCK_MAX      = 32
+	I2C_SMBUS_READ  = 1
+	I2C_SMBUS_WRITE = 0
+
+	// Sizes, which select the SMBus operation
+	I2C_SMBUS_QUICK           = 0
+	I2C_SMBUS_BYTE            = 1
+	I2C_SMBUS_BYTE_DATA       = 2
+	I2C_SMBUS_WORD_DATA       = 3
+	I2C_SMBUS_PROC_CALL       = 4
+	I2C_SMBUS_BLOCK_DATA      = 5
+	I2C_SMBUS_BLOCK_PROC_CALL = 7
+	I2C_SMBUS_I2C_BLOCK_DATA  = 8
+
+	I2C_SMBUS_BLOCK_MAX = 32
 
 	// Talk to bus
 	I2C_SMBUS = 0x0720
 
 	// Set bus slave
 	I2C_SLAVE = 0x0703
+
+	// Get the functionality of the bus adapter, and the flags it returns that Scan uses
+	I2C_FUNCS                = 0x0705
+	I2C_FUNC_SMBUS_QUICK     = 0x00010000
+	I2C_FUNC_SMBUS_READ_BYTE = 0x00020000
+
+	// Combined read/write transfer
+	I2C_RDWR = 0x0707
+
+	// Maximum number of messages in one I2C_RDWR transfer, and the maximum length of each
+	I2C_RDWR_IOCTL_MAX_MSGS = 42
+	I2C_RDWR_MAX_MSG_LEN    = 8192
 )
 
 func NewDTI2CModule(name string) (result *DTI2CModule) {
@@ -87,6 +135,9 @@ func (module *DTI2CModule) SetOptions(options map[string]interface{}) error {
 
 // enable this I2C module
 func (module *DTI2CModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
 	// Assign the pins so nothing else can allocate them.
 	for _, pin := range module.definedPins {
 		// fmt.Printf("assigning pin %d\n", pin)
@@ -106,12 +157,15 @@ func (module *DTI2CModule) Enable() error {
 
 // disables module and release any pins assigned.
 func (module *DTI2CModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
 	if e := module.fd.Close(); e != nil {
 		return e
 	}
 
 	for _, pin := range module.definedPins {
-		UnassignPin(pin)
+		unassignPin(pin, module)
 	}
 
 	return nil
@@ -125,6 +179,46 @@ func (module *DTI2CModule) GetDevice(address int) I2CDevice {
 	return NewDTI2CDevice(module, address)
 }
 
+// Scan the bus for devices. As with i2cdetect, an address is probed with a receive byte for the ranges where a quick
+// write can upset devices, and a quick write otherwise. If the adapter can't do one of these, the addresses that need it
+// are skipped. Addresses that the kernel reports as busy are in use by a driver, so are included.
+func (module *DTI2CModule) Scan() ([]int, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.fd == nil {
+		return nil, &I2CError{Op: "Scan", Module: module.name, Err: ErrClosed}
+	}
+
+	funcs := uint64(0)
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(module.fd.Fd()), I2C_FUNCS, uintptr(unsafe.Pointer(&funcs)))
+	if err != 0 {
+		return nil, &I2CError{Op: "Scan", Module: module.name, Err: err}
+	}
+	canQuick := funcs&I2C_FUNC_SMBUS_QUICK != 0
+	canReadByte := funcs&I2C_FUNC_SMBUS_READ_BYTE != 0
+	if !canQuick && !canReadByte {
+		return nil, &I2CError{Op: "Scan", Module: module.name,
+			Err: fmt.Errorf("scanning is %w by the adapter, which can't do quick writes or receive bytes", ErrUnsupported)}
+	}
+
+	result := scanI2CAddresses(func(address int, readByte bool) bool {
+		device := NewDTI2CDevice(module, address)
+		var e error
+		switch {
+		case readByte && canReadByte:
+			data := uint8(0)
+			e = device.smbusAccess("Scan", I2C_SMBUS_READ, 0, I2C_SMBUS_BYTE, unsafe.Pointer(&data))
+		case !readByte && canQuick:
+			e = device.smbusAccess("Scan", I2C_SMBUS_WRITE, 0, I2C_SMBUS_QUICK, nil)
+		default:
+			return false
+		}
+		return e == nil || errors.Is(e, syscall.EBUSY)
+	})
+	return result, nil
+}
+
 type DTI2CDevice struct {
 	module  *DTI2CModule
 	address int
@@ -138,50 +232,23 @@ func (device *DTI2CDevice) Write(command byte, data []byte) (e error) {
 	device.module.Lock()
 	defer device.module.Unlock()
 
-	device.sendSlaveAddress()
-
-	buffer := make([]byte, len(data)+1)
+	buffer := make([]byte, I2C_SMBUS_BLOCK_MAX+2)
 	buffer[0] = byte(len(data))
 	copy(buffer[1:], data)
 
-	//	buffer := make([]byte, numBytes+2)
-
-	busData := i2c_smbus_ioctl_data{
-		read_write: I2C_SMBUS_WRITE,
-		command:    command,
-		size:       I2C_SMBUS_I2C_BLOCK_DATA,
-		data:       uintptr(unsafe.Pointer(&buffer[0])),
-	}
-
-	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(device.module.fd.Fd()), I2C_SMBUS, uintptr(unsafe.Pointer(&busData)))
-	if err != 0 {
-		return syscall.Errno(err)
-	}
-
-	return nil
+	return device.smbusAccess("Write", I2C_SMBUS_WRITE, command, I2C_SMBUS_I2C_BLOCK_DATA, unsafe.Pointer(&buffer[0]))
 }
 
 func (device *DTI2CDevice) Read(command byte, numBytes int) ([]byte, error) {
 	device.module.Lock()
 	defer device.module.Unlock()
 
-	device.sendSlaveAddress()
-
-	buffer := make([]byte, numBytes+1)
+	buffer := make([]byte, I2C_SMBUS_BLOCK_MAX+2)
 	buffer[0] = byte(numBytes)
 
-	//	buffer := make([]byte, numBytes+2)
-
-	busData := i2c_smbus_ioctl_data{
-		read_write: I2C_SMBUS_READ,
-		command:    command,
-		size:       I2C_SMBUS_I2C_BLOCK_DATA,
-		data:       uintptr(unsafe.Pointer(&buffer[0])),
-	}
-
-	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(device.module.fd.Fd()), I2C_SMBUS, uintptr(unsafe.Pointer(&busData)))
-	if err != 0 {
-		return nil, syscall.Errno(err)
+	e := device.smbusAccess("Read", I2C_SMBUS_READ, command, I2C_SMBUS_I2C_BLOCK_DATA, unsafe.Pointer(&buffer[0]))
+	if e != nil {
+		return nil, e
 	}
 
 	result := make([]byte, numBytes)
@@ -195,56 +262,196 @@ func (device *DTI2CDevice) ReadByte(command byte) (byte, error) {
 	device.module.Lock()
 	defer device.module.Unlock()
 
-	e := device.sendSlaveAddress()
-	if e != nil {
-		return 0, e
+	data := uint8(0)
+	e := device.smbusAccess("ReadByte", I2C_SMBUS_READ, command, I2C_SMBUS_BYTE_DATA, unsafe.Pointer(&data))
+	return data, e
+}
+
+func (device *DTI2CDevice) WriteByte(command byte, value byte) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	return device.smbusAccess("WriteByte", I2C_SMBUS_WRITE, command, I2C_SMBUS_BYTE_DATA, unsafe.Pointer(&value))
+}
+
+func (device *DTI2CDevice) QuickCommand(read bool) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	readWrite := uint8(I2C_SMBUS_WRITE)
+	if read {
+		readWrite = I2C_SMBUS_READ
 	}
+	return device.smbusAccess("QuickCommand", readWrite, 0, I2C_SMBUS_QUICK, nil)
+}
+
+func (device *DTI2CDevice) ReceiveByte() (byte, error) {
+	device.module.Lock()
+	defer device.module.Unlock()
 
 	data := uint8(0)
+	e := device.smbusAccess("ReceiveByte", I2C_SMBUS_READ, 0, I2C_SMBUS_BYTE, unsafe.Pointer(&data))
+	return data, e
+}
 
-	busData := i2c_smbus_ioctl_data{
-		read_write: I2C_SMBUS_READ,
-		command:    command,
-		size:       I2C_SMBUS_BYTE_DATA,
-		data:       uintptr(unsafe.Pointer(&data)),
-	}
+func (device *DTI2CDevice) SendByte(value byte) error {
+	device.module.Lock()
+	defer device.module.Unlock()
 
-	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(device.module.fd.Fd()), I2C_SMBUS, uintptr(unsafe.Pointer(&busData)))
-	if err != 0 {
-		return 0, syscall.Errno(err)
+	// the byte is sent in place of the command
+	return device.smbusAccess("SendByte", I2C_SMBUS_WRITE, value, I2C_SMBUS_BYTE, nil)
+}
+
+func (device *DTI2CDevice) ReadWord(command byte) (uint16, error) {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	data := uint16(0)
+	e := device.smbusAccess("ReadWord", I2C_SMBUS_READ, command, I2C_SMBUS_WORD_DATA, unsafe.Pointer(&data))
+	return data, e
+}
+
+func (device *DTI2CDevice) WriteWord(command byte, value uint16) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	return device.smbusAccess("WriteWord", I2C_SMBUS_WRITE, command, I2C_SMBUS_WORD_DATA, unsafe.Pointer(&value))
+}
+
+func (device *DTI2CDevice) ProcessCall(command byte, value uint16) (uint16, error) {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	// the kernel writes the reply over the value
+	e := device.smbusAccess("ProcessCall", I2C_SMBUS_WRITE, command, I2C_SMBUS_PROC_CALL, unsafe.Pointer(&value))
+	return value, e
+}
+
+func (device *DTI2CDevice) BlockRead(command byte) ([]byte, error) {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	buffer := make([]byte, I2C_SMBUS_BLOCK_MAX+2)
+	e := device.smbusAccess("BlockRead", I2C_SMBUS_READ, command, I2C_SMBUS_BLOCK_DATA, unsafe.Pointer(&buffer[0]))
+	if e != nil {
+		return nil, e
 	}
+	return smbusBlock(buffer), nil
+}
 
-	return data, nil
+func (device *DTI2CDevice) BlockWrite(command byte, data []byte) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	buffer, e := device.makeSMBusBlock("BlockWrite", data)
+	if e != nil {
+		return e
+	}
+	return device.smbusAccess("BlockWrite", I2C_SMBUS_WRITE, command, I2C_SMBUS_BLOCK_DATA, unsafe.Pointer(&buffer[0]))
 }
 
-func (device *DTI2CDevice) WriteByte(command byte, value byte) error {
+func (device *DTI2CDevice) BlockProcessCall(command byte, data []byte) ([]byte, error) {
 	device.module.Lock()
 	defer device.module.Unlock()
 
+	buffer, e := device.makeSMBusBlock("BlockProcessCall", data)
+	if e != nil {
+		return nil, e
+	}
+	e = device.smbusAccess("BlockProcessCall", I2C_SMBUS_WRITE, command, I2C_SMBUS_BLOCK_PROC_CALL, unsafe.Pointer(&buffer[0]))
+	if e != nil {
+		return nil, e
+	}
+	return smbusBlock(buffer), nil
+}
+
+// Make the buffer for a block that is sent with its length, as i2c_smbus_data.block. The block is limited to
+// I2C_SMBUS_BLOCK_MAX bytes.
+func (device *DTI2CDevice) makeSMBusBlock(op string, data []byte) ([]byte, error) {
+	if len(data) > I2C_SMBUS_BLOCK_MAX {
+		return nil, &I2CError{Op: op, Module: device.module.GetName(), Address: device.address,
+			Err: fmt.Errorf("a block of %d bytes is %w, the limit is %d", len(data), ErrUnsupported, I2C_SMBUS_BLOCK_MAX)}
+	}
+	buffer := make([]byte, I2C_SMBUS_BLOCK_MAX+2)
+	buffer[0] = byte(len(data))
+	copy(buffer[1:], data)
+	return buffer, nil
+}
+
+// Get the bytes of a block received with its length.
+func smbusBlock(buffer []byte) []byte {
+	n := int(buffer[0])
+	if n > I2C_SMBUS_BLOCK_MAX {
+		n = I2C_SMBUS_BLOCK_MAX
+	}
+	return append([]byte{}, buffer[1:1+n]...)
+}
+
+// Select the device and perform an SMBus operation with the I2C_SMBUS ioctl. data points to the i2c_smbus_data for
+// the operation, which must be big enough for its size: a byte, a word, or I2C_SMBUS_BLOCK_MAX+2 bytes for a block.
+func (device *DTI2CDevice) smbusAccess(op string, readWrite uint8, command byte, size int, data unsafe.Pointer) error {
 	e := device.sendSlaveAddress()
 	if e != nil {
 		return e
 	}
 
 	busData := i2c_smbus_ioctl_data{
-		read_write: I2C_SMBUS_WRITE,
+		read_write: readWrite,
 		command:    command,
-		size:       I2C_SMBUS_BYTE_DATA,
-		data:       uintptr(unsafe.Pointer(&value)),
+		size:       uint32(size),
+		data:       uintptr(data),
 	}
 
 	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(device.module.fd.Fd()), I2C_SMBUS, uintptr(unsafe.Pointer(&busData)))
 	if err != 0 {
-		return syscall.Errno(err)
+		return device.i2cError(op, err)
 	}
+	return nil
+}
+
+// Perform a transaction with the I2C_RDWR ioctl. The kernel limits each message to I2C_RDWR_MAX_MSG_LEN bytes, and a
+// transaction to I2C_RDWR_IOCTL_MAX_MSGS messages.
+func (device *DTI2CDevice) Tx(messages []I2CMessage) error {
+	device.module.Lock()
+	defer device.module.Unlock()
 
+	if len(messages) == 0 {
+		return nil
+	}
+	if len(messages) > I2C_RDWR_IOCTL_MAX_MSGS {
+		return &I2CError{Op: "Tx", Module: device.module.GetName(), Address: device.address,
+			Err: fmt.Errorf("a transaction of %d messages is %w, the limit is %d", len(messages), ErrUnsupported, I2C_RDWR_IOCTL_MAX_MSGS)}
+	}
+
+	msgs := make([]i2c_msg, len(messages))
+	for i, m := range messages {
+		if len(m.Data) > I2C_RDWR_MAX_MSG_LEN {
+			return &I2CError{Op: "Tx", Module: device.module.GetName(), Address: device.address,
+				Err: fmt.Errorf("a message of %d bytes is %w, the limit is %d", len(m.Data), ErrUnsupported, I2C_RDWR_MAX_MSG_LEN)}
+		}
+		msgs[i] = i2c_msg{addr: uint16(device.address), flags: m.Flags, len: uint16(len(m.Data))}
+		if len(m.Data) > 0 {
+			msgs[i].buf = &m.Data[0]
+		}
+	}
+	data := i2c_rdwr_ioctl_data{msgs: &msgs[0], nmsgs: uint32(len(msgs))}
+
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(device.module.fd.Fd()), I2C_RDWR, uintptr(unsafe.Pointer(&data)))
+	if err != 0 {
+		return device.i2cError("Tx", err)
+	}
 	return nil
 }
 
 func (device *DTI2CDevice) sendSlaveAddress() error {
 	_, _, enum := syscall.Syscall(syscall.SYS_IOCTL, uintptr(device.module.fd.Fd()), I2C_SLAVE, uintptr(device.address))
 	if enum != 0 {
-		return fmt.Errorf("Could not open I2C bus on module %s", device.module.GetName())
+		return device.i2cError("SetAddress", enum)
 	}
 	return nil
 }
+
+// Wrap an errno from an ioctl on the bus in an I2CError for this device.
+func (device *DTI2CDevice) i2cError(op string, errno syscall.Errno) error {
+	return &I2CError{Op: op, Module: device.module.GetName(), Address: device.address, Err: errno}
+}
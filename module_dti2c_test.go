@@ -0,0 +1,29 @@
+package hwio
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestI2CStructSizes(t *testing.T) {
+	// layouts from linux/i2c.h and linux/i2c-dev.h, which the kernel reads directly.
+	ptr := unsafe.Sizeof(uintptr(0))
+	if o := unsafe.Offsetof(i2c_msg{}.buf); o != 8 {
+		t.Errorf("i2c_msg.buf should be at offset 8, is at %d", o)
+	}
+	if s := unsafe.Sizeof(i2c_msg{}); s != 8+ptr {
+		t.Errorf("i2c_msg should be %d bytes, is %d", 8+ptr, s)
+	}
+	if s := unsafe.Sizeof(i2c_rdwr_ioctl_data{}); s != 2*ptr {
+		t.Errorf("i2c_rdwr_ioctl_data should be %d bytes, is %d", 2*ptr, s)
+	}
+	if o := unsafe.Offsetof(i2c_smbus_ioctl_data{}.size); o != 4 {
+		t.Errorf("i2c_smbus_ioctl_data.size should be at offset 4, is at %d", o)
+	}
+	if o := unsafe.Offsetof(i2c_smbus_ioctl_data{}.data); o != 8 {
+		t.Errorf("i2c_smbus_ioctl_data.data should be at offset 8, is at %d", o)
+	}
+	if s := unsafe.Sizeof(i2c_smbus_ioctl_data{}); s != 8+ptr {
+		t.Errorf("i2c_smbus_ioctl_data should be %d bytes, is %d", 8+ptr, s)
+	}
+}
@@ -1,9 +1,9 @@
 package hwio
 
 import (
-	"errors"
 	"fmt"
 	"strings"
+	"sync"
 )
 
 // This is a module to support the onboard LED functions. While these are actually attached to GPIO pins that
@@ -11,6 +11,8 @@ import (
 // to map what is displayed on the LEDs.
 type (
 	DTLEDModule struct {
+		sync.Mutex
+
 		name        string
 		definedPins DTLEDModulePins
 
@@ -18,6 +20,8 @@ type (
 	}
 
 	DTLEDModuleLED struct {
+		sync.Mutex
+
 		path           string
 		currentTrigger string
 	}
@@ -56,6 +60,9 @@ func (m *DTLEDModule) SetOptions(options map[string]interface{}) error {
 
 // Get a LED to manipulate. 'led' must be 0 to 3.
 func (m *DTLEDModule) GetLED(led string) (LEDModuleLED, error) {
+	m.Lock()
+	defer m.Unlock()
+
 	led = strings.ToLower(led)
 
 	if ol := m.leds[led]; ol != nil {
@@ -69,7 +76,7 @@ func (m *DTLEDModule) GetLED(led string) (LEDModuleLED, error) {
 		m.leds[led] = result
 		return result, nil
 	} else {
-		return nil, fmt.Errorf("GetLED: invalid led '%s'", led)
+		return nil, fmt.Errorf("GetLED: invalid led '%s': %w", led, ErrUnknownPin)
 	}
 }
 
@@ -90,13 +97,19 @@ func (m *DTLEDModule) GetLED(led string) (LEDModuleLED, error) {
 // - USR3: mmc1
 // For Raspberry Pi is mmc0.
 func (led *DTLEDModuleLED) SetTrigger(trigger string) error {
+	led.Lock()
+	defer led.Unlock()
+
 	led.currentTrigger = trigger
 	return WriteStringToFile(led.path+"trigger", trigger)
 }
 
 func (led *DTLEDModuleLED) SetOn(on bool) error {
+	led.Lock()
+	defer led.Unlock()
+
 	if led.currentTrigger != "none" {
-		return errors.New("LED SetOn requires that the LED trigger has been set to 'none'")
+		return fmt.Errorf("LED SetOn requires that the LED trigger has been set to 'none': %w", ErrWrongMode)
 	}
 
 	v := "0"
@@ -0,0 +1,284 @@
+// Implementation of the 1-Wire module interface for buses run by the kernel's w1 subsystem, such as a bus on a GPIO
+// pin with the w1-gpio overlay. The kernel searches the bus itself, and lists the devices it finds as directories
+// under /sys/bus/w1/devices, named by their IDs.
+
+package hwio
+
+// references:
+// - https://www.kernel.org/doc/html/latest/w1/w1-generic.html
+// - Maxim application note 27, "Understanding and Using Cyclic Redundancy Checks with Maxim 1-Wire and iButton
+//   Products"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// A list of the pins that are allocated when the module is enabled, usually just the data pin.
+type DTOneWireModulePins []Pin
+
+type DTOneWireModule struct {
+	sync.Mutex
+
+	name        string
+	path        string
+	definedPins DTOneWireModulePins
+
+	enabled bool
+}
+
+func NewDTOneWireModule(name string) *DTOneWireModule {
+	return &DTOneWireModule{name: name, path: "/sys/bus/w1/devices"}
+}
+
+// Accept options for the 1-Wire module. Expected options include:
+// - "pins" - a DTOneWireModulePins of the pins that are assigned when the module is enabled.
+// - "path" - optionally, a string with the directory the devices are listed in, by default /sys/bus/w1/devices.
+func (module *DTOneWireModule) SetOptions(options map[string]interface{}) error {
+	vp := options["pins"]
+	if vp == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.definedPins = vp.(DTOneWireModulePins)
+
+	if path, ok := options["path"].(string); ok {
+		module.path = path
+	}
+
+	return nil
+}
+
+// Enable the bus, assigning its pins. The kernel has to have a bus master, otherwise the devices directory doesn't
+// exist and this fails.
+func (module *DTOneWireModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.enabled {
+		return nil
+	}
+
+	e := AssignPins(PinList(module.definedPins), module)
+	if e != nil {
+		return e
+	}
+
+	if _, e = os.Stat(module.path); e != nil {
+		for _, pin := range module.definedPins {
+			unassignPin(pin, module)
+		}
+		return module.oneWireError("Enable", "", e)
+	}
+
+	module.enabled = true
+	return nil
+}
+
+// Disable the bus, releasing its pins. The kernel carries on running the bus.
+func (module *DTOneWireModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.enabled {
+		return nil
+	}
+
+	for _, pin := range module.definedPins {
+		unassignPin(pin, module)
+	}
+	module.enabled = false
+	return nil
+}
+
+func (module *DTOneWireModule) GetName() string {
+	return module.name
+}
+
+// Get the devices the kernel has found, in order of their IDs. The kernel searches the bus every few seconds, so a
+// device that has just been connected may not be listed straight away.
+func (module *DTOneWireModule) Devices() ([]OneWireDevice, error) {
+	e := module.check()
+	if e != nil {
+		return nil, module.oneWireError("Devices", "", e)
+	}
+
+	entries, e := os.ReadDir(module.path)
+	if e != nil {
+		return nil, module.oneWireError("Devices", "", e)
+	}
+
+	result := make([]OneWireDevice, 0)
+	for _, entry := range entries {
+		// the directory also has the bus masters, e.g. w1_bus_master1
+		if _, _, ok := parseOneWireID(entry.Name()); ok {
+			result = append(result, module.GetDevice(entry.Name()))
+		}
+	}
+	return result, nil
+}
+
+func (module *DTOneWireModule) GetDevice(id string) OneWireDevice {
+	return &DTOneWireDevice{module: module, id: id}
+}
+
+// Return an error if the module isn't enabled.
+func (module *DTOneWireModule) check() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.enabled {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (module *DTOneWireModule) oneWireError(op string, device string, err error) error {
+	return &OneWireError{Op: op, Module: module.name, Device: device, Err: err}
+}
+
+// A device on a DTOneWireModule, which is read and written through the files in its directory. Transfers are locked
+// by the kernel, but only one at a time, so a Write and the Read of its reply could have another transfer between
+// them.
+type DTOneWireDevice struct {
+	module *DTOneWireModule
+	id     string
+}
+
+func (device *DTOneWireDevice) ID() string {
+	return device.id
+}
+
+// Get the family code from the device's ID. This is 0 if the ID isn't valid.
+func (device *DTOneWireDevice) Family() byte {
+	family, _, _ := parseOneWireID(device.id)
+	return family
+}
+
+func (device *DTOneWireDevice) ROM() (uint64, error) {
+	b, e := device.read("ROM", "id", 8)
+	if e != nil {
+		return 0, e
+	}
+	if OneWireCRC8(b[:7]) != b[7] {
+		return 0, device.module.oneWireError("ROM", device.id, ErrCRC)
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// Write bytes to the device through the kernel's rw file, which resets the bus and selects the device first.
+func (device *DTOneWireDevice) Write(data []byte) error {
+	return device.write("Write", "rw", data)
+}
+
+// Read bytes from the device through the kernel's rw file. This doesn't reset the bus, so it carries on from the last
+// Write.
+func (device *DTOneWireDevice) Read(n int) ([]byte, error) {
+	return device.read("Read", "rw", n)
+}
+
+// Read the whole of one of the files of the device's driver, e.g. w1_slave.
+func (device *DTOneWireDevice) ReadAttribute(name string) ([]byte, error) {
+	e := device.module.check()
+	if e != nil {
+		return nil, device.module.oneWireError("ReadAttribute", device.id, e)
+	}
+
+	b, e := os.ReadFile(device.file(name))
+	if e != nil {
+		return nil, device.module.oneWireError("ReadAttribute", device.id, e)
+	}
+	return b, nil
+}
+
+func (device *DTOneWireDevice) WriteAttribute(name string, data []byte) error {
+	return device.write("WriteAttribute", name, data)
+}
+
+// Read n bytes from a file of the device. A device that has gone from the bus gives os.ErrNotExist.
+func (device *DTOneWireDevice) read(op string, name string, n int) ([]byte, error) {
+	e := device.module.check()
+	if e != nil {
+		return nil, device.module.oneWireError(op, device.id, e)
+	}
+
+	f, e := os.Open(device.file(name))
+	if e != nil {
+		return nil, device.module.oneWireError(op, device.id, e)
+	}
+	defer f.Close()
+
+	b := make([]byte, n)
+	_, e = io.ReadFull(f, b)
+	if e == io.EOF || e == io.ErrUnexpectedEOF {
+		e = syscall.EIO
+	}
+	if e != nil {
+		return nil, device.module.oneWireError(op, device.id, e)
+	}
+	return b, nil
+}
+
+// Write data to a file of the device in a single write, as sysfs files expect. The file is truncated as a shell's
+// redirection does, which sysfs ignores.
+func (device *DTOneWireDevice) write(op string, name string, data []byte) error {
+	e := device.module.check()
+	if e != nil {
+		return device.module.oneWireError(op, device.id, e)
+	}
+
+	f, e := os.OpenFile(device.file(name), os.O_WRONLY|os.O_TRUNC, 0)
+	if e == nil {
+		_, e = f.Write(data)
+		if ce := f.Close(); e == nil {
+			e = ce
+		}
+	}
+	if e != nil {
+		return device.module.oneWireError(op, device.id, e)
+	}
+	return nil
+}
+
+func (device *DTOneWireDevice) file(name string) string {
+	return filepath.Join(device.module.path, device.id, name)
+}
+
+// Split a device ID such as "28-0000055f1b2c" into the family code and the serial number.
+func parseOneWireID(id string) (family byte, serial uint64, ok bool) {
+	if len(id) != 15 || id[2] != '-' {
+		return 0, 0, false
+	}
+	f, e := strconv.ParseUint(id[:2], 16, 8)
+	if e != nil {
+		return 0, 0, false
+	}
+	serial, e = strconv.ParseUint(id[3:], 16, 48)
+	if e != nil {
+		return 0, 0, false
+	}
+	return byte(f), serial, true
+}
+
+// Calculate the CRC that 1-Wire devices use to check ROM codes and data, with the polynomial x^8 + x^5 + x^4 + 1.
+// The CRC of data followed by its CRC is 0.
+func OneWireCRC8(data []byte) byte {
+	crc := byte(0)
+	for _, b := range data {
+		for i := 0; i < 8; i++ {
+			mix := (crc ^ b) & 1
+			crc >>= 1
+			if mix != 0 {
+				crc ^= 0x8c
+			}
+			b >>= 1
+		}
+	}
+	return crc
+}
@@ -0,0 +1,109 @@
+package hwio
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Make a directory like /sys/bus/w1/devices with a bus master and a device, and return its path.
+func newTestOneWireTree(t *testing.T) string {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "w1_bus_master1"), 0755)
+
+	device := filepath.Join(dir, "28-0000055f1b2c")
+	os.Mkdir(device, 0755)
+	os.WriteFile(filepath.Join(device, "id"), []byte{0x28, 0x2c, 0x1b, 0x5f, 0x05, 0x00, 0x00, 0xae}, 0644)
+	os.WriteFile(filepath.Join(device, "rw"), nil, 0644)
+	os.WriteFile(filepath.Join(device, "name"), []byte("28-0000055f1b2c\n"), 0644)
+	return dir
+}
+
+func TestOneWireCRC8(t *testing.T) {
+	// a DS18B20 scratchpad, as the kernel reports it
+	data := []byte{0x72, 0x01, 0x4b, 0x46, 0x7f, 0xff, 0x0e, 0x10, 0x57}
+	if crc := OneWireCRC8(data[:8]); crc != 0x57 {
+		t.Errorf("Expected a CRC of 0x57, got 0x%02x", crc)
+	}
+	if crc := OneWireCRC8(data); crc != 0 {
+		t.Errorf("Expected the CRC of data and its CRC to be 0, got 0x%02x", crc)
+	}
+}
+
+func TestDTOneWireModule(t *testing.T) {
+	SetDriver(new(TestDriver))
+	dir := newTestOneWireTree(t)
+
+	var w1 OneWireModule = NewDTOneWireModule("onewire")
+	e := w1.SetOptions(map[string]interface{}{"pins": DTOneWireModulePins{3}, "path": dir})
+	if e != nil {
+		t.Fatalf("SetOptions returned an unexpected error: %s", e)
+	}
+	if _, e = w1.Devices(); !errors.Is(e, ErrClosed) {
+		t.Errorf("Expected ErrClosed listing the devices of a bus that isn't enabled, got %v", e)
+	}
+
+	if e = w1.Enable(); e != nil {
+		t.Fatalf("Enable returned an unexpected error: %s", e)
+	}
+	defer w1.Disable()
+	if e = PinMode(3, OUTPUT); !errors.Is(e, ErrPinInUse) {
+		t.Errorf("Expected the data pin to be assigned, got %v", e)
+	}
+
+	devices, e := w1.Devices()
+	if e != nil || len(devices) != 1 {
+		t.Fatalf("Expected to find one device, got %d, %v", len(devices), e)
+	}
+	device := devices[0]
+	if device.ID() != "28-0000055f1b2c" || device.Family() != 0x28 {
+		t.Errorf("Expected device 28-0000055f1b2c of family 0x28, got %s of family 0x%02x", device.ID(), device.Family())
+	}
+	if rom, e := device.ROM(); e != nil || rom != 0xae0000055f1b2c28 {
+		t.Errorf("Expected ROM code 0xae0000055f1b2c28, got 0x%016x, %v", rom, e)
+	}
+
+	// the rw file of the tree is a plain file, so it reads back what was written
+	if e = device.Write([]byte{0xcc, 0x44}); e != nil {
+		t.Errorf("Write returned an unexpected error: %s", e)
+	}
+	if b, e := device.Read(2); e != nil || b[0] != 0xcc || b[1] != 0x44 {
+		t.Errorf("Expected to read the bytes of the rw file, got %x, %v", b, e)
+	}
+	var oneWireError *OneWireError
+	if _, e = device.Read(3); !errors.As(e, &oneWireError) || oneWireError.Device != device.ID() {
+		t.Errorf("Expected a OneWireError for a short read, got %v", e)
+	}
+
+	attributes := device.(OneWireAttributeDevice)
+	if b, e := attributes.ReadAttribute("name"); e != nil || string(b) != "28-0000055f1b2c\n" {
+		t.Errorf("Expected to read the name file, got %q, %v", b, e)
+	}
+	if e = attributes.WriteAttribute("missing", []byte("1")); !errors.Is(e, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist writing a file the driver doesn't have, got %v", e)
+	}
+
+	os.WriteFile(filepath.Join(dir, device.ID(), "id"), []byte{0x28, 0x2c, 0x1b, 0x5f, 0x05, 0x00, 0x00, 0x00}, 0644)
+	if _, e = device.ROM(); !errors.Is(e, ErrCRC) {
+		t.Errorf("Expected ErrCRC for a ROM code with the wrong CRC, got %v", e)
+	}
+	if _, e = w1.GetDevice("28-000000000001").ROM(); !errors.Is(e, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist for a device that isn't on the bus, got %v", e)
+	}
+
+	w1.Disable()
+	if e = PinMode(3, OUTPUT); e != nil {
+		t.Errorf("Expected the data pin to be released, got %v", e)
+	}
+	ClosePin(3)
+
+	missing := NewDTOneWireModule("onewire")
+	missing.SetOptions(map[string]interface{}{"pins": DTOneWireModulePins{3}, "path": filepath.Join(dir, "missing")})
+	if e = missing.Enable(); !errors.Is(e, os.ErrNotExist) {
+		t.Errorf("Expected Enable to fail when there is no bus master, got %v", e)
+	}
+	if e = PinMode(3, OUTPUT); e != nil {
+		t.Errorf("Expected the data pin to be released when Enable fails, got %v", e)
+	}
+}
@@ -0,0 +1,412 @@
+// Implementation of the PWM module interface for the kernel's PWM sysfs interface, /sys/class/pwm. Each PWM chip has
+// a directory pwmchipN, and writing a channel number to its export file makes a directory pwmM for that channel, with
+// the files period, duty_cycle, polarity and enable. Times are in nanoseconds.
+
+package hwio
+
+// References:
+// - https://www.kernel.org/doc/html/latest/driver-api/pwm.html
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A PWM output: a channel of a PWM chip.
+type DTPWMModulePinDef struct {
+	// Directory of the chip, e.g. "/sys/class/pwm/pwmchip0". This can be a glob pattern, as chips are numbered in the
+	// order the kernel finds them, so a chip is better found by the device it belongs to. The first match is used.
+	Chip string
+
+	// The chip's channel that drives the pin.
+	Channel int
+}
+
+type DTPWMModulePinDefMap map[Pin]*DTPWMModulePinDef
+
+type DTPWMModule struct {
+	sync.Mutex
+
+	name        string
+	definedPins DTPWMModulePinDefMap
+	openPins    map[Pin]*dtPWMOpenPin
+}
+
+// An exported channel.
+type dtPWMOpenPin struct {
+	chip string
+	dir  string
+
+	period   int64
+	duty     int64
+	polarity PWMPolarity
+
+	// If the pin has been enabled. The kernel won't run a channel that has no period, so it isn't started until the
+	// period is set.
+	enabled bool
+	running bool
+}
+
+// How long to wait for udev to give access to the files of a channel that has just been exported.
+const dtPWMExportTimeout = time.Second
+
+func NewDTPWMModule(name string) *DTPWMModule {
+	return &DTPWMModule{name: name, openPins: make(map[Pin]*dtPWMOpenPin)}
+}
+
+// Set options of the module. Parameters we look for include:
+// - "pins" - a DTPWMModulePinDefMap of the pins the module can use, and their chips and channels.
+func (module *DTPWMModule) SetOptions(options map[string]interface{}) error {
+	v := options["pins"]
+	if v == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.definedPins = v.(DTPWMModulePinDefMap)
+	return nil
+}
+
+// Enable the module. Pins are only assigned and exported when they are enabled with EnablePin.
+func (module *DTPWMModule) Enable() error {
+	return nil
+}
+
+// Stop and unexport the channels that have been enabled, and release their pins.
+func (module *DTPWMModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	var result error
+	for pin, openPin := range module.openPins {
+		e := openPin.close()
+		if e != nil && result == nil {
+			result = pinError("Disable", pin, module, e)
+		}
+		unassignPin(pin, module)
+	}
+	module.openPins = make(map[Pin]*dtPWMOpenPin)
+	return result
+}
+
+func (module *DTPWMModule) GetName() string {
+	return module.name
+}
+
+// Enable or disable output on a pin. The first time a pin is enabled, it is assigned to the module and its channel is
+// exported, with normal polarity, so that the duty time is the time the output is HIGH. Output starts once the period
+// has been set. Where two pins share a channel, only one of them can be enabled until the module is disabled.
+func (module *DTPWMModule) EnablePin(pin Pin, enabled bool) error {
+	module.Lock()
+	defer module.Unlock()
+
+	def := module.definedPins[pin]
+	if def == nil {
+		return pinError("EnablePin", pin, module, ErrUnknownPin)
+	}
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		if !enabled {
+			return nil
+		}
+
+		// a channel that can be routed to more than one pin can only drive one of them
+		for other := range module.openPins {
+			otherDef := module.definedPins[other]
+			if otherDef.Chip == def.Chip && otherDef.Channel == def.Channel {
+				return pinError("EnablePin", pin, module, fmt.Errorf("%w: PWM channel %d is used by pin %d", ErrPinInUse, def.Channel, other))
+			}
+		}
+
+		e := AssignPin(pin, module)
+		if e != nil {
+			return e
+		}
+		openPin, e = openDTPWMPin(def)
+		if e != nil {
+			unassignPin(pin, module)
+			return pinError("EnablePin", pin, module, e)
+		}
+		module.openPins[pin] = openPin
+	}
+
+	openPin.enabled = enabled
+	e := openPin.update()
+	if e != nil {
+		return pinError("EnablePin", pin, module, e)
+	}
+	return nil
+}
+
+// Set the period of this pin, in nanoseconds
+func (module *DTPWMModule) SetPeriod(pin Pin, ns int64) error {
+	return module.setTiming("SetPeriod", pin, func(op *dtPWMOpenPin) (int64, int64, error) {
+		return ns, op.duty, nil
+	})
+}
+
+// Set the period of this pin, in nanoseconds, scaling the duty time to keep the duty cycle.
+func (module *DTPWMModule) SetPeriodKeepDutyCycle(pin Pin, ns int64) error {
+	return module.setTiming("SetPeriodKeepDutyCycle", pin, func(op *dtPWMOpenPin) (int64, int64, error) {
+		return ns, scalePWMDuty(op.duty, op.period, ns), nil
+	})
+}
+
+// Set the duty time, the amount of time during each period that that output is HIGH.
+func (module *DTPWMModule) SetDuty(pin Pin, ns int64) error {
+	return module.setTiming("SetDuty", pin, func(op *dtPWMOpenPin) (int64, int64, error) {
+		return op.period, ns, nil
+	})
+}
+
+// Set the duty time as a fraction of the period.
+func (module *DTPWMModule) SetDutyCycle(pin Pin, fraction float64) error {
+	return module.setTiming("SetDutyCycle", pin, func(op *dtPWMOpenPin) (int64, int64, error) {
+		duty, e := pwmDutyCycle(op.period, fraction)
+		return op.period, duty, e
+	})
+}
+
+// Change the period and duty time of a pin to those given by timing, which gets the current settings.
+func (module *DTPWMModule) setTiming(op string, pin Pin, timing func(op *dtPWMOpenPin) (int64, int64, error)) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notEnabledError(op, pin, module)
+	}
+
+	period, duty, e := timing(openPin)
+	if e == nil {
+		e = openPin.setTiming(period, duty)
+	}
+	if e != nil {
+		return pinError(op, pin, module, e)
+	}
+	return nil
+}
+
+// Set whether the output is HIGH or LOW for the duty time. The channel is stopped while it is changed.
+func (module *DTPWMModule) SetPolarity(pin Pin, polarity PWMPolarity) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notEnabledError("SetPolarity", pin, module)
+	}
+
+	e := openPin.setPolarity(polarity)
+	if e != nil {
+		return pinError("SetPolarity", pin, module, e)
+	}
+	return nil
+}
+
+func (module *DTPWMModule) IsPinEnabled(pin Pin) (bool, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.definedPins[pin] == nil {
+		return false, pinError("IsPinEnabled", pin, module, ErrUnknownPin)
+	}
+	openPin := module.openPins[pin]
+	return openPin != nil && openPin.enabled, nil
+}
+
+func (module *DTPWMModule) GetPeriod(pin Pin) (int64, error) {
+	openPin, e := module.getOpenPin("GetPeriod", pin)
+	if e != nil {
+		return 0, e
+	}
+	return openPin.period, nil
+}
+
+func (module *DTPWMModule) GetDuty(pin Pin) (int64, error) {
+	openPin, e := module.getOpenPin("GetDuty", pin)
+	if e != nil {
+		return 0, e
+	}
+	return openPin.duty, nil
+}
+
+func (module *DTPWMModule) GetPolarity(pin Pin) (PWMPolarity, error) {
+	openPin, e := module.getOpenPin("GetPolarity", pin)
+	if e != nil {
+		return PWM_POLARITY_NORMAL, e
+	}
+	return openPin.polarity, nil
+}
+
+// Get a copy of the settings of a pin that has been enabled.
+func (module *DTPWMModule) getOpenPin(op string, pin Pin) (dtPWMOpenPin, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return dtPWMOpenPin{}, notEnabledError(op, pin, module)
+	}
+	return *openPin, nil
+}
+
+// Export the channel of a pin, if it hasn't been already, and read its settings.
+func openDTPWMPin(def *DTPWMModulePinDef) (*dtPWMOpenPin, error) {
+	chip, e := findFirstMatchingFile(def.Chip)
+	if e != nil {
+		return nil, e
+	}
+	if chip == "" {
+		return nil, fmt.Errorf("PWM chip %s: %w", def.Chip, os.ErrNotExist)
+	}
+
+	result := &dtPWMOpenPin{chip: chip, dir: filepath.Join(chip, fmt.Sprintf("pwm%d", def.Channel))}
+
+	if _, e = os.Stat(result.dir); os.IsNotExist(e) {
+		e = WriteStringToFile(filepath.Join(chip, "export"), strconv.Itoa(def.Channel))
+		if e != nil {
+			return nil, e
+		}
+		e = waitForDTPWMChannel(result.dir)
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	result.period, e = result.read("period")
+	if e == nil {
+		result.duty, e = result.read("duty_cycle")
+	}
+	if e == nil {
+		result.running, e = result.readBool("enable")
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	polarity, e := os.ReadFile(filepath.Join(result.dir, "polarity"))
+	if e != nil {
+		return nil, e
+	}
+	if strings.TrimSpace(string(polarity)) != "normal" {
+		// polarity can only be changed while the channel is stopped
+		e = result.run(false)
+		if e == nil {
+			e = WriteStringToFile(filepath.Join(result.dir, "polarity"), "normal")
+		}
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	return result, nil
+}
+
+// Wait for the files of a channel that has just been exported to be writable. The kernel makes them straight away, but
+// on systems where a udev rule gives access to them, that happens a moment later.
+func waitForDTPWMChannel(dir string) error {
+	deadline := time.Now().Add(dtPWMExportTimeout)
+	for {
+		e := syscall.Access(filepath.Join(dir, "enable"), 2) // W_OK
+		if e == nil || time.Now().After(deadline) {
+			return e
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Write a new period and duty time. The kernel won't take a duty time longer than the period, so when both change,
+// they are written in the order that keeps the duty time within the period.
+func (op *dtPWMOpenPin) setTiming(period int64, duty int64) error {
+	e := checkPWMDuty(duty, period)
+	if e != nil {
+		return e
+	}
+
+	if duty > op.period {
+		e = op.write("period", period)
+		if e == nil {
+			op.period = period
+			e = op.write("duty_cycle", duty)
+		}
+	} else {
+		e = op.write("duty_cycle", duty)
+		if e == nil {
+			op.duty = duty
+			e = op.write("period", period)
+		}
+	}
+	if e != nil {
+		return e
+	}
+	op.period = period
+	op.duty = duty
+	return op.update()
+}
+
+func (op *dtPWMOpenPin) setPolarity(polarity PWMPolarity) error {
+	name, e := pwmPolarityName(polarity, "normal", "inversed")
+	if e != nil || polarity == op.polarity {
+		return e
+	}
+
+	e = op.run(false)
+	if e == nil {
+		e = WriteStringToFile(filepath.Join(op.dir, "polarity"), name)
+	}
+	if e != nil {
+		return e
+	}
+	op.polarity = polarity
+	return op.update()
+}
+
+// Start or stop the channel to match what has been asked for.
+func (op *dtPWMOpenPin) update() error {
+	return op.run(op.enabled && op.period > 0)
+}
+
+func (op *dtPWMOpenPin) run(running bool) error {
+	if running == op.running {
+		return nil
+	}
+	value := "0"
+	if running {
+		value = "1"
+	}
+	e := WriteStringToFile(filepath.Join(op.dir, "enable"), value)
+	if e != nil {
+		return e
+	}
+	op.running = running
+	return nil
+}
+
+// Stop and unexport the channel.
+func (op *dtPWMOpenPin) close() error {
+	e := op.run(false)
+	if e != nil {
+		return e
+	}
+	return WriteStringToFile(filepath.Join(op.chip, "unexport"), strings.TrimPrefix(filepath.Base(op.dir), "pwm"))
+}
+
+func (op *dtPWMOpenPin) write(name string, ns int64) error {
+	return WriteStringToFile(filepath.Join(op.dir, name), strconv.FormatInt(ns, 10))
+}
+
+func (op *dtPWMOpenPin) read(name string) (int64, error) {
+	return readPWMFile(filepath.Join(op.dir, name))
+}
+
+func (op *dtPWMOpenPin) readBool(name string) (bool, error) {
+	v, e := op.read(name)
+	return v != 0, e
+}
@@ -0,0 +1,183 @@
+package hwio
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Make a directory like /sys/class/pwm/pwmchip0, with channel 0 already exported and inverted, and return its path.
+func newTestPWMChip(t *testing.T) string {
+	chip := filepath.Join(t.TempDir(), "pwmchip0")
+	os.Mkdir(chip, 0755)
+	os.WriteFile(filepath.Join(chip, "export"), nil, 0644)
+	os.WriteFile(filepath.Join(chip, "unexport"), nil, 0644)
+	makeTestPWMChannel(chip, 0, "inversed")
+	return chip
+}
+
+// Make the directory of an exported channel, which is stopped.
+func makeTestPWMChannel(chip string, channel int, polarity string) {
+	dir := filepath.Join(chip, "pwm"+strconv.Itoa(channel))
+	os.Mkdir(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "period"), []byte("0\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "duty_cycle"), []byte("0\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "polarity"), []byte(polarity+"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "enable"), []byte("0\n"), 0644)
+}
+
+func readTestFile(t *testing.T, path string) string {
+	b, e := os.ReadFile(path)
+	if e != nil {
+		t.Fatalf("Could not read %s: %s", path, e)
+	}
+	return string(b)
+}
+
+func TestDTPWMModule(t *testing.T) {
+	SetDriver(new(TestDriver))
+	chip := newTestPWMChip(t)
+
+	var pwm PWMModule = NewDTPWMModule("pwm")
+	e := pwm.SetOptions(map[string]interface{}{"pins": DTPWMModulePinDefMap{
+		0: {Chip: filepath.Join(filepath.Dir(chip), "pwmchip*"), Channel: 0},
+		1: {Chip: chip, Channel: 1},
+		2: {Chip: filepath.Join(chip, "missing"), Channel: 0},
+	}})
+	if e != nil {
+		t.Fatalf("SetOptions returned an unexpected error: %s", e)
+	}
+	pwm.Enable()
+	defer pwm.Disable()
+
+	if e = pwm.SetPeriod(0, 1000000); !errors.Is(e, ErrNotOpen) {
+		t.Errorf("Expected ErrNotOpen setting the period of a pin that isn't enabled, got %v", e)
+	}
+	if e = pwm.EnablePin(3, true); !errors.Is(e, ErrUnknownPin) {
+		t.Errorf("Expected ErrUnknownPin enabling a pin that isn't PWM, got %v", e)
+	}
+
+	// channel 0 is already exported, so it is used as it is, with its polarity set back to normal
+	if e = pwm.EnablePin(0, true); e != nil {
+		t.Fatalf("EnablePin returned an unexpected error: %s", e)
+	}
+	if e = PinMode(0, OUTPUT); !errors.Is(e, ErrPinInUse) {
+		t.Errorf("Expected the PWM pin to be assigned, got %v", e)
+	}
+	dir := filepath.Join(chip, "pwm0")
+	if s := readTestFile(t, filepath.Join(dir, "polarity")); s != "normal" {
+		t.Errorf("Expected the polarity to be set to normal, got %q", s)
+	}
+	if s := readTestFile(t, filepath.Join(dir, "enable")); s != "0\n" {
+		t.Errorf("Expected the channel not to start until it has a period, got enable %q", s)
+	}
+
+	pwm.SetPeriod(0, 1000000)
+	pwm.SetDuty(0, 250000)
+	if readTestFile(t, filepath.Join(dir, "period")) != "1000000" || readTestFile(t, filepath.Join(dir, "duty_cycle")) != "250000" {
+		t.Errorf("Expected the period and duty cycle to be written")
+	}
+	if s := readTestFile(t, filepath.Join(dir, "enable")); s != "1" {
+		t.Errorf("Expected the channel to start once it has a period, got enable %q", s)
+	}
+	var dutyError *PWMDutyError
+	if e = pwm.SetPeriod(0, 200000); !errors.As(e, &dutyError) || readTestFile(t, filepath.Join(dir, "period")) != "1000000" {
+		t.Errorf("Expected a PWMDutyError for a period shorter than the duty time, without writing it, got %v", e)
+	}
+	if e = pwm.SetPeriodKeepDutyCycle(0, 200000); e != nil {
+		t.Errorf("SetPeriodKeepDutyCycle returned an unexpected error: %s", e)
+	}
+	if readTestFile(t, filepath.Join(dir, "period")) != "200000" || readTestFile(t, filepath.Join(dir, "duty_cycle")) != "50000" {
+		t.Errorf("Expected the duty cycle to be kept at a quarter of the new period")
+	}
+	pwm.SetDutyCycle(0, 0.5)
+	if duty, e := pwm.GetDuty(0); e != nil || duty != 100000 || readTestFile(t, filepath.Join(dir, "duty_cycle")) != "100000" {
+		t.Errorf("Expected half the period, got %d, %v", duty, e)
+	}
+
+	// the channel is stopped while the polarity changes, then started again
+	if e = pwm.SetPolarity(0, PWM_POLARITY_INVERSED); e != nil {
+		t.Errorf("SetPolarity returned an unexpected error: %s", e)
+	}
+	if readTestFile(t, filepath.Join(dir, "polarity")) != "inversed" || readTestFile(t, filepath.Join(dir, "enable")) != "1" {
+		t.Errorf("Expected the polarity to be inversed, with the channel running")
+	}
+
+	pwm.EnablePin(0, false)
+	if s := readTestFile(t, filepath.Join(dir, "enable")); s != "0" {
+		t.Errorf("Expected the channel to stop, got enable %q", s)
+	}
+	if enabled, e := pwm.IsPinEnabled(0); e != nil || enabled {
+		t.Errorf("Expected IsPinEnabled to report the pin disabled, got %v, %v", enabled, e)
+	}
+
+	// channel 1 is exported, and appears a moment later
+	time.AfterFunc(20*time.Millisecond, func() { makeTestPWMChannel(chip, 1, "normal") })
+	if e = pwm.EnablePin(1, true); e != nil {
+		t.Fatalf("EnablePin returned an unexpected error: %s", e)
+	}
+	if s := readTestFile(t, filepath.Join(chip, "export")); s != "1" {
+		t.Errorf("Expected channel 1 to be exported, got %q", s)
+	}
+
+	if e = pwm.EnablePin(2, true); !errors.Is(e, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist for a chip that isn't there, got %v", e)
+	}
+	if e = PinMode(2, OUTPUT); e != nil {
+		t.Errorf("Expected the pin to be released when EnablePin fails, got %v", e)
+	}
+	ClosePin(2)
+
+	pwm.SetPeriod(1, 20000000)
+	if e = pwm.Disable(); e != nil {
+		t.Errorf("Disable returned an unexpected error: %s", e)
+	}
+	if s := readTestFile(t, filepath.Join(chip, "pwm1", "enable")); s != "0" {
+		t.Errorf("Expected Disable to stop the channels, got enable %q", s)
+	}
+	if s := readTestFile(t, filepath.Join(chip, "unexport")); s != "0" && s != "1" {
+		t.Errorf("Expected Disable to unexport the channels, got %q", s)
+	}
+	if e = PinMode(1, OUTPUT); e != nil {
+		t.Errorf("Expected Disable to release the pins, got %v", e)
+	}
+}
+
+func TestDTPWMChannelConflict(t *testing.T) {
+	SetDriver(new(TestDriver))
+	chip := newTestPWMChip(t)
+
+	// pins 0 and 1 can both be driven by channel 0
+	pwm := NewDTPWMModule("pwm")
+	pwm.SetOptions(map[string]interface{}{"pins": DTPWMModulePinDefMap{
+		0: {Chip: chip, Channel: 0},
+		1: {Chip: chip, Channel: 0},
+	}})
+	pwm.Enable()
+	defer pwm.Disable()
+
+	if e := pwm.EnablePin(0, true); e != nil {
+		t.Fatalf("EnablePin returned an unexpected error: %s", e)
+	}
+	var pinError *PinError
+	if e := pwm.EnablePin(1, true); !errors.As(e, &pinError) || pinError.Pin != 1 || !errors.Is(e, ErrPinInUse) {
+		t.Errorf("Expected ErrPinInUse enabling a second pin on the same channel, got %v", e)
+	}
+	if e := PinMode(1, OUTPUT); e != nil {
+		t.Errorf("Expected the second pin to be left for GPIO, got %v", e)
+	}
+	ClosePin(1)
+
+	// disabling output doesn't free the channel, but disabling the module does
+	pwm.EnablePin(0, false)
+	if e := pwm.EnablePin(1, true); !errors.Is(e, ErrPinInUse) {
+		t.Errorf("Expected the channel to stay with the first pin, got %v", e)
+	}
+	pwm.Disable()
+	if e := pwm.EnablePin(1, true); e != nil {
+		t.Errorf("Expected the second pin to get the channel once the module was disabled, got %v", e)
+	}
+}
@@ -0,0 +1,350 @@
+// Implementation of the serial module interface for serial ports on Linux, such as /dev/ttyS0, /dev/ttyAMA0 and
+// /dev/ttyO1, which are configured with termios.
+
+package hwio
+
+// references:
+// - man 3 termios
+// - asm-generic/termbits.h
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// A list of the pins that are allocated when the port is enabled, e.g. TX and RX, and RTS and CTS if the port has
+// them. As with I2C, the module doesn't need to know what each of them is.
+type DTSerialModulePins []Pin
+
+type DTSerialModule struct {
+	sync.Mutex
+
+	name        string
+	deviceFile  string
+	definedPins DTSerialModulePins
+
+	config SerialConfig
+
+	// The open port while the module is enabled
+	file *os.File
+}
+
+// Constants for termios that are missing from the syscall package, from asm-generic/termbits.h
+const (
+	CBAUD   = 0x0000100f
+	CRTSCTS = 0x80000000
+)
+
+// The baud rates the kernel supports, and their termios speed values.
+var serialBaudRates = map[int]uint32{
+	50:      syscall.B50,
+	75:      syscall.B75,
+	110:     syscall.B110,
+	134:     syscall.B134,
+	150:     syscall.B150,
+	200:     syscall.B200,
+	300:     syscall.B300,
+	600:     syscall.B600,
+	1200:    syscall.B1200,
+	1800:    syscall.B1800,
+	2400:    syscall.B2400,
+	4800:    syscall.B4800,
+	9600:    syscall.B9600,
+	19200:   syscall.B19200,
+	38400:   syscall.B38400,
+	57600:   syscall.B57600,
+	115200:  syscall.B115200,
+	230400:  syscall.B230400,
+	460800:  syscall.B460800,
+	500000:  syscall.B500000,
+	576000:  syscall.B576000,
+	921600:  syscall.B921600,
+	1000000: syscall.B1000000,
+	1152000: syscall.B1152000,
+	1500000: syscall.B1500000,
+	2000000: syscall.B2000000,
+	2500000: syscall.B2500000,
+	3000000: syscall.B3000000,
+	3500000: syscall.B3500000,
+	4000000: syscall.B4000000,
+}
+
+func NewDTSerialModule(name string) *DTSerialModule {
+	return &DTSerialModule{name: name}
+}
+
+// Accept options for the serial module. Expected options include:
+// - "device" - a string with the device file of the port, e.g. "/dev/ttyS0".
+// - "pins" - a DTSerialModulePins of the pins that are assigned when the module is enabled.
+func (module *DTSerialModule) SetOptions(options map[string]interface{}) error {
+	vd := options["device"]
+	if vd == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'device' value", module.GetName())
+	}
+
+	module.deviceFile = vd.(string)
+
+	vp := options["pins"]
+	if vp == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.definedPins = vp.(DTSerialModulePins)
+
+	return nil
+}
+
+// Enable the port, assigning its pins and opening the device file with the current settings.
+func (module *DTSerialModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.file != nil {
+		return nil
+	}
+
+	e := AssignPins(PinList(module.definedPins), module)
+	if e != nil {
+		return e
+	}
+
+	// Opened without blocking, as opening a port that isn't set to ignore the modem lines waits for carrier detect.
+	// It is made blocking again once CLOCAL is set.
+	fd, e := syscall.Open(module.deviceFile, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if e == nil {
+		e = module.applyConfig(fd, module.config)
+		if e == nil {
+			e = syscall.SetNonblock(fd, false)
+		}
+		if e != nil {
+			syscall.Close(fd)
+		}
+	}
+	if e != nil {
+		for _, pin := range module.definedPins {
+			unassignPin(pin, module)
+		}
+		return module.serialError("Enable", e)
+	}
+
+	module.file = os.NewFile(uintptr(fd), module.deviceFile)
+	return nil
+}
+
+// Disable the port, closing the device file and releasing the pins.
+func (module *DTSerialModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.file == nil {
+		return nil
+	}
+
+	e := module.file.Close()
+	module.file = nil
+	for _, pin := range module.definedPins {
+		unassignPin(pin, module)
+	}
+
+	if e != nil {
+		return module.serialError("Close", e)
+	}
+	return nil
+}
+
+func (module *DTSerialModule) GetName() string {
+	return module.name
+}
+
+func (module *DTSerialModule) Configure(config SerialConfig) error {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.file != nil {
+		e := module.applyConfig(int(module.file.Fd()), config)
+		if e != nil {
+			return module.serialError("Configure", e)
+		}
+	} else {
+		// check the settings now, rather than failing when the port is enabled
+		_, e := serialTermios(syscall.Termios{}, config)
+		if e != nil {
+			return module.serialError("Configure", e)
+		}
+	}
+	module.config = config
+	return nil
+}
+
+// Set the termios settings of an open port from a config.
+func (module *DTSerialModule) applyConfig(fd int, config SerialConfig) error {
+	var t syscall.Termios
+	e := serialIoctl(fd, syscall.TCGETS, &t)
+	if e != nil {
+		return e
+	}
+	t, e = serialTermios(t, config)
+	if e != nil {
+		return e
+	}
+	return serialIoctl(fd, syscall.TCSETS, &t)
+}
+
+func serialIoctl(fd int, request uintptr, t *syscall.Termios) error {
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(unsafe.Pointer(t)))
+	if err != 0 {
+		return err
+	}
+	return nil
+}
+
+// Change termios settings to those of a config. The port is put in raw mode, so that bytes are passed through as they
+// are, as cfmakeraw does.
+func serialTermios(t syscall.Termios, config SerialConfig) (syscall.Termios, error) {
+	baud := config.BaudRate
+	if baud == 0 {
+		baud = 9600
+	}
+	speed, ok := serialBaudRates[baud]
+	if !ok {
+		return t, fmt.Errorf("a baud rate of %d is %w", baud, ErrUnsupported)
+	}
+
+	dataBits := config.DataBits
+	if dataBits == 0 {
+		dataBits = 8
+	}
+	sizes := map[int]uint32{5: syscall.CS5, 6: syscall.CS6, 7: syscall.CS7, 8: syscall.CS8}
+	size, ok := sizes[dataBits]
+	if !ok {
+		return t, fmt.Errorf("%d data bits is %w", dataBits, ErrUnsupported)
+	}
+
+	if config.StopBits < 0 || config.StopBits > 2 {
+		return t, fmt.Errorf("%d stop bits is %w", config.StopBits, ErrUnsupported)
+	}
+
+	timeout := (config.ReadTimeout + 100*time.Millisecond - 1) / (100 * time.Millisecond)
+	if config.ReadTimeout < 0 || timeout > 255 {
+		return t, fmt.Errorf("a read timeout of %s is %w, the limit is 25.5s", config.ReadTimeout, ErrUnsupported)
+	}
+
+	// raw mode
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR |
+		syscall.ICRNL | syscall.IXON | syscall.IXOFF | syscall.IXANY | syscall.INPCK
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= CBAUD | syscall.CSIZE | syscall.CSTOPB | syscall.PARENB | syscall.PARODD | CRTSCTS
+
+	t.Cflag |= speed | size | syscall.CREAD | syscall.CLOCAL
+
+	if config.StopBits == 2 {
+		t.Cflag |= syscall.CSTOPB
+	}
+
+	switch config.Parity {
+	case PARITY_NONE:
+	case PARITY_ODD:
+		t.Cflag |= syscall.PARENB | syscall.PARODD
+		t.Iflag |= syscall.INPCK
+	case PARITY_EVEN:
+		t.Cflag |= syscall.PARENB
+		t.Iflag |= syscall.INPCK
+	default:
+		return t, fmt.Errorf("parity %d is %w", config.Parity, ErrUnsupported)
+	}
+
+	switch config.FlowControl {
+	case FLOW_NONE:
+	case FLOW_HARDWARE:
+		t.Cflag |= CRTSCTS
+	case FLOW_SOFTWARE:
+		t.Iflag |= syscall.IXON | syscall.IXOFF
+	default:
+		return t, fmt.Errorf("flow control %d is %w", config.FlowControl, ErrUnsupported)
+	}
+
+	// with VMIN 1 a read waits for at least one byte. With VMIN 0 and VTIME set, it returns what has arrived, or
+	// nothing if the time runs out first.
+	if timeout > 0 {
+		t.Cc[syscall.VMIN] = 0
+		t.Cc[syscall.VTIME] = uint8(timeout)
+	} else {
+		t.Cc[syscall.VMIN] = 1
+		t.Cc[syscall.VTIME] = 0
+	}
+
+	return t, nil
+}
+
+// Get the open port, or an error if the module isn't enabled.
+func (module *DTSerialModule) port(op string) (*os.File, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.file == nil {
+		return nil, module.serialError(op, ErrClosed)
+	}
+	return module.file, nil
+}
+
+// Read the bytes that have arrived, waiting for at least one, or until the read timeout if there is one. The port
+// isn't locked while waiting, so it can be written from another goroutine at the same time.
+func (module *DTSerialModule) Read(b []byte) (int, error) {
+	file, e := module.port("Read")
+	if e != nil {
+		return 0, e
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	n, e := file.Read(b)
+	if e == io.EOF {
+		// with CLOCAL set, a read only returns nothing when the timeout runs out
+		return 0, module.serialError("Read", os.ErrDeadlineExceeded)
+	}
+	if e != nil {
+		return n, module.serialError("Read", e)
+	}
+	return n, nil
+}
+
+// Write bytes to the port. This returns once they have been queued, which may be before they have been sent.
+func (module *DTSerialModule) Write(b []byte) (int, error) {
+	file, e := module.port("Write")
+	if e != nil {
+		return 0, e
+	}
+
+	n, e := file.Write(b)
+	if e != nil {
+		return n, module.serialError("Write", e)
+	}
+	return n, nil
+}
+
+// Close the port, as Disable does.
+func (module *DTSerialModule) Close() error {
+	return module.Disable()
+}
+
+func (module *DTSerialModule) serialError(op string, err error) error {
+	return &SerialError{Op: op, Module: module.name, Err: err}
+}
+
+// Choose the device file of a port that has different names on different kernels: the first of the names that exists,
+// or the first name if none do, so that the error from Enable is about the usual name.
+func serialDeviceFile(names ...string) string {
+	for _, name := range names {
+		if _, e := os.Stat(name); e == nil {
+			return name
+		}
+	}
+	return names[0]
+}
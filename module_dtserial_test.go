@@ -0,0 +1,144 @@
+package hwio
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// Open a pseudo-terminal, and return the master end and the name of the slave end, which behaves like a serial port.
+func openTestPty(t *testing.T) (*os.File, string) {
+	master, e := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if e != nil {
+		t.Skipf("Pseudo-terminals are not available: %s", e)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	unlock := int32(0)
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+	if err != 0 {
+		t.Fatalf("Could not unlock the pseudo-terminal: %s", err)
+	}
+	n := uint32(0)
+	_, _, err = syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+	if err != 0 {
+		t.Fatalf("Could not get the pseudo-terminal number: %s", err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestSerialTermios(t *testing.T) {
+	tio, e := serialTermios(syscall.Termios{Cflag: syscall.CS8 | syscall.B9600, Lflag: syscall.ICANON}, SerialConfig{
+		BaudRate: 57600, DataBits: 7, Parity: PARITY_ODD, FlowControl: FLOW_SOFTWARE, ReadTimeout: 250 * time.Millisecond})
+	if e != nil {
+		t.Fatalf("serialTermios returned an unexpected error: %s", e)
+	}
+	if tio.Cflag&(CBAUD|syscall.CSIZE|syscall.PARENB|syscall.PARODD|syscall.CSTOPB) != syscall.B57600|syscall.CS7|syscall.PARENB|syscall.PARODD {
+		t.Errorf("Expected 57600 7O1, got cflag 0x%x", tio.Cflag)
+	}
+	if tio.Iflag&(syscall.IXON|syscall.IXOFF|syscall.INPCK) != syscall.IXON|syscall.IXOFF|syscall.INPCK || tio.Lflag != 0 {
+		t.Errorf("Expected XON/XOFF, parity checking and raw mode, got iflag 0x%x, lflag 0x%x", tio.Iflag, tio.Lflag)
+	}
+	if tio.Cc[syscall.VMIN] != 0 || tio.Cc[syscall.VTIME] != 3 {
+		t.Errorf("Expected a timeout rounded up to 3 tenths of a second, got VMIN %d VTIME %d", tio.Cc[syscall.VMIN], tio.Cc[syscall.VTIME])
+	}
+}
+
+func TestDTSerialModule(t *testing.T) {
+	SetDriver(new(TestDriver))
+	master, device := openTestPty(t)
+
+	var serial SerialModule = NewDTSerialModule("serial")
+	e := serial.SetOptions(map[string]interface{}{"device": device, "pins": DTSerialModulePins{0, 1}})
+	if e != nil {
+		t.Fatalf("SetOptions returned an unexpected error: %s", e)
+	}
+	if _, e = serial.Write([]byte("x")); !errors.Is(e, ErrClosed) {
+		t.Errorf("Expected ErrClosed writing to a port that isn't enabled, got %v", e)
+	}
+
+	e = serial.Configure(SerialConfig{BaudRate: 115200, DataBits: 7, Parity: PARITY_EVEN, StopBits: 2, FlowControl: FLOW_HARDWARE})
+	if e != nil {
+		t.Fatalf("Configure returned an unexpected error: %s", e)
+	}
+	if e = serial.Enable(); e != nil {
+		t.Fatalf("Enable returned an unexpected error: %s", e)
+	}
+	defer serial.Close()
+	if e = PinMode(1, OUTPUT); !errors.Is(e, ErrPinInUse) {
+		t.Errorf("Expected the serial pins to be assigned, got %v", e)
+	}
+
+	var tio syscall.Termios
+	port := serial.(*DTSerialModule).file
+	if e = serialIoctl(int(port.Fd()), syscall.TCGETS, &tio); e != nil {
+		t.Fatalf("TCGETS failed: %s", e)
+	}
+	// a pseudo-terminal always has 8 data bits and no parity, so only the other settings can be checked
+	if tio.Cflag&(CBAUD|syscall.CSTOPB|CRTSCTS) != syscall.B115200|syscall.CSTOPB|CRTSCTS {
+		t.Errorf("Expected 115200 baud, 2 stop bits and RTS/CTS, got cflag 0x%x", tio.Cflag)
+	}
+	if tio.Lflag&syscall.ICANON != 0 || tio.Cc[syscall.VMIN] != 1 {
+		t.Errorf("Expected the port to be in raw mode, waiting for a byte")
+	}
+
+	// bytes go both ways unchanged
+	serial.Write([]byte("hello\n"))
+	b := make([]byte, 16)
+	if n, e := master.Read(b); e != nil || string(b[:n]) != "hello\n" {
+		t.Errorf("Expected to receive what was written to the port, got %q, %v", b[:n], e)
+	}
+	master.Write([]byte{0x00, 0x0d, 0xff})
+	if n, e := serial.Read(b); e != nil || n != 3 || b[0] != 0x00 || b[1] != 0x0d || b[2] != 0xff {
+		t.Errorf("Expected to read the bytes sent to the port, got %x, %v", b[:n], e)
+	}
+
+	e = serial.Configure(SerialConfig{ReadTimeout: 100 * time.Millisecond})
+	if e != nil {
+		t.Fatalf("Configure returned an unexpected error: %s", e)
+	}
+	start := time.Now()
+	if _, e = serial.Read(b); !errors.Is(e, os.ErrDeadlineExceeded) || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Expected the read to time out, got %v after %s", e, time.Since(start))
+	}
+
+	var serialError *SerialError
+	if e = serial.Configure(SerialConfig{BaudRate: 12345}); !errors.As(e, &serialError) || !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for a baud rate the kernel doesn't have, got %v", e)
+	}
+	if e = serial.Configure(SerialConfig{ReadTimeout: time.Minute}); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for a read timeout longer than 25.5s, got %v", e)
+	}
+
+	serial.Close()
+	if e = PinMode(1, OUTPUT); e != nil {
+		t.Errorf("Expected the serial pins to be released, got %v", e)
+	}
+}
+
+func TestDTSerialModulePinConflict(t *testing.T) {
+	SetDriver(new(TestDriver))
+	if e := PinMode(1, OUTPUT); e != nil {
+		t.Fatalf("PinMode returned an unexpected error: %s", e)
+	}
+	defer ClosePin(1)
+
+	serial := NewDTSerialModule("serial")
+	serial.SetOptions(map[string]interface{}{"device": "/dev/null", "pins": DTSerialModulePins{0, 1}})
+	if e := serial.Enable(); !errors.Is(e, ErrPinInUse) {
+		t.Fatalf("Expected ErrPinInUse enabling a port whose pin is in use, got %v", e)
+	}
+
+	// the pin assigned before the conflict is released, and the other module keeps its pin
+	if e := PinMode(0, OUTPUT); e != nil {
+		t.Errorf("Expected the port's other pin to be released, got %v", e)
+	}
+	ClosePin(0)
+	if e := AssignPin(1, serial); !errors.Is(e, ErrPinInUse) {
+		t.Errorf("Expected the pin to stay assigned to the GPIO module, got %v", e)
+	}
+}
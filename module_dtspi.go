@@ -0,0 +1,349 @@
+// Implementation of SPI module interface for systems using device tree, through the kernel's spidev driver.
+
+package hwio
+
+// references:
+// - https://www.kernel.org/doc/Documentation/spi/spidev
+// - linux/spi/spidev.h
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// A list of the pins that are allocated when the bus is enabled: MOSI, MISO, SCLK and the chip selects. As with I2C,
+// the module doesn't need to know what each of them is.
+type DTSPIModulePins []Pin
+
+// The spidev device file for each chip select on the bus, e.g. "/dev/spidev0.0" and "/dev/spidev0.1".
+type DTSPIModuleDevices []string
+
+type DTSPIModule struct {
+	sync.Mutex
+
+	name        string
+	devices     DTSPIModuleDevices
+	definedPins DTSPIModulePins
+
+	enabled bool
+
+	// Device files for the chip selects that have been used, which are opened when they are first needed.
+	files map[int]*os.File
+
+	// Settings that have been applied to each device file. Devices sharing a chip select can have different settings,
+	// so they are applied again when they differ from the device's.
+	applied map[int]SPIConfig
+
+	// The speed of each device file when it was opened, from the device tree, which is used for devices that don't
+	// set MaxSpeedHz.
+	defaultSpeeds map[int]uint32
+
+	// makes ioctl calls on the device files. Replaced in unit tests so the module can be exercised without spidev.
+	ioctlFunc func(file *os.File, request uintptr, arg unsafe.Pointer) error
+}
+
+// The ioctl function used on real hardware.
+func linuxSPIIoctl(file *os.File, request uintptr, arg unsafe.Pointer) error {
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), request, uintptr(arg))
+	if err != 0 {
+		return err
+	}
+	return nil
+}
+
+// A transfer passed to the SPI_IOC_MESSAGE ioctl, from spidev.h
+type spi_ioc_transfer struct {
+	tx_buf           uint64
+	rx_buf           uint64
+	len              uint32
+	speed_hz         uint32
+	delay_usecs      uint16
+	bits_per_word    uint8
+	cs_change        uint8
+	tx_nbits         uint8
+	rx_nbits         uint8
+	word_delay_usecs uint8
+	pad              uint8
+}
+
+// Constants used by ioctl, from spidev.h
+const (
+	// Bits of the mode, after SPI_CPHA and SPI_CPOL
+	SPI_CS_HIGH   = 0x04
+	SPI_LSB_FIRST = 0x08
+	SPI_3WIRE     = 0x10
+	SPI_LOOP      = 0x20
+	SPI_NO_CS     = 0x40
+	SPI_READY     = 0x80
+
+	SPI_IOC_WR_MODE          = 0x40016b01
+	SPI_IOC_WR_BITS_PER_WORD = 0x40016b03
+	SPI_IOC_WR_MAX_SPEED_HZ  = 0x40046b04
+	SPI_IOC_RD_MAX_SPEED_HZ  = 0x80046b04
+
+	// The most segments in one SPI_IOC_MESSAGE, as the size of the transfers has to fit in 14 bits
+	SPI_IOC_MAX_SEGMENTS = 511
+)
+
+// Get the ioctl request for a message of n transfers, SPI_IOC_MESSAGE(n), which has the size of the transfers in bits
+// 16-29.
+func spiIOCMessage(n int) uintptr {
+	return uintptr(0x40006b00 | (n*int(unsafe.Sizeof(spi_ioc_transfer{})))<<16)
+}
+
+func NewDTSPIModule(name string) *DTSPIModule {
+	return &DTSPIModule{
+		name:          name,
+		files:         make(map[int]*os.File),
+		applied:       make(map[int]SPIConfig),
+		defaultSpeeds: make(map[int]uint32),
+		ioctlFunc:     linuxSPIIoctl,
+	}
+}
+
+// Accept options for the SPI module. Expected options include:
+// - "devices" - a DTSPIModuleDevices with the device file of each chip select, so chip select n uses devices[n].
+// - "pins" - a DTSPIModulePins of the pins that are assigned when the module is enabled.
+func (module *DTSPIModule) SetOptions(options map[string]interface{}) error {
+	vd := options["devices"]
+	if vd == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'devices' value", module.GetName())
+	}
+
+	module.devices = vd.(DTSPIModuleDevices)
+
+	vp := options["pins"]
+	if vp == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.definedPins = vp.(DTSPIModulePins)
+
+	return nil
+}
+
+// Enable the bus, assigning its pins. The device files are opened when each chip select is first used.
+func (module *DTSPIModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	e := AssignPins(PinList(module.definedPins), module)
+	if e != nil {
+		return e
+	}
+	module.enabled = true
+
+	return nil
+}
+
+// Disable the bus, closing the device files and releasing the pins.
+func (module *DTSPIModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.enabled {
+		return nil
+	}
+
+	var result error
+	for cs, file := range module.files {
+		if e := file.Close(); e != nil && result == nil {
+			result = e
+		}
+		delete(module.files, cs)
+	}
+
+	for _, pin := range module.definedPins {
+		unassignPin(pin, module)
+	}
+	module.enabled = false
+
+	return result
+}
+
+func (module *DTSPIModule) GetName() string {
+	return module.name
+}
+
+func (module *DTSPIModule) GetDevice(chipSelect int) SPIDevice {
+	return NewDTSPIDevice(module, chipSelect)
+}
+
+// Get the device file for a chip select with the settings of a device applied to it, opening it if this is the first
+// time it is used. The module must be locked.
+func (module *DTSPIModule) file(op string, chipSelect int, config SPIConfig) (*os.File, error) {
+	if !module.enabled {
+		return nil, &SPIError{Op: op, Module: module.name, ChipSelect: chipSelect, Err: ErrClosed}
+	}
+
+	file := module.files[chipSelect]
+	if file == nil {
+		if chipSelect < 0 || chipSelect >= len(module.devices) {
+			return nil, &SPIError{Op: op, Module: module.name, ChipSelect: chipSelect,
+				Err: fmt.Errorf("chip select is %w, the bus has %d", ErrUnsupported, len(module.devices))}
+		}
+
+		var e error
+		file, e = os.OpenFile(module.devices[chipSelect], os.O_RDWR, 0)
+		if e != nil {
+			return nil, &SPIError{Op: op, Module: module.name, ChipSelect: chipSelect, Err: e}
+		}
+
+		// nothing has changed the speed yet, so it is still the default for the device
+		var speed uint32
+		e = module.ioctl(file, chipSelect, SPI_IOC_RD_MAX_SPEED_HZ, unsafe.Pointer(&speed))
+		if e != nil {
+			file.Close()
+			return nil, e
+		}
+		module.defaultSpeeds[chipSelect] = speed
+		module.files[chipSelect] = file
+	} else if module.applied[chipSelect] == config {
+		return file, nil
+	}
+
+	e := module.applyConfig(chipSelect, file, config)
+	if e != nil {
+		// the settings of the file are unknown now, so they are applied again next time
+		delete(module.applied, chipSelect)
+		return nil, e
+	}
+	module.applied[chipSelect] = config
+
+	return file, nil
+}
+
+// Set the mode, word size and speed of a chip select's device file.
+func (module *DTSPIModule) applyConfig(chipSelect int, file *os.File, config SPIConfig) error {
+
+	mode := uint8(config.Mode & (SPI_CPOL | SPI_CPHA))
+	if config.LSBFirst {
+		mode |= SPI_LSB_FIRST
+	}
+	if config.CSHigh {
+		mode |= SPI_CS_HIGH
+	}
+	if config.NoCS {
+		mode |= SPI_NO_CS
+	}
+	e := module.ioctl(file, chipSelect, SPI_IOC_WR_MODE, unsafe.Pointer(&mode))
+	if e != nil {
+		return e
+	}
+
+	bits := uint8(config.BitsPerWord)
+	if bits == 0 {
+		bits = 8
+	}
+	e = module.ioctl(file, chipSelect, SPI_IOC_WR_BITS_PER_WORD, unsafe.Pointer(&bits))
+	if e != nil {
+		return e
+	}
+
+	// The speed is always set, so that a device without one doesn't get the speed of another on the chip select.
+	speed := module.defaultSpeeds[chipSelect]
+	if config.MaxSpeedHz > 0 {
+		speed = uint32(config.MaxSpeedHz)
+	}
+	return module.ioctl(file, chipSelect, SPI_IOC_WR_MAX_SPEED_HZ, unsafe.Pointer(&speed))
+}
+
+func (module *DTSPIModule) ioctl(file *os.File, chipSelect int, request uintptr, data unsafe.Pointer) error {
+	e := module.ioctlFunc(file, request, data)
+	if e != nil {
+		return &SPIError{Op: "Configure", Module: module.name, ChipSelect: chipSelect, Err: e}
+	}
+	return nil
+}
+
+// A device on a spidev bus.
+type DTSPIDevice struct {
+	module     *DTSPIModule
+	chipSelect int
+
+	// settings for the device's transfers, protected by the module's lock
+	config SPIConfig
+}
+
+func NewDTSPIDevice(module *DTSPIModule, chipSelect int) *DTSPIDevice {
+	return &DTSPIDevice{module: module, chipSelect: chipSelect}
+}
+
+// Change the settings for the device. They are applied to the bus at the next transfer, so errors from the kernel
+// about them are returned from that transfer.
+func (device *DTSPIDevice) Configure(config SPIConfig) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	if config.BitsPerWord < 0 || config.BitsPerWord > 32 {
+		return device.spiError("Configure", fmt.Errorf("%d bits per word is %w", config.BitsPerWord, ErrUnsupported))
+	}
+	device.config = config
+	return nil
+}
+
+func (device *DTSPIDevice) Tx(write []byte, read []byte) error {
+	return device.TxSegments([]SPISegment{{Write: write, Read: read}})
+}
+
+// Do the segments as one SPI_IOC_MESSAGE. The kernel limits the total length, to 4096 bytes by default (the bufsiz
+// parameter of the spidev module), the number of segments to SPI_IOC_MAX_SEGMENTS, and the delay after each segment to
+// 65535 microseconds.
+func (device *DTSPIDevice) TxSegments(segments []SPISegment) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	if len(segments) == 0 {
+		return nil
+	}
+	if len(segments) > SPI_IOC_MAX_SEGMENTS {
+		return device.spiError("Tx", fmt.Errorf("a transfer of %d segments is %w, the limit is %d", len(segments), ErrUnsupported, SPI_IOC_MAX_SEGMENTS))
+	}
+
+	transfers := make([]spi_ioc_transfer, len(segments))
+	for i, s := range segments {
+		n, e := spiTransferLength(s.Write, s.Read)
+		if e != nil {
+			return device.spiError("Tx", e)
+		}
+		delay := s.Delay / time.Microsecond
+		if delay > 0xffff {
+			return device.spiError("Tx", fmt.Errorf("a delay of %s is %w, the limit is 65535us", s.Delay, ErrUnsupported))
+		}
+
+		t := &transfers[i]
+		t.len = uint32(n)
+		t.speed_hz = uint32(s.SpeedHz)
+		t.delay_usecs = uint16(delay)
+		if s.CSChange && i < len(segments)-1 {
+			t.cs_change = 1
+		}
+		if len(s.Write) > 0 {
+			t.tx_buf = uint64(uintptr(unsafe.Pointer(&s.Write[0])))
+		}
+		if len(s.Read) > 0 {
+			t.rx_buf = uint64(uintptr(unsafe.Pointer(&s.Read[0])))
+		}
+	}
+
+	file, e := device.module.file("Tx", device.chipSelect, device.config)
+	if e != nil {
+		return e
+	}
+
+	e = device.module.ioctlFunc(file, spiIOCMessage(len(transfers)), unsafe.Pointer(&transfers[0]))
+	runtime.KeepAlive(segments)
+	if e != nil {
+		return device.spiError("Tx", e)
+	}
+	return nil
+}
+
+func (device *DTSPIDevice) spiError(op string, err error) error {
+	return &SPIError{Op: op, Module: device.module.GetName(), ChipSelect: device.chipSelect, Err: err}
+}
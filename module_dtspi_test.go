@@ -0,0 +1,110 @@
+package hwio
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestSPIStructSizes(t *testing.T) {
+	// layout from linux/spi/spidev.h, which the kernel reads directly.
+	if s := unsafe.Sizeof(spi_ioc_transfer{}); s != 32 {
+		t.Errorf("spi_ioc_transfer should be 32 bytes, is %d", s)
+	}
+	if r := spiIOCMessage(1); r != 0x40206b00 {
+		t.Errorf("SPI_IOC_MESSAGE(1) should be 0x40206b00, is 0x%x", r)
+	}
+	if r := spiIOCMessage(2); r != 0x40406b00 {
+		t.Errorf("SPI_IOC_MESSAGE(2) should be 0x40406b00, is 0x%x", r)
+	}
+}
+
+func TestDTSPIModule(t *testing.T) {
+	SetDriver(new(TestDriver))
+
+	var spi SPIModule = NewDTSPIModule("spi")
+	e := spi.SetOptions(map[string]interface{}{
+		"pins":    DTSPIModulePins{0, 1, 2},
+		"devices": DTSPIModuleDevices{filepath.Join(t.TempDir(), "spidev0.0")},
+	})
+	if e != nil {
+		t.Fatalf("SetOptions returned an unexpected error: %s", e)
+	}
+
+	device := spi.GetDevice(0)
+	if e = device.Tx([]byte{1}, nil); !errors.Is(e, ErrClosed) {
+		t.Errorf("Expected ErrClosed writing to a bus that isn't enabled, got %v", e)
+	}
+
+	if e = spi.Enable(); e != nil {
+		t.Fatalf("Enable returned an unexpected error: %s", e)
+	}
+	if e = PinMode(1, OUTPUT); !errors.Is(e, ErrPinInUse) {
+		t.Errorf("Expected the SPI pins to be assigned, got %v", e)
+	}
+
+	if e = spi.GetDevice(1).Tx([]byte{1}, nil); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for a chip select the bus doesn't have, got %v", e)
+	}
+	var spiError *SPIError
+	if e = device.Tx([]byte{1}, nil); !errors.As(e, &spiError) || !errors.Is(e, os.ErrNotExist) {
+		t.Errorf("Expected an SPIError when the device file doesn't exist, got %v", e)
+	}
+	if e = device.Tx(make([]byte, 2), make([]byte, 3)); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for buffers of different lengths, got %v", e)
+	}
+	if e = device.TxSegments([]SPISegment{{Write: []byte{1}, Delay: time.Second}}); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for a delay the kernel can't do, got %v", e)
+	}
+	if e = device.Configure(SPIConfig{BitsPerWord: 64}); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for 64 bit words, got %v", e)
+	}
+
+	spi.Disable()
+	if e = PinMode(1, OUTPUT); e != nil {
+		t.Errorf("Expected the SPI pins to be released, got %v", e)
+	}
+}
+
+func TestDTSPISpeedPerDevice(t *testing.T) {
+	SetDriver(new(TestDriver))
+	path := filepath.Join(t.TempDir(), "spidev0.0")
+	os.WriteFile(path, nil, 0644)
+
+	spi := NewDTSPIModule("spi")
+	spi.SetOptions(map[string]interface{}{"pins": DTSPIModulePins{0}, "devices": DTSPIModuleDevices{path}})
+
+	// a device file whose speed starts at 500kHz, recording the speeds written to it
+	speed := uint32(500000)
+	var written []uint32
+	spi.ioctlFunc = func(file *os.File, request uintptr, arg unsafe.Pointer) error {
+		switch request {
+		case SPI_IOC_RD_MAX_SPEED_HZ:
+			*(*uint32)(arg) = speed
+		case SPI_IOC_WR_MAX_SPEED_HZ:
+			speed = *(*uint32)(arg)
+			written = append(written, speed)
+		}
+		return nil
+	}
+	spi.Enable()
+	defer spi.Disable()
+
+	fast := spi.GetDevice(0)
+	fast.Configure(SPIConfig{MaxSpeedHz: 8000000})
+	slow := spi.GetDevice(0)
+	slow.Configure(SPIConfig{Mode: SPI_MODE_3})
+
+	if e := fast.Tx([]byte{1}, nil); e != nil {
+		t.Fatalf("Tx returned an unexpected error: %s", e)
+	}
+	if e := slow.Tx([]byte{1}, nil); e != nil {
+		t.Fatalf("Tx returned an unexpected error: %s", e)
+	}
+	if len(written) != 2 || written[0] != 8000000 || written[1] != 500000 {
+		t.Errorf("Expected a device without a speed to get the default speed back, speeds written were %v", written)
+	}
+}
@@ -1,14 +1,16 @@
 package hwio
 
 import (
-	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"sync"
 )
 
 // ODroidC1AnalogModule is a module for handling the Odroid C1 analog hardware, which is not generic.
 type ODroidC1AnalogModule struct {
+	sync.Mutex
+
 	name string
 
 	analogInitialised bool
@@ -58,6 +60,9 @@ func (module *ODroidC1AnalogModule) SetOptions(options map[string]interface{}) e
 
 // enable GPIO module. It doesn't allocate any pins immediately.
 func (module *ODroidC1AnalogModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
 	// once-off initialisation of analog
 	if !module.analogInitialised {
 		module.analogInitialised = true
@@ -80,10 +85,13 @@ func (module *ODroidC1AnalogModule) Enable() error {
 
 // disables module and release any pins assigned.
 func (module *ODroidC1AnalogModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
 	// Unassign any pins we may have assigned
 	for pin, _ := range module.definedPins {
 		// attempt to assign this pin for this module.
-		UnassignPin(pin)
+		unassignPin(pin, module)
 	}
 
 	// if there are any open analog pins, close them
@@ -98,9 +106,12 @@ func (module *ODroidC1AnalogModule) GetName() string {
 }
 
 func (module *ODroidC1AnalogModule) AnalogRead(pin Pin) (value int, e error) {
+	module.Lock()
+	defer module.Unlock()
+
 	openPin := module.openPins[pin]
 	if openPin == nil {
-		return 0, errors.New("Pin is being read for analog value but has not been opened. Have you called PinMode?")
+		return 0, pinError("AnalogRead", pin, module, fmt.Errorf("%w, has the module been enabled?", ErrNotOpen))
 	}
 	return openPin.analogGetValue()
 }
@@ -108,7 +119,7 @@ func (module *ODroidC1AnalogModule) AnalogRead(pin Pin) (value int, e error) {
 func (module *ODroidC1AnalogModule) makeOpenAnalogPin(pin Pin) error {
 	p := module.definedPins[pin]
 	if p == nil {
-		return fmt.Errorf("Pin %d is not known to analog module", pin)
+		return pinError("Enable", pin, module, ErrUnknownPin)
 	}
 
 	path := fmt.Sprintf("/sys/class/saradc/saradc_ch%d", p.analogLogical)
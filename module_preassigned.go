@@ -32,7 +32,10 @@ func (module *PreassignedModule) Enable() error {
 }
 
 func (module *PreassignedModule) Disable() error {
-	return UnassignPins(module.pins)
+	for _, pin := range module.pins {
+		unassignPin(pin, module)
+	}
+	return nil
 }
 
 func (module *PreassignedModule) GetName() string {
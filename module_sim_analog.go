@@ -0,0 +1,84 @@
+package hwio
+
+// A simulated analog input module. The values read by AnalogRead are set from tests with MockSetAnalogValue.
+
+import (
+	"fmt"
+	"sync"
+)
+
+type SimAnalogModule struct {
+	sync.Mutex
+
+	name string
+
+	definedPins SimPinMap
+
+	// pins that have been read, which are assigned to this module
+	openPins map[Pin]bool
+
+	values map[Pin]int
+}
+
+func NewSimAnalogModule(name string) *SimAnalogModule {
+	return &SimAnalogModule{name: name, openPins: make(map[Pin]bool), values: make(map[Pin]int)}
+}
+
+// Accept options for the module. The only option is "pins", a SimPinMap of the pins the module can use.
+func (module *SimAnalogModule) SetOptions(options map[string]interface{}) error {
+	v := options["pins"]
+	if v == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.definedPins = v.(SimPinMap)
+	return nil
+}
+
+func (module *SimAnalogModule) Enable() error {
+	return nil
+}
+
+// Release the pins that have been read.
+func (module *SimAnalogModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	for pin := range module.openPins {
+		unassignPin(pin, module)
+	}
+	module.openPins = make(map[Pin]bool)
+	return nil
+}
+
+func (module *SimAnalogModule) GetName() string {
+	return module.name
+}
+
+// Read the simulated value of a pin. As with the BeagleBone analog module, pins are opened on demand.
+func (module *SimAnalogModule) AnalogRead(pin Pin) (int, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.definedPins[pin] == nil {
+		return 0, pinError("AnalogRead", pin, module, ErrUnknownPin)
+	}
+
+	if !module.openPins[pin] {
+		e := AssignPin(pin, module)
+		if e != nil {
+			return 0, e
+		}
+		module.openPins[pin] = true
+	}
+
+	return module.values[pin], nil
+}
+
+// Set the value that AnalogRead returns for a pin.
+func (module *SimAnalogModule) MockSetAnalogValue(pin Pin, value int) {
+	module.Lock()
+	defer module.Unlock()
+
+	module.values[pin] = value
+}
@@ -0,0 +1,280 @@
+package hwio
+
+// A simulated GPIO module. Pin levels are held in memory. Outputs are set by DigitalWrite, and inputs are driven from
+// tests with MockSetPinValue, or by wires from other pins (see SimDriver.Wire).
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type SimGPIOModule struct {
+	sync.Mutex
+
+	name string
+
+	definedPins SimPinMap
+
+	// modes of the pins that have been opened with PinMode
+	pinModes map[Pin]PinIOMode
+
+	// simulated pin levels
+	pinValues map[Pin]int
+
+	// pins being watched for edges. MockSetPinValue delivers events to these.
+	watches map[Pin]*pinWatch
+
+	// functions that are told when a pin's level or mode changes
+	listeners map[Pin][]simPinListener
+}
+
+// Function told about the state of a simulated pin after its level or mode changes. old is the level before the
+// change, and output is true if the pin is open as an output.
+type simPinListener func(pin Pin, old int, level int, output bool)
+
+func NewSimGPIOModule(name string) *SimGPIOModule {
+	result := &SimGPIOModule{name: name}
+	result.pinModes = make(map[Pin]PinIOMode)
+	result.pinValues = make(map[Pin]int)
+	result.watches = make(map[Pin]*pinWatch)
+	result.listeners = make(map[Pin][]simPinListener)
+	return result
+}
+
+// Accept options for the module. The only option is "pins", a SimPinMap of the pins the module can use.
+func (module *SimGPIOModule) SetOptions(options map[string]interface{}) error {
+	v := options["pins"]
+	if v == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.definedPins = v.(SimPinMap)
+	return nil
+}
+
+func (module *SimGPIOModule) Enable() error {
+	return nil
+}
+
+// Close all open pins.
+func (module *SimGPIOModule) Disable() error {
+	module.Lock()
+	pins := PinList{}
+	for pin := range module.pinModes {
+		pins = append(pins, pin)
+	}
+	module.Unlock()
+
+	for _, pin := range pins {
+		module.withListeners(pin, func() error {
+			return module.closePin(pin)
+		})
+	}
+	return nil
+}
+
+func (module *SimGPIOModule) GetName() string {
+	return module.name
+}
+
+func (module *SimGPIOModule) PinMode(pin Pin, mode PinIOMode) error {
+	return module.withListeners(pin, func() error {
+		return module.pinMode(pin, mode)
+	})
+}
+
+func (module *SimGPIOModule) pinMode(pin Pin, mode PinIOMode) error {
+	if module.definedPins[pin] == nil {
+		return pinError("PinMode", pin, module, ErrUnknownPin)
+	}
+
+	if _, ok := module.pinModes[pin]; !ok {
+		e := AssignPin(pin, module)
+		if e != nil {
+			return e
+		}
+	} else if w := module.watches[pin]; w != nil && mode == OUTPUT {
+		w.close()
+		delete(module.watches, pin)
+	}
+
+	module.pinModes[pin] = mode
+	return nil
+}
+
+// Drive a pin LOW as an output, or release it as an input. Both the mode and level change in one step, so listeners
+// never see the pin as an output at another level.
+func (module *SimGPIOModule) SetOpenDrain(pin Pin, value int) error {
+	return module.withListeners(pin, func() error {
+		if _, ok := module.pinModes[pin]; !ok {
+			return notOpenError("SetOpenDrain", pin, module)
+		}
+		if value != LOW {
+			return module.pinMode(pin, INPUT)
+		}
+		e := module.pinMode(pin, OUTPUT)
+		if e == nil {
+			module.pinValues[pin] = LOW
+		}
+		return e
+	})
+}
+
+func (module *SimGPIOModule) DigitalWrite(pin Pin, value int) error {
+	return module.withListeners(pin, func() error {
+		return module.digitalWrite(pin, value)
+	})
+}
+
+func (module *SimGPIOModule) digitalWrite(pin Pin, value int) error {
+	mode, ok := module.pinModes[pin]
+	if !ok {
+		return notOpenError("DigitalWrite", pin, module)
+	}
+	if mode != OUTPUT {
+		return pinError("DigitalWrite", pin, module, fmt.Errorf("%w, only outputs can be written", ErrWrongMode))
+	}
+
+	module.pinValues[pin] = value
+	return nil
+}
+
+func (module *SimGPIOModule) DigitalRead(pin Pin) (int, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	if _, ok := module.pinModes[pin]; !ok {
+		return 0, notOpenError("DigitalRead", pin, module)
+	}
+	return module.pinValues[pin], nil
+}
+
+func (module *SimGPIOModule) ClosePin(pin Pin) error {
+	return module.withListeners(pin, func() error {
+		if _, ok := module.pinModes[pin]; !ok {
+			return notOpenError("ClosePin", pin, module)
+		}
+		return module.closePin(pin)
+	})
+}
+
+func (module *SimGPIOModule) closePin(pin Pin) error {
+	if w := module.watches[pin]; w != nil {
+		w.close()
+		delete(module.watches, pin)
+	}
+	delete(module.pinModes, pin)
+	return unassignPin(pin, module)
+}
+
+func (module *SimGPIOModule) WatchPin(pin Pin, edge Edge) (<-chan PinEvent, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	mode, ok := module.pinModes[pin]
+	if !ok {
+		return nil, notOpenError("WatchPin", pin, module)
+	}
+	if mode == OUTPUT {
+		return nil, pinError("WatchPin", pin, module, fmt.Errorf("%w, only inputs can be watched", ErrWrongMode))
+	}
+	if module.watches[pin] != nil {
+		return nil, pinError("WatchPin", pin, module, fmt.Errorf("%w, it is already being watched", ErrPinBusy))
+	}
+
+	w := newPinWatch(pin, edge)
+	module.watches[pin] = w
+	return w.events, nil
+}
+
+func (module *SimGPIOModule) UnwatchPin(pin Pin) error {
+	module.Lock()
+	defer module.Unlock()
+
+	w := module.watches[pin]
+	if w == nil {
+		return pinError("UnwatchPin", pin, module, ErrNotWatched)
+	}
+	w.close()
+	delete(module.watches, pin)
+	return nil
+}
+
+// Get the mode a pin has been set to. Pins that have not been opened are reported as INPUT.
+func (module *SimGPIOModule) MockGetPinMode(pin Pin) PinIOMode {
+	module.Lock()
+	defer module.Unlock()
+
+	return module.pinModes[pin]
+}
+
+// Get the current level of a pin, whether it was written by DigitalWrite or set by MockSetPinValue.
+func (module *SimGPIOModule) MockGetPinValue(pin Pin) int {
+	module.Lock()
+	defer module.Unlock()
+
+	return module.pinValues[pin]
+}
+
+// Set the value of a pin as if it was driven externally. If the pin is being watched and the change is a watched edge,
+// an event is delivered.
+func (module *SimGPIOModule) MockSetPinValue(pin Pin, value int) {
+	module.withListeners(pin, func() error {
+		old := module.pinValues[pin]
+		module.pinValues[pin] = value
+
+		if w := module.watches[pin]; w != nil && w.edge.matches(old, value) {
+			edge := EDGE_FALLING
+			if value == HIGH {
+				edge = EDGE_RISING
+			}
+			w.send(edge, time.Now())
+		}
+		return nil
+	})
+}
+
+// Call f whenever the level of a pin changes, whether it is written by DigitalWrite, set by MockSetPinValue or
+// driven by a wire. This lets a test simulate a device that reacts to the pin, such as a shift register clocked by it.
+// f is called after the change has been made, from the goroutine that made it, and may use the module.
+func (module *SimGPIOModule) MockOnChange(pin Pin, f func(value int)) {
+	module.addListener(pin, func(pin Pin, old int, level int, output bool) {
+		if level != old {
+			f(level)
+		}
+	})
+}
+
+func (module *SimGPIOModule) addListener(pin Pin, listener simPinListener) {
+	module.Lock()
+	defer module.Unlock()
+
+	module.listeners[pin] = append(module.listeners[pin], listener)
+}
+
+// Get the level of a pin, and whether it is open as an output. The module must be locked.
+func (module *SimGPIOModule) pinState(pin Pin) (level int, output bool) {
+	mode, ok := module.pinModes[pin]
+	return module.pinValues[pin], ok && mode == OUTPUT
+}
+
+// Run f with the module locked, and then, if f changed the level or mode of the pin, tell the pin's listeners.
+// Listeners are called after the module is unlocked, so that they can use it.
+func (module *SimGPIOModule) withListeners(pin Pin, f func() error) error {
+	module.Lock()
+	level, _ := module.pinState(pin)
+	mode, open := module.pinModes[pin]
+	e := f()
+	newLevel, newOutput := module.pinState(pin)
+	newMode, newOpen := module.pinModes[pin]
+	listeners := module.listeners[pin]
+	module.Unlock()
+
+	if newLevel != level || newMode != mode || newOpen != open {
+		for _, listener := range listeners {
+			listener(pin, level, newLevel, newOutput)
+		}
+	}
+	return e
+}
@@ -0,0 +1,378 @@
+package hwio
+
+// A simulated I2C bus. Tests attach devices to the bus at an address with MockAttachDevice; a device is any I2CDevice,
+// which gets the calls made on the devices returned by GetDevice. Calls to an address with nothing attached fail as
+// the kernel does when a device doesn't acknowledge, with syscall.ENXIO.
+//
+// Transactions from Tx are passed to devices that implement I2CTxDevice. For other devices, the transactions that
+// can be expressed with I2CDevice are translated: a write of a register followed by a read is a Read, and a write on
+// its own is a Write of the register with the rest of the bytes. In the same way, SMBus operations from
+// I2CSMBusDevice are passed to devices that implement it, and otherwise translated where they can be: a quick command
+// succeeds if there is a device, SendByte is a Write of the byte with no data, and words are two byte reads and writes
+// with the low byte first.
+//
+// Scan probes the attached devices in the same way as on a real bus, so a device that implements I2CSMBusDevice can
+// refuse the probe to stay hidden. Other devices always answer.
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+type SimI2CModule struct {
+	sync.Mutex
+
+	name string
+
+	definedPins SimPinMap
+
+	enabled bool
+
+	devices map[int]I2CDevice
+}
+
+func NewSimI2CModule(name string) *SimI2CModule {
+	return &SimI2CModule{name: name, devices: make(map[int]I2CDevice)}
+}
+
+// Accept options for the module. The only option is "pins", a SimPinMap of the pins that are assigned when the bus is
+// enabled.
+func (module *SimI2CModule) SetOptions(options map[string]interface{}) error {
+	v := options["pins"]
+	if v == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.definedPins = v.(SimPinMap)
+	return nil
+}
+
+// Enable the bus, assigning its pins.
+func (module *SimI2CModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	for pin := range module.definedPins {
+		e := AssignPin(pin, module)
+		if e != nil {
+			return e
+		}
+	}
+	module.enabled = true
+	return nil
+}
+
+// Disable the bus and release its pins.
+func (module *SimI2CModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.enabled {
+		return nil
+	}
+	for pin := range module.definedPins {
+		unassignPin(pin, module)
+	}
+	module.enabled = false
+	return nil
+}
+
+func (module *SimI2CModule) GetName() string {
+	return module.name
+}
+
+func (module *SimI2CModule) GetDevice(address int) I2CDevice {
+	return &simI2CDevice{module: module, address: address}
+}
+
+// Scan the bus for attached devices. Each address is probed with a quick write or a receive byte, as on a real bus.
+func (module *SimI2CModule) Scan() ([]int, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.enabled {
+		return nil, &I2CError{Op: "Scan", Module: module.name, Err: ErrClosed}
+	}
+
+	result := scanI2CAddresses(func(address int, readByte bool) bool {
+		device := module.devices[address]
+		if device == nil {
+			return false
+		}
+		smbus, ok := device.(I2CSMBusDevice)
+		if !ok {
+			return true
+		}
+		if readByte {
+			_, e := smbus.ReceiveByte()
+			return e == nil
+		}
+		return smbus.QuickCommand(false) == nil
+	})
+	return result, nil
+}
+
+// Attach a simulated device to the bus at an address, replacing any device already there.
+func (module *SimI2CModule) MockAttachDevice(address int, device I2CDevice) {
+	module.Lock()
+	defer module.Unlock()
+
+	module.devices[address] = device
+}
+
+// Remove the device at an address, so that it no longer responds.
+func (module *SimI2CModule) MockDetachDevice(address int) {
+	module.Lock()
+	defer module.Unlock()
+
+	delete(module.devices, address)
+}
+
+// Get the device a transfer is sent to. The bus is locked for the transfer, as the kernel does for a real bus.
+func (module *SimI2CModule) target(op string, address int) (I2CDevice, error) {
+	if !module.enabled {
+		return nil, &I2CError{Op: op, Module: module.name, Address: address, Err: ErrClosed}
+	}
+	device := module.devices[address]
+	if device == nil {
+		return nil, &I2CError{Op: op, Module: module.name, Address: address, Err: syscall.ENXIO}
+	}
+	return device, nil
+}
+
+// Handle for a device on a simulated bus, which passes calls on to the device attached at its address.
+type simI2CDevice struct {
+	module  *SimI2CModule
+	address int
+}
+
+func (device *simI2CDevice) ReadByte(command byte) (byte, error) {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	target, e := device.module.target("ReadByte", device.address)
+	if e != nil {
+		return 0, e
+	}
+	return target.ReadByte(command)
+}
+
+func (device *simI2CDevice) WriteByte(command byte, value byte) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	target, e := device.module.target("WriteByte", device.address)
+	if e != nil {
+		return e
+	}
+	return target.WriteByte(command, value)
+}
+
+func (device *simI2CDevice) Read(command byte, numBytes int) ([]byte, error) {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	target, e := device.module.target("Read", device.address)
+	if e != nil {
+		return nil, e
+	}
+	return target.Read(command, numBytes)
+}
+
+func (device *simI2CDevice) Write(command byte, buffer []byte) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	target, e := device.module.target("Write", device.address)
+	if e != nil {
+		return e
+	}
+	return target.Write(command, buffer)
+}
+
+func (device *simI2CDevice) Tx(messages []I2CMessage) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	target, e := device.module.target("Tx", device.address)
+	if e != nil {
+		return e
+	}
+	if tx, ok := target.(I2CTxDevice); ok {
+		return tx.Tx(messages)
+	}
+
+	for i := 0; i < len(messages); i++ {
+		m := messages[i]
+		if m.Flags&I2C_M_RD != 0 || len(m.Data) == 0 {
+			return device.unsupported("Tx")
+		}
+
+		// a register write followed by a read
+		if i+1 < len(messages) && messages[i+1].Flags&I2C_M_RD != 0 {
+			if len(m.Data) != 1 {
+				return device.unsupported("Tx")
+			}
+			read := messages[i+1].Data
+			result, e := target.Read(m.Data[0], len(read))
+			if e != nil {
+				return e
+			}
+			copy(read, result)
+			i++
+			continue
+		}
+
+		e = target.Write(m.Data[0], m.Data[1:])
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Get the target of an SMBus operation, if it implements I2CSMBusDevice. The module must be locked.
+func (device *simI2CDevice) smbusTarget(op string) (I2CDevice, I2CSMBusDevice, error) {
+	target, e := device.module.target(op, device.address)
+	if e != nil {
+		return nil, nil, e
+	}
+	smbus, _ := target.(I2CSMBusDevice)
+	return target, smbus, nil
+}
+
+func (device *simI2CDevice) QuickCommand(read bool) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	_, smbus, e := device.smbusTarget("QuickCommand")
+	if e != nil || smbus == nil {
+		return e
+	}
+	return smbus.QuickCommand(read)
+}
+
+func (device *simI2CDevice) ReceiveByte() (byte, error) {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	_, smbus, e := device.smbusTarget("ReceiveByte")
+	if e != nil {
+		return 0, e
+	}
+	if smbus == nil {
+		return 0, device.unsupported("ReceiveByte")
+	}
+	return smbus.ReceiveByte()
+}
+
+func (device *simI2CDevice) SendByte(value byte) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	target, smbus, e := device.smbusTarget("SendByte")
+	if e != nil {
+		return e
+	}
+	if smbus == nil {
+		return target.Write(value, nil)
+	}
+	return smbus.SendByte(value)
+}
+
+func (device *simI2CDevice) ReadWord(command byte) (uint16, error) {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	target, smbus, e := device.smbusTarget("ReadWord")
+	if e != nil {
+		return 0, e
+	}
+	if smbus != nil {
+		return smbus.ReadWord(command)
+	}
+
+	b, e := target.Read(command, 2)
+	if e != nil {
+		return 0, e
+	}
+	return uint16(b[1])<<8 | uint16(b[0]), nil
+}
+
+func (device *simI2CDevice) WriteWord(command byte, value uint16) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	target, smbus, e := device.smbusTarget("WriteWord")
+	if e != nil {
+		return e
+	}
+	if smbus != nil {
+		return smbus.WriteWord(command, value)
+	}
+	return target.Write(command, []byte{byte(value), byte(value >> 8)})
+}
+
+func (device *simI2CDevice) ProcessCall(command byte, value uint16) (uint16, error) {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	_, smbus, e := device.smbusTarget("ProcessCall")
+	if e != nil {
+		return 0, e
+	}
+	if smbus == nil {
+		return 0, device.unsupported("ProcessCall")
+	}
+	return smbus.ProcessCall(command, value)
+}
+
+func (device *simI2CDevice) BlockRead(command byte) ([]byte, error) {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	_, smbus, e := device.smbusTarget("BlockRead")
+	if e != nil {
+		return nil, e
+	}
+	if smbus == nil {
+		return nil, device.unsupported("BlockRead")
+	}
+	return smbus.BlockRead(command)
+}
+
+func (device *simI2CDevice) BlockWrite(command byte, data []byte) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	_, smbus, e := device.smbusTarget("BlockWrite")
+	if e != nil {
+		return e
+	}
+	if smbus == nil {
+		return device.unsupported("BlockWrite")
+	}
+	return smbus.BlockWrite(command, data)
+}
+
+func (device *simI2CDevice) BlockProcessCall(command byte, data []byte) ([]byte, error) {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	_, smbus, e := device.smbusTarget("BlockProcessCall")
+	if e != nil {
+		return nil, e
+	}
+	if smbus == nil {
+		return nil, device.unsupported("BlockProcessCall")
+	}
+	return smbus.BlockProcessCall(command, data)
+}
+
+// Error for an operation that can't be passed on to the simulated device, as it doesn't implement the interface the
+// operation is from.
+func (device *simI2CDevice) unsupported(op string) error {
+	return &I2CError{Op: op, Module: device.module.name, Address: device.address,
+		Err: fmt.Errorf("%s is %w by the simulated device", op, ErrUnsupported)}
+}
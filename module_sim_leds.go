@@ -0,0 +1,105 @@
+package hwio
+
+// A simulated LED module. As with DTLEDModule, an LED can only be turned on and off when its trigger is "none". The
+// state of each LED can be inspected from tests.
+
+import (
+	"fmt"
+	"sync"
+)
+
+type (
+	SimLEDModule struct {
+		sync.Mutex
+
+		name string
+
+		leds map[string]*SimLED
+	}
+
+	SimLED struct {
+		sync.Mutex
+
+		trigger string
+		on      bool
+	}
+)
+
+func NewSimLEDModule(name string) *SimLEDModule {
+	return &SimLEDModule{name: name, leds: make(map[string]*SimLED)}
+}
+
+// Accept options for the module. The only option is "leds", a []string of the names of the LEDs.
+func (m *SimLEDModule) SetOptions(options map[string]interface{}) error {
+	v := options["leds"]
+	if v == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'leds' value", m.GetName())
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	for _, led := range v.([]string) {
+		m.leds[led] = &SimLED{}
+	}
+	return nil
+}
+
+func (m *SimLEDModule) Enable() error {
+	return nil
+}
+
+func (m *SimLEDModule) Disable() error {
+	return nil
+}
+
+func (m *SimLEDModule) GetName() string {
+	return m.name
+}
+
+// Get a LED by name. The result is a *SimLED.
+func (m *SimLEDModule) GetLED(led string) (LEDModuleLED, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	result := m.leds[led]
+	if result == nil {
+		return nil, fmt.Errorf("GetLED: invalid led '%s': %w", led, ErrUnknownPin)
+	}
+	return result, nil
+}
+
+func (led *SimLED) SetTrigger(trigger string) error {
+	led.Lock()
+	defer led.Unlock()
+
+	led.trigger = trigger
+	return nil
+}
+
+func (led *SimLED) SetOn(on bool) error {
+	led.Lock()
+	defer led.Unlock()
+
+	if led.trigger != "none" {
+		return fmt.Errorf("LED SetOn requires that the LED trigger has been set to 'none': %w", ErrWrongMode)
+	}
+	led.on = on
+	return nil
+}
+
+// Get the trigger that has been set on the LED.
+func (led *SimLED) MockGetTrigger() string {
+	led.Lock()
+	defer led.Unlock()
+
+	return led.trigger
+}
+
+// Determine if the LED has been turned on.
+func (led *SimLED) MockIsOn() bool {
+	led.Lock()
+	defer led.Unlock()
+
+	return led.on
+}
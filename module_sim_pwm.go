@@ -0,0 +1,226 @@
+package hwio
+
+// A simulated PWM module. The settings of each pin can be inspected from tests with MockGetPWM.
+
+import (
+	"fmt"
+	"sync"
+)
+
+type SimPWMModule struct {
+	sync.Mutex
+
+	name string
+
+	definedPins SimPinMap
+
+	openPins map[Pin]*simPWMPin
+}
+
+// Settings of a simulated PWM pin, in nanoseconds.
+type simPWMPin struct {
+	enabled  bool
+	period   int64
+	duty     int64
+	polarity PWMPolarity
+}
+
+func NewSimPWMModule(name string) *SimPWMModule {
+	return &SimPWMModule{name: name, openPins: make(map[Pin]*simPWMPin)}
+}
+
+// Accept options for the module. The only option is "pins", a SimPinMap of the pins the module can use.
+func (module *SimPWMModule) SetOptions(options map[string]interface{}) error {
+	v := options["pins"]
+	if v == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.definedPins = v.(SimPinMap)
+	return nil
+}
+
+func (module *SimPWMModule) Enable() error {
+	return nil
+}
+
+// Release the pins that have been enabled.
+func (module *SimPWMModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	for pin := range module.openPins {
+		unassignPin(pin, module)
+	}
+	module.openPins = make(map[Pin]*simPWMPin)
+	return nil
+}
+
+func (module *SimPWMModule) GetName() string {
+	return module.name
+}
+
+// Enable or disable output on a pin. The pin is assigned to the module the first time it is enabled.
+func (module *SimPWMModule) EnablePin(pin Pin, enabled bool) error {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.definedPins[pin] == nil {
+		return pinError("EnablePin", pin, module, ErrUnknownPin)
+	}
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		if !enabled {
+			return nil
+		}
+		e := AssignPin(pin, module)
+		if e != nil {
+			return e
+		}
+		openPin = &simPWMPin{}
+		module.openPins[pin] = openPin
+	}
+	openPin.enabled = enabled
+	return nil
+}
+
+// Set the period of this pin, in nanoseconds
+func (module *SimPWMModule) SetPeriod(pin Pin, ns int64) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notEnabledError("SetPeriod", pin, module)
+	}
+	e := checkPWMDuty(openPin.duty, ns)
+	if e != nil {
+		return pinError("SetPeriod", pin, module, e)
+	}
+	openPin.period = ns
+	return nil
+}
+
+// Set the period of this pin, in nanoseconds, scaling the duty time to keep the duty cycle.
+func (module *SimPWMModule) SetPeriodKeepDutyCycle(pin Pin, ns int64) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notEnabledError("SetPeriodKeepDutyCycle", pin, module)
+	}
+	openPin.duty = scalePWMDuty(openPin.duty, openPin.period, ns)
+	openPin.period = ns
+	return nil
+}
+
+// Set the duty time, the amount of time during each period that that output is HIGH.
+func (module *SimPWMModule) SetDuty(pin Pin, ns int64) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notEnabledError("SetDuty", pin, module)
+	}
+	e := checkPWMDuty(ns, openPin.period)
+	if e != nil {
+		return pinError("SetDuty", pin, module, e)
+	}
+	openPin.duty = ns
+	return nil
+}
+
+// Set the duty time as a fraction of the period.
+func (module *SimPWMModule) SetDutyCycle(pin Pin, fraction float64) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notEnabledError("SetDutyCycle", pin, module)
+	}
+	duty, e := pwmDutyCycle(openPin.period, fraction)
+	if e != nil {
+		return pinError("SetDutyCycle", pin, module, e)
+	}
+	openPin.duty = duty
+	return nil
+}
+
+func (module *SimPWMModule) SetPolarity(pin Pin, polarity PWMPolarity) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notEnabledError("SetPolarity", pin, module)
+	}
+	_, e := pwmPolarityName(polarity, "normal", "inversed")
+	if e != nil {
+		return pinError("SetPolarity", pin, module, e)
+	}
+	openPin.polarity = polarity
+	return nil
+}
+
+func (module *SimPWMModule) IsPinEnabled(pin Pin) (bool, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.definedPins[pin] == nil {
+		return false, pinError("IsPinEnabled", pin, module, ErrUnknownPin)
+	}
+	openPin := module.openPins[pin]
+	return openPin != nil && openPin.enabled, nil
+}
+
+func (module *SimPWMModule) GetPeriod(pin Pin) (int64, error) {
+	openPin, e := module.getOpenPin("GetPeriod", pin)
+	if e != nil {
+		return 0, e
+	}
+	return openPin.period, nil
+}
+
+func (module *SimPWMModule) GetDuty(pin Pin) (int64, error) {
+	openPin, e := module.getOpenPin("GetDuty", pin)
+	if e != nil {
+		return 0, e
+	}
+	return openPin.duty, nil
+}
+
+func (module *SimPWMModule) GetPolarity(pin Pin) (PWMPolarity, error) {
+	openPin, e := module.getOpenPin("GetPolarity", pin)
+	if e != nil {
+		return PWM_POLARITY_NORMAL, e
+	}
+	return openPin.polarity, nil
+}
+
+// Get a copy of the settings of a pin that has been enabled.
+func (module *SimPWMModule) getOpenPin(op string, pin Pin) (simPWMPin, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return simPWMPin{}, notEnabledError(op, pin, module)
+	}
+	return *openPin, nil
+}
+
+// Get the settings of a pin. A pin that has never been enabled is reported as disabled with a zero period and duty.
+func (module *SimPWMModule) MockGetPWM(pin Pin) (enabled bool, period int64, duty int64) {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return false, 0, 0
+	}
+	return openPin.enabled, openPin.period, openPin.duty
+}
@@ -0,0 +1,179 @@
+package hwio
+
+// A simulated SPI bus. Everything written to a chip select is recorded, and can be inspected with MockGetWritten, along
+// with the settings of the last transfer (MockGetConfig). Tests can also attach a SimSPIDevice to a chip select with
+// MockAttachDevice, to see each transfer and supply the bytes that are read back. Each segment of a transfer from
+// TxSegments is passed to the device separately. Reads from a chip select with no device return zeros.
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A simulated device on an SPI bus. As SPI is full duplex, each transfer has the bytes sent to the device in write, and
+// the device fills read, which is the same length, with the bytes it sends back.
+type SimSPIDevice interface {
+	Transfer(write []byte, read []byte) error
+}
+
+type SimSPIModule struct {
+	sync.Mutex
+
+	name string
+
+	definedPins SimPinMap
+
+	enabled bool
+
+	devices map[int]SimSPIDevice
+
+	// everything written to each chip select
+	written map[int][]byte
+
+	// settings of the last transfer to each chip select
+	configs map[int]SPIConfig
+}
+
+func NewSimSPIModule(name string) *SimSPIModule {
+	return &SimSPIModule{name: name, devices: make(map[int]SimSPIDevice), written: make(map[int][]byte), configs: make(map[int]SPIConfig)}
+}
+
+// Accept options for the module. The only option is "pins", a SimPinMap of the pins that are assigned when the bus is
+// enabled.
+func (module *SimSPIModule) SetOptions(options map[string]interface{}) error {
+	v := options["pins"]
+	if v == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.definedPins = v.(SimPinMap)
+	return nil
+}
+
+// Enable the bus, assigning its pins.
+func (module *SimSPIModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	for pin := range module.definedPins {
+		e := AssignPin(pin, module)
+		if e != nil {
+			return e
+		}
+	}
+	module.enabled = true
+	return nil
+}
+
+// Disable the bus and release its pins.
+func (module *SimSPIModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.enabled {
+		return nil
+	}
+	for pin := range module.definedPins {
+		unassignPin(pin, module)
+	}
+	module.enabled = false
+	return nil
+}
+
+func (module *SimSPIModule) GetName() string {
+	return module.name
+}
+
+func (module *SimSPIModule) GetDevice(chipSelect int) SPIDevice {
+	return &simSPIDevice{module: module, chipSelect: chipSelect}
+}
+
+// Do one segment of a transfer. The module must be locked.
+func (module *SimSPIModule) transfer(chipSelect int, config SPIConfig, write []byte, read []byte) error {
+	n, e := spiTransferLength(write, read)
+	if e != nil {
+		return &SPIError{Op: "Tx", Module: module.name, ChipSelect: chipSelect, Err: e}
+	}
+	if !module.enabled {
+		return &SPIError{Op: "Tx", Module: module.name, ChipSelect: chipSelect, Err: ErrClosed}
+	}
+	if write == nil {
+		write = make([]byte, n)
+	}
+	if read == nil {
+		read = make([]byte, n)
+	}
+
+	module.written[chipSelect] = append(module.written[chipSelect], write...)
+	module.configs[chipSelect] = config
+
+	device := module.devices[chipSelect]
+	if device == nil {
+		for i := range read {
+			read[i] = 0
+		}
+		return nil
+	}
+	return device.Transfer(write, read)
+}
+
+// Attach a simulated device to a chip select, replacing any device already there.
+func (module *SimSPIModule) MockAttachDevice(chipSelect int, device SimSPIDevice) {
+	module.Lock()
+	defer module.Unlock()
+
+	module.devices[chipSelect] = device
+}
+
+// Get everything that has been written to a chip select, including the zeros sent by transfers that only read.
+func (module *SimSPIModule) MockGetWritten(chipSelect int) []byte {
+	module.Lock()
+	defer module.Unlock()
+
+	return append([]byte{}, module.written[chipSelect]...)
+}
+
+// Get the settings of the device that last did a transfer on a chip select.
+func (module *SimSPIModule) MockGetConfig(chipSelect int) SPIConfig {
+	module.Lock()
+	defer module.Unlock()
+
+	return module.configs[chipSelect]
+}
+
+// Handle for a device on a simulated bus.
+type simSPIDevice struct {
+	module     *SimSPIModule
+	chipSelect int
+
+	// protected by the module's lock
+	config SPIConfig
+}
+
+func (device *simSPIDevice) Configure(config SPIConfig) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	device.config = config
+	return nil
+}
+
+func (device *simSPIDevice) Tx(write []byte, read []byte) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	return device.module.transfer(device.chipSelect, device.config, write, read)
+}
+
+func (device *simSPIDevice) TxSegments(segments []SPISegment) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	for _, s := range segments {
+		e := device.module.transfer(device.chipSelect, device.config, s.Write, s.Read)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
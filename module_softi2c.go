@@ -0,0 +1,612 @@
+package hwio
+
+// A software I2C module, which bit-bangs the bus on two GPIO pins. It implements the same interfaces as the hardware
+// modules, including I2CTxDevice and I2CSMBusDevice on its devices, so the packages in hwio/devices work over either.
+//
+// The pins are driven open-drain: a line is pulled low by making its pin an output at LOW, and released by making it
+// an input, so that the pull-up resistor on the bus takes it high. GPIO modules that implement GPIOOpenDrainModule
+// switch between the two without ever driving the line high. The bus must have pull-ups, as for a hardware bus.
+// Devices can hold the clock low to slow the bus down (clock stretching), and the module waits for them, up to a
+// timeout.
+//
+// The module is created by the application rather than the driver:
+//	i2c := hwio.NewSoftI2CModule("softi2c")
+//	e := i2c.SetOptions(map[string]interface{}{
+//		"pins":  hwio.SoftI2CModulePins{SDA: sda, SCL: scl},
+//		"speed": hwio.SoftI2CModuleSpeed(400000),
+//	})
+//	e = i2c.Enable()
+
+// references:
+// - https://www.nxp.com/docs/en/user-guide/UM10204.pdf
+// - drivers/i2c/algos/i2c-algo-bit.c in the kernel
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The pins of a software I2C bus.
+type SoftI2CModulePins struct {
+	SDA Pin
+	SCL Pin
+}
+
+// The bit rate of a software I2C bus, in Hz. This is the fastest the bus will go; it is usually slower, as each change
+// of a pin takes time, and devices can stretch the clock.
+type SoftI2CModuleSpeed int
+
+// How long to wait for a device that is stretching the clock before failing with syscall.ETIMEDOUT.
+type SoftI2CModuleTimeout time.Duration
+
+// Defaults for the options of a software I2C bus
+const (
+	SOFT_I2C_DEFAULT_SPEED   = 100000
+	SOFT_I2C_DEFAULT_TIMEOUT = 100 * time.Millisecond
+)
+
+type SoftI2CModule struct {
+	sync.Mutex
+
+	name    string
+	pins    SoftI2CModulePins
+	speed   int
+	timeout time.Duration
+
+	// GPIO module that drives the pins, from the "gpio" option or the board's GPIO module when enabled
+	gpio GPIOModule
+
+	enabled bool
+
+	// the levels the module is leaving the lines at, false if it is pulling them low
+	sdaReleased bool
+	sclReleased bool
+
+	halfPeriod time.Duration
+}
+
+func NewSoftI2CModule(name string) *SoftI2CModule {
+	return &SoftI2CModule{name: name, speed: SOFT_I2C_DEFAULT_SPEED, timeout: SOFT_I2C_DEFAULT_TIMEOUT}
+}
+
+// Accept options for the module. Expected options include:
+// - "pins" - a SoftI2CModulePins with the pins of the bus.
+// - "speed" - optional, a SoftI2CModuleSpeed with the bit rate. The default is SOFT_I2C_DEFAULT_SPEED, standard mode.
+// - "timeout" - optional, a SoftI2CModuleTimeout for clock stretching. The default is SOFT_I2C_DEFAULT_TIMEOUT.
+// - "gpio" - optional, the GPIOModule to use for the pins. If not given, the GPIO module of the board is used.
+func (module *SoftI2CModule) SetOptions(options map[string]interface{}) error {
+	vp := options["pins"]
+	if vp == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.pins = vp.(SoftI2CModulePins)
+
+	if vs := options["speed"]; vs != nil {
+		speed := int(vs.(SoftI2CModuleSpeed))
+		if speed <= 0 {
+			return fmt.Errorf("Module '%s' SetOptions() got a speed of %d, it must be more than 0", module.GetName(), speed)
+		}
+		module.speed = speed
+	}
+	if vt := options["timeout"]; vt != nil {
+		module.timeout = time.Duration(vt.(SoftI2CModuleTimeout))
+	}
+	if vg := options["gpio"]; vg != nil {
+		module.gpio = vg.(GPIOModule)
+	}
+
+	return nil
+}
+
+// Enable the bus, opening its pins through the GPIO module and releasing both lines.
+func (module *SoftI2CModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.enabled {
+		return nil
+	}
+	if module.gpio == nil {
+		gpio, e := boardForModule(module).GetGPIOModule()
+		if e != nil {
+			return e
+		}
+		module.gpio = gpio
+	}
+
+	e := module.gpio.PinMode(module.pins.SDA, INPUT)
+	if e != nil {
+		return e
+	}
+	e = module.gpio.PinMode(module.pins.SCL, INPUT)
+	if e != nil {
+		module.gpio.ClosePin(module.pins.SDA)
+		return e
+	}
+	module.sdaReleased = true
+	module.sclReleased = true
+	module.halfPeriod = time.Second / time.Duration(2*module.speed)
+
+	module.enabled = true
+	return nil
+}
+
+// Disable the bus, closing its pins.
+func (module *SoftI2CModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.enabled {
+		return nil
+	}
+
+	e := module.gpio.ClosePin(module.pins.SDA)
+	if e2 := module.gpio.ClosePin(module.pins.SCL); e == nil {
+		e = e2
+	}
+	module.enabled = false
+
+	return e
+}
+
+func (module *SoftI2CModule) GetName() string {
+	return module.name
+}
+
+func (module *SoftI2CModule) GetDevice(address int) I2CDevice {
+	return NewSoftI2CDevice(module, address)
+}
+
+// Scan the bus for devices, probing each address with a quick write or a receive byte as i2cdetect does.
+func (module *SoftI2CModule) Scan() ([]int, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.enabled {
+		return nil, &I2CError{Op: "Scan", Module: module.name, Err: ErrClosed}
+	}
+
+	var failed *I2CError
+	result := scanI2CAddresses(func(address int, readByte bool) bool {
+		if failed != nil {
+			return false
+		}
+		probe := I2CMessage{}
+		if readByte {
+			probe = I2CMessage{Flags: I2C_M_RD, Data: make([]byte, 1)}
+		}
+		e := module.tx(address, []I2CMessage{probe})
+		if e != nil && !errors.Is(e, syscall.ENXIO) {
+			failed = &I2CError{Op: "Scan", Module: module.name, Address: address, Err: e}
+		}
+		return e == nil
+	})
+	if failed != nil {
+		return nil, failed
+	}
+	return result, nil
+}
+
+// Do a transaction with the device at an address, as I2CTxDevice.Tx. The module must be locked. Errors are not
+// wrapped, so that the caller can wrap them with its operation.
+func (module *SoftI2CModule) tx(address int, messages []I2CMessage) error {
+	if !module.enabled {
+		return ErrClosed
+	}
+	for _, m := range messages {
+		if m.Flags&I2C_M_TEN != 0 {
+			return fmt.Errorf("10-bit addresses are %w", ErrUnsupported)
+		}
+		if m.Flags&I2C_M_RECV_LEN != 0 && len(m.Data) < I2C_SMBUS_BLOCK_MAX+1 {
+			return fmt.Errorf("a block read into %d bytes is %w, it needs %d", len(m.Data), ErrUnsupported,
+				I2C_SMBUS_BLOCK_MAX+1)
+		}
+	}
+
+	e := module.messages(address, messages)
+	if e != nil {
+		// leave the bus idle for the next transaction, if the lines can still be driven
+		module.stop()
+		return e
+	}
+	return module.stop()
+}
+
+// Send the messages of a transaction, without the stop at the end.
+func (module *SoftI2CModule) messages(address int, messages []I2CMessage) error {
+	started := false
+	for _, m := range messages {
+		if !started || m.Flags&I2C_M_NOSTART == 0 {
+			e := module.start(started)
+			if e != nil {
+				return e
+			}
+			started = true
+
+			addr := byte(address << 1)
+			if (m.Flags&I2C_M_RD != 0) != (m.Flags&I2C_M_REV_DIR_ADDR != 0) {
+				addr |= 1
+			}
+			acked, e := module.writeByte(addr)
+			if e != nil {
+				return e
+			}
+			if !acked && m.Flags&I2C_M_IGNORE_NAK == 0 {
+				return syscall.ENXIO
+			}
+		}
+
+		var e error
+		if m.Flags&I2C_M_RD != 0 {
+			e = module.readMessage(m)
+		} else {
+			e = module.writeMessage(m)
+		}
+		if e != nil {
+			return e
+		}
+
+		if m.Flags&I2C_M_STOP != 0 {
+			e = module.stop()
+			if e != nil {
+				return e
+			}
+			started = false
+		}
+	}
+	return nil
+}
+
+func (module *SoftI2CModule) writeMessage(m I2CMessage) error {
+	for _, b := range m.Data {
+		acked, e := module.writeByte(b)
+		if e != nil {
+			return e
+		}
+		if !acked && m.Flags&I2C_M_IGNORE_NAK == 0 {
+			return syscall.EIO
+		}
+	}
+	return nil
+}
+
+// Read the bytes of a message, acknowledging all but the last so the device knows when to stop. For I2C_M_RECV_LEN,
+// the first byte is the length of the rest, which must be 1 to I2C_SMBUS_BLOCK_MAX.
+func (module *SoftI2CModule) readMessage(m I2CMessage) error {
+	n := len(m.Data)
+	for i := 0; i < n; i++ {
+		b, e := module.readByte()
+		if e != nil {
+			return e
+		}
+		m.Data[i] = b
+
+		if i == 0 && m.Flags&I2C_M_RECV_LEN != 0 {
+			if b == 0 || b > I2C_SMBUS_BLOCK_MAX {
+				if m.Flags&I2C_M_NO_RD_ACK == 0 {
+					module.writeBit(true)
+				}
+				return fmt.Errorf("a block length of %d: %w", b, syscall.EPROTO)
+			}
+			n = 1 + int(b)
+		}
+
+		if m.Flags&I2C_M_NO_RD_ACK == 0 {
+			// a low bit acknowledges the byte
+			e = module.writeBit(i == n-1)
+			if e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// Send a start condition, or a repeated start if the bus is already in a transaction. Both lines are low afterwards.
+func (module *SoftI2CModule) start(repeated bool) error {
+	if repeated {
+		e := module.setSDA(true)
+		if e == nil {
+			spinWait(module.halfPeriod)
+			e = module.setSCL(true)
+		}
+		if e != nil {
+			return e
+		}
+		spinWait(module.halfPeriod)
+	}
+
+	e := module.setSDA(false)
+	if e != nil {
+		return e
+	}
+	spinWait(module.halfPeriod)
+	e = module.setSCL(false)
+	spinWait(module.halfPeriod)
+	return e
+}
+
+// Send a stop condition, leaving both lines released.
+func (module *SoftI2CModule) stop() error {
+	if module.sdaReleased && module.sclReleased {
+		return nil
+	}
+
+	e := module.setSDA(false)
+	if e != nil {
+		return e
+	}
+	spinWait(module.halfPeriod)
+	e = module.setSCL(true)
+	if e != nil {
+		return e
+	}
+	spinWait(module.halfPeriod)
+	e = module.setSDA(true)
+	spinWait(module.halfPeriod)
+	return e
+}
+
+// Clock out a byte, most significant bit first, and return whether the device acknowledged it.
+func (module *SoftI2CModule) writeByte(b byte) (bool, error) {
+	for i := 7; i >= 0; i-- {
+		e := module.writeBit(b>>uint(i)&1 != 0)
+		if e != nil {
+			return false, e
+		}
+	}
+	nack, e := module.readBit()
+	return !nack, e
+}
+
+// Clock in a byte, most significant bit first. The caller sends the acknowledge bit.
+func (module *SoftI2CModule) readByte() (byte, error) {
+	result := byte(0)
+	for i := 0; i < 8; i++ {
+		bit, e := module.readBit()
+		if e != nil {
+			return 0, e
+		}
+		result <<= 1
+		if bit {
+			result |= 1
+		}
+	}
+	return result, nil
+}
+
+// Send a bit, with the clock low before and after.
+func (module *SoftI2CModule) writeBit(bit bool) error {
+	e := module.setSDA(bit)
+	if e != nil {
+		return e
+	}
+	spinWait(module.halfPeriod)
+	e = module.setSCL(true)
+	if e != nil {
+		return e
+	}
+	spinWait(module.halfPeriod)
+	return module.setSCL(false)
+}
+
+// Receive a bit, with the clock low before and after.
+func (module *SoftI2CModule) readBit() (bool, error) {
+	e := module.setSDA(true)
+	if e != nil {
+		return false, e
+	}
+	spinWait(module.halfPeriod)
+	e = module.setSCL(true)
+	if e != nil {
+		return false, e
+	}
+	v, e := module.gpio.DigitalRead(module.pins.SDA)
+	if e != nil {
+		return false, e
+	}
+	spinWait(module.halfPeriod)
+	return v == HIGH, module.setSCL(false)
+}
+
+// Release the data line, or pull it low.
+func (module *SoftI2CModule) setSDA(release bool) error {
+	if release == module.sdaReleased {
+		return nil
+	}
+	e := module.setLine(module.pins.SDA, release)
+	if e != nil {
+		return e
+	}
+	module.sdaReleased = release
+	return nil
+}
+
+// Release the clock line, or pull it low. After releasing it, wait until it goes high, as a device may be holding it
+// low.
+func (module *SoftI2CModule) setSCL(release bool) error {
+	if release == module.sclReleased {
+		return nil
+	}
+	e := module.setLine(module.pins.SCL, release)
+	if e != nil {
+		return e
+	}
+	module.sclReleased = release
+	if !release {
+		return nil
+	}
+
+	deadline := time.Now().Add(module.timeout)
+	for {
+		v, e := module.gpio.DigitalRead(module.pins.SCL)
+		if e != nil {
+			return e
+		}
+		if v == HIGH {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the clock was held low for more than %s: %w", module.timeout, syscall.ETIMEDOUT)
+		}
+		time.Sleep(module.halfPeriod)
+	}
+}
+
+func (module *SoftI2CModule) setLine(pin Pin, release bool) error {
+	if od, ok := module.gpio.(GPIOOpenDrainModule); ok {
+		if release {
+			return od.SetOpenDrain(pin, HIGH)
+		}
+		return od.SetOpenDrain(pin, LOW)
+	}
+
+	if release {
+		return module.gpio.PinMode(pin, INPUT)
+	}
+
+	// Set the level before the pin becomes an output, in case it was left HIGH. Modules that can't write an input
+	// return an error here, which doesn't matter as long as their outputs start LOW.
+	module.gpio.DigitalWrite(pin, LOW)
+	e := module.gpio.PinMode(pin, OUTPUT)
+	if e != nil {
+		return e
+	}
+	return module.gpio.DigitalWrite(pin, LOW)
+}
+
+// A device on a software I2C bus. As with the kernel's drivers, a device that doesn't acknowledge its address fails
+// with syscall.ENXIO, and one that doesn't acknowledge data with syscall.EIO.
+type SoftI2CDevice struct {
+	module  *SoftI2CModule
+	address int
+}
+
+func NewSoftI2CDevice(module *SoftI2CModule, address int) *SoftI2CDevice {
+	return &SoftI2CDevice{module: module, address: address}
+}
+
+// Do a transaction, wrapping errors in an I2CError for the operation.
+func (device *SoftI2CDevice) tx(op string, messages ...I2CMessage) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	e := device.module.tx(device.address, messages)
+	if e != nil {
+		return &I2CError{Op: op, Module: device.module.GetName(), Address: device.address, Err: e}
+	}
+	return nil
+}
+
+// Messages can have any of the I2C_M flags except I2C_M_TEN. A message with I2C_M_RECV_LEN must have room for
+// I2C_SMBUS_BLOCK_MAX+1 bytes, and gets the length followed by the block, as for the kernel.
+func (device *SoftI2CDevice) Tx(messages []I2CMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	return device.tx("Tx", messages...)
+}
+
+func (device *SoftI2CDevice) ReadByte(command byte) (byte, error) {
+	b, e := device.Read(command, 1)
+	if e != nil {
+		return 0, e
+	}
+	return b[0], nil
+}
+
+func (device *SoftI2CDevice) WriteByte(command byte, value byte) error {
+	return device.tx("WriteByte", I2CMessage{Data: []byte{command, value}})
+}
+
+func (device *SoftI2CDevice) Read(command byte, numBytes int) ([]byte, error) {
+	result := make([]byte, numBytes)
+	e := device.tx("Read", I2CMessage{Data: []byte{command}}, I2CMessage{Flags: I2C_M_RD, Data: result})
+	if e != nil {
+		return nil, e
+	}
+	return result, nil
+}
+
+func (device *SoftI2CDevice) Write(command byte, buffer []byte) error {
+	return device.tx("Write", I2CMessage{Data: append([]byte{command}, buffer...)})
+}
+
+func (device *SoftI2CDevice) QuickCommand(read bool) error {
+	m := I2CMessage{}
+	if read {
+		m.Flags = I2C_M_RD
+	}
+	return device.tx("QuickCommand", m)
+}
+
+func (device *SoftI2CDevice) ReceiveByte() (byte, error) {
+	b := make([]byte, 1)
+	e := device.tx("ReceiveByte", I2CMessage{Flags: I2C_M_RD, Data: b})
+	return b[0], e
+}
+
+func (device *SoftI2CDevice) SendByte(value byte) error {
+	return device.tx("SendByte", I2CMessage{Data: []byte{value}})
+}
+
+func (device *SoftI2CDevice) ReadWord(command byte) (uint16, error) {
+	b := make([]byte, 2)
+	e := device.tx("ReadWord", I2CMessage{Data: []byte{command}}, I2CMessage{Flags: I2C_M_RD, Data: b})
+	return uint16(b[1])<<8 | uint16(b[0]), e
+}
+
+func (device *SoftI2CDevice) WriteWord(command byte, value uint16) error {
+	return device.tx("WriteWord", I2CMessage{Data: []byte{command, byte(value), byte(value >> 8)}})
+}
+
+func (device *SoftI2CDevice) ProcessCall(command byte, value uint16) (uint16, error) {
+	b := make([]byte, 2)
+	write := I2CMessage{Data: []byte{command, byte(value), byte(value >> 8)}}
+	e := device.tx("ProcessCall", write, I2CMessage{Flags: I2C_M_RD, Data: b})
+	return uint16(b[1])<<8 | uint16(b[0]), e
+}
+
+func (device *SoftI2CDevice) BlockRead(command byte) ([]byte, error) {
+	buffer := make([]byte, I2C_SMBUS_BLOCK_MAX+1)
+	read := I2CMessage{Flags: I2C_M_RD | I2C_M_RECV_LEN, Data: buffer}
+	e := device.tx("BlockRead", I2CMessage{Data: []byte{command}}, read)
+	if e != nil {
+		return nil, e
+	}
+	return smbusBlock(buffer), nil
+}
+
+func (device *SoftI2CDevice) BlockWrite(command byte, data []byte) error {
+	block, e := device.makeBlock("BlockWrite", command, data)
+	if e != nil {
+		return e
+	}
+	return device.tx("BlockWrite", I2CMessage{Data: block})
+}
+
+func (device *SoftI2CDevice) BlockProcessCall(command byte, data []byte) ([]byte, error) {
+	block, e := device.makeBlock("BlockProcessCall", command, data)
+	if e != nil {
+		return nil, e
+	}
+	buffer := make([]byte, I2C_SMBUS_BLOCK_MAX+1)
+	e = device.tx("BlockProcessCall", I2CMessage{Data: block}, I2CMessage{Flags: I2C_M_RD | I2C_M_RECV_LEN, Data: buffer})
+	if e != nil {
+		return nil, e
+	}
+	return smbusBlock(buffer), nil
+}
+
+// Make a write of a register and a block with its length, limited to I2C_SMBUS_BLOCK_MAX bytes as for SMBus.
+func (device *SoftI2CDevice) makeBlock(op string, command byte, data []byte) ([]byte, error) {
+	if len(data) > I2C_SMBUS_BLOCK_MAX {
+		return nil, &I2CError{Op: op, Module: device.module.GetName(), Address: device.address,
+			Err: fmt.Errorf("a block of %d bytes is %w, the limit is %d", len(data), ErrUnsupported, I2C_SMBUS_BLOCK_MAX)}
+	}
+	return append([]byte{command, byte(len(data))}, data...), nil
+}
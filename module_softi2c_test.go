@@ -0,0 +1,362 @@
+package hwio
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// States of testSoftI2CSlave
+const (
+	testI2CIdle     = iota // waiting for a start
+	testI2CAddress         // receiving the address
+	testI2CAddrAck         // acknowledging the address
+	testI2CWrite           // receiving a byte
+	testI2CWriteAck        // acknowledging a byte
+	testI2CRead            // sending a byte
+	testI2CReadAck         // waiting for the master to acknowledge a byte
+)
+
+// A device on the pins of a software I2C bus, which decodes the bus signals and passes the transfers on to a simulated
+// device. Lines are modelled as open-drain with pull-ups: a line is low if the master's pin is an output (which is
+// always low) or the slave is pulling it low.
+type testSoftI2CSlave struct {
+	sync.Mutex
+
+	gpio     *SimGPIOModule
+	sda, scl Pin
+	address  int
+	device   I2CTxDevice
+
+	// If not zero, the slave holds the clock low for this long after acknowledging its address.
+	stretch time.Duration
+
+	pullSDA bool
+	holdSCL bool
+
+	// set when the slave starts holding the clock, to the time until it lets go
+	stretching time.Duration
+
+	busSDA int
+	busSCL int
+
+	state    int
+	bits     int
+	shift    byte
+	read     bool
+	acked    bool
+	writing  []byte
+	failures int
+}
+
+func newTestSoftI2CSlave(gpio *SimGPIOModule, sda Pin, scl Pin, address int, device I2CTxDevice) *testSoftI2CSlave {
+	s := &testSoftI2CSlave{gpio: gpio, sda: sda, scl: scl, address: address, device: device, busSDA: HIGH, busSCL: HIGH}
+	gpio.addListener(sda, s.changed)
+	gpio.addListener(scl, s.changed)
+	return s
+}
+
+// Set a line that the master has released to the level the slave gives it.
+func (s *testSoftI2CSlave) update(pin Pin, pulled bool) {
+	if s.gpio.MockGetPinMode(pin) == OUTPUT {
+		return
+	}
+	want := HIGH
+	if pulled {
+		want = LOW
+	}
+	if s.gpio.MockGetPinValue(pin) != want {
+		s.gpio.MockSetPinValue(pin, want)
+	}
+}
+
+func (s *testSoftI2CSlave) changed(pin Pin, old int, level int, output bool) {
+	s.Lock()
+	pulled := s.pullSDA
+	if pin == s.scl {
+		pulled = s.holdSCL
+	}
+	if !output && (level == HIGH) == pulled {
+		// the master has released the line, which is where the slave leaves it
+		s.Unlock()
+		s.update(pin, pulled)
+		return
+	}
+
+	if pin == s.scl && level != s.busSCL {
+		s.busSCL = level
+		if level == HIGH {
+			s.clockRising()
+		} else {
+			s.clockFalling()
+		}
+	} else if pin == s.sda && level != s.busSDA {
+		s.busSDA = level
+		if s.busSCL == HIGH {
+			s.flush()
+			s.state = testI2CIdle
+			if level == LOW {
+				s.state = testI2CAddress
+				s.bits = 0
+			}
+		}
+	}
+	pullSDA, stretch := s.pullSDA, s.stretching
+	s.stretching = 0
+	s.Unlock()
+
+	s.update(s.sda, pullSDA)
+	if stretch > 0 {
+		time.AfterFunc(stretch, func() {
+			s.Lock()
+			s.holdSCL = false
+			s.Unlock()
+			s.update(s.scl, false)
+		})
+	}
+}
+
+func (s *testSoftI2CSlave) clockRising() {
+	switch s.state {
+	case testI2CAddress, testI2CWrite:
+		s.shift = s.shift<<1 | byte(s.busSDA)
+		s.bits++
+	case testI2CReadAck:
+		s.acked = s.busSDA == LOW
+	}
+}
+
+func (s *testSoftI2CSlave) clockFalling() {
+	switch s.state {
+	case testI2CAddress:
+		if s.bits < 8 {
+			return
+		}
+		s.read = s.shift&1 != 0
+		s.state = testI2CIdle
+		if int(s.shift>>1) == s.address && s.device.(I2CSMBusDevice).QuickCommand(s.read) == nil {
+			s.pullSDA = true
+			s.state = testI2CAddrAck
+		}
+	case testI2CAddrAck:
+		if s.stretch > 0 {
+			s.holdSCL = true
+			s.stretching = s.stretch
+		}
+		if s.read {
+			s.nextByte()
+		} else {
+			s.pullSDA = false
+			s.state = testI2CWrite
+			s.bits = 0
+		}
+	case testI2CWrite:
+		if s.bits == 8 {
+			s.writing = append(s.writing, s.shift)
+			s.pullSDA = true
+			s.state = testI2CWriteAck
+		}
+	case testI2CWriteAck:
+		s.pullSDA = false
+		s.state = testI2CWrite
+		s.bits = 0
+	case testI2CRead:
+		s.bits++
+		if s.bits < 8 {
+			s.pullSDA = s.shift&(0x80>>uint(s.bits)) == 0
+		} else {
+			s.pullSDA = false
+			s.state = testI2CReadAck
+		}
+	case testI2CReadAck:
+		if s.acked {
+			s.nextByte()
+		} else {
+			s.state = testI2CIdle
+		}
+	}
+}
+
+// Get the next byte to send from the device, and put its first bit on the bus.
+func (s *testSoftI2CSlave) nextByte() {
+	s.flush()
+	b := make([]byte, 1)
+	if s.device.Tx([]I2CMessage{{Flags: I2C_M_RD, Data: b}}) != nil {
+		s.failures++
+	}
+	s.shift = b[0]
+	s.bits = 0
+	s.pullSDA = s.shift&0x80 == 0
+	s.state = testI2CRead
+}
+
+// Pass the bytes written since the start on to the device.
+func (s *testSoftI2CSlave) flush() {
+	if len(s.writing) > 0 {
+		if s.device.Tx([]I2CMessage{{Data: s.writing}}) != nil {
+			s.failures++
+		}
+		s.writing = nil
+	}
+}
+
+func newTestSoftI2C(t *testing.T, options map[string]interface{}) (*SoftI2CModule, *testSoftI2CSlave, *SimI2CRegisters) {
+	driver := newTestWiredDriver()
+	SetDriver(driver)
+	gpio := driver.GetModules()["gpio"].(*SimGPIOModule)
+
+	regs := NewSimI2CRegisters()
+	slave := newTestSoftI2CSlave(gpio, 0, 1, 0x48, regs)
+
+	i2c := NewSoftI2CModule("softi2c")
+	options["pins"] = SoftI2CModulePins{SDA: 0, SCL: 1}
+	if e := i2c.SetOptions(options); e != nil {
+		t.Fatalf("SetOptions returned an unexpected error: %s", e)
+	}
+	if e := i2c.Enable(); e != nil {
+		t.Fatalf("Enable returned an unexpected error: %s", e)
+	}
+	return i2c, slave, regs
+}
+
+func TestSoftI2C(t *testing.T) {
+	i2c, slave, regs := newTestSoftI2C(t, map[string]interface{}{"speed": SoftI2CModuleSpeed(1000000)})
+	defer i2c.Disable()
+	device := i2c.GetDevice(0x48)
+
+	if e := device.WriteByte(0x10, 0x42); e != nil || regs.Get(0x10, 1)[0] != 0x42 {
+		t.Errorf("Expected WriteByte to set the register, got %v, %v", regs.Get(0x10, 1), e)
+	}
+	device.Write(0x20, []byte{1, 2, 3})
+	if b, e := device.Read(0x20, 3); e != nil || b[0] != 1 || b[1] != 2 || b[2] != 3 {
+		t.Errorf("Expected to read back 3 registers, got %v, %v", b, e)
+	}
+
+	smbus := device.(I2CSMBusDevice)
+	smbus.WriteWord(0x30, 0x1234)
+	if v, e := smbus.ReadWord(0x30); e != nil || v != 0x1234 || regs.Get(0x30, 1)[0] != 0x34 {
+		t.Errorf("Expected to read back the word with the low byte first, got 0x%04x, %v", v, e)
+	}
+	regs.Set(0x40, 2, 0xaa, 0xbb)
+	if b, e := smbus.BlockRead(0x40); e != nil || len(b) != 2 || b[0] != 0xaa || b[1] != 0xbb {
+		t.Errorf("Expected to read a block of 2 bytes, got %x, %v", b, e)
+	}
+
+	// a read without a register carries on from the register pointer
+	buffer := make([]byte, 2)
+	e := device.(I2CTxDevice).Tx([]I2CMessage{{Data: []byte{0x20}}, {Flags: I2C_M_RD, Data: buffer[:1]}, {Flags: I2C_M_RD, Data: buffer[1:]}})
+	if e != nil || buffer[0] != 1 || buffer[1] != 2 {
+		t.Errorf("Expected Tx to read from the register pointer, got %v, %v", buffer, e)
+	}
+
+	var i2cError *I2CError
+	if _, e = i2c.GetDevice(0x49).ReadByte(0); !errors.As(e, &i2cError) || !errors.Is(e, syscall.ENXIO) {
+		t.Errorf("Expected ENXIO from an address with no device, got %v", e)
+	}
+	if found, e := i2c.Scan(); e != nil || len(found) != 1 || found[0] != 0x48 {
+		t.Errorf("Expected the scan to find the device at 0x48, got %x, %v", found, e)
+	}
+	if slave.failures != 0 {
+		t.Errorf("Expected the transfers to reach the device cleanly, %d failed", slave.failures)
+	}
+}
+
+// A GPIO module that rejects PinMode on a pin it already has open, as modules that assign the pin each time do.
+type testSoftI2CStrictGPIO struct {
+	*SimGPIOModule
+	opened map[Pin]bool
+}
+
+func (g *testSoftI2CStrictGPIO) PinMode(pin Pin, mode PinIOMode) error {
+	if g.opened[pin] {
+		return pinError("PinMode", pin, g, ErrPinInUse)
+	}
+	g.opened[pin] = true
+	return g.SimGPIOModule.PinMode(pin, mode)
+}
+
+func TestSoftI2COpenDrain(t *testing.T) {
+	driver := newTestWiredDriver()
+	SetDriver(driver)
+	sim := driver.GetModules()["gpio"].(*SimGPIOModule)
+	gpio := &testSoftI2CStrictGPIO{SimGPIOModule: sim, opened: make(map[Pin]bool)}
+
+	// the master must never drive a line high
+	var lock sync.Mutex
+	drivenHigh := 0
+	for _, pin := range []Pin{0, 1} {
+		sim.addListener(pin, func(pin Pin, old int, level int, output bool) {
+			if output && level == HIGH {
+				lock.Lock()
+				drivenHigh++
+				lock.Unlock()
+			}
+		})
+	}
+
+	regs := NewSimI2CRegisters()
+	newTestSoftI2CSlave(sim, 0, 1, 0x48, regs)
+	i2c := NewSoftI2CModule("softi2c")
+	i2c.SetOptions(map[string]interface{}{"pins": SoftI2CModulePins{SDA: 0, SCL: 1}, "gpio": GPIOModule(gpio)})
+	if e := i2c.Enable(); e != nil {
+		t.Fatalf("Enable returned an unexpected error: %s", e)
+	}
+	defer i2c.Disable()
+
+	device := i2c.GetDevice(0x48)
+	if e := device.WriteByte(0x10, 0x42); e != nil {
+		t.Errorf("Expected the lines to be switched without opening the pins again, got %v", e)
+	}
+	if v, e := device.ReadByte(0x10); e != nil || v != 0x42 {
+		t.Errorf("Expected to read back the register, got 0x%02x, %v", v, e)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if drivenHigh != 0 {
+		t.Errorf("Expected the lines never to be driven high, they were %d times", drivenHigh)
+	}
+}
+
+func TestSoftI2CClockStretching(t *testing.T) {
+	i2c, slave, regs := newTestSoftI2C(t, map[string]interface{}{"timeout": SoftI2CModuleTimeout(20 * time.Millisecond)})
+	defer i2c.Disable()
+	device := i2c.GetDevice(0x48)
+
+	regs.Set(0, 0x5a)
+	slave.Lock()
+	slave.stretch = 2 * time.Millisecond
+	slave.Unlock()
+	if v, e := device.ReadByte(0); e != nil || v != 0x5a {
+		t.Errorf("Expected to wait for a device stretching the clock, got 0x%02x, %v", v, e)
+	}
+
+	slave.Lock()
+	slave.stretch = 100 * time.Millisecond
+	slave.Unlock()
+	if _, e := device.ReadByte(0); !errors.Is(e, syscall.ETIMEDOUT) {
+		t.Errorf("Expected ETIMEDOUT when the clock is held too long, got %v", e)
+	}
+}
+
+func TestSoftI2CErrors(t *testing.T) {
+	SetDriver(newTestWiredDriver())
+
+	if e := NewSoftI2CModule("softi2c").SetOptions(map[string]interface{}{"pins": SoftI2CModulePins{}, "speed": SoftI2CModuleSpeed(0)}); e == nil {
+		t.Errorf("Expected SetOptions to reject a speed of 0")
+	}
+
+	i2c := NewSoftI2CModule("softi2c")
+	i2c.SetOptions(map[string]interface{}{"pins": SoftI2CModulePins{SDA: 0, SCL: 1}})
+	if e := i2c.GetDevice(0x48).WriteByte(0, 0); !errors.Is(e, ErrClosed) {
+		t.Errorf("Expected ErrClosed writing to a bus that isn't enabled, got %v", e)
+	}
+
+	i2c.Enable()
+	defer i2c.Disable()
+	if e := i2c.GetDevice(0x48).(I2CTxDevice).Tx([]I2CMessage{{Flags: I2C_M_TEN}}); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for a 10-bit address, got %v", e)
+	}
+}
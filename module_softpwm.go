@@ -0,0 +1,473 @@
+package hwio
+
+// A software PWM module, which drives GPIO outputs from a goroutine per pin. It implements the same PWMModule interface
+// as the hardware modules, so it can be used for LED dimming or servos on pins that have no hardware PWM. The timing is
+// only as good as the scheduler: edges can be late by tens of microseconds, or much more on a busy system. The jitter
+// statistics of each pin show how late its edges have been, so callers can judge if it is good enough.
+//
+// The module is created by the application rather than the driver:
+//	pwm := hwio.NewSoftPWMModule("softpwm")
+//	e := pwm.SetOptions(map[string]interface{}{"pins": hwio.SoftPWMModulePins{pin}})
+//	e = pwm.Enable()
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The pins a software PWM module can use.
+type SoftPWMModulePins []Pin
+
+// How long before each edge to stop sleeping and busy wait instead. Busy waiting makes edges much more punctual, but
+// uses a CPU for that long for each edge.
+type SoftPWMModuleSpin time.Duration
+
+type SoftPWMModule struct {
+	sync.Mutex
+
+	name        string
+	definedPins SoftPWMModulePins
+	spin        time.Duration
+
+	// GPIO module that drives the pins, from the "gpio" option or the board's GPIO module
+	gpio GPIOModule
+
+	openPins map[Pin]*softPWMPin
+}
+
+// Statistics of how late the edges of a software PWM pin have been.
+type SoftPWMJitter struct {
+	// Number of edges measured
+	Edges int64
+
+	// Average and largest time an edge was late by
+	Mean time.Duration
+	Max  time.Duration
+
+	// Number of times the output fell more than a period behind, and periods were skipped to catch up
+	Overruns int64
+}
+
+// A pin with output. Its settings are locked separately from the module, as they are read by its goroutine.
+type softPWMPin struct {
+	sync.Mutex
+
+	pin Pin
+
+	// settings, which take effect at the start of the next period
+	period   int64
+	duty     int64
+	polarity PWMPolarity
+	enabled  bool
+
+	jitter    SoftPWMJitter
+	totalLate time.Duration
+
+	// an error from writing the pin, which stopped the output
+	err error
+
+	// set while the goroutine is running. stop is closed to stop it, and it closes done when it has.
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewSoftPWMModule(name string) *SoftPWMModule {
+	return &SoftPWMModule{name: name, openPins: make(map[Pin]*softPWMPin)}
+}
+
+// Accept options for the module. Expected options include:
+// - "pins" - a SoftPWMModulePins with the pins the module can use.
+// - "spin" - optional, a SoftPWMModuleSpin. The default is 0, which sleeps until each edge.
+// - "gpio" - optional, the GPIOModule to use for the pins. If not given, the GPIO module of the board is used.
+func (module *SoftPWMModule) SetOptions(options map[string]interface{}) error {
+	vp := options["pins"]
+	if vp == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.definedPins = vp.(SoftPWMModulePins)
+
+	if vs := options["spin"]; vs != nil {
+		module.spin = time.Duration(vs.(SoftPWMModuleSpin))
+	}
+
+	if vg := options["gpio"]; vg != nil {
+		module.gpio = vg.(GPIOModule)
+	}
+
+	return nil
+}
+
+// Enable the module. Pins are only opened when they are enabled with EnablePin.
+func (module *SoftPWMModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	return module.getGPIO()
+}
+
+// Stop the output on all pins, and close them.
+func (module *SoftPWMModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	var result error
+	for pin, openPin := range module.openPins {
+		module.stop(openPin)
+		if e := module.gpio.ClosePin(pin); e != nil && result == nil {
+			result = e
+		}
+	}
+	module.openPins = make(map[Pin]*softPWMPin)
+	return result
+}
+
+func (module *SoftPWMModule) GetName() string {
+	return module.name
+}
+
+// Get the board's GPIO module if none was given in the options.
+func (module *SoftPWMModule) getGPIO() error {
+	if module.gpio != nil {
+		return nil
+	}
+	gpio, e := boardForModule(module).GetGPIOModule()
+	if e != nil {
+		return e
+	}
+	module.gpio = gpio
+	return nil
+}
+
+// Enable or disable output on a pin. The first time a pin is enabled it is opened as an output through the GPIO
+// module. Output starts once the period has been set. When it is disabled, the pin is left at its inactive level.
+func (module *SoftPWMModule) EnablePin(pin Pin, enabled bool) error {
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.definedPins.contains(pin) {
+		return pinError("EnablePin", pin, module, ErrUnknownPin)
+	}
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		if !enabled {
+			return nil
+		}
+
+		e := module.getGPIO()
+		if e == nil {
+			e = module.gpio.PinMode(pin, OUTPUT)
+		}
+		if e == nil {
+			e = module.gpio.DigitalWrite(pin, LOW)
+		}
+		if e != nil {
+			return e
+		}
+		openPin = &softPWMPin{pin: pin}
+		module.openPins[pin] = openPin
+	}
+
+	openPin.Lock()
+	openPin.enabled = enabled
+	openPin.err = nil
+	openPin.Unlock()
+	return module.update(openPin)
+}
+
+// Set the period of this pin, in nanoseconds
+func (module *SoftPWMModule) SetPeriod(pin Pin, ns int64) error {
+	return module.setTiming("SetPeriod", pin, func(op *softPWMPin) (int64, int64, error) {
+		return ns, op.duty, nil
+	})
+}
+
+// Set the period of this pin, in nanoseconds, scaling the duty time to keep the duty cycle.
+func (module *SoftPWMModule) SetPeriodKeepDutyCycle(pin Pin, ns int64) error {
+	return module.setTiming("SetPeriodKeepDutyCycle", pin, func(op *softPWMPin) (int64, int64, error) {
+		return ns, scalePWMDuty(op.duty, op.period, ns), nil
+	})
+}
+
+// Set the duty time, the amount of time during each period that that output is HIGH.
+func (module *SoftPWMModule) SetDuty(pin Pin, ns int64) error {
+	return module.setTiming("SetDuty", pin, func(op *softPWMPin) (int64, int64, error) {
+		return op.period, ns, nil
+	})
+}
+
+// Set the duty time as a fraction of the period.
+func (module *SoftPWMModule) SetDutyCycle(pin Pin, fraction float64) error {
+	return module.setTiming("SetDutyCycle", pin, func(op *softPWMPin) (int64, int64, error) {
+		duty, e := pwmDutyCycle(op.period, fraction)
+		return op.period, duty, e
+	})
+}
+
+// Change the period and duty time of a pin to those given by timing, which gets the current settings. They take
+// effect from the next period.
+func (module *SoftPWMModule) setTiming(op string, pin Pin, timing func(op *softPWMPin) (int64, int64, error)) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notEnabledError(op, pin, module)
+	}
+
+	openPin.Lock()
+	period, duty, e := timing(openPin)
+	if e == nil {
+		e = checkPWMDuty(duty, period)
+	}
+	if e == nil {
+		openPin.period = period
+		openPin.duty = duty
+	}
+	openPin.Unlock()
+
+	if e != nil {
+		return pinError(op, pin, module, e)
+	}
+	return module.update(openPin)
+}
+
+func (module *SoftPWMModule) SetPolarity(pin Pin, polarity PWMPolarity) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notEnabledError("SetPolarity", pin, module)
+	}
+	_, e := pwmPolarityName(polarity, "normal", "inversed")
+	if e != nil {
+		return pinError("SetPolarity", pin, module, e)
+	}
+
+	openPin.Lock()
+	openPin.polarity = polarity
+	openPin.Unlock()
+
+	// the inactive level changes, so a pin that isn't running needs to change too
+	return module.update(openPin)
+}
+
+// Determine if output is enabled on the pin. If writing the pin failed, which stops the output, this returns the error.
+func (module *SoftPWMModule) IsPinEnabled(pin Pin) (bool, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.definedPins.contains(pin) {
+		return false, pinError("IsPinEnabled", pin, module, ErrUnknownPin)
+	}
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return false, nil
+	}
+
+	openPin.Lock()
+	defer openPin.Unlock()
+	if openPin.err != nil {
+		return false, pinError("IsPinEnabled", pin, module, openPin.err)
+	}
+	return openPin.enabled, nil
+}
+
+func (module *SoftPWMModule) GetPeriod(pin Pin) (int64, error) {
+	openPin, e := module.getOpenPin("GetPeriod", pin)
+	if e != nil {
+		return 0, e
+	}
+	return openPin.period, nil
+}
+
+func (module *SoftPWMModule) GetDuty(pin Pin) (int64, error) {
+	openPin, e := module.getOpenPin("GetDuty", pin)
+	if e != nil {
+		return 0, e
+	}
+	return openPin.duty, nil
+}
+
+func (module *SoftPWMModule) GetPolarity(pin Pin) (PWMPolarity, error) {
+	openPin, e := module.getOpenPin("GetPolarity", pin)
+	if e != nil {
+		return PWM_POLARITY_NORMAL, e
+	}
+	return openPin.polarity, nil
+}
+
+// Get the statistics of how late the edges of a pin have been since it was enabled, or since they were reset.
+func (module *SoftPWMModule) GetJitter(pin Pin) (SoftPWMJitter, error) {
+	openPin, e := module.getOpenPin("GetJitter", pin)
+	if e != nil {
+		return SoftPWMJitter{}, e
+	}
+	return openPin.jitter, nil
+}
+
+// Reset the jitter statistics of a pin, e.g. after changing its settings.
+func (module *SoftPWMModule) ResetJitter(pin Pin) error {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return notEnabledError("ResetJitter", pin, module)
+	}
+	openPin.Lock()
+	openPin.jitter = SoftPWMJitter{}
+	openPin.totalLate = 0
+	openPin.Unlock()
+	return nil
+}
+
+// Get a copy of the settings of a pin that has been enabled.
+func (module *SoftPWMModule) getOpenPin(op string, pin Pin) (softPWMPin, error) {
+	module.Lock()
+	defer module.Unlock()
+
+	openPin := module.openPins[pin]
+	if openPin == nil {
+		return softPWMPin{}, notEnabledError(op, pin, module)
+	}
+	openPin.Lock()
+	defer openPin.Unlock()
+	return softPWMPin{period: openPin.period, duty: openPin.duty, polarity: openPin.polarity, jitter: openPin.jitter}, nil
+}
+
+// Start or stop the goroutine of a pin to match its settings. A pin that isn't running is left at its inactive level.
+// The module must be locked.
+func (module *SoftPWMModule) update(openPin *softPWMPin) error {
+	openPin.Lock()
+	run := openPin.enabled && openPin.period > 0
+	_, inactive := openPin.levels()
+	openPin.Unlock()
+
+	if run {
+		// a goroutine that stopped because a write failed has to be started again
+		if openPin.stop != nil {
+			select {
+			case <-openPin.done:
+				openPin.stop = nil
+				openPin.done = nil
+			default:
+			}
+		}
+		if openPin.stop == nil {
+			openPin.stop = make(chan struct{})
+			openPin.done = make(chan struct{})
+			openPin.Lock()
+			openPin.jitter = SoftPWMJitter{}
+			openPin.totalLate = 0
+			openPin.Unlock()
+			go module.run(openPin, openPin.stop, openPin.done)
+		}
+		return nil
+	}
+
+	module.stop(openPin)
+	return module.gpio.DigitalWrite(openPin.pin, inactive)
+}
+
+// Stop the goroutine of a pin if it is running, and wait for it to finish. The module must be locked.
+func (module *SoftPWMModule) stop(openPin *softPWMPin) {
+	if openPin.stop == nil {
+		return
+	}
+	close(openPin.stop)
+	<-openPin.done
+	openPin.stop = nil
+	openPin.done = nil
+}
+
+// Drive a pin until stopped. Each period starts at a fixed time after the last, rather than after the last edge, so
+// that lateness doesn't accumulate.
+func (module *SoftPWMModule) run(openPin *softPWMPin, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	next := time.Now()
+	for {
+		openPin.Lock()
+		period := time.Duration(openPin.period)
+		duty := time.Duration(openPin.duty)
+		active, inactive := openPin.levels()
+		openPin.Unlock()
+
+		start := next
+		next = start.Add(period)
+
+		if duty > 0 && !module.edge(openPin, start, active, stop) {
+			return
+		}
+		if duty < period && !module.edge(openPin, start.Add(duty), inactive, stop) {
+			return
+		}
+
+		// If the next period should already have started, skip ahead rather than rushing to catch up.
+		if now := time.Now(); now.After(next) {
+			openPin.Lock()
+			openPin.jitter.Overruns++
+			openPin.Unlock()
+			next = now
+		}
+	}
+}
+
+// Wait until the time of an edge, then write the level and record how late it was. Returns false if the goroutine
+// has been stopped, or the write failed.
+func (module *SoftPWMModule) edge(openPin *softPWMPin, at time.Time, level int, stop chan struct{}) bool {
+	if d := time.Until(at) - module.spin; d > 0 {
+		timer := time.NewTimer(d)
+		select {
+		case <-stop:
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	} else {
+		select {
+		case <-stop:
+			return false
+		default:
+		}
+	}
+	spinWait(time.Until(at))
+
+	late := time.Since(at)
+	e := module.gpio.DigitalWrite(openPin.pin, level)
+
+	openPin.Lock()
+	defer openPin.Unlock()
+	if e != nil {
+		openPin.err = e
+		return false
+	}
+	openPin.jitter.Edges++
+	openPin.totalLate += late
+	openPin.jitter.Mean = openPin.totalLate / time.Duration(openPin.jitter.Edges)
+	if late > openPin.jitter.Max {
+		openPin.jitter.Max = late
+	}
+	return true
+}
+
+// Get the levels of the output during and after the duty time. The pin must be locked.
+func (openPin *softPWMPin) levels() (active int, inactive int) {
+	if openPin.polarity == PWM_POLARITY_INVERSED {
+		return LOW, HIGH
+	}
+	return HIGH, LOW
+}
+
+func (pins SoftPWMModulePins) contains(pin Pin) bool {
+	for _, p := range pins {
+		if p == pin {
+			return true
+		}
+	}
+	return false
+}
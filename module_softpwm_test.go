@@ -0,0 +1,199 @@
+package hwio
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Records the times a pin goes high.
+type testSoftPWMProbe struct {
+	sync.Mutex
+	rises []time.Time
+}
+
+func (p *testSoftPWMProbe) attach(gpio *SimGPIOModule, pin Pin) {
+	gpio.MockOnChange(pin, func(value int) {
+		if value == HIGH {
+			p.Lock()
+			p.rises = append(p.rises, time.Now())
+			p.Unlock()
+		}
+	})
+}
+
+func (p *testSoftPWMProbe) count() int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.rises)
+}
+
+func TestSoftPWMModule(t *testing.T) {
+	driver := newTestWiredDriver()
+	SetDriver(driver)
+	gpio := driver.GetModules()["gpio"].(*SimGPIOModule)
+	probe := &testSoftPWMProbe{}
+	probe.attach(gpio, 0)
+
+	var pwm PWMModule = NewSoftPWMModule("softpwm")
+	pwm.SetOptions(map[string]interface{}{"pins": SoftPWMModulePins{0, 1}, "spin": SoftPWMModuleSpin(50 * time.Microsecond)})
+	if e := pwm.Enable(); e != nil {
+		t.Fatalf("Enable returned an unexpected error: %s", e)
+	}
+	defer pwm.Disable()
+
+	if e := pwm.EnablePin(0, true); e != nil {
+		t.Fatalf("EnablePin returned an unexpected error: %s", e)
+	}
+	if probe.count() != 0 || gpio.MockGetPinValue(0) != LOW {
+		t.Errorf("Expected the pin to stay low until it has a period")
+	}
+
+	pwm.SetPeriod(0, 2000000)
+	pwm.SetDutyCycle(0, 0.25)
+	time.Sleep(50 * time.Millisecond)
+	if n := probe.count(); n < 5 || n > 30 {
+		t.Errorf("Expected about 25 periods in 50ms, got %d", n)
+	}
+
+	jitter, e := pwm.(*SoftPWMModule).GetJitter(0)
+	if e != nil || jitter.Edges < 10 || jitter.Max < jitter.Mean {
+		t.Errorf("Expected jitter statistics for the edges, got %+v, %v", jitter, e)
+	}
+	pwm.(*SoftPWMModule).ResetJitter(0)
+	if jitter, _ = pwm.(*SoftPWMModule).GetJitter(0); jitter.Edges > 2 {
+		t.Errorf("Expected ResetJitter to clear the statistics, got %+v", jitter)
+	}
+
+	// full duty leaves the pin high
+	pwm.SetDutyCycle(0, 1)
+	time.Sleep(10 * time.Millisecond)
+	n := probe.count()
+	time.Sleep(10 * time.Millisecond)
+	if probe.count() != n || gpio.MockGetPinValue(0) != HIGH {
+		t.Errorf("Expected the pin to stay high at full duty")
+	}
+
+	// disabling the pin leaves it at the inactive level, which inverting the polarity changes
+	pwm.EnablePin(0, false)
+	if gpio.MockGetPinValue(0) != LOW {
+		t.Errorf("Expected the pin to be low once disabled")
+	}
+	pwm.SetPolarity(0, PWM_POLARITY_INVERSED)
+	if gpio.MockGetPinValue(0) != HIGH {
+		t.Errorf("Expected the pin to be high once disabled with inversed polarity")
+	}
+	if enabled, e := pwm.IsPinEnabled(0); e != nil || enabled {
+		t.Errorf("Expected IsPinEnabled to report the pin disabled, got %v, %v", enabled, e)
+	}
+	if period, _ := pwm.GetPeriod(0); period != 2000000 {
+		t.Errorf("Expected the period to be kept, got %d", period)
+	}
+
+	if e = pwm.Disable(); e != nil {
+		t.Errorf("Disable returned an unexpected error: %s", e)
+	}
+	if e = DigitalWrite(0, HIGH); !errors.Is(e, ErrNotOpen) {
+		t.Errorf("Expected Disable to close the pins, got %v", e)
+	}
+}
+
+// A GPIO module whose writes can be made to fail.
+type testSoftPWMFailingGPIO struct {
+	*SimGPIOModule
+
+	sync.Mutex
+	fail bool
+}
+
+func (g *testSoftPWMFailingGPIO) setFail(fail bool) {
+	g.Lock()
+	g.fail = fail
+	g.Unlock()
+}
+
+func (g *testSoftPWMFailingGPIO) DigitalWrite(pin Pin, value int) error {
+	g.Lock()
+	fail := g.fail
+	g.Unlock()
+	if fail {
+		return pinError("DigitalWrite", pin, g, syscall.EIO)
+	}
+	return g.SimGPIOModule.DigitalWrite(pin, value)
+}
+
+func TestSoftPWMWriteFailure(t *testing.T) {
+	driver := newTestWiredDriver()
+	SetDriver(driver)
+	sim := driver.GetModules()["gpio"].(*SimGPIOModule)
+	gpio := &testSoftPWMFailingGPIO{SimGPIOModule: sim}
+	probe := &testSoftPWMProbe{}
+	probe.attach(sim, 0)
+
+	pwm := NewSoftPWMModule("softpwm")
+	pwm.SetOptions(map[string]interface{}{"pins": SoftPWMModulePins{0}, "gpio": GPIOModule(gpio)})
+	pwm.Enable()
+	defer pwm.Disable()
+	pwm.EnablePin(0, true)
+	pwm.SetPeriod(0, 1000000)
+	pwm.SetDutyCycle(0, 0.5)
+
+	// a failed write stops the output, and IsPinEnabled reports it
+	gpio.setFail(true)
+	var e error
+	for deadline := time.Now().Add(time.Second); e == nil && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+		_, e = pwm.IsPinEnabled(0)
+	}
+	if !errors.Is(e, syscall.EIO) {
+		t.Fatalf("Expected IsPinEnabled to report the failed write, got %v", e)
+	}
+
+	// enabling the pin again restarts the output
+	gpio.setFail(false)
+	if e = pwm.EnablePin(0, true); e != nil {
+		t.Fatalf("EnablePin returned an unexpected error: %s", e)
+	}
+	n := probe.count()
+	time.Sleep(20 * time.Millisecond)
+	if probe.count() < n+5 {
+		t.Errorf("Expected the output to start again, got %d periods in 20ms", probe.count()-n)
+	}
+	if enabled, e := pwm.IsPinEnabled(0); e != nil || !enabled {
+		t.Errorf("Expected the pin to be enabled, got %v, %v", enabled, e)
+	}
+}
+
+func TestSoftPWMErrors(t *testing.T) {
+	SetDriver(newTestWiredDriver())
+
+	pwm := NewSoftPWMModule("softpwm")
+	pwm.SetOptions(map[string]interface{}{"pins": SoftPWMModulePins{0}})
+	pwm.Enable()
+	defer pwm.Disable()
+
+	if e := pwm.SetPeriod(0, 1000000); !errors.Is(e, ErrNotOpen) {
+		t.Errorf("Expected ErrNotOpen setting the period of a pin that isn't enabled, got %v", e)
+	}
+	if e := pwm.EnablePin(1, true); !errors.Is(e, ErrUnknownPin) {
+		t.Errorf("Expected ErrUnknownPin enabling a pin that isn't in the module's list, got %v", e)
+	}
+	if _, e := pwm.GetJitter(1); !errors.Is(e, ErrNotOpen) {
+		t.Errorf("Expected ErrNotOpen getting the jitter of a pin that isn't enabled, got %v", e)
+	}
+
+	pwm.EnablePin(0, true)
+	pwm.SetPeriod(0, 1000000)
+	var dutyError *PWMDutyError
+	if e := pwm.SetDuty(0, 2000000); !errors.As(e, &dutyError) {
+		t.Errorf("Expected a PWMDutyError for a duty time longer than the period, got %v", e)
+	}
+	if duty, _ := pwm.GetDuty(0); duty != 0 {
+		t.Errorf("Expected the duty time not to change, got %d", duty)
+	}
+	if e := pwm.SetPolarity(0, PWMPolarity(5)); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for an unknown polarity, got %v", e)
+	}
+}
@@ -0,0 +1,390 @@
+package hwio
+
+// A software SPI module, which bit-bangs the bus on GPIO pins. It implements the same SPIModule interface as the
+// hardware modules, so device drivers work over either, but is much slower, and the clock is not regular as the
+// goroutine can be descheduled in the middle of a transfer. Devices don't mind this, as SPI is synchronous.
+//
+// The module is created by the application rather than the driver:
+//	spi := hwio.NewSoftSPIModule("softspi")
+//	e := spi.SetOptions(map[string]interface{}{
+//		"pins": hwio.SoftSPIModulePins{SCLK: sclk, MOSI: mosi, MISO: miso, CS: hwio.PinList{cs0, cs1}},
+//	})
+//	e = spi.Enable()
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The pins of a software SPI bus. MOSI or MISO can be NO_PIN for a bus that only reads or only writes, and CS can be
+// empty if the devices are always selected, in which case they must be configured with NoCS. Chip select n is CS[n].
+type SoftSPIModulePins struct {
+	SCLK Pin
+	MOSI Pin
+	MISO Pin
+	CS   PinList
+}
+
+type SoftSPIModule struct {
+	sync.Mutex
+
+	name string
+	pins SoftSPIModulePins
+
+	// GPIO module that drives the pins, from the "gpio" option or the board's GPIO module when enabled
+	gpio GPIOModule
+
+	enabled bool
+}
+
+func NewSoftSPIModule(name string) *SoftSPIModule {
+	return &SoftSPIModule{name: name}
+}
+
+// Accept options for the module. Expected options include:
+// - "pins" - a SoftSPIModulePins with the pins of the bus.
+// - "gpio" - optional, the GPIOModule to use for the pins. If not given, the GPIO module of the board is used.
+func (module *SoftSPIModule) SetOptions(options map[string]interface{}) error {
+	vp := options["pins"]
+	if vp == nil {
+		return fmt.Errorf("Module '%s' SetOptions() did not get 'pins' values", module.GetName())
+	}
+
+	module.pins = vp.(SoftSPIModulePins)
+
+	if vg := options["gpio"]; vg != nil {
+		module.gpio = vg.(GPIOModule)
+	}
+
+	return nil
+}
+
+// Enable the bus, opening its pins through the GPIO module. SCLK starts low and the chip selects inactive (high), until
+// devices configured with another mode or with CSHigh use them.
+func (module *SoftSPIModule) Enable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if module.enabled {
+		return nil
+	}
+	if module.gpio == nil {
+		gpio, e := boardForModule(module).GetGPIOModule()
+		if e != nil {
+			return e
+		}
+		module.gpio = gpio
+	}
+
+	opened := PinList{}
+	open := func(pin Pin, mode PinIOMode, level int) error {
+		e := module.gpio.PinMode(pin, mode)
+		if e != nil {
+			return e
+		}
+		opened = append(opened, pin)
+		if mode == OUTPUT {
+			return module.gpio.DigitalWrite(pin, level)
+		}
+		return nil
+	}
+
+	e := open(module.pins.SCLK, OUTPUT, LOW)
+	if e == nil && module.pins.MOSI != NO_PIN {
+		e = open(module.pins.MOSI, OUTPUT, LOW)
+	}
+	if e == nil && module.pins.MISO != NO_PIN {
+		e = open(module.pins.MISO, INPUT, LOW)
+	}
+	for _, pin := range module.pins.CS {
+		if e == nil {
+			e = open(pin, OUTPUT, HIGH)
+		}
+	}
+	if e != nil {
+		for _, pin := range opened {
+			module.gpio.ClosePin(pin)
+		}
+		return e
+	}
+
+	module.enabled = true
+	return nil
+}
+
+// Disable the bus, closing its pins.
+func (module *SoftSPIModule) Disable() error {
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.enabled {
+		return nil
+	}
+
+	var result error
+	for _, pin := range module.allPins() {
+		if e := module.gpio.ClosePin(pin); e != nil && result == nil {
+			result = e
+		}
+	}
+	module.enabled = false
+
+	return result
+}
+
+// Get the pins of the bus that are connected.
+func (module *SoftSPIModule) allPins() PinList {
+	result := PinList{module.pins.SCLK}
+	if module.pins.MOSI != NO_PIN {
+		result = append(result, module.pins.MOSI)
+	}
+	if module.pins.MISO != NO_PIN {
+		result = append(result, module.pins.MISO)
+	}
+	return append(result, module.pins.CS...)
+}
+
+func (module *SoftSPIModule) GetName() string {
+	return module.name
+}
+
+func (module *SoftSPIModule) GetDevice(chipSelect int) SPIDevice {
+	return NewSoftSPIDevice(module, chipSelect)
+}
+
+// A device on a software SPI bus.
+type SoftSPIDevice struct {
+	module     *SoftSPIModule
+	chipSelect int
+
+	// settings for the device's transfers, protected by the module's lock
+	config SPIConfig
+}
+
+func NewSoftSPIDevice(module *SoftSPIModule, chipSelect int) *SoftSPIDevice {
+	return &SoftSPIDevice{module: module, chipSelect: chipSelect}
+}
+
+// Change the settings for the device. Words of up to 32 bits are supported, and are stored in the buffers as spidev
+// does: in one byte for up to 8 bits, and in 2 or 4 bytes with the least significant byte first for longer words.
+func (device *SoftSPIDevice) Configure(config SPIConfig) error {
+	device.module.Lock()
+	defer device.module.Unlock()
+
+	if config.BitsPerWord < 0 || config.BitsPerWord > 32 {
+		return device.spiError("Configure", fmt.Errorf("%d bits per word is %w", config.BitsPerWord, ErrUnsupported))
+	}
+	device.config = config
+
+	// put the chip select in its inactive state now, so the device doesn't see a spurious select before the first
+	// transfer
+	if device.module.enabled && !config.NoCS && device.chipSelect >= 0 && device.chipSelect < len(device.module.pins.CS) {
+		e := device.module.gpio.DigitalWrite(device.module.pins.CS[device.chipSelect], device.csLevel(false))
+		if e != nil {
+			return device.spiError("Configure", e)
+		}
+	}
+	return nil
+}
+
+func (device *SoftSPIDevice) Tx(write []byte, read []byte) error {
+	return device.TxSegments([]SPISegment{{Write: write, Read: read}})
+}
+
+// Do the segments one after another, with the device selected from the start of the first to the end of the last,
+// except after segments with CSChange set.
+func (device *SoftSPIDevice) TxSegments(segments []SPISegment) error {
+	module := device.module
+	module.Lock()
+	defer module.Unlock()
+
+	if !module.enabled {
+		return device.spiError("Tx", ErrClosed)
+	}
+	config := device.config
+	cs := NO_PIN
+	if !config.NoCS {
+		if device.chipSelect < 0 || device.chipSelect >= len(module.pins.CS) {
+			return device.spiError("Tx", fmt.Errorf("chip select is %w, the bus has %d", ErrUnsupported, len(module.pins.CS)))
+		}
+		cs = module.pins.CS[device.chipSelect]
+	}
+
+	bits := config.BitsPerWord
+	if bits == 0 {
+		bits = 8
+	}
+	wordSize := 1
+	if bits > 16 {
+		wordSize = 4
+	} else if bits > 8 {
+		wordSize = 2
+	}
+	for _, s := range segments {
+		n, e := spiTransferLength(s.Write, s.Read)
+		if e != nil {
+			return device.spiError("Tx", e)
+		}
+		if n%wordSize != 0 {
+			return device.spiError("Tx", fmt.Errorf("a transfer of %d bytes with %d bit words is %w", n, bits, ErrUnsupported))
+		}
+		if s.Write != nil && module.pins.MOSI == NO_PIN {
+			return device.spiError("Tx", fmt.Errorf("writing is %w, the bus has no MOSI pin", ErrUnsupported))
+		}
+		if s.Read != nil && module.pins.MISO == NO_PIN {
+			return device.spiError("Tx", fmt.Errorf("reading is %w, the bus has no MISO pin", ErrUnsupported))
+		}
+	}
+
+	// the clock must be at its idle level before the device is selected, as the mode may have changed since the last
+	// transfer
+	e := module.gpio.DigitalWrite(module.pins.SCLK, device.clockLevel(false))
+	if e != nil {
+		return device.spiError("Tx", e)
+	}
+
+	selected := false
+	for i, s := range segments {
+		if cs != NO_PIN && !selected {
+			e = module.gpio.DigitalWrite(cs, device.csLevel(true))
+			if e != nil {
+				return device.spiError("Tx", e)
+			}
+			selected = true
+		}
+
+		e = device.transfer(s, bits, wordSize)
+		if e != nil {
+			if selected {
+				module.gpio.DigitalWrite(cs, device.csLevel(false))
+			}
+			return device.spiError("Tx", e)
+		}
+
+		if s.Delay > 0 {
+			time.Sleep(s.Delay)
+		}
+		if selected && (i == len(segments)-1 || s.CSChange) {
+			e = module.gpio.DigitalWrite(cs, device.csLevel(false))
+			if e != nil {
+				return device.spiError("Tx", e)
+			}
+			selected = false
+		}
+	}
+
+	return nil
+}
+
+// Clock the words of one segment. The module must be locked.
+func (device *SoftSPIDevice) transfer(s SPISegment, bits int, wordSize int) error {
+	gpio := device.module.gpio
+	pins := device.module.pins
+	config := device.config
+
+	n, _ := spiTransferLength(s.Write, s.Read)
+	speed := s.SpeedHz
+	if speed == 0 {
+		speed = config.MaxSpeedHz
+	}
+	halfPeriod := time.Duration(0)
+	if speed > 0 {
+		halfPeriod = time.Second / time.Duration(2*speed)
+	}
+	cpha := config.Mode&SPI_CPHA != 0
+
+	for offset := 0; offset < n; offset += wordSize {
+		out := uint32(0)
+		if s.Write != nil {
+			for i := 0; i < wordSize; i++ {
+				out |= uint32(s.Write[offset+i]) << (8 * uint(i))
+			}
+		}
+
+		in := uint32(0)
+		for i := 0; i < bits; i++ {
+			bit := uint(bits - 1 - i)
+			if config.LSBFirst {
+				bit = uint(i)
+			}
+
+			// in modes 0 and 2 the data is set before the leading edge, and in modes 1 and 3 on it. It is sampled on
+			// the other edge.
+			if cpha {
+				e := gpio.DigitalWrite(pins.SCLK, device.clockLevel(true))
+				if e != nil {
+					return e
+				}
+			}
+			if pins.MOSI != NO_PIN {
+				e := gpio.DigitalWrite(pins.MOSI, int(out>>bit)&1)
+				if e != nil {
+					return e
+				}
+			}
+			spinWait(halfPeriod)
+
+			e := gpio.DigitalWrite(pins.SCLK, device.clockLevel(!cpha))
+			if e != nil {
+				return e
+			}
+			if s.Read != nil {
+				v, e := gpio.DigitalRead(pins.MISO)
+				if e != nil {
+					return e
+				}
+				in |= uint32(v&1) << bit
+			}
+			spinWait(halfPeriod)
+
+			if !cpha {
+				e = gpio.DigitalWrite(pins.SCLK, device.clockLevel(false))
+				if e != nil {
+					return e
+				}
+			}
+		}
+
+		if s.Read != nil {
+			for i := 0; i < wordSize; i++ {
+				s.Read[offset+i] = byte(in >> (8 * uint(i)))
+			}
+		}
+	}
+	return nil
+}
+
+// Get the level of the clock when it is active or idle, from the clock polarity.
+func (device *SoftSPIDevice) clockLevel(active bool) int {
+	idle := LOW
+	if device.config.Mode&SPI_CPOL != 0 {
+		idle = HIGH
+	}
+	if active {
+		return HIGH - idle
+	}
+	return idle
+}
+
+// Get the level of the chip select when the device is selected or not.
+func (device *SoftSPIDevice) csLevel(selected bool) int {
+	if selected == device.config.CSHigh {
+		return HIGH
+	}
+	return LOW
+}
+
+func (device *SoftSPIDevice) spiError(op string, err error) error {
+	return &SPIError{Op: op, Module: device.module.GetName(), ChipSelect: device.chipSelect, Err: err}
+}
+
+// Wait for a short time, such as half a clock period of a software bus. time.Sleep can't sleep for less than tens of
+// microseconds, so this spins instead.
+func spinWait(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	for start := time.Now(); time.Since(start) < d; {
+	}
+}
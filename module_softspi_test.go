@@ -0,0 +1,133 @@
+package hwio
+
+import (
+	"errors"
+	"testing"
+)
+
+// A simulated SPI device on the pins of a software bus, which records the bits it receives and sends back reply.
+type testSoftSPISlave struct {
+	gpio             *SimGPIOModule
+	sclk, mosi, miso Pin
+	config           SPIConfig
+
+	reply    []byte
+	received []byte
+
+	selected bool
+	bit      int
+}
+
+func (s *testSoftSPISlave) bitMask() byte {
+	if s.config.LSBFirst {
+		return 1 << uint(s.bit%8)
+	}
+	return 0x80 >> uint(s.bit%8)
+}
+
+// Put the next bit of the reply on MISO.
+func (s *testSoftSPISlave) shiftOut() {
+	v := LOW
+	if s.reply[s.bit/8]&s.bitMask() != 0 {
+		v = HIGH
+	}
+	s.gpio.MockSetPinValue(s.miso, v)
+}
+
+// Take a bit from MOSI.
+func (s *testSoftSPISlave) sample() {
+	if s.bit%8 == 0 {
+		s.received = append(s.received, 0)
+	}
+	if s.gpio.MockGetPinValue(s.mosi) == HIGH {
+		s.received[s.bit/8] |= s.bitMask()
+	}
+	s.bit++
+}
+
+func (s *testSoftSPISlave) attach(cs Pin) {
+	s.gpio.MockOnChange(cs, func(value int) {
+		s.selected = value == LOW
+		if s.selected && s.config.Mode&SPI_CPHA == 0 {
+			s.shiftOut()
+		}
+	})
+	s.gpio.MockOnChange(s.sclk, func(value int) {
+		if !s.selected {
+			return
+		}
+		leading := (value == HIGH) == (s.config.Mode&SPI_CPOL == 0)
+		if leading == (s.config.Mode&SPI_CPHA == 0) {
+			s.sample()
+		} else if s.bit < len(s.reply)*8 {
+			s.shiftOut()
+		}
+	})
+}
+
+func TestSoftSPIModes(t *testing.T) {
+	for mode := SPI_MODE_0; mode <= SPI_MODE_3; mode++ {
+		for _, lsb := range []bool{false, true} {
+			driver := newTestWiredDriver()
+			SetDriver(driver)
+			gpio := driver.GetModules()["gpio"].(*SimGPIOModule)
+
+			config := SPIConfig{Mode: mode, LSBFirst: lsb}
+			slave := &testSoftSPISlave{gpio: gpio, sclk: 0, mosi: 1, miso: 2, config: config, reply: []byte{0x96, 0x01}}
+			slave.attach(3)
+
+			spi := NewSoftSPIModule("softspi")
+			spi.SetOptions(map[string]interface{}{"pins": SoftSPIModulePins{SCLK: 0, MOSI: 1, MISO: 2, CS: PinList{3}}})
+			if e := spi.Enable(); e != nil {
+				t.Fatalf("Enable returned an unexpected error: %s", e)
+			}
+
+			device := spi.GetDevice(0)
+			device.Configure(config)
+			read := make([]byte, 2)
+			if e := device.Tx([]byte{0xa5, 0x3c}, read); e != nil {
+				t.Fatalf("Tx returned an unexpected error: %s", e)
+			}
+
+			if len(slave.received) != 2 || slave.received[0] != 0xa5 || slave.received[1] != 0x3c {
+				t.Errorf("Mode %d, LSB first %v: expected the device to receive a5 3c, got %x", mode, lsb, slave.received)
+			}
+			if read[0] != 0x96 || read[1] != 0x01 {
+				t.Errorf("Mode %d, LSB first %v: expected to read 96 01, got %x", mode, lsb, read)
+			}
+			if gpio.MockGetPinValue(3) != HIGH || gpio.MockGetPinValue(0) != mode>>1 {
+				t.Errorf("Mode %d: expected the chip select to be released and the clock to be idle", mode)
+			}
+			spi.Disable()
+		}
+	}
+}
+
+func TestSoftSPIErrors(t *testing.T) {
+	SetDriver(newTestWiredDriver())
+
+	spi := NewSoftSPIModule("softspi")
+	spi.SetOptions(map[string]interface{}{"pins": SoftSPIModulePins{SCLK: 0, MOSI: 1, MISO: NO_PIN, CS: PinList{3}}})
+	device := spi.GetDevice(0)
+	if e := device.Tx([]byte{1}, nil); !errors.Is(e, ErrClosed) {
+		t.Errorf("Expected ErrClosed writing to a bus that isn't enabled, got %v", e)
+	}
+
+	spi.Enable()
+	defer spi.Disable()
+
+	var spiError *SPIError
+	if e := device.Tx(nil, make([]byte, 1)); !errors.As(e, &spiError) || !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected an SPIError reading from a bus without MISO, got %v", e)
+	}
+	if e := spi.GetDevice(1).Tx([]byte{1}, nil); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for a chip select the bus doesn't have, got %v", e)
+	}
+	device.Configure(SPIConfig{BitsPerWord: 12})
+	if e := device.Tx([]byte{1, 2, 3}, nil); !errors.Is(e, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported for a buffer that isn't a whole number of words, got %v", e)
+	}
+	if e := device.Tx([]byte{1, 2}, nil); e != nil {
+		t.Errorf("Expected a transfer of one 12 bit word to work, got %v", e)
+	}
+}
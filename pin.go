@@ -38,6 +38,9 @@ const (
 
 type Pin int
 
+// A Pin value for an optional pin that is not connected, e.g. the MISO pin of a software SPI bus that only writes.
+const NO_PIN Pin = -1
+
 type PinDef struct {
 	pin     Pin      // the pin, also in the map key of HardwarePinMap
 	names   []string // a list of names for the pin as defined by Driver. There should be at least one. The first is the canonical name.
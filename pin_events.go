@@ -0,0 +1,121 @@
+package hwio
+
+import (
+	"time"
+)
+
+// Edges that can be watched on an input pin.
+type Edge int
+
+const (
+	EDGE_NONE Edge = iota
+	EDGE_RISING
+	EDGE_FALLING
+	EDGE_BOTH
+)
+
+// String representation of an edge
+func (edge Edge) String() string {
+	switch edge {
+	case EDGE_NONE:
+		return "EDGE_NONE"
+	case EDGE_RISING:
+		return "EDGE_RISING"
+	case EDGE_FALLING:
+		return "EDGE_FALLING"
+	case EDGE_BOTH:
+		return "EDGE_BOTH"
+	}
+	return ""
+}
+
+// Determine if a change in logic level from 'from' to 'to' is an edge that is being watched for.
+func (edge Edge) matches(from int, to int) bool {
+	if from == to {
+		return false
+	}
+	switch edge {
+	case EDGE_RISING:
+		return to == HIGH
+	case EDGE_FALLING:
+		return to == LOW
+	case EDGE_BOTH:
+		return true
+	}
+	return false
+}
+
+// An edge detected on a watched pin.
+type PinEvent struct {
+	Pin Pin
+
+	// EDGE_RISING or EDGE_FALLING
+	Edge Edge
+
+	// When the edge was detected. Where the kernel timestamps events this is the kernel's time, otherwise it is when
+	// the event was picked up.
+	Time time.Time
+}
+
+// Number of events that are buffered on a watch channel. If the receiver doesn't keep up, further events are dropped
+// until there is room in the channel again.
+const pinEventBufferSize = 16
+
+// How often goroutines that are waiting for edges from the kernel check whether the watch has been stopped.
+const watchPollInterval = 100 * time.Millisecond
+
+// pinWatch holds the state of a pin being watched by a GPIO module.
+type pinWatch struct {
+	pin    Pin
+	edge   Edge
+	events chan PinEvent
+	stop   chan struct{}
+
+	// closed when the goroutine delivering events exits. nil if the module has no goroutine.
+	done chan struct{}
+}
+
+func newPinWatch(pin Pin, edge Edge) *pinWatch {
+	return &pinWatch{pin: pin, edge: edge, events: make(chan PinEvent, pinEventBufferSize), stop: make(chan struct{})}
+}
+
+// Run the function in its own goroutine to deliver events. It should return when stopping() is true, or when it can't
+// carry on, e.g. because reading from the kernel failed. The events channel is closed when it returns, so receivers
+// don't wait for events that will never come.
+func (w *pinWatch) start(run func()) {
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		defer close(w.events)
+		run()
+	}()
+}
+
+// Deliver an event, dropping it if the channel is full.
+func (w *pinWatch) send(edge Edge, t time.Time) {
+	select {
+	case w.events <- PinEvent{Pin: w.pin, Edge: edge, Time: t}:
+	default:
+	}
+}
+
+// Determine if the watch has been asked to stop.
+func (w *pinWatch) stopping() bool {
+	select {
+	case <-w.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop the watch, wait for the goroutine delivering events to finish, and close the events channel.
+func (w *pinWatch) close() {
+	close(w.stop)
+	if w.done != nil {
+		// the goroutine closes the channel
+		<-w.done
+		return
+	}
+	close(w.events)
+}
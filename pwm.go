@@ -0,0 +1,60 @@
+// Checks and conversions of PWM settings that are shared by the PWM modules.
+
+package hwio
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The defaults for AnalogWrite: values from 0 to 255, as on Arduino, and a frequency of 1kHz.
+const (
+	DEFAULT_ANALOG_WRITE_RESOLUTION = 8
+	DEFAULT_ANALOG_WRITE_FREQUENCY  = 1000
+)
+
+// Check that a duty time fits in a period.
+func checkPWMDuty(duty int64, period int64) error {
+	if duty < 0 || duty > period {
+		return &PWMDutyError{Duty: duty, Period: period}
+	}
+	return nil
+}
+
+// Get the duty time for a fraction of a period, which has to be from 0 to 1.
+func pwmDutyCycle(period int64, fraction float64) (int64, error) {
+	if math.IsNaN(fraction) || math.IsInf(fraction, 0) {
+		return 0, fmt.Errorf("a duty cycle of %v is not a number", fraction)
+	}
+	duty := int64(math.Round(float64(period) * fraction))
+	return duty, checkPWMDuty(duty, period)
+}
+
+// Scale a duty time to a new period, so that the duty cycle stays the same.
+func scalePWMDuty(duty int64, period int64, newPeriod int64) int64 {
+	if period == 0 {
+		return 0
+	}
+	return int64(math.Round(float64(duty) * float64(newPeriod) / float64(period)))
+}
+
+// Get the value a PWM module writes to its polarity file, given the values for each polarity: "normal" and "inversed"
+// for /sys/class/pwm, or "0" and "1" for the BeagleBone pwm_test devices.
+func pwmPolarityName(polarity PWMPolarity, names ...string) (string, error) {
+	if polarity < 0 || int(polarity) >= len(names) {
+		return "", fmt.Errorf("polarity %d is %w", polarity, ErrUnsupported)
+	}
+	return names[polarity], nil
+}
+
+// Read a time in nanoseconds from a PWM file.
+func readPWMFile(path string) (int64, error) {
+	b, e := os.ReadFile(path)
+	if e != nil {
+		return 0, e
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
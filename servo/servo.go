@@ -61,15 +61,16 @@ func (servo *Servo) SetPeriod(milliseconds int) error {
 
 // Set the servo to the specified angle, typically 0-180. This sets the duty cycle proportionally between min and max,
 // which are defaulted to 1000-2000 microseconds range.
-func (servo *Servo) Write(angle int) {
-	servo.WriteMicroseconds(hwio.Map(angle, 0, 180, servo.minDuty, servo.maxDuty))
+func (servo *Servo) Write(angle int) error {
+	return servo.WriteMicroseconds(hwio.Map(angle, 0, 180, servo.minDuty, servo.maxDuty))
 }
 
 // Like the Arduino Servo.writeMicroseconds function. This is really setting the PWM duty directly, so it is possible
-// to write values too small or too large for the servo to track.
-func (servo *Servo) WriteMicroseconds(ms int) {
+// to write values too small or too large for the servo to track. A duty time longer than the period gives an error
+// that wraps a *hwio.PWMDutyError.
+func (servo *Servo) WriteMicroseconds(ms int) error {
 	// just pass to the underlying PWM pin.
-	servo.PWM.SetDuty(servo.Pin, int64(ms*1000))
+	return servo.PWM.SetDuty(servo.Pin, int64(ms*1000))
 }
 
 // Set the minimum and maximum number of microseconds for the servo. Write maps 0-180 to these values.
@@ -0,0 +1,333 @@
+package hwio
+
+// A model of an I2C device with a file of byte registers, to attach to a simulated I2C bus with MockAttachDevice.
+//
+// The device behaves like most register based devices: the first byte of a write sets the register pointer, and the
+// bytes after it are written to the registers from there. Reads return the registers from the pointer. After each
+// byte read or written, the pointer moves on to the next register. The hooks change this for devices that work
+// differently, and the models for the devices in hwio/devices are built with them.
+//
+// Usage:
+//	regs := hwio.NewSimI2CRegisters()
+//	regs.Set(0x75, 0x68)
+//	regs.SetReadClears(0x3a, 0xff)
+//	i2c.MockAttachDevice(0x68, regs)
+
+import (
+	"sync"
+	"syscall"
+)
+
+type SimI2CRegisters struct {
+	sync.Mutex
+
+	regs    []byte
+	pointer byte
+
+	// Bits that are cleared in a register when it is read
+	clears map[byte]byte
+
+	// The hooks below are called with the registers locked, and are passed the registers so that they can look at or
+	// change them. They must not call the methods of the device.
+
+	// Called when a write sets the register pointer, and returns the register to use. This can be used for devices
+	// that take commands rather than register addresses.
+	OnPointer func(regs []byte, register byte) byte
+
+	// Called for each byte read, and returns the value. If nil, the value of the register is read.
+	OnRead func(regs []byte, register byte) byte
+
+	// Called for each byte written, instead of storing it in the register.
+	OnWrite func(regs []byte, register byte, value byte)
+
+	// Returns the register that follows another in reads and writes of more than one byte. If nil, it is the next
+	// register, wrapping around after 0xff. Returning the same register turns off auto-increment.
+	Next func(regs []byte, register byte) byte
+
+	// Called at the start of each message of a transfer. If it returns true, the device doesn't acknowledge its address,
+	// and the transfer fails with syscall.ENXIO.
+	NACK func(read bool) bool
+}
+
+// Create a device with 256 registers, all zero.
+func NewSimI2CRegisters() *SimI2CRegisters {
+	return &SimI2CRegisters{regs: make([]byte, 256), clears: make(map[byte]byte)}
+}
+
+// Set registers, starting at a register. This doesn't go through the hooks.
+func (d *SimI2CRegisters) Set(register byte, values ...byte) {
+	d.Lock()
+	defer d.Unlock()
+
+	for i, v := range values {
+		d.regs[register+byte(i)] = v
+	}
+}
+
+// Get n registers, starting at a register. This doesn't go through the hooks.
+func (d *SimI2CRegisters) Get(register byte, n int) []byte {
+	d.Lock()
+	defer d.Unlock()
+
+	result := make([]byte, n)
+	for i := range result {
+		result[i] = d.regs[register+byte(i)]
+	}
+	return result
+}
+
+// Make reading a register clear the bits in mask, as status registers often do.
+func (d *SimI2CRegisters) SetReadClears(register byte, mask byte) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.clears[register] = mask
+}
+
+// Check if the device acknowledges its address.
+func (d *SimI2CRegisters) ack(read bool) error {
+	if d.NACK != nil && d.NACK(read) {
+		return syscall.ENXIO
+	}
+	return nil
+}
+
+func (d *SimI2CRegisters) setPointer(register byte) {
+	if d.OnPointer != nil {
+		register = d.OnPointer(d.regs, register)
+	}
+	d.pointer = register
+}
+
+func (d *SimI2CRegisters) next(register byte) byte {
+	if d.Next != nil {
+		return d.Next(d.regs, register)
+	}
+	return register + 1
+}
+
+// Read the register at the pointer, and move the pointer on.
+func (d *SimI2CRegisters) readNext() byte {
+	register := d.pointer
+	value := d.regs[register]
+	if d.OnRead != nil {
+		value = d.OnRead(d.regs, register)
+	}
+	d.regs[register] &^= d.clears[register]
+	d.pointer = d.next(register)
+	return value
+}
+
+// Write the register at the pointer, and move the pointer on.
+func (d *SimI2CRegisters) writeNext(value byte) {
+	register := d.pointer
+	if d.OnWrite != nil {
+		d.OnWrite(d.regs, register, value)
+	} else {
+		d.regs[register] = value
+	}
+	d.pointer = d.next(register)
+}
+
+// Write the register pointer and then data, as one message.
+func (d *SimI2CRegisters) write(command byte, data []byte) error {
+	e := d.ack(false)
+	if e != nil {
+		return e
+	}
+	d.setPointer(command)
+	for _, b := range data {
+		d.writeNext(b)
+	}
+	return nil
+}
+
+// Read n bytes from the pointer, as one message.
+func (d *SimI2CRegisters) read(n int) ([]byte, error) {
+	e := d.ack(true)
+	if e != nil {
+		return nil, e
+	}
+	result := make([]byte, n)
+	for i := range result {
+		result[i] = d.readNext()
+	}
+	return result, nil
+}
+
+// Write a register pointer, then read n bytes after a repeated start.
+func (d *SimI2CRegisters) readFrom(command byte, n int) ([]byte, error) {
+	e := d.write(command, nil)
+	if e != nil {
+		return nil, e
+	}
+	return d.read(n)
+}
+
+func (d *SimI2CRegisters) ReadByte(command byte) (byte, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	b, e := d.readFrom(command, 1)
+	if e != nil {
+		return 0, e
+	}
+	return b[0], nil
+}
+
+func (d *SimI2CRegisters) WriteByte(command byte, value byte) error {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.write(command, []byte{value})
+}
+
+func (d *SimI2CRegisters) Read(command byte, numBytes int) ([]byte, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.readFrom(command, numBytes)
+}
+
+func (d *SimI2CRegisters) Write(command byte, buffer []byte) error {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.write(command, buffer)
+}
+
+func (d *SimI2CRegisters) Tx(messages []I2CMessage) error {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, m := range messages {
+		if m.Flags&I2C_M_RD != 0 {
+			b, e := d.read(len(m.Data))
+			if e != nil {
+				return e
+			}
+			copy(m.Data, b)
+			continue
+		}
+
+		if len(m.Data) == 0 {
+			e := d.ack(false)
+			if e != nil {
+				return e
+			}
+			continue
+		}
+		e := d.write(m.Data[0], m.Data[1:])
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (d *SimI2CRegisters) QuickCommand(read bool) error {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.ack(read)
+}
+
+func (d *SimI2CRegisters) ReceiveByte() (byte, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	b, e := d.read(1)
+	if e != nil {
+		return 0, e
+	}
+	return b[0], nil
+}
+
+func (d *SimI2CRegisters) SendByte(value byte) error {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.write(value, nil)
+}
+
+// Words are read and written with the low byte first, as SMBus does.
+func (d *SimI2CRegisters) ReadWord(command byte) (uint16, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	b, e := d.readFrom(command, 2)
+	if e != nil {
+		return 0, e
+	}
+	return uint16(b[1])<<8 | uint16(b[0]), nil
+}
+
+func (d *SimI2CRegisters) WriteWord(command byte, value uint16) error {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.write(command, []byte{byte(value), byte(value >> 8)})
+}
+
+func (d *SimI2CRegisters) ProcessCall(command byte, value uint16) (uint16, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	e := d.write(command, []byte{byte(value), byte(value >> 8)})
+	if e != nil {
+		return 0, e
+	}
+	b, e := d.read(2)
+	if e != nil {
+		return 0, e
+	}
+	return uint16(b[1])<<8 | uint16(b[0]), nil
+}
+
+// Blocks start with their length, so a block read returns the register at the pointer as the length, followed by
+// that many registers after it.
+func (d *SimI2CRegisters) BlockRead(command byte) ([]byte, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	e := d.write(command, nil)
+	if e != nil {
+		return nil, e
+	}
+	return d.readBlock()
+}
+
+func (d *SimI2CRegisters) BlockWrite(command byte, data []byte) error {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.write(command, append([]byte{byte(len(data))}, data...))
+}
+
+func (d *SimI2CRegisters) BlockProcessCall(command byte, data []byte) ([]byte, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	e := d.write(command, append([]byte{byte(len(data))}, data...))
+	if e != nil {
+		return nil, e
+	}
+	return d.readBlock()
+}
+
+// Read a length and then a block of that length, up to I2C_SMBUS_BLOCK_MAX.
+func (d *SimI2CRegisters) readBlock() ([]byte, error) {
+	e := d.ack(true)
+	if e != nil {
+		return nil, e
+	}
+	n := int(d.readNext())
+	if n > I2C_SMBUS_BLOCK_MAX {
+		n = I2C_SMBUS_BLOCK_MAX
+	}
+	result := make([]byte, n)
+	for i := range result {
+		result[i] = d.readNext()
+	}
+	return result, nil
+}
@@ -0,0 +1,62 @@
+package hwio
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestSimI2CRegisters(t *testing.T) {
+	SetDriver(new(TestDriver))
+	m, _ := GetModule("i2c")
+	i2c := m.(*SimI2CModule)
+	i2c.Enable()
+
+	regs := NewSimI2CRegisters()
+	i2c.MockAttachDevice(0x40, regs)
+	device := i2c.GetDevice(0x40)
+
+	// writes and reads of more than one byte auto-increment
+	device.Write(0x10, []byte{1, 2, 3})
+	if b := regs.Get(0x10, 3); b[0] != 1 || b[1] != 2 || b[2] != 3 {
+		t.Errorf("Expected the write to fill 3 registers, got %v", b)
+	}
+	if b, _ := device.Read(0x11, 2); b[0] != 2 || b[1] != 3 {
+		t.Errorf("Expected to read back registers 0x11 and 0x12, got %v", b)
+	}
+
+	// a plain read carries on from the register pointer
+	buffer := make([]byte, 2)
+	e := device.(I2CTxDevice).Tx([]I2CMessage{{Data: []byte{0x10}}, {Flags: I2C_M_RD, Data: buffer}})
+	if e != nil || buffer[0] != 1 || buffer[1] != 2 {
+		t.Errorf("Expected Tx to read from the register pointer, got %v, %v", buffer, e)
+	}
+
+	// read-clears
+	regs.Set(0x20, 0xff)
+	regs.SetReadClears(0x20, 0x0f)
+	device.ReadByte(0x20)
+	if v, _ := device.ReadByte(0x20); v != 0xf0 {
+		t.Errorf("Expected reading to clear the low bits, got 0x%02x", v)
+	}
+
+	// a hook that turns off auto-increment
+	regs.Lock()
+	regs.Next = func(regs []byte, register byte) byte { return register }
+	regs.Unlock()
+	device.Write(0x30, []byte{1, 2})
+	if b := regs.Get(0x30, 2); b[0] != 2 || b[1] != 0 {
+		t.Errorf("Expected both bytes to go to the same register, got %v", b)
+	}
+
+	// NACK hides the device from transfers and scans
+	regs.Lock()
+	regs.NACK = func(read bool) bool { return true }
+	regs.Unlock()
+	if _, e := device.ReadByte(0); !errors.Is(e, syscall.ENXIO) {
+		t.Errorf("Expected ENXIO from a device that doesn't acknowledge, got %v", e)
+	}
+	if found, _ := i2c.Scan(); len(found) != 0 {
+		t.Errorf("Expected the scan not to find a device that doesn't acknowledge, got %x", found)
+	}
+}
@@ -0,0 +1,147 @@
+package hwio
+
+// Virtual wires between the pins of a simulated board.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A wire on a simulated board, from a pin that drives it to a pin that reads it. Both pins must be on simulated GPIO
+// modules, but they can be on different modules.
+type SimWire struct {
+	// The pin that drives the wire, when it is an output.
+	From Pin
+
+	// The pin that reads the wire. Its level follows the wire unless it is an output itself.
+	To Pin
+
+	// If true, To reads the opposite of the level of From.
+	Invert bool
+
+	// Time for a change on From to reach To. If zero, To changes before DigitalWrite on From returns.
+	Delay time.Duration
+
+	// Level of the wire when From is not driving it, i.e. before From is opened as an output or after it is closed.
+	// If To is opened with INPUT_PULLUP or INPUT_PULLDOWN, its pull resistor decides the level instead.
+	Pull int
+}
+
+// A wire that has been connected to the modules of its pins.
+type simWire struct {
+	sync.Mutex
+
+	SimWire
+
+	from *SimGPIOModule
+	to   *SimGPIOModule
+
+	// Updates to To are numbered, so that a delayed update doesn't overwrite a later one.
+	scheduled int
+	applied   int
+}
+
+// Add a wire between two pins. Wires can be added before or after the driver is initialised. Pins that are driven by
+// wires can still be set from tests with MockSetPinValue.
+func (d *SimDriver) Wire(wire SimWire) error {
+	if d.modules != nil {
+		e := d.connectWire(wire)
+		if e != nil {
+			return e
+		}
+	}
+	d.wires = append(d.wires, wire)
+	return nil
+}
+
+// Connect a wire to the modules of its pins.
+func (d *SimDriver) connectWire(wire SimWire) error {
+	w := &simWire{SimWire: wire}
+	w.from = d.simGPIOModuleFor(wire.From)
+	if w.from == nil {
+		return &PinError{Op: "Wire", Pin: wire.From, Err: fmt.Errorf("%w, only pins on simulated GPIO modules can be wired", ErrUnknownPin)}
+	}
+	w.to = d.simGPIOModuleFor(wire.To)
+	if w.to == nil {
+		return &PinError{Op: "Wire", Pin: wire.To, Err: fmt.Errorf("%w, only pins on simulated GPIO modules can be wired", ErrUnknownPin)}
+	}
+
+	w.from.addListener(wire.From, func(pin Pin, old int, level int, output bool) {
+		w.changed()
+	})
+	// To only needs updating when its mode changes, and not when its level does, which may be a test overriding it.
+	w.to.addListener(wire.To, func(pin Pin, old int, level int, output bool) {
+		if level == old {
+			w.changed()
+		}
+	})
+	w.changed()
+	return nil
+}
+
+// Get the simulated GPIO module that defines a pin, or nil if there is none.
+func (d *SimDriver) simGPIOModuleFor(pin Pin) *SimGPIOModule {
+	for _, module := range d.modules {
+		if gpio, ok := module.(*SimGPIOModule); ok && gpio.definedPins[pin] != nil {
+			return gpio
+		}
+	}
+	return nil
+}
+
+// Called when either end of the wire changes. Works out the level of To, and sets it now or after the delay.
+func (w *simWire) changed() {
+	w.from.Lock()
+	fromLevel, driven := w.from.pinState(w.From)
+	w.from.Unlock()
+
+	w.to.Lock()
+	_, toOutput := w.to.pinState(w.To)
+	toMode := w.to.pinModes[w.To]
+	w.to.Unlock()
+
+	// An output is not changed by the wire. It will be updated when it stops being an output.
+	if toOutput {
+		return
+	}
+
+	value := w.Pull
+	switch {
+	case driven:
+		value = fromLevel
+		if w.Invert {
+			value = HIGH - value
+		}
+	case toMode == INPUT_PULLUP:
+		value = HIGH
+	case toMode == INPUT_PULLDOWN:
+		value = LOW
+	}
+
+	w.Lock()
+	w.scheduled++
+	n := w.scheduled
+	w.Unlock()
+
+	if w.Delay == 0 {
+		w.apply(n, value)
+	} else {
+		time.AfterFunc(w.Delay, func() {
+			w.apply(n, value)
+		})
+	}
+}
+
+// Set the level of To, unless a later update has already been applied.
+func (w *simWire) apply(n int, value int) {
+	w.Lock()
+	if n < w.applied {
+		w.Unlock()
+		return
+	}
+	w.applied = n
+	w.Unlock()
+
+	w.to.MockSetPinValue(w.To, value)
+}
@@ -0,0 +1,131 @@
+package hwio
+
+import (
+	"testing"
+	"time"
+)
+
+// Create a simulated driver with 8 GPIO pins, named "a" to "h".
+func newTestWiredDriver() *SimDriver {
+	pins := []*SimPinDef{}
+	for i := 0; i < 8; i++ {
+		pins = append(pins, &SimPinDef{Names: []string{string(rune('a' + i))}, Modules: []string{"gpio"}})
+	}
+	return NewSimDriver(pins, nil)
+}
+
+func TestWireLoopback(t *testing.T) {
+	driver := newTestWiredDriver()
+	for i := 0; i < 3; i++ {
+		driver.Wire(SimWire{From: Pin(i), To: Pin(i + 3)})
+	}
+	SetDriver(driver)
+
+	for i := 0; i < 3; i++ {
+		PinMode(Pin(i), OUTPUT)
+		PinMode(Pin(i+3), INPUT)
+	}
+
+	e := WriteUIntToPins(5, []Pin{0, 1, 2})
+	if e != nil {
+		t.Fatalf("WriteUIntToPins returned an unexpected error: %s", e)
+	}
+
+	v := 0
+	for i := 3; i < 6; i++ {
+		b, _ := DigitalRead(Pin(i))
+		v = v<<1 | b
+	}
+	if v != 5 {
+		t.Errorf("Expected to read back 5 through the wires, got %d", v)
+	}
+}
+
+func TestWireShiftOut(t *testing.T) {
+	driver := newTestWiredDriver()
+	SetDriver(driver)
+	gpio := driver.GetModules()["gpio"].(*SimGPIOModule)
+
+	// a shift register on the other end, which samples data on the rising edge of the clock
+	driver.Wire(SimWire{From: 0, To: 2})
+	driver.Wire(SimWire{From: 1, To: 3})
+	received := uint(0)
+	gpio.MockOnChange(3, func(value int) {
+		if value == HIGH {
+			received = received<<1 | uint(gpio.MockGetPinValue(2))
+		}
+	})
+
+	PinMode(0, OUTPUT)
+	PinMode(1, OUTPUT)
+	e := ShiftOut(0, 1, 0xa5, MSBFIRST)
+	if e != nil {
+		t.Fatalf("ShiftOut returned an unexpected error: %s", e)
+	}
+	if received != 0xa5 {
+		t.Errorf("Expected the simulated shift register to receive 0xa5, got 0x%02x", received)
+	}
+}
+
+func TestWireInvertAndPull(t *testing.T) {
+	driver := newTestWiredDriver()
+	SetDriver(driver)
+	gpio := driver.GetModules()["gpio"].(*SimGPIOModule)
+
+	e := driver.Wire(SimWire{From: 0, To: 1, Invert: true, Pull: HIGH})
+	if e != nil {
+		t.Fatalf("Wire returned an unexpected error: %s", e)
+	}
+	if e = driver.Wire(SimWire{From: 0, To: 20}); e == nil {
+		t.Error("Expected an error wiring a pin that doesn't exist")
+	}
+
+	PinMode(1, INPUT)
+	if v, _ := DigitalRead(1); v != HIGH {
+		t.Error("Expected the pull level while the driving pin is not an output")
+	}
+
+	PinMode(0, OUTPUT)
+	DigitalWrite(0, HIGH)
+	if v, _ := DigitalRead(1); v != LOW {
+		t.Error("Expected an inverting wire to give LOW")
+	}
+
+	// tests can still override a wired input
+	gpio.MockSetPinValue(1, HIGH)
+	if v, _ := DigitalRead(1); v != HIGH {
+		t.Error("Expected MockSetPinValue to override the wire")
+	}
+
+	// when the driving pin is closed, the input's own pull resistor wins over the wire's
+	PinMode(1, INPUT_PULLDOWN)
+	ClosePin(0)
+	if v, _ := DigitalRead(1); v != LOW {
+		t.Error("Expected a pulled down input to read LOW when nothing drives the wire")
+	}
+}
+
+func TestWireDelay(t *testing.T) {
+	driver := newTestWiredDriver()
+	SetDriver(driver)
+
+	driver.Wire(SimWire{From: 0, To: 1, Delay: 20 * time.Millisecond})
+	PinMode(0, OUTPUT)
+	PinMode(1, INPUT)
+	events, _ := WatchPin(1, EDGE_RISING)
+	defer UnwatchPin(1)
+
+	DigitalWrite(0, HIGH)
+	if v, _ := DigitalRead(1); v != LOW {
+		t.Error("Expected the input not to change before the delay")
+	}
+
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a rising edge after the delay")
+	}
+	if v, _ := DigitalRead(1); v != HIGH {
+		t.Error("Expected the input to be HIGH after the delay")
+	}
+}
@@ -0,0 +1,18 @@
+package hwio
+
+// Helpers shared by the SPI modules.
+
+import (
+	"fmt"
+)
+
+// Get the length of a transfer from its buffers, which must be the same length if both are given.
+func spiTransferLength(write []byte, read []byte) (int, error) {
+	if write == nil {
+		return len(read), nil
+	}
+	if read != nil && len(read) != len(write) {
+		return 0, fmt.Errorf("write and read buffers of different lengths (%d and %d) are %w", len(write), len(read), ErrUnsupported)
+	}
+	return len(write), nil
+}